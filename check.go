@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// printCheckFile writes one line per occurrence of the forbidden patterns,
+// with the message or the description of the rule if it has one, as expected
+// by the pre-commit hooks.
+func printCheckFile(res engine.SearchResult) {
+	clearProgress()
+
+	if res.Skipped != "" {
+		reportSkip(res.Filename, res.Skipped)
+		return
+	}
+
+	if res.Err != nil {
+		reportError(res.Filename, res.Err)
+	}
+
+	for _, item := range res.Findings {
+		filename := res.Filename
+
+		if item.Member != "" {
+			filename += engine.MemberSeparator + item.Member
+		}
+
+		matches := item.Matches(res.Rules)
+
+		for i, m := range matches {
+			line, column := item.LineNumber, 0
+
+			// the positions are in the same order as the occurrences.
+			if len(item.Positions) == len(matches) {
+				line, column = item.Positions[i].Line, item.Positions[i].Column
+			}
+
+			message := fmt.Sprintf("%q is forbidden", item.OriginalText[m.Loc[0]:m.Loc[1]])
+
+			if m.Rule.Message != "" {
+				message += ": " + m.Rule.Message
+			} else if m.Rule.Description != "" {
+				message += ": " + m.Rule.Description
+			}
+
+			if column > 0 {
+				fmt.Printf("%s:%d:%d: %s\n", paint("0;35", filename), line, column, message)
+			} else {
+				fmt.Printf("%s:%d: %s\n", paint("0;35", filename), line, message)
+			}
+		}
+	}
+}
+
+// printCheckSummary writes the number of forbidden occurrences, if any.
+func printCheckSummary(stats engine.Stats) {
+	if stats.Occurrences > 0 {
+		fmt.Fprintf(os.Stderr, "%d forbidden occurrence(s) in %d file(s)\n", stats.Occurrences, stats.FilesMatched)
+	}
+}
+
+// checkStatus returns the exit status of -check, which fails when the
+// forbidden patterns are found.
+func checkStatus(failed bool, stats engine.Stats) int {
+	if failed {
+		return exitFailure
+	}
+
+	if stats.FilesMatched > 0 {
+		return exitViolations
+	}
+
+	return exitClean
+}
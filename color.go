@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// colorize is true if the output includes ANSI escape sequences.
+var colorize bool
+
+// setupColor decides if the output is colored. In auto mode, the colors are
+// disabled when stdout is not a terminal or when the NO_COLOR environment
+// variable is set, as described in https://no-color.org/.
+func setupColor(mode string) error {
+	switch mode {
+	case "always":
+		colorize = true
+	case "never":
+		colorize = false
+	case "auto":
+		fi, err := os.Stdout.Stat()
+		colorize = os.Getenv("NO_COLOR") == "" && err == nil && fi.Mode()&os.ModeCharDevice != 0
+	default:
+		return fmt.Errorf("invalid -color %q, use auto, always or never", mode)
+	}
+
+	return nil
+}
+
+// paint wraps the text with the graphic rendition, i.e. "1;31" for bold red,
+// if the output is colored.
+func paint(style string, text string) string {
+	if !colorize {
+		return text
+	}
+
+	return "\x1b[" + style + "m" + text + "\x1b[0m"
+}
+
+// paintChange renders the old text struck through followed by the new text,
+// or with the [-old-]{+new+} markers of git diff --word-diff if the output is
+// not colored.
+func paintChange(oldText string, newText string) string {
+	if !colorize {
+		return "[-" + oldText + "-]{+" + newText + "+}"
+	}
+
+	return paint("0;9", oldText) + paint("1;34", newText)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// subcommands are the commands completed in the first position.
+var subcommands = [][2]string{
+	{"search", "Preview the replacements without modifying any file"},
+	{"replace", "Replace the text in the files, the same as -x"},
+	{"undo", "Revert the files modified by the most recent execution"},
+	{"apply", "Apply a patch written with -patch"},
+	{"rules", "Validate a rules file and print its rules"},
+	{"image", "Search the layers of a container image"},
+	{"serve", "Run the HTTP API to submit search and replace jobs"},
+	{"mcp", "Run the Model Context Protocol server for coding agents"},
+	{"lsp-rename", "Rename the symbol at FILE:LINE:COLUMN with a language server"},
+	{"completion", "Print the completion script for bash, zsh or fish"},
+}
+
+// flagChoices are the values completed for the flags with a fixed set.
+var flagChoices = map[string][]string{
+	"color":         {"auto", "always", "never"},
+	"group-by":      {"owner"},
+	"only":          {"comments", "strings", "identifiers", "function_name", "type_name", "field_name", "variable_name", "package_name", "string_literal", "comment"},
+	"skip":          {"comments", "strings", "identifiers", "function_name", "type_name", "field_name", "variable_name", "package_name", "string_literal", "comment"},
+	"lang":          {"go", "javascript", "python", "shell", "json", "yaml"},
+	"normalize":     {"nfc", "nfd"},
+	"output-format": {"text", "json", "sarif", "github", "vimgrep", "rdjsonl"},
+}
+
+// completionFlag describes one flag of the main command.
+type completionFlag struct {
+	name    string
+	usage   string
+	boolean bool
+}
+
+// option returns the flag as written in the command line: one dash for the
+// single letter flags and two for the others.
+func (f completionFlag) option() string {
+	if len(f.name) == 1 {
+		return "-" + f.name
+	}
+
+	return "--" + f.name
+}
+
+// completionCommand prints the completion script for the shell.
+func completionCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage:\n  refactor completion bash|zsh|fish")
+		os.Exit(exitUsage)
+	}
+
+	var flags []completionFlag
+
+	flag.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{name: f.Name, usage: f.Usage, boolean: ok && b.IsBoolFlag()})
+	})
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion(flags))
+	case "zsh":
+		fmt.Print(zshCompletion(flags))
+	case "fish":
+		fmt.Print(fishCompletion(flags))
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported shell %q, use bash, zsh or fish\n", args[0])
+		os.Exit(exitUsage)
+	}
+}
+
+func bashCompletion(flags []completionFlag) string {
+	var options, values []string
+
+	for _, f := range flags {
+		options = append(options, f.option())
+
+		if choices, ok := flagChoices[f.name]; ok {
+			values = append(values, fmt.Sprintf("\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;", f.option(), strings.Join(choices, " ")))
+		}
+	}
+
+	var commands []string
+
+	for _, cmd := range subcommands {
+		commands = append(commands, cmd[0])
+	}
+
+	return fmt.Sprintf(`# bash completion for refactor, load it with:
+#   source <(refactor completion bash)
+_refactor() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+
+	case "${COMP_WORDS[1]}" in
+	undo) COMPREPLY=($(compgen -W "-f" -- "$cur")); return ;;
+	apply|rules) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+	completion) COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur")); return ;;
+	esac
+
+	case "$prev" in
+%s
+	esac
+
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	elif [ "$COMP_CWORD" -eq 1 ]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur") $(compgen -f -- "$cur"))
+	else
+		COMPREPLY=($(compgen -f -- "$cur"))
+	fi
+}
+
+complete -o filenames -F _refactor refactor
+`, strings.Join(values, "\n"), strings.Join(options, " "), strings.Join(commands, " "))
+}
+
+func zshCompletion(flags []completionFlag) string {
+	var specs []string
+
+	for _, f := range flags {
+		spec := "'" + f.option() + "[" + zshEscape(f.usage) + "]"
+
+		switch choices, ok := flagChoices[f.name]; {
+		case ok:
+			spec += ":value:(" + strings.Join(choices, " ") + ")"
+		case !f.boolean:
+			spec += ":value:_files"
+		}
+
+		specs = append(specs, "\t\t"+spec+"' \\")
+	}
+
+	var commands []string
+
+	for _, cmd := range subcommands {
+		commands = append(commands, "\t\t\t'"+cmd[0]+":"+zshEscape(cmd[1])+"'")
+	}
+
+	return fmt.Sprintf(`#compdef refactor
+
+# zsh completion for refactor, load it with:
+#   source <(refactor completion zsh)
+_refactor() {
+	local state
+
+	case $words[2] in
+	undo) _arguments '-f[Restore files even if they changed after the replacement]'; return ;;
+	apply|rules) _files; return ;;
+	completion) _values shell bash zsh fish; return ;;
+	esac
+
+	_arguments \
+%s
+		'1: :->first' \
+		'*:file:_files'
+
+	if [[ $state == first ]]; then
+		local -a commands
+		commands=(
+%s
+		)
+		_describe command commands
+		_files
+	fi
+}
+
+if [ "$funcstack[1]" = "_refactor" ]; then
+	_refactor "$@"
+else
+	compdef _refactor refactor
+fi
+`, strings.Join(specs, "\n"), strings.Join(commands, "\n"))
+}
+
+// zshEscape escapes the characters with a meaning in the specifications of
+// _arguments and the single quotes around them.
+func zshEscape(text string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(text)
+}
+
+func fishCompletion(flags []completionFlag) string {
+	var out strings.Builder
+
+	out.WriteString("# fish completion for refactor, load it with:\n#   refactor completion fish | source\n")
+
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&out, "complete -c refactor -n __fish_use_subcommand -a %s -d %s\n", cmd[0], fishQuote(cmd[1]))
+	}
+
+	out.WriteString("complete -c refactor -n '__fish_seen_subcommand_from undo' -s f -d 'Restore files even if they changed after the replacement'\n")
+	out.WriteString("complete -c refactor -n '__fish_seen_subcommand_from completion' -x -a 'bash zsh fish'\n")
+
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+
+	for _, f := range flags {
+		kind := "-l"
+
+		if len(f.name) == 1 {
+			kind = "-s"
+		}
+
+		line := fmt.Sprintf("complete -c refactor -n 'not __fish_seen_subcommand_from undo apply rules completion' %s %s -d %s", kind, f.name, fishQuote(f.usage))
+
+		if choices, ok := flagChoices[f.name]; ok {
+			line += " -x -a " + fishQuote(strings.Join(choices, " "))
+		} else if !f.boolean {
+			line += " -r"
+		}
+
+		out.WriteString(line + "\n")
+	}
+
+	return out.String()
+}
+
+// fishQuote quotes the text for fish, where the backslash only escapes the
+// quote and itself in single-quoted strings.
+func fishQuote(text string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(text) + "'"
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configName is the name of the configuration file of a repository.
+const configName = ".refactor.toml"
+
+// errUnterminatedArray is returned while an array continues on the next line.
+var errUnterminatedArray = errors.New("unterminated array")
+
+// configTable maps the name of a flag to its values. The repeatable flags can
+// have multiple values; the other flags have one.
+type configTable map[string][]string
+
+// config is the content of a configuration file, a small subset of TOML: the
+// settings at the top level apply to every execution and the ones of the
+// [profile.NAME] sections only when the profile is selected.
+//
+//	exclude = ["testdata/**", "*.pb.go"]
+//	color = "never"
+//	concurrency = 8
+//
+//	[profile.api-rename]
+//	a = ["Client", "NewClient"]
+//	b = ["Caller", "NewCaller"]
+//	include = ["*.go"]
+type config struct {
+	settings configTable
+	profiles map[string]configTable
+}
+
+// configAliases are the settings that are not named after a flag.
+var configAliases = map[string]string{
+	"concurrency":   "j",
+	"search":        "a",
+	"replace":       "b",
+	"regexp":        "e",
+	"ignore-case":   "i",
+	"whole-word":    "w",
+	"preserve-case": "p",
+}
+
+// repoSettings are the flags that the configuration of a repository can set.
+// They only choose what is searched and how it is printed: a repository that
+// was just cloned must not be able to run commands, write files or apply the
+// changes, which only the user configuration and the command line can do.
+// Following the symbolic links, searching the hidden files or expanding the
+// placeholders of the environment would let the later -x of the user write
+// outside of the checkout, into .git or the secrets into the files.
+var repoSettings = map[string]bool{
+	"a": true, "b": true, "pairs": true, "escapes": true, "rules": true,
+	"e": true, "i": true, "w": true, "p": true,
+	"structural": true, "normalize": true, "multiline": true,
+	"include": true, "exclude": true, "no-ignore": true,
+	"git": true, "binary": true, "archives": true,
+	"max-depth": true, "max-files": true, "max-filesize": true, "stream-threshold": true,
+	"lang": true, "symbol": true, "tags": true, "tags-only": true, "key": true,
+	"value": true, "to": true, "only": true, "skip": true, "unless": true,
+	"max-per-line": true, "first-only": true, "max-changes": true, "max-occurrences": true,
+	"owner": true, "group-by": true, "json": true, "output-format": true,
+	"column": true, "color": true, "stats": true, "no-progress": true, "j": true,
+}
+
+// configFile is a configuration file and the settings it can change, all of
+// them if nil.
+type configFile struct {
+	filename string
+	allowed  map[string]bool
+}
+
+// loadConfig applies the user configuration, the configuration of the
+// repository and then the profile, if not empty. Each one overrides the
+// previous ones, and the flags specified in the command line override all of
+// them.
+func loadConfig(profile string) error {
+	explicit := map[string]bool{}
+
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var files []configFile
+	var names []string
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, configFile{filename: filepath.Join(dir, "refactor", "config.toml")})
+	}
+
+	if filename, ok := findRepoConfig(); ok {
+		files = append(files, configFile{filename: filename, allowed: repoSettings})
+	}
+
+	type profileTable struct {
+		table   configTable
+		allowed map[string]bool
+	}
+
+	var profiles []profileTable
+
+	for _, file := range files {
+		names = append(names, file.filename)
+		cfg, err := readConfig(file.filename)
+
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := applyConfig(flag.CommandLine, file.filename, cfg.settings, file.allowed, explicit); err != nil {
+			return err
+		}
+
+		if table, ok := cfg.profiles[profile]; ok {
+			profiles = append(profiles, profileTable{table: table, allowed: file.allowed})
+		}
+	}
+
+	if profile == "" {
+		return nil
+	}
+
+	if len(profiles) == 0 {
+		return fmt.Errorf("profile %q is not defined in %s", profile, strings.Join(names, " or "))
+	}
+
+	for _, p := range profiles {
+		if err := applyConfig(flag.CommandLine, "profile "+profile, p.table, p.allowed, explicit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findRepoConfig looks for the configuration file in the working directory
+// and its parents, up to the root of the git repository.
+func findRepoConfig() (string, bool) {
+	dir, err := os.Getwd()
+
+	if err != nil {
+		return "", false
+	}
+
+	for {
+		filename := filepath.Join(dir, configName)
+
+		if _, err := os.Stat(filename); err == nil {
+			return filename, true
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", false
+		}
+
+		parent := filepath.Dir(dir)
+
+		if parent == dir {
+			return "", false
+		}
+
+		dir = parent
+	}
+}
+
+// applyConfig sets the flags of the table that are not in the command line.
+// The values of the repeatable flags replace the ones of the previous
+// configuration files instead of adding to them. The settings not in allowed,
+// unless it is nil, are rejected.
+func applyConfig(fs *flag.FlagSet, source string, table configTable, allowed map[string]bool, explicit map[string]bool) error {
+	reset := map[string]bool{}
+
+	for key, values := range table {
+		name := strings.Replace(key, "_", "-", -1)
+
+		if alias, ok := configAliases[name]; ok {
+			name = alias
+		}
+
+		if fs.Lookup(name) == nil || name == "profile" {
+			return fmt.Errorf("%s: unknown setting %q", source, key)
+		}
+
+		if allowed != nil && !allowed[name] {
+			return fmt.Errorf("%s: setting %q is only allowed in the user configuration or the command line", source, key)
+		}
+
+		if explicit[name] {
+			continue
+		}
+
+		if list, ok := fs.Lookup(name).Value.(*stringList); ok && !reset[name] {
+			reset[name] = true
+			*list = nil
+		}
+
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: %s %s", source, key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// readConfig parses the configuration file.
+func readConfig(filename string) (*config, error) {
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := parseConfig(string(data))
+
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", filename, err)
+	}
+
+	return cfg, nil
+}
+
+// parseConfig reads the settings and the profiles. The errors start with the
+// line number.
+func parseConfig(data string) (*config, error) {
+	cfg := &config{settings: configTable{}, profiles: map[string]configTable{}}
+	table := cfg.settings
+	lines := strings.Split(data, "\n")
+
+	for n := 0; n < len(lines); n++ {
+		line := strings.TrimSpace(stripComment(lines[n]))
+		start := n + 1
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%d: invalid section %q", start, line)
+			}
+
+			name := strings.TrimSpace(line[1 : len(line)-1])
+
+			if !strings.HasPrefix(name, "profile.") {
+				return nil, fmt.Errorf("%d: unknown section %q, use [profile.NAME]", start, name)
+			}
+
+			table = configTable{}
+			cfg.profiles[unquoteKey(name[len("profile."):])] = table
+		default:
+			i := strings.IndexByte(line, '=')
+
+			if i < 0 {
+				return nil, fmt.Errorf("%d: expected key = value", start)
+			}
+
+			key := unquoteKey(strings.TrimSpace(line[:i]))
+			value := strings.TrimSpace(line[i+1:])
+			values, err := parseValue(value)
+
+			// the arrays can continue on the next lines.
+			for err == errUnterminatedArray && n+1 < len(lines) {
+				n++
+				value += " " + strings.TrimSpace(stripComment(lines[n]))
+				values, err = parseValue(value)
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s %s", start, key, err)
+			}
+
+			table[key] = values
+		}
+	}
+
+	return cfg, nil
+}
+
+// stripComment removes the comment at the end of the line, if any.
+func stripComment(line string) string {
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// unquoteKey removes the quotes of a key, i.e. [profile."api rename"].
+func unquoteKey(key string) string {
+	if s, err := strconv.Unquote(key); err == nil {
+		return s
+	}
+
+	return strings.Trim(key, "'")
+}
+
+// parseValue parses a string, a number, a boolean or an array of them.
+func parseValue(text string) ([]string, error) {
+	if text == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+
+	if !strings.HasPrefix(text, "[") {
+		value, rest, err := parseScalar(text)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected %q after the value", rest)
+		}
+
+		return []string{value}, nil
+	}
+
+	values := []string{}
+	rest := strings.TrimSpace(text[1:])
+
+	for {
+		if rest == "" {
+			return nil, errUnterminatedArray
+		}
+
+		if rest[0] == ']' {
+			if strings.TrimSpace(rest[1:]) != "" {
+				return nil, fmt.Errorf("unexpected %q after the array", rest[1:])
+			}
+			return values, nil
+		}
+
+		value, next, err := parseScalar(rest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+		rest = strings.TrimSpace(next)
+
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if rest != "" && rest[0] != ']' {
+			return nil, fmt.Errorf("expected a comma before %q", rest)
+		}
+	}
+}
+
+// parseScalar parses the value at the beginning of the text and returns the
+// rest of the text.
+func parseScalar(text string) (string, string, error) {
+	switch text[0] {
+	case '"':
+		for i := 1; i < len(text); i++ {
+			switch text[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(text[:i+1])
+				return value, text[i+1:], err
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string")
+	case '\'':
+		if i := strings.IndexByte(text[1:], '\''); i >= 0 {
+			return text[1 : i+1], text[i+2:], nil
+		}
+		return "", "", fmt.Errorf("unterminated string")
+	}
+
+	end := strings.IndexAny(text, " \t,]")
+
+	if end < 0 {
+		end = len(text)
+	}
+
+	value := text[:end]
+
+	if _, err := strconv.ParseFloat(value, 64); err != nil && value != "true" && value != "false" {
+		return "", "", fmt.Errorf("invalid value %q, strings must be quoted", value)
+	}
+
+	return value, text[end:], nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		settings configTable
+		profiles map[string]configTable
+		wantErr  string
+	}{
+		{
+			name:     "scalars",
+			data:     "color = \"never\"\nconcurrency = 8\nwhole_word = true\nsearch = 'C:\\path'\n",
+			settings: configTable{"color": {"never"}, "concurrency": {"8"}, "whole_word": {"true"}, "search": {`C:\path`}},
+			profiles: map[string]configTable{},
+		},
+		{
+			name:     "arrays and comments",
+			data:     "# shared settings\nexclude = [\"testdata/**\", \"*.pb.go\"] # generated\ninclude = []\n",
+			settings: configTable{"exclude": {"testdata/**", "*.pb.go"}, "include": {}},
+			profiles: map[string]configTable{},
+		},
+		{
+			name:     "multiline array",
+			data:     "exclude = [\n  \"a\",\n  \"b#c\", # comment\n]\n",
+			settings: configTable{"exclude": {"a", "b#c"}},
+			profiles: map[string]configTable{},
+		},
+		{
+			name:     "profiles",
+			data:     "color = \"auto\"\n\n[profile.api-rename]\nsearch = [\"Client\"]\n\n[profile.\"with space\"]\ni = true\n",
+			settings: configTable{"color": {"auto"}},
+			profiles: map[string]configTable{
+				"api-rename": {"search": {"Client"}},
+				"with space": {"i": {"true"}},
+			},
+		},
+		{
+			name:     "escaped quotes",
+			data:     `search = "say \"hi\""`,
+			settings: configTable{"search": {`say "hi"`}},
+			profiles: map[string]configTable{},
+		},
+		{name: "unknown section", data: "[tool]\n", wantErr: "1: unknown section"},
+		{name: "invalid section", data: "[profile.a\n", wantErr: "1: invalid section"},
+		{name: "missing equal sign", data: "\ncolor\n", wantErr: "2: expected key = value"},
+		{name: "missing value", data: "color =\n", wantErr: "1: color missing value"},
+		{name: "unquoted string", data: "color = never\n", wantErr: "strings must be quoted"},
+		{name: "unterminated string", data: "color = \"never\n", wantErr: "unterminated string"},
+		{name: "unterminated array", data: "exclude = [\"a\"\n", wantErr: "unterminated array"},
+		{name: "missing comma", data: "exclude = [\"a\" \"b\"]\n", wantErr: "expected a comma"},
+		{name: "trailing text", data: "j = 8 9\n", wantErr: "after the value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfig(tt.data)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseConfig = %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseConfig %s", err)
+			}
+
+			if !reflect.DeepEqual(cfg.settings, tt.settings) {
+				t.Fatalf("settings = %q, want %q", cfg.settings, tt.settings)
+			}
+
+			if !reflect.DeepEqual(cfg.profiles, tt.profiles) {
+				t.Fatalf("profiles = %q, want %q", cfg.profiles, tt.profiles)
+			}
+		})
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    configTable
+		allowed  map[string]bool
+		explicit map[string]bool
+		want     map[string]string
+		wantErr  string
+	}{
+		{
+			name:  "aliases and repeatable flags",
+			table: configTable{"search": {"a", "b"}, "whole_word": {"true"}, "concurrency": {"4"}},
+			want:  map[string]string{"a": "a,b", "w": "true", "j": "4"},
+		},
+		{
+			name:     "command line takes precedence",
+			table:    configTable{"color": {"never"}},
+			explicit: map[string]bool{"color": true},
+			want:     map[string]string{"color": "auto"},
+		},
+		{
+			name:  "user configuration runs commands",
+			table: configTable{"map-cmd": {"cat"}, "x": {"true"}},
+			want:  map[string]string{"map-cmd": "cat", "x": "true"},
+		},
+		{
+			name:    "repository configuration cannot run commands",
+			table:   configTable{"map_cmd": {"touch PWNED; cat"}},
+			allowed: repoSettings,
+			wantErr: `setting "map_cmd" is only allowed`,
+		},
+		{
+			name:    "repository configuration cannot apply the changes",
+			table:   configTable{"x": {"true"}},
+			allowed: repoSettings,
+			wantErr: `setting "x" is only allowed`,
+		},
+		{
+			name:    "repository configuration cannot follow the links",
+			table:   configTable{"follow": {"true"}},
+			allowed: repoSettings,
+			wantErr: `setting "follow" is only allowed`,
+		},
+		{
+			name:    "repository configuration cannot search the hidden files",
+			table:   configTable{"hidden": {"true"}},
+			allowed: repoSettings,
+			wantErr: `setting "hidden" is only allowed`,
+		},
+		{
+			name:    "repository configuration cannot skip the default filters",
+			table:   configTable{"no-default-filters": {"true"}},
+			allowed: repoSettings,
+			wantErr: `setting "no-default-filters" is only allowed`,
+		},
+		{
+			name:    "repository configuration cannot expand the placeholders",
+			table:   configTable{"placeholders": {"true"}},
+			allowed: repoSettings,
+			wantErr: `setting "placeholders" is only allowed`,
+		},
+		{
+			name:    "repository configuration",
+			table:   configTable{"exclude": {"vendor/**"}, "color": {"never"}},
+			allowed: repoSettings,
+			want:    map[string]string{"exclude": "vendor/**", "color": "never"},
+		},
+		{
+			name:    "unknown setting",
+			table:   configTable{"colour": {"never"}},
+			wantErr: `unknown setting "colour"`,
+		},
+		{
+			name:    "profile is not a setting",
+			table:   configTable{"profile": {"other"}},
+			wantErr: `unknown setting "profile"`,
+		},
+		{
+			name:    "invalid value",
+			table:   configTable{"concurrency": {"many"}},
+			wantErr: "concurrency",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var search stringList
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.Var(&search, "a", "")
+			fs.Bool("w", false, "")
+			fs.Int("j", 0, "")
+			fs.Bool("x", false, "")
+			fs.String("color", "auto", "")
+			fs.String("exclude", "", "")
+			fs.String("map-cmd", "", "")
+			fs.String("profile", "", "")
+			fs.Bool("follow", false, "")
+			fs.Bool("hidden", false, "")
+			fs.Bool("no-default-filters", false, "")
+			fs.Bool("placeholders", false, "")
+
+			err := applyConfig(fs, "test", tt.table, tt.allowed, tt.explicit)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("applyConfig = %v, want an error with %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("applyConfig %s", err)
+			}
+
+			for name, want := range tt.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("-%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyConfigOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		tables   []configTable
+		explicit map[string]bool
+		want     map[string]string
+	}{
+		{
+			name: "profile replaces the pairs",
+			tables: []configTable{
+				{"a": {"Foo"}, "b": {"Bar"}},
+				{"search": {"Client", "NewClient"}, "replace": {"Caller", "NewCaller"}},
+			},
+			want: map[string]string{"a": "Client,NewClient", "b": "Caller,NewCaller"},
+		},
+		{
+			name: "profile keeps the other lists",
+			tables: []configTable{
+				{"a": {"Foo"}, "b": {"Bar"}, "exclude": {"vendor/**"}},
+				{"a": {"Client"}, "b": {"Caller"}},
+			},
+			want: map[string]string{"a": "Client", "b": "Caller", "exclude": "vendor/**"},
+		},
+		{
+			name: "both names of a flag in the same table",
+			tables: []configTable{
+				{"a": {"Foo"}},
+				{"a": {"Client"}, "search": {"Client"}},
+			},
+			want: map[string]string{"a": "Client,Client"},
+		},
+		{
+			name:     "command line takes precedence",
+			tables:   []configTable{{"a": {"Foo"}}, {"a": {"Client"}}},
+			explicit: map[string]bool{"a": true},
+			want:     map[string]string{"a": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var search, replace, exclude stringList
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.Var(&search, "a", "")
+			fs.Var(&replace, "b", "")
+			fs.Var(&exclude, "exclude", "")
+
+			for _, table := range tt.tables {
+				if err := applyConfig(fs, "test", table, nil, tt.explicit); err != nil {
+					t.Fatalf("applyConfig %s", err)
+				}
+			}
+
+			for name, want := range tt.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("-%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// contextFlags holds the number of lines printed around every finding with
+// -C, -A and -B. The last two override the first one.
+type contextFlags struct {
+	both   int
+	after  int
+	before int
+}
+
+// lines returns the number of lines of context before and after a finding.
+func (c contextFlags) lines() (int, int) {
+	before, after := c.both, c.both
+
+	if c.before >= 0 {
+		before = c.before
+	}
+
+	if c.after >= 0 {
+		after = c.after
+	}
+
+	return before, after
+}
+
+func (c contextFlags) enabled() bool {
+	before, after := c.lines()
+	return before > 0 || after > 0
+}
+
+// contextStarted is true once a group of lines was printed, so the groups of
+// the following files are also separated.
+var contextStarted bool
+
+// printWithContext prints the findings of the file with the surrounding lines,
+// like grep(1): matches are separated from the line number by a colon, the
+// context lines by a dash, and non-contiguous groups by a line with "--".
+func printWithContext(res engine.SearchResult) {
+	content, err := engine.ReadText(res.Filename)
+
+	if err != nil {
+		fmt.Println("engine.ReadText", res.Filename, err)
+		return
+	}
+
+	before, after := flagContext.lines()
+	lines := strings.Split(string(bytes.TrimSuffix(content, []byte("\n"))), "\n")
+
+	printLine := func(row int) {
+		text := strings.TrimSuffix(lines[row-1], "\r")
+		fmt.Printf("%s-%s-%s\n", paint("0;35", res.Filename), paint("0;32", strconv.Itoa(row)), text)
+	}
+
+	last := 0 /* last printed line */
+
+	for i, item := range res.Findings {
+		from := item.LineNumber - before
+
+		if from <= last {
+			from = last + 1
+		}
+
+		if from < 1 {
+			from = 1
+		}
+
+		if contextStarted && (i == 0 || from > last+1) {
+			fmt.Println("--")
+		}
+
+		contextStarted = true
+
+		for row := from; row < item.LineNumber && row <= len(lines); row++ {
+			printLine(row)
+		}
+
+		fmt.Println(formatMatch(res.Filename, item, res.Rules))
+		last = item.EndLine
+
+		to := item.EndLine + after
+
+		// the lines of the next finding are printed as a match instead.
+		if i+1 < len(res.Findings) && to >= res.Findings[i+1].LineNumber {
+			to = res.Findings[i+1].LineNumber - 1
+		}
+
+		for ; last < to && last < len(lines); last++ {
+			printLine(last + 1)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package engine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The formats of the archives processed with Options.Archives.
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+	archiveGzip  = "gz"
+)
+
+// MemberSeparator separates the name of an archive and the name of one of its
+// members, i.e. "app.jar!/META-INF/MANIFEST.MF", like the URLs of Java.
+const MemberSeparator = "!/"
+
+// archiveFormat returns the format of the archive, detected from the name of
+// the file, or an empty string if it is not an archive.
+func archiveFormat(filename string) string {
+	name := strings.ToLower(filename)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"), strings.HasSuffix(name, ".jar"), strings.HasSuffix(name, ".war"), strings.HasSuffix(name, ".ear"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	case strings.HasSuffix(name, ".gz"):
+		return archiveGzip
+	}
+
+	return ""
+}
+
+// archiveMember is one file of an archive. The headers are kept so the archive
+// can be written again with the same metadata.
+type archiveMember struct {
+	name string
+	data []byte
+	// regular is false for the directories, the links and the other special
+	// files, which are copied as they are.
+	regular bool
+
+	zip  *zip.File
+	tar  *tar.Header
+	gzip gzip.Header
+}
+
+// readArchive returns the members of the archive, in their order.
+func readArchive(format string, raw []byte) ([]archiveMember, error) {
+	switch format {
+	case archiveZip:
+		return readZip(raw)
+	case archiveTar:
+		return readTar(bytes.NewReader(raw))
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer zr.Close()
+
+	if format == archiveTarGz {
+		return readTar(zr)
+	}
+
+	data, err := io.ReadAll(zr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the name of the compressed file, if the header has none.
+	name := zr.Name
+
+	if name == "" {
+		name = "-"
+	}
+
+	return []archiveMember{{name: name, data: data, regular: true, gzip: zr.Header}}, nil
+}
+
+func readZip(raw []byte) ([]archiveMember, error) {
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var members []archiveMember
+
+	for _, f := range zr.File {
+		m := archiveMember{name: f.Name, zip: f, regular: f.Mode().IsRegular()}
+
+		if m.regular {
+			rc, err := f.Open()
+
+			if err != nil {
+				return nil, fmt.Errorf("%s %s", f.Name, err)
+			}
+
+			m.data, err = io.ReadAll(rc)
+			rc.Close()
+
+			if err != nil {
+				return nil, fmt.Errorf("%s %s", f.Name, err)
+			}
+		}
+
+		members = append(members, m)
+	}
+
+	return members, nil
+}
+
+func readTar(r io.Reader) ([]archiveMember, error) {
+	tr := tar.NewReader(r)
+
+	var members []archiveMember
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return members, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		m := archiveMember{name: hdr.Name, tar: hdr, regular: hdr.Typeflag == tar.TypeReg}
+
+		if m.data, err = io.ReadAll(tr); err != nil {
+			return nil, fmt.Errorf("%s %s", hdr.Name, err)
+		}
+
+		members = append(members, m)
+	}
+}
+
+// writeArchive returns the archive with the members, whose content can be
+// different from the original one. The members that did not change keep
+// their compressed data.
+func writeArchive(format string, members []archiveMember, changed map[string]bool) ([]byte, error) {
+	var out bytes.Buffer
+	var err error
+
+	switch format {
+	case archiveZip:
+		err = writeZip(&out, members, changed)
+	case archiveTar:
+		err = writeTar(&out, members)
+	case archiveTarGz, archiveGzip:
+		zw := gzip.NewWriter(&out)
+
+		// the metadata of the original archive, i.e. the name and the time.
+		if len(members) > 0 {
+			zw.Header = members[0].gzip
+		}
+
+		if format == archiveTarGz {
+			err = writeTar(zw, members)
+		} else if len(members) == 1 {
+			_, err = zw.Write(members[0].data)
+		}
+
+		if err == nil {
+			err = zw.Close()
+		}
+	}
+
+	return out.Bytes(), err
+}
+
+func writeZip(w io.Writer, members []archiveMember, changed map[string]bool) error {
+	zw := zip.NewWriter(w)
+
+	for _, m := range members {
+		if !changed[m.name] {
+			if err := zw.Copy(m.zip); err != nil {
+				return fmt.Errorf("%s %s", m.name, err)
+			}
+			continue
+		}
+
+		hdr := m.zip.FileHeader
+		fw, err := zw.CreateHeader(&hdr)
+
+		if err != nil {
+			return fmt.Errorf("%s %s", m.name, err)
+		}
+
+		if _, err := fw.Write(m.data); err != nil {
+			return fmt.Errorf("%s %s", m.name, err)
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeTar(w io.Writer, members []archiveMember) error {
+	tw := tar.NewWriter(w)
+
+	for _, m := range members {
+		hdr := *m.tar
+		hdr.Size = int64(len(m.data))
+
+		if err := tw.WriteHeader(&hdr); err != nil {
+			return fmt.Errorf("%s %s", m.name, err)
+		}
+
+		if _, err := tw.Write(m.data); err != nil {
+			return fmt.Errorf("%s %s", m.name, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+// searchArchive finds the rules in the text members of the archive, see
+// Options.Archives. The findings of every member are sorted by line and
+// have the name of the member.
+func (e *Engine) searchArchive(ctx context.Context, filename string, format string, keep bool) (SearchResult, bool) {
+	res := SearchResult{Filename: filename, Rules: e.rules, archive: format}
+
+	fi, err := os.Lstat(filename)
+
+	if err != nil {
+		res.Err = err
+		return res, true
+	}
+
+	if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+		return res, false
+	}
+
+	res.size, res.modTime = fi.Size(), fi.ModTime()
+
+	if e.opts.MaxFileSize > 0 && fi.Size() > e.opts.MaxFileSize {
+		res.Skipped = fmt.Sprintf("%d bytes, larger than the limit of %d", fi.Size(), e.opts.MaxFileSize)
+		e.skipped()
+		return res, true
+	}
+
+	raw, err := os.ReadFile(filename)
+
+	if err != nil {
+		res.Err = err
+		return res, true
+	}
+
+	members, err := readArchive(format, raw)
+
+	if err != nil {
+		res.Err = fmt.Errorf("%s %s", filename, err)
+		return res, true
+	}
+
+	e.scanned()
+
+	for _, m := range members {
+		if ctx.Err() != nil {
+			break
+		}
+
+		findings, err := e.searchMember(filename, m)
+
+		if err != nil {
+			res.Err = fmt.Errorf("%s%s%s %s", filename, MemberSeparator, m.name, err)
+			return res, true
+		}
+
+		res.Findings = append(res.Findings, findings...)
+	}
+
+	if len(res.Findings) > 0 {
+		e.matched(res.Findings)
+
+		if keep {
+			res.raw = raw
+		}
+	}
+
+	return res, true
+}
+
+// searchMember returns the findings of the member of the archive, which is
+// skipped, like the files, if it is binary, generated or not in a language of
+// the options.
+func (e *Engine) searchMember(filename string, m archiveMember) ([]Finding, error) {
+	rules := e.rules.ForFile(m.name)
+
+	if !m.regular || len(rules) == 0 || (e.locator != nil && !e.locator.handles(m.name)) {
+		return nil, nil
+	}
+
+	if e.opts.Lang != "" {
+		if lang := LanguageFor(m.name, nil); lang == nil || lang.Name != e.opts.Lang {
+			return nil, nil
+		}
+	}
+
+	head := m.data
+
+	if len(head) > sniffLength {
+		head = head[:sniffLength]
+	}
+
+	// only the members in UTF-8 are searched, since the archive is written
+	// again with the same bytes.
+	if detectEncoding(head) != UTF8 || isBinary(head) {
+		return nil, nil
+	}
+
+	if !e.opts.NoDefaultFilters && isGenerated(head) {
+		return nil, nil
+	}
+
+	if lang := LanguageFor(m.name, head); e.scoped() && !e.supports(lang) {
+		return nil, unsupportedNode(lang, e.opts.Only, e.opts.Skip)
+	}
+
+	findings, err := e.findContent(m.name, rules, m.data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if findings, _, err = suppress(m.name, m.data, findings); err != nil {
+		return nil, err
+	}
+
+	findings = e.limiter(filename + MemberSeparator + m.name).limit(findings)
+
+	if e.mapper != nil {
+		if err := e.mapper.mapFindings(rules, findings); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range findings {
+		findings[i].Member = m.name
+	}
+
+	return findings, nil
+}
+
+// findContent returns the findings of the rules in the content, which is in
+// memory, in any mode except symbol mode.
+func (e *Engine) findContent(filename string, rules RuleSet, content []byte) ([]Finding, error) {
+	switch {
+	case e.locator != nil:
+		matches, err := e.locator.matches(filename, rules, content)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return locatedFindings(content, matches), nil
+	case e.scoped():
+		return groupMatches(content, e.scopedMatches(rules, filename, content)), nil
+	case e.opts.Multiline:
+		return findMultiline(rules, content), nil
+	}
+
+	var findings []Finding
+	var row int
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), MaxLineLength)
+
+	for scanner.Scan() {
+		row++ /* line number */
+
+		if item, ok := findInLine(rules, scanner.Text(), row); ok {
+			findings = append(findings, item)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", row+1, err)
+	}
+
+	return findings, nil
+}
+
+// previewArchive returns the original and the modified archive, where the
+// findings of every member are replaced.
+func (e *Engine) previewArchive(res SearchResult) ([]byte, []byte, error) {
+	raw := res.raw
+
+	if raw == nil {
+		var err error
+
+		if raw, err = os.ReadFile(res.Filename); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	members, err := readArchive(res.archive, raw)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s", res.Filename, err)
+	}
+
+	byMember := map[string][]Finding{}
+
+	for _, item := range res.Findings {
+		byMember[item.Member] = append(byMember[item.Member], item)
+	}
+
+	changed := map[string]bool{}
+
+	for i, m := range members {
+		findings := byMember[m.name]
+
+		if !m.regular || len(findings) == 0 {
+			continue
+		}
+
+		member := SearchResult{Filename: m.name, Rules: e.rules.ForFile(m.name), Findings: findings, raw: m.data}
+		_, content, err := e.Preview(member, nil)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s%s%s", res.Filename, MemberSeparator, err)
+		}
+
+		if !bytes.Equal(content, m.data) {
+			members[i].data, changed[m.name] = content, true
+		}
+	}
+
+	if len(changed) == 0 {
+		return raw, raw, nil
+	}
+
+	content, err := writeArchive(res.archive, members, changed)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s", res.Filename, err)
+	}
+
+	return raw, content, nil
+}
+
+// applyArchive rewrites the archive at once if any of its members changed.
+func (e *Engine) applyArchive(res *SearchResult, perm os.FileMode) error {
+	raw, content, err := e.previewArchive(*res)
+
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(raw, content) {
+		return nil
+	}
+
+	if e.journal != nil {
+		if err := e.journal.Record(res.Filename, raw, content); err != nil {
+			return fmt.Errorf("journal.Record %s %s", res.Filename, err)
+		}
+	}
+
+	return writeFileAtomic(res.Filename, content, perm)
+}
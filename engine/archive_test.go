@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// buildArchive writes the files in an archive of the format.
+func buildArchive(t *testing.T, format string, files map[string]string) []byte {
+	t.Helper()
+
+	var names []string
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+
+	switch format {
+	case archiveZip:
+		zw := zip.NewWriter(&buf)
+		for _, name := range names {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.WriteString(w, files[name])
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case archiveTar:
+		tw := tar.NewWriter(&buf)
+		for _, name := range names {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}); err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.WriteString(tw, files[name])
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case archiveTarGz:
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		for _, name := range names {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}); err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.WriteString(tw, files[name])
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case archiveGzip:
+		gw := gzip.NewWriter(&buf)
+		gw.Name = names[0]
+		_, _ = io.WriteString(gw, files[names[0]])
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestArchiveFormat(t *testing.T) {
+	tests := map[string]string{
+		"app.jar":      archiveZip,
+		"a.ZIP":        archiveZip,
+		"a.tar":        archiveTar,
+		"a.tar.gz":     archiveTarGz,
+		"a.tgz":        archiveTarGz,
+		"a.txt.gz":     archiveGzip,
+		"a.txt":        "",
+		"archive.zip/": "",
+	}
+
+	for name, want := range tests {
+		if got := archiveFormat(name); got != want {
+			t.Errorf("archiveFormat(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestApplyArchive(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		format string
+		files  map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "zip",
+			file:   "app.jar",
+			format: archiveZip,
+			files:  map[string]string{"META-INF/app.properties": "host=old\n", "other.txt": "keep\n"},
+			want:   map[string]string{"META-INF/app.properties": "host=new\n", "other.txt": "keep\n"},
+		},
+		{
+			name:   "tar.gz",
+			file:   "conf.tar.gz",
+			format: archiveTarGz,
+			files:  map[string]string{"etc/app.conf": "old old\n", "etc/keep.conf": "keep\n"},
+			want:   map[string]string{"etc/app.conf": "new new\n", "etc/keep.conf": "keep\n"},
+		},
+		{
+			name:   "gz",
+			file:   "app.conf.gz",
+			format: archiveGzip,
+			files:  map[string]string{"app.conf": "old\n"},
+			want:   map[string]string{"app.conf": "new\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), tt.file)
+
+			if err := os.WriteFile(filename, buildArchive(t, tt.format, tt.files), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := New(Options{
+				Rules:    []RuleSpec{{Search: "old", Replace: "new"}},
+				Paths:    []string{filename},
+				Archives: true,
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results, err := e.Apply(context.Background())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, res := range results {
+				if res.Err != nil {
+					t.Fatalf("Apply %s %s", res.Filename, res.Err)
+				}
+			}
+
+			raw, err := os.ReadFile(filename)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			members, err := readArchive(tt.format, raw)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{}
+
+			for _, m := range members {
+				if m.regular {
+					got[m.name] = string(m.data)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("members = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
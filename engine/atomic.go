@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// atomicFile is a temporary file that replaces the original file when it is
+// committed, so the original file is never left in a partially written state.
+type atomicFile struct {
+	*os.File
+	filename string
+	perm     os.FileMode
+	fi       os.FileInfo
+}
+
+// createAtomic creates the temporary file in the same directory as the file
+// it is going to replace. If the file already exists, its permissions and,
+// where possible, its ownership are preserved; otherwise the file is created
+// with the specified permissions.
+func createAtomic(filename string, perm os.FileMode) (*atomicFile, error) {
+	fi, err := os.Stat(filename)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if fi != nil {
+		perm = fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".refactor-*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &atomicFile{File: tmp, filename: filename, perm: perm, fi: fi}, nil
+}
+
+// Commit flushes the temporary file to disk and renames it over the original
+// file, which is an atomic operation on POSIX file systems.
+func (f *atomicFile) Commit() error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Chmod(f.perm); err != nil {
+		return err
+	}
+
+	if f.fi != nil {
+		if err := copyOwner(f.File, f.fi); err != nil {
+			return err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), f.filename); err != nil {
+		return err
+	}
+
+	f.File = nil
+
+	return nil
+}
+
+// Abort removes the temporary file unless it was already committed.
+func (f *atomicFile) Abort() {
+	if f.File != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		f.File = nil
+	}
+}
+
+// writeFileAtomic replaces the content of the file without leaving it in a
+// partially written state. The data is written to a temporary file in the
+// same directory, flushed to disk, and then renamed over the original file.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := createAtomic(filename, perm)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Abort()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+
+	return f.Commit()
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// backupName returns where the copy of the original file is written: next to
+// the file, with the suffix appended, or in the same relative location under
+// the backup folder. Paths outside of the working directory are mirrored by
+// their absolute path.
+func (e *Engine) backupName(filename string) (string, error) {
+	name := filename + e.opts.BackupSuffix
+
+	if e.opts.BackupDir == "" {
+		return name, nil
+	}
+
+	rel := cleanPath(name)
+
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		abspath, err := filepath.Abs(name)
+
+		if err != nil {
+			return "", err
+		}
+
+		rel = strings.TrimPrefix(abspath, filepath.VolumeName(abspath))
+	}
+
+	return filepath.Join(e.opts.BackupDir, rel), nil
+}
+
+// backup copies the file before it is modified, keeping its permissions.
+func (e *Engine) backup(filename string) error {
+	name, err := e.backupName(filename)
+
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	fi, err := file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	return copyToFile(name, file, fi.Mode().Perm())
+}
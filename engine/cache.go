@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultCacheDir is the folder where the files that did not match the rules
+// are recorded, so they are skipped by the next executions.
+var DefaultCacheDir = filepath.Join(StateDir, "cache")
+
+// cacheName is the name of the file, inside the cache folder, with the files
+// that did not match.
+const cacheName = "files.json"
+
+// cacheVersion changes when the meaning of the recorded rules changes.
+const cacheVersion = 1
+
+// maxCleanRules is the number of rules remembered for every file, the most
+// recent ones, which is enough while the rules are tweaked one at a time.
+const maxCleanRules = 64
+
+// cache records the files that did not match some rules. A file is skipped,
+// without being read, while its size and modification time do not change
+// and all its rules are recorded. If only the modification time changed, i.e.
+// after a checkout, the content is compared with the recorded checksum.
+type cache struct {
+	filename string
+
+	mu      sync.Mutex
+	Version int                   `json:"version"`
+	Files   map[string]cacheEntry `json:"files"`
+}
+
+// cacheEntry describes one file that did not match the Clean rules.
+type cacheEntry struct {
+	Size     int64    `json:"size"`
+	ModTime  int64    `json:"mtime"`
+	Checksum string   `json:"sha256"`
+	Clean    []string `json:"clean"`
+}
+
+// loadCache reads the cache in the folder. A missing, invalid or outdated
+// cache is replaced by an empty one.
+func loadCache(dir string) *cache {
+	c := &cache{filename: filepath.Join(dir, cacheName)}
+
+	if data, err := os.ReadFile(c.filename); err == nil {
+		if json.Unmarshal(data, c) != nil || c.Version != cacheVersion {
+			c.Files = nil
+		}
+	}
+
+	c.Version = cacheVersion
+
+	if c.Files == nil {
+		c.Files = map[string]cacheEntry{}
+	}
+
+	return c
+}
+
+// ruleKey identifies what the rule matches, regardless of the replacement,
+// so tweaking a replacement, or adding a rule, keeps the recorded files.
+func (e *Engine) ruleKey(rule *Rule) string {
+	text := fmt.Sprintf("%q %+v %q %v %v", rule.Search, rule.Options, e.opts.Lang, e.opts.Only, e.opts.Skip)
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// cached reports whether the file can be skipped because it did not match
+// any of the rules, and it did not change, since it was recorded.
+func (e *Engine) cached(filename string, fi os.FileInfo, rs RuleSet) bool {
+	e.cache.mu.Lock()
+	entry, ok := e.cache.Files[cleanPath(filename)]
+	e.cache.mu.Unlock()
+
+	if !ok || entry.Size != fi.Size() {
+		return false
+	}
+
+	clean := map[string]bool{}
+
+	for _, key := range entry.Clean {
+		clean[key] = true
+	}
+
+	for _, rule := range rs {
+		if !clean[e.ruleKey(rule)] {
+			return false
+		}
+	}
+
+	if entry.ModTime == fi.ModTime().UnixNano() {
+		return true
+	}
+
+	if sum, err := checksumFile(filename); err != nil || sum != entry.Checksum {
+		return false
+	}
+
+	entry.ModTime = fi.ModTime().UnixNano()
+
+	e.cache.mu.Lock()
+	e.cache.Files[cleanPath(filename)] = entry
+	e.cache.mu.Unlock()
+
+	return true
+}
+
+// recordClean records that the file, with the checksum, did not match the
+// rules. The rules recorded for a previous content are forgotten.
+func (e *Engine) recordClean(filename string, fi os.FileInfo, sum string, rs RuleSet) {
+	e.cache.mu.Lock()
+	defer e.cache.mu.Unlock()
+
+	entry := e.cache.Files[cleanPath(filename)]
+
+	if entry.Checksum != sum {
+		entry = cacheEntry{Checksum: sum}
+	}
+
+	entry.Size = fi.Size()
+	entry.ModTime = fi.ModTime().UnixNano()
+
+	for _, rule := range rs {
+		key := e.ruleKey(rule)
+
+		var found bool
+
+		for _, k := range entry.Clean {
+			found = found || k == key
+		}
+
+		if !found {
+			entry.Clean = append(entry.Clean, key)
+		}
+	}
+
+	if len(entry.Clean) > maxCleanRules {
+		entry.Clean = entry.Clean[len(entry.Clean)-maxCleanRules:]
+	}
+
+	e.cache.Files[cleanPath(filename)] = entry
+}
+
+// forget removes the file from the cache because it matched.
+func (e *Engine) forget(filename string) {
+	e.cache.mu.Lock()
+	delete(e.cache.Files, cleanPath(filename))
+	e.cache.mu.Unlock()
+}
+
+// saveCache writes the cache, if enabled.
+func (e *Engine) saveCache() error {
+	if e.cache == nil {
+		return nil
+	}
+
+	e.cache.mu.Lock()
+	data, err := json.Marshal(e.cache)
+	e.cache.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("json.Marshal %s", err)
+	}
+
+	if err := makeDir(filepath.Dir(e.cache.filename)); err != nil {
+		return fmt.Errorf("os.MkdirAll %s", err)
+	}
+
+	if err := writeFileAtomic(e.cache.filename, data, 0644); err != nil {
+		return fmt.Errorf("cache %s %s", e.cache.filename, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"regexp"
+	"strings"
+)
+
+// caseFilters are the conversions of the capture groups in the replacements
+// of the regular expressions, i.e. snake($1).
+var caseFilters = map[string]func(string) string{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"camel": func(text string) string { return applyCase(splitWords(text), caseCamel) },
+	"snake": func(text string) string { return applyCase(splitWords(text), caseSnake) },
+	"kebab": func(text string) string { return applyCase(splitWords(text), caseKebab) },
+}
+
+// caseFilterRef matches the conversions of the replacements: a function
+// applied to one group reference, i.e. camel(${name}), or \U and \L, which
+// convert the rest of the replacement to upper or lower case until \E.
+var caseFilterRef = regexp.MustCompile(`\b(upper|lower|camel|snake|kebab)\((\$(?:\{[^}]*\}|[a-zA-Z0-9_]+))\)|\\[ULE]`)
+
+// parseCaseFilters splits the text of a replacement at the conversions. The
+// text is returned as one single part if there are none.
+func parseCaseFilters(text string) []templatePart {
+	var parts []templatePart
+	var convert func(string) string
+	var last int
+
+	flush := func(end int) {
+		if end > last {
+			parts = append(parts, templatePart{text: text[last:end], convert: convert})
+		}
+	}
+
+	for _, m := range caseFilterRef.FindAllStringSubmatchIndex(text, -1) {
+		flush(m[0])
+
+		switch ref := text[m[0]:m[1]]; {
+		case ref == `\U`:
+			convert = strings.ToUpper
+		case ref == `\L`:
+			convert = strings.ToLower
+		case ref == `\E`:
+			convert = nil
+		default:
+			parts = append(parts, templatePart{text: text[m[4]:m[5]], convert: caseFilters[text[m[2]:m[3]]]})
+		}
+
+		last = m[1]
+	}
+
+	flush(len(text))
+
+	if len(parts) == 0 {
+		parts = append(parts, templatePart{text: text})
+	}
+
+	return parts
+}
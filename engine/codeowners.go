@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// CodeOwnersFiles are the locations of the CODEOWNERS file, relative to the
+// root of the repository, in the order they are looked up by GitHub.
+var CodeOwnersFiles = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// ErrNoCodeOwners is returned by FindCodeOwners when the repository has no
+// CODEOWNERS file.
+var ErrNoCodeOwners = errors.New("no CODEOWNERS file")
+
+// CodeOwners are the owners of the files of a repository, see Options.Owners.
+type CodeOwners struct {
+	// Filename is the CODEOWNERS file.
+	Filename string
+	// root is the absolute path of the folder the patterns are relative to.
+	root  string
+	rules []ownerRule
+}
+
+// ownerRule is one line of a CODEOWNERS file.
+type ownerRule struct {
+	re     *regexp.Regexp
+	owners []string
+}
+
+// FindCodeOwners reads the CODEOWNERS file of the repository containing the
+// folder, looking in the folder and then in its parents until the root of the
+// git repository.
+func FindCodeOwners(dir string) (*CodeOwners, error) {
+	dir, err := filepath.Abs(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		for _, name := range CodeOwnersFiles {
+			filename := filepath.Join(dir, filepath.FromSlash(name))
+
+			if data, err := os.ReadFile(filename); err == nil {
+				owners := ParseCodeOwners(data, dir)
+				owners.Filename = filename
+				return owners, nil
+			}
+		}
+
+		parent := filepath.Dir(dir)
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil || parent == dir {
+			return nil, ErrNoCodeOwners
+		}
+
+		dir = parent
+	}
+}
+
+// ParseCodeOwners reads the patterns of a CODEOWNERS file, relative to the
+// root folder. The patterns follow the rules of .gitignore, without the
+// negation; the lines without owners remove the owners of the files, and the
+// sections of GitLab are ignored.
+func ParseCodeOwners(data []byte, root string) *CodeOwners {
+	c := &CodeOwners{root: root}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "^[") {
+			continue
+		}
+
+		if k := strings.Index(line, " #"); k >= 0 {
+			line = line[:k]
+		}
+
+		fields := strings.Fields(line)
+		pattern := strings.TrimPrefix(fields[0], `\`)
+		suffix := "(?:/.*)?"
+
+		// a folder owns all of its files, but "docs/*" only owns the files
+		// directly inside of docs.
+		if strings.HasSuffix(pattern, "/*") {
+			suffix = ""
+		}
+
+		pattern = strings.TrimSuffix(pattern, "/")
+
+		if pattern == "" || pattern == "*" {
+			pattern = "**"
+		} else if strings.Contains(pattern, "/") {
+			pattern = strings.TrimPrefix(pattern, "/")
+		} else {
+			pattern = "**/" + pattern
+		}
+
+		re, err := regexp.Compile("^" + globToRegexp(pattern) + suffix + "$")
+
+		if err != nil {
+			continue
+		}
+
+		c.rules = append(c.rules, ownerRule{re: re, owners: fields[1:]})
+	}
+
+	return c
+}
+
+// Owners returns the owners of the file, or nil if it has none. The last
+// matching pattern takes precedence, as in GitHub.
+func (c *CodeOwners) Owners(name string) []string {
+	if abs, err := filepath.Abs(name); err == nil {
+		if rel, err := filepath.Rel(c.root, abs); err == nil {
+			name = rel
+		}
+	}
+
+	name = cleanPath(name)
+
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		if c.rules[i].re.MatchString(name) {
+			return c.rules[i].owners
+		}
+	}
+
+	return nil
+}
+
+// OwnedBy reports whether the file is owned by any of the owners, compared
+// without case like the names of the teams and the users of GitHub.
+func (c *CodeOwners) OwnedBy(name string, owners []string) bool {
+	for _, owner := range c.Owners(name) {
+		for _, want := range owners {
+			if strings.EqualFold(owner, want) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
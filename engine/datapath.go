@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keyPath selects the keys and the values of a data file, i.e. "a.b[0].c".
+// The elements are separated by dots; * matches any key or array index, and
+// [*] any array index. The path matches at any depth unless it begins with $,
+// which anchors it to the root of the document.
+type keyPath struct {
+	anchored bool
+	elements []string
+}
+
+// parseKeyPath parses the path of Options.Keys and Options.Values.
+func parseKeyPath(text string) (keyPath, error) {
+	var path keyPath
+
+	rest := text
+
+	if strings.HasPrefix(rest, "$") {
+		path.anchored = true
+		rest = strings.TrimPrefix(rest[1:], ".")
+	}
+
+	if rest == "" {
+		return path, fmt.Errorf("the path %q is empty", text)
+	}
+
+	for _, part := range strings.Split(rest, ".") {
+		name := part
+
+		if k := strings.IndexByte(part, '['); k >= 0 {
+			name = part[:k]
+		}
+
+		if name != "" {
+			path.elements = append(path.elements, name)
+		} else if part == name {
+			return path, fmt.Errorf("the path %q has an empty key", text)
+		}
+
+		for index := part[len(name):]; index != ""; {
+			end := strings.IndexByte(index, ']')
+
+			if index[0] != '[' || end < 0 {
+				return path, fmt.Errorf("the path %q has an invalid index %q", text, index)
+			}
+
+			if n := index[1:end]; n != "*" {
+				if _, err := strconv.Atoi(n); err != nil {
+					return path, fmt.Errorf("the path %q has an invalid index %q", text, index[:end+1])
+				}
+			}
+
+			path.elements = append(path.elements, index[:end+1])
+			index = index[end+1:]
+		}
+	}
+
+	return path, nil
+}
+
+// match reports whether the path selects the location, a list of keys and
+// array indices, like "[0]", from the root of the document.
+func (path keyPath) match(location []string) bool {
+	if len(location) < len(path.elements) || (path.anchored && len(location) != len(path.elements)) {
+		return false
+	}
+
+	location = location[len(location)-len(path.elements):]
+
+	for i, element := range path.elements {
+		isIndex := strings.HasPrefix(location[i], "[")
+
+		switch {
+		case element == "*":
+		case element == "[*]" && isIndex:
+		case element != location[i]:
+			return false
+		}
+	}
+
+	return true
+}
+
+// isKey reports whether the last element of the path is a key name.
+func (path keyPath) isKey() bool {
+	last := path.elements[len(path.elements)-1]
+
+	return last != "*" && !strings.HasPrefix(last, "[")
+}
+
+// dataToken is a key, or a scalar value, of a data file, with the location of
+// the value it belongs to.
+type dataToken struct {
+	start, end int
+	location   []string
+	key        bool
+}
+
+// dataFormat is a format of data files, identified by Options.Lang.
+type dataFormat struct {
+	// scan finds the keys and the scalar values of a file.
+	scan func(content []byte) ([]dataToken, error)
+	// key and value write the replacements of the keys and the values in
+	// the syntax of the format.
+	key, value func(text string) string
+}
+
+// dataFormats are the supported data formats.
+var dataFormats = map[string]dataFormat{
+	"json": {scan: scanJSON, key: jsonString, value: jsonText},
+	"yaml": {scan: scanYAML, key: yamlText, value: yamlText},
+}
+
+// dataPaths renames the keys, or replaces the values, of the data files
+// selected by the paths of the rules.
+type dataPaths struct {
+	format dataFormat
+	values bool
+	paths  map[*Rule]keyPath
+}
+
+func newDataPaths(lang string, values bool) (*dataPaths, error) {
+	format, ok := dataFormats[lang]
+
+	if !ok {
+		return nil, fmt.Errorf("keys and values are only supported for the json and yaml languages")
+	}
+
+	return &dataPaths{format: format, values: values, paths: map[*Rule]keyPath{}}, nil
+}
+
+// handles reports whether the file is in the format; Options.Lang already
+// restricts the files to the ones of the format.
+func (d *dataPaths) handles(filename string) bool {
+	return true
+}
+
+// compile compiles the rule of a path. The rule matches the path itself,
+// which is never used: the keys and the values are located by the scanner of
+// the format.
+func (d *dataPaths) compile(spec RuleSpec) (*Rule, error) {
+	path, err := parseKeyPath(spec.Search)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !d.values && !path.isKey() {
+		return nil, fmt.Errorf("the last element of the path %q must be a key", spec.Search)
+	}
+
+	if d.values {
+		spec.Replace = d.format.value(spec.Replace)
+	} else {
+		spec.Replace = d.format.key(spec.Replace)
+	}
+
+	rule, err := spec.Compile(RuleOptions{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.paths[rule] = path
+
+	return rule, nil
+}
+
+// matches returns the tokens of the content selected by the rules.
+func (d *dataPaths) matches(filename string, rs RuleSet, content []byte) ([]RuleMatch, error) {
+	tokens, err := d.format.scan(content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []RuleMatch
+
+	for _, tok := range tokens {
+		if tok.key == d.values {
+			continue
+		}
+
+		for _, rule := range rs {
+			if path, ok := d.paths[rule]; ok && path.match(tok.location) {
+				matches = append(matches, RuleMatch{Rule: rule, Loc: []int{tok.start, tok.end}})
+				break
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// locatedFindings groups the matches of the content by line, keeping the
+// matches of every finding since the rules cannot find them in the text.
+func locatedFindings(content []byte, matches []RuleMatch) []Finding {
+	findings := groupMatches(content, matches)
+	offsets := lineOffsets(content)
+
+	for i, j := 0, 0; i < len(findings); i++ {
+		start := offsets[findings[i].LineNumber-1]
+
+		for ; j < len(matches) && lineAt(offsets, matches[j].Loc[0]) <= findings[i].EndLine; j++ {
+			loc := []int{matches[j].Loc[0] - start, matches[j].Loc[1] - start}
+			findings[i].located = append(findings[i].located, RuleMatch{Rule: matches[j].Rule, Loc: loc})
+		}
+	}
+
+	return findings
+}
+
+// jsonText returns the text if it is valid JSON, or the text encoded as a
+// JSON string otherwise, i.e. for the values 1.2.3 or hello.
+func jsonText(text string) string {
+	if json.Valid([]byte(text)) {
+		return strings.TrimSpace(text)
+	}
+
+	return jsonString(text)
+}
+
+// jsonString encodes the text as a JSON string, without escaping the HTML
+// characters.
+func jsonString(text string) string {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(text)
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyPath(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    keyPath
+		wantErr bool
+	}{
+		{text: "a.b", want: keyPath{elements: []string{"a", "b"}}},
+		{text: "$.items[*].name", want: keyPath{anchored: true, elements: []string{"items", "[*]", "name"}}},
+		{text: "a[0][1]", want: keyPath{elements: []string{"a", "[0]", "[1]"}}},
+		{text: "*.name", want: keyPath{elements: []string{"*", "name"}}},
+		{text: "", wantErr: true},
+		{text: "$", wantErr: true},
+		{text: "a..b", wantErr: true},
+		{text: "a[x]", wantErr: true},
+		{text: "a[0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseKeyPath(tt.text)
+
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseKeyPath(%q) succeeded, want an error", tt.text)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseKeyPath(%q) %s", tt.text, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseKeyPath(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestKeyPathMatch(t *testing.T) {
+	tests := []struct {
+		path     string
+		location []string
+		want     bool
+	}{
+		{"a.b", []string{"a", "b"}, true},
+		{"a.b", []string{"x", "a", "b"}, true},
+		{"$.a.b", []string{"x", "a", "b"}, false},
+		{"$.a.b", []string{"a", "b"}, true},
+		{"items[*].name", []string{"items", "[3]", "name"}, true},
+		{"items[*].name", []string{"items", "key", "name"}, false},
+		{"*.name", []string{"items", "[3]", "name"}, true},
+		{"a.b", []string{"b"}, false},
+	}
+
+	for _, tt := range tests {
+		path, err := parseKeyPath(tt.path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := path.match(tt.location); got != tt.want {
+			t.Errorf("%q.match(%q) = %v, want %v", tt.path, tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestDataPathsApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		lang    string
+		values  bool
+		path    string
+		to      string
+		content string
+		want    string
+	}{
+		{
+			name:    "json key",
+			lang:    "json",
+			path:    "user.name",
+			to:      "login",
+			content: `{"user": {"name": "a", "id": 1}, "name": "b"}`,
+			want:    `{"user": {"login": "a", "id": 1}, "name": "b"}`,
+		},
+		{
+			name:    "json value",
+			lang:    "json",
+			values:  true,
+			path:    "$.items[*].host",
+			to:      "new.example.com",
+			content: `{"items": [{"host": "old"}, {"host": "old"}], "host": "old"}`,
+			want:    `{"items": [{"host": "new.example.com"}, {"host": "new.example.com"}], "host": "old"}`,
+		},
+		{
+			name:    "yaml key",
+			lang:    "yaml",
+			path:    "spec.replicas",
+			to:      "count",
+			content: "spec:\n  replicas: 2\nreplicas: 1\n",
+			want:    "spec:\n  count: 2\nreplicas: 1\n",
+		},
+		{
+			name:    "yaml value in a list",
+			lang:    "yaml",
+			values:  true,
+			path:    "images[*]",
+			to:      "nginx:2",
+			content: "images:\n  - nginx:1\n  - redis:1\n",
+			want:    "images:\n  - nginx:2\n  - nginx:2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "data."+tt.lang)
+
+			if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := New(Options{
+				Rules:  []RuleSpec{{Search: tt.path, Replace: tt.to}},
+				Lang:   tt.lang,
+				Keys:   !tt.values,
+				Values: tt.values,
+				Paths:  []string{filename},
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := e.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(filename)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Fatalf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultExclude is the list of patterns skipped by the walker unless
+// Options.NoDefaultFilters is set: version control metadata, vendored
+// dependencies and build artifacts, which are rarely meant to be modified.
+var DefaultExclude = []string{
+	".git",
+	"vendor",
+	"node_modules",
+	"dist",
+	"*.min.js",
+}
+
+// generatedHeader matches the comment recommended by the Go project, and
+// adopted by many other code generators, to mark files that must not be
+// edited by hand: "// Code generated by protoc-gen-go. DO NOT EDIT."
+var generatedHeader = regexp.MustCompile(`(?m)^\W*Code generated .*DO NOT EDIT`)
+
+// isGenerated reports whether the beginning of the file has the header of a
+// generated file.
+func isGenerated(head []byte) bool {
+	return generatedHeader.Match(head)
+}
+
+// skipDefault reports whether the file found while walking the folder matches
+// one of the default exclusions. The path is relative to the folder, so the
+// folder itself can be a vendored directory explicitly requested by the user.
+func (e *Engine) skipDefault(root string, name string) bool {
+	if e.defaults == nil {
+		return false
+	}
+
+	if rel, err := filepath.Rel(root, name); err == nil {
+		name = rel
+	}
+
+	return !e.defaults.Allow(name)
+}
+
+// skipHidden reports whether the file found inside the folder, or any of its
+// parent directories up to the folder, is hidden and Options.Hidden is not
+// set. The folder itself is never hidden because it was requested by the user.
+func (e *Engine) skipHidden(root string, name string) bool {
+	if e.opts.Hidden {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, name)
+
+	if err != nil {
+		rel = name
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if len(part) > 1 && part[0] == '.' && part != ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tooDeep reports whether the file, or the content of the directory, found
+// inside the folder is deeper than Options.MaxDepth.
+func (e *Engine) tooDeep(root string, name string, dir bool) bool {
+	if e.opts.MaxDepth <= 0 {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, name)
+
+	if err != nil || rel == "." {
+		return false
+	}
+
+	depth := strings.Count(filepath.ToSlash(rel), "/") + 1
+
+	if dir {
+		return depth >= e.opts.MaxDepth
+	}
+
+	return depth > e.opts.MaxDepth
+}
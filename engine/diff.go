@@ -0,0 +1,295 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// diffContext is the number of unchanged lines around every hunk.
+const diffContext = 3
+
+// maxDiffTable is the largest table used to compute the longest common
+// subsequence of two regions without unique lines in common. Larger regions
+// are reported as a block of deletions followed by a block of insertions.
+const maxDiffTable = 1 << 22
+
+// diffOp is one line of the edit script: an unchanged line (' '), a line
+// deleted from the original (-) or a line inserted in the new version (+).
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// splitLines splits the content keeping the line terminators, so a missing
+// newline at the end of the file is also a difference.
+func splitLines(content []byte) []string {
+	var lines []string
+
+	for len(content) > 0 {
+		i := bytes.IndexByte(content, '\n') + 1
+
+		if i == 0 {
+			i = len(content)
+		}
+
+		lines = append(lines, string(content[:i]))
+		content = content[i:]
+	}
+
+	return lines
+}
+
+// diffLines computes the edit script between the two lists of lines. The
+// lines that occur once in both lists are used as anchors, as in the patience
+// diff algorithm, and the regions between them are compared recursively.
+func diffLines(a, b []string) []diffOp {
+	var ops []diffOp
+	diffRange(a, b, 0, 0, &ops)
+	return ops
+}
+
+func diffRange(a, b []string, aoff, boff int, ops *[]diffOp) {
+	var suffix int
+
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		*ops = append(*ops, diffOp{' ', aoff, boff})
+		a, b, aoff, boff = a[1:], b[1:], aoff+1, boff+1
+	}
+
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch anchors := uniqueAnchors(a, b); {
+	case len(a) == 0 || len(b) == 0:
+		diffBlock(a, b, aoff, boff, ops)
+	case len(anchors) > 0:
+		var i, j int
+		for _, anchor := range anchors {
+			diffRange(a[i:anchor[0]], b[j:anchor[1]], aoff+i, boff+j, ops)
+			*ops = append(*ops, diffOp{' ', aoff + anchor[0], boff + anchor[1]})
+			i, j = anchor[0]+1, anchor[1]+1
+		}
+		diffRange(a[i:], b[j:], aoff+i, boff+j, ops)
+	case len(a)*len(b) <= maxDiffTable:
+		diffTable(a, b, aoff, boff, ops)
+	default:
+		diffBlock(a, b, aoff, boff, ops)
+	}
+
+	for k := suffix; k > 0; k-- {
+		*ops = append(*ops, diffOp{' ', aoff + len(a) + suffix - k, boff + len(b) + suffix - k})
+	}
+}
+
+// diffBlock deletes all the lines of a and inserts all the lines of b.
+func diffBlock(a, b []string, aoff, boff int, ops *[]diffOp) {
+	for i := range a {
+		*ops = append(*ops, diffOp{'-', aoff + i, boff})
+	}
+
+	for j := range b {
+		*ops = append(*ops, diffOp{'+', aoff + len(a), boff + j})
+	}
+}
+
+// uniqueAnchors returns the longest increasing sequence of the pairs of lines
+// that occur exactly once in a and once in b.
+func uniqueAnchors(a, b []string) [][2]int {
+	count := map[string][2]int{}
+	where := map[string][2]int{}
+
+	for i, line := range a {
+		c := count[line]
+		c[0]++
+		count[line] = c
+		w := where[line]
+		w[0] = i
+		where[line] = w
+	}
+
+	for j, line := range b {
+		c := count[line]
+		c[1]++
+		count[line] = c
+		w := where[line]
+		w[1] = j
+		where[line] = w
+	}
+
+	var pairs [][2]int
+
+	for line, c := range count {
+		if c[0] == 1 && c[1] == 1 {
+			pairs = append(pairs, where[line])
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+
+	// patience sorting: tails[k] is the index of the pair ending the longest
+	// increasing sequence of length k+1.
+	var tails []int
+	prev := make([]int, len(pairs))
+
+	for i, pair := range pairs {
+		k := sort.Search(len(tails), func(k int) bool { return pairs[tails[k]][1] >= pair[1] })
+
+		if k > 0 {
+			prev[i] = tails[k-1]
+		} else {
+			prev[i] = -1
+		}
+
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+
+	if len(tails) == 0 {
+		return nil
+	}
+
+	anchors := make([][2]int, len(tails))
+
+	for i, k := tails[len(tails)-1], len(tails)-1; k >= 0; i, k = prev[i], k-1 {
+		anchors[k] = pairs[i]
+	}
+
+	return anchors
+}
+
+// diffTable computes the longest common subsequence with dynamic programming.
+func diffTable(a, b []string, aoff, boff int, ops *[]diffOp) {
+	n, m := len(a), len(b)
+	table := make([]int32, (n+1)*(m+1))
+
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i*(m+1)+j] = table[(i+1)*(m+1)+j+1] + 1
+			} else if x, y := table[(i+1)*(m+1)+j], table[i*(m+1)+j+1]; x >= y {
+				table[i*(m+1)+j] = x
+			} else {
+				table[i*(m+1)+j] = y
+			}
+		}
+	}
+
+	var i, j int
+
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			*ops = append(*ops, diffOp{' ', aoff + i, boff + j})
+			i, j = i+1, j+1
+		case table[(i+1)*(m+1)+j] >= table[i*(m+1)+j+1]:
+			*ops = append(*ops, diffOp{'-', aoff + i, boff + j})
+			i++
+		default:
+			*ops = append(*ops, diffOp{'+', aoff + i, boff + j})
+			j++
+		}
+	}
+
+	diffBlock(a[i:], b[j:], aoff+i, boff+j, ops)
+}
+
+// UnifiedDiff returns the differences between the original and the modified
+// content in the unified format, with the "a/" and "b/" prefixes used by git,
+// or nothing if the contents are equal.
+func UnifiedDiff(filename string, original []byte, modified []byte) []byte {
+	a, b := splitLines(original), splitLines(modified)
+	ops := diffLines(a, b)
+
+	var out bytes.Buffer
+
+	for start := 0; start < len(ops); {
+		// find the next change and extend the hunk while the changes are
+		// separated by less than twice the context.
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+
+		if start == len(ops) {
+			break
+		}
+
+		end := start
+
+		for k := start; k < len(ops) && k-end <= 2*diffContext; k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			}
+		}
+
+		first, last := start-diffContext, end+diffContext
+
+		if first < 0 {
+			first = 0
+		}
+
+		if last > len(ops) {
+			last = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", cleanPath(filename), cleanPath(filename))
+		}
+
+		writeHunk(&out, a, b, ops[first:last])
+		start = last
+	}
+
+	return out.Bytes()
+}
+
+// writeHunk formats one hunk of the edit script.
+func writeHunk(out *bytes.Buffer, a, b []string, ops []diffOp) {
+	var acount, bcount int
+
+	for _, op := range ops {
+		if op.kind != '+' {
+			acount++
+		}
+		if op.kind != '-' {
+			bcount++
+		}
+	}
+
+	// an empty range is identified by the line before it.
+	astart, bstart := ops[0].a+1, ops[0].b+1
+
+	if acount == 0 {
+		astart--
+	}
+
+	if bcount == 0 {
+		bstart--
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", astart, acount, bstart, bcount)
+
+	for _, op := range ops {
+		var line string
+
+		// the index of the other side can be past its end.
+		if op.kind == '+' {
+			line = b[op.b]
+		} else {
+			line = a[op.a]
+		}
+
+		out.WriteByte(op.kind)
+		out.WriteString(line)
+
+		if line == "" || line[len(line)-1] != '\n' {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding is the character encoding of a file. The files are converted to
+// UTF-8 before they are searched and converted back when they are written.
+type Encoding int
+
+const (
+	UTF8 Encoding = iota
+	UTF8BOM
+	UTF16LE
+	UTF16LEBOM
+	UTF16BE
+	UTF16BEBOM
+	Latin1
+)
+
+var encodingNames = map[Encoding]string{
+	UTF8:       "utf-8",
+	UTF8BOM:    "utf-8 with bom",
+	UTF16LE:    "utf-16le",
+	UTF16LEBOM: "utf-16le with bom",
+	UTF16BE:    "utf-16be",
+	UTF16BEBOM: "utf-16be with bom",
+	Latin1:     "latin-1",
+}
+
+func (enc Encoding) String() string {
+	return encodingNames[enc]
+}
+
+// ErrLossyEncoding is returned when a file cannot be written back in its
+// original encoding without losing characters.
+var ErrLossyEncoding = errors.New("cannot be converted without loss")
+
+// bom returns the byte order mark written at the beginning of the file.
+func (enc Encoding) bom() []byte {
+	switch enc {
+	case UTF8BOM:
+		return []byte{0xEF, 0xBB, 0xBF}
+	case UTF16LEBOM:
+		return []byte{0xFF, 0xFE}
+	case UTF16BEBOM:
+		return []byte{0xFE, 0xFF}
+	}
+
+	return nil
+}
+
+// order returns the byte order of the UTF-16 encodings.
+func (enc Encoding) order() binary.ByteOrder {
+	if enc == UTF16BE || enc == UTF16BEBOM {
+		return binary.BigEndian
+	}
+
+	return binary.LittleEndian
+}
+
+// detectEncoding guesses the encoding from the beginning of a file. Without a
+// byte order mark, UTF-16 is recognized by the NUL bytes of the ASCII
+// characters, and data that is not valid UTF-8 is assumed to be Latin-1.
+func detectEncoding(head []byte) Encoding {
+	for _, enc := range []Encoding{UTF8BOM, UTF16LEBOM, UTF16BEBOM} {
+		if bytes.HasPrefix(head, enc.bom()) {
+			return enc
+		}
+	}
+
+	if pairs := len(head) / 2; pairs >= 2 {
+		var even, odd int
+
+		for i := 0; i+1 < len(head); i += 2 {
+			if head[i] == 0 {
+				even++
+			}
+			if head[i+1] == 0 {
+				odd++
+			}
+		}
+
+		if odd*10 >= pairs*4 && even*10 < pairs {
+			return UTF16LE
+		}
+
+		if even*10 >= pairs*4 && odd*10 < pairs {
+			return UTF16BE
+		}
+	}
+
+	if utf8.Valid(head) {
+		return UTF8
+	}
+
+	// the data may end in the middle of a multibyte character.
+	for i := 1; i < utf8.UTFMax && i < len(head); i++ {
+		if utf8.Valid(head[:len(head)-i]) {
+			return UTF8
+		}
+	}
+
+	if bytes.IndexByte(head, 0) >= 0 {
+		return UTF8 /* binary data */
+	}
+
+	return Latin1
+}
+
+// decode converts the content of the file to UTF-8, without byte order mark.
+func (enc Encoding) decode(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(data, enc.bom())
+
+	switch enc {
+	case UTF16LE, UTF16LEBOM, UTF16BE, UTF16BEBOM:
+		if len(data)%2 != 0 {
+			return nil, fmt.Errorf("odd number of bytes in %s data", enc)
+		}
+
+		units := make([]uint16, len(data)/2)
+
+		for i := range units {
+			units[i] = enc.order().Uint16(data[2*i:])
+		}
+
+		return []byte(string(utf16.Decode(units))), nil
+	case Latin1:
+		var buf [utf8.UTFMax]byte
+
+		out := make([]byte, 0, len(data))
+
+		for _, c := range data {
+			n := utf8.EncodeRune(buf[:], rune(c))
+			out = append(out, buf[:n]...)
+		}
+
+		return out, nil
+	}
+
+	return data, nil
+}
+
+// encode converts the UTF-8 text back to the encoding of the file.
+func (enc Encoding) encode(text []byte) ([]byte, error) {
+	out := append([]byte{}, enc.bom()...)
+
+	switch enc {
+	case UTF16LE, UTF16LEBOM, UTF16BE, UTF16BEBOM:
+		for _, unit := range utf16.Encode([]rune(string(text))) {
+			var buf [2]byte
+			enc.order().PutUint16(buf[:], unit)
+			out = append(out, buf[:]...)
+		}
+
+		return out, nil
+	case Latin1:
+		for _, r := range string(text) {
+			if r > 0xFF {
+				return nil, fmt.Errorf("%q %w to %s", r, ErrLossyEncoding, enc)
+			}
+			out = append(out, byte(r))
+		}
+
+		return out, nil
+	}
+
+	return append(out, text...), nil
+}
+
+// ReadText returns the content of the file converted to UTF-8, the same text
+// that is searched, regardless of the encoding of the file.
+func ReadText(filename string) ([]byte, error) {
+	_, _, text, err := readText(filename)
+	return text, err
+}
+
+// readText returns the raw content of the file, its encoding and the content
+// converted to UTF-8.
+func readText(filename string) ([]byte, Encoding, []byte, error) {
+	raw, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, UTF8, nil, err
+	}
+
+	enc, text, err := decodeText(filename, raw)
+
+	if err != nil {
+		return nil, UTF8, nil, err
+	}
+
+	return raw, enc, text, nil
+}
+
+// decodeText detects the encoding of the raw content of the file and
+// converts it to UTF-8.
+func decodeText(filename string, raw []byte) (Encoding, []byte, error) {
+	head := raw
+
+	if len(head) > sniffLength {
+		head = head[:sniffLength]
+	}
+
+	enc := detectEncoding(head)
+	text, err := enc.transcode(raw)
+
+	if err != nil {
+		return UTF8, nil, fmt.Errorf("%s %s", filename, err)
+	}
+
+	return enc, text, nil
+}
+
+// transcode converts the content to UTF-8 and verifies that it can be
+// converted back to exactly the same bytes, so invalid sequences are not
+// silently replaced when the file is written.
+func (enc Encoding) transcode(data []byte) ([]byte, error) {
+	text, err := enc.decode(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if enc != UTF8 {
+		back, err := enc.encode(text)
+
+		if err != nil || !bytes.Equal(back, data) {
+			return nil, fmt.Errorf("%s data %w", enc, ErrLossyEncoding)
+		}
+	}
+
+	return text, nil
+}
@@ -0,0 +1,1546 @@
+// Package engine implements the search and replace operations of refactor so
+// they can be embedded in other programs. Results are returned as values and
+// never printed, leaving the presentation to the caller.
+//
+//	e, err := engine.New(engine.Options{
+//		Rules: []engine.RuleSpec{{Search: "userName", Replace: "accountName"}},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	results, err := e.Search(ctx)
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultConcurrency is the number of files processed at the same time when
+// Options.Concurrency is not specified. Processing a file is mostly waiting for
+// the disk, so a few files per CPU keeps the processors busy without flooding
+// slow or network file systems with open files.
+var DefaultConcurrency = 4 * runtime.NumCPU()
+
+// sniffLength is the number of bytes inspected to detect binary files.
+const sniffLength = 8192
+
+// mmapThreshold is the size from which the files are mapped in memory while
+// they are searched, instead of being copied through a buffered reader.
+const mmapThreshold = 1 << 20
+
+// MaxLineLength is the length of the longest line that can be searched, one
+// line at a time, before the file is reported with ErrLineTooLong. Minified
+// files often have lines longer than the default of bufio.Scanner.
+var MaxLineLength = 256 << 20
+
+// ErrLineTooLong is reported for files with a line longer than MaxLineLength.
+var ErrLineTooLong = errors.New("line too long")
+
+// ErrTooManyFiles is returned when there are more files than Options.MaxFiles.
+var ErrTooManyFiles = errors.New("too many files")
+
+// ErrChanged is returned by ApplyFile for the files modified after they were
+// searched, i.e. in an editor during an interactive session, unless
+// Options.Force is set.
+var ErrChanged = errors.New("modified after it was searched")
+
+// Options configures the engine.
+type Options struct {
+	// Rules is the ordered list of search and replace operations.
+	Rules []RuleSpec
+	// Regexp, IgnoreCase, WholeWord, PreserveCase, Structural and
+	// Placeholders are the default values for the rules that do not specify
+	// them.
+	Regexp       bool
+	IgnoreCase   bool
+	WholeWord    bool
+	PreserveCase bool
+	Structural   bool
+	Placeholders bool
+	// Normalize is the normal form, NormalizeNFC or NormalizeNFD, of the
+	// replacements. If not empty, the composed and decomposed forms of the
+	// accented letters of the search texts match each other.
+	Normalize string
+	// Multiline allows the patterns to match across lines. It is enabled
+	// automatically if the search text of any rule contains a newline.
+	Multiline bool
+	// Paths is the list of files and directories to process. Directories are
+	// processed recursively. If empty, all the files in the working directory
+	// are processed (recursively).
+	Paths []string
+	// Include and Exclude are glob patterns to select the files.
+	Include []string
+	Exclude []string
+	// NoIgnore disables the .gitignore rules when walking the tree.
+	NoIgnore bool
+	// NoDefaultFilters disables the DefaultExclude patterns and allows the
+	// search of generated files.
+	NoDefaultFilters bool
+	// Git lists the files tracked by git instead of walking the directories.
+	Git bool
+	// MaxDepth is the maximum number of directories below the folders that
+	// are walked: 1 only processes the files directly inside of them. If zero,
+	// there is no limit.
+	MaxDepth int
+	// MaxFileSize is the size, in bytes, of the largest file processed. The
+	// larger files are reported as skipped. If zero, there is no limit.
+	MaxFileSize int64
+	// MaxFiles aborts the execution, before any file is processed, if there
+	// are more files than this. If zero, there is no limit.
+	MaxFiles int
+	// Hidden includes the files and directories whose name starts with a dot,
+	// which are skipped by the walker unless they are explicitly listed.
+	Hidden bool
+	// Lang restricts the processing to the files of a registered language,
+	// i.e. "go" or "json", see RegisterLanguage.
+	Lang string
+	// Keys and Values change the meaning of the rules for the data files of
+	// Lang, "json" or "yaml": the search texts are paths, i.e. "a.b" or
+	// "$.items[*].name", see keyPath. Keys renames the last key of the
+	// paths to the replacements, and Values replaces the scalar values at
+	// the paths with the replacements, written as they are if they are
+	// valid scalars of the format or as strings otherwise. The formatting,
+	// the comments and the anchors of the files are preserved.
+	Keys   bool
+	Values bool
+	// Imports changes the meaning of the rules: the search texts are the
+	// paths of Go modules or packages, replaced only in the imports, the
+	// import comments and the go:generate directives of the Go files, and
+	// in the directives of go.mod and go.work. The packages inside the
+	// modules are renamed too, i.e. example.com/old/pkg, and the other
+	// files are not processed. go.sum is left to go mod tidy.
+	Imports bool
+	// Symbol renames the identifiers of the language instead of replacing the
+	// text, leaving strings, comments and longer identifiers untouched. The
+	// search text can be qualified with the package, i.e. "api.Client", to
+	// rename only the identifiers referring to the objects of that package.
+	Symbol bool
+	// Tags are the keys of the struct tags, i.e. "json", "yaml" or "db",
+	// whose values are renamed with the fields in symbol mode when they are
+	// the name of the field in any naming convention, i.e. user_id for the
+	// field UserID, keeping the convention. TagsOnly renames the values of
+	// the tags but not the fields, to change only the wire format.
+	Tags     []string
+	TagsOnly bool
+	// Only restricts the findings to the comments, to the string literals or
+	// to the identifiers, with the value ScopeComments, ScopeStrings or
+	// ScopeIdentifiers, and Skip ignores the findings inside of them. They
+	// can also be the kind of a syntax node, i.e. NodeFunctionName, and then
+	// the files of the languages whose parser does not find the node, or
+	// that are not registered, are reported with an error. Otherwise, the
+	// files of the languages that are not registered, see RegisterLanguage,
+	// are not processed when either of them is set.
+	Only string
+	Skip string
+	// Follow descends into the symbolic links to directories and processes
+	// the targets of the symbolic links to files. Every file and directory is
+	// visited once, even if it is reachable through multiple links.
+	Follow bool
+	// ChangedSince is a git reference. If not empty, only the files modified
+	// in the current branch since the reference are processed.
+	ChangedSince string
+	// Owners are the owners of the files in CodeOwners, like @org/team. If
+	// not empty, only the files owned by one of them are processed.
+	Owners     []string
+	CodeOwners *CodeOwners
+	// Binary allows processing files that look like binary data.
+	Binary bool
+	// Archives searches the members of the zip, jar, tar, tar.gz and gz
+	// files instead of skipping them as binary data. An archive is written
+	// again at once, with the same metadata, if any of its members changed.
+	Archives bool
+	// Concurrency is the maximum number of files processed at the same time.
+	Concurrency int
+	// StreamThreshold is the file size, in bytes, above which the files are
+	// processed in chunks instead of being loaded in memory. If zero, the
+	// value of DefaultStreamThreshold is used; if negative, files are never
+	// streamed.
+	StreamThreshold int64
+	// JournalDir is the folder where the original content of the modified
+	// files is recorded. If empty, the changes cannot be undone.
+	JournalDir string
+	// BackupSuffix and BackupDir enable a copy of every file before it is
+	// modified. The copy is named after the file, plus the suffix, either
+	// next to it or in the same relative location under BackupDir.
+	BackupSuffix string
+	BackupDir    string
+	// Formatters are executed on every modified file, in order, after the
+	// file is written.
+	Formatters []Formatter
+	// Force replaces the findings even if the file was modified after it was
+	// searched, which may replace the wrong lines.
+	Force bool
+	// Lines restricts the replacements to the ranges of lines. The ranges
+	// with a file name only apply to that file; the others to every file.
+	Lines []LineRange
+	// Unless is a regular expression. If not empty, the occurrences on the
+	// lines matching it are not replaced, i.e. "nolint|TODO".
+	Unless string
+	// MapCommand is a command, i.e. "base64", that reads the text of every
+	// occurrence from stdin and writes its replacement to stdout, instead of
+	// the replacements of the rules. If empty, the rules are used.
+	MapCommand string
+	// Plugin is the command of a plugin, see PluginProtocol, that finds the
+	// occurrences of the rules, computes their replacements, or both. A
+	// matcher replaces the patterns of the rules, which are compiled
+	// literally, so it cannot be combined with the options of the patterns.
+	// The plugin runs until the engine is closed.
+	Plugin string
+	// MaxPerLine replaces only the first occurrences of every line, up to
+	// this number, and FirstOnly only the first occurrence of every file. The
+	// other occurrences are not reported either. If zero, there is no limit.
+	MaxPerLine int
+	FirstOnly  bool
+	// CacheDir is the folder where the files that did not match the rules
+	// are recorded, i.e. DefaultCacheDir, so the next executions skip them
+	// while they are not modified. If empty, every file is searched.
+	CacheDir string
+	// StateFile is the file where the progress of Apply and ApplyFunc is
+	// recorded, i.e. DefaultStateFile, while they run. It is removed once
+	// every file is completed. With Resume, the files completed by the
+	// previous runs with the same rules are skipped, and the others are
+	// processed, so an interrupted run can be resumed.
+	StateFile string
+	Resume    bool
+}
+
+// Engine searches and replaces text in multiple files concurrently.
+type Engine struct {
+	opts   Options
+	rules  RuleSet
+	filter *FileFilter
+	// defaults is the filter with the DefaultExclude patterns, or nil.
+	defaults *FileFilter
+	// symbols locates the identifiers in symbol mode, or nil.
+	symbols *symbolTable
+	// locator finds the occurrences of Options.Keys, Options.Values and
+	// Options.Imports, or nil.
+	locator locator
+	// unless is the compiled Options.Unless, or nil.
+	unless *regexp.Regexp
+	// mapper runs Options.MapCommand, or nil.
+	mapper *commandMap
+	// plugin is the running Options.Plugin, or nil.
+	plugin  *plugin
+	journal *journal
+	cache   *cache
+	state   *runState
+	// watchDir is called with the directories of the walk in watch mode, or
+	// nil.
+	watchDir func(dir string)
+
+	mu       sync.Mutex
+	stats    Stats
+	progress Progress
+}
+
+// locator finds the occurrences of the rules with a parser instead of their
+// patterns, i.e. the keys of the data files. The rules are compiled by the
+// locator, which knows what their search texts mean.
+type locator interface {
+	handles(filename string) bool
+	compile(spec RuleSpec) (*Rule, error)
+	matches(filename string, rs RuleSet, content []byte) ([]RuleMatch, error)
+}
+
+// SearchResult holds the findings of one single file.
+type SearchResult struct {
+	Filename string
+	Findings []Finding
+	// Rules is the subset of rules applicable to the file.
+	Rules RuleSet
+	// Encoding is the character encoding of the file.
+	Encoding Encoding
+	// LineEndings describes the line terminators of the file.
+	LineEndings LineEndings
+	// Modified is true if the file was rewritten.
+	Modified bool
+	// Skipped is the reason why the file was not searched, if any.
+	Skipped string
+	// Err is the error found while processing the file, if any.
+	Err error
+
+	// raw is the content of the file read by the search, which is replaced
+	// without reading the file again when the findings are applied at once.
+	raw []byte
+	// size and modTime describe the file when it was searched.
+	size    int64
+	modTime time.Time
+	// archive is the format of the file if it is an archive, or empty.
+	archive string
+}
+
+// Finding is one line, or a range of lines in multiline mode, matching one or
+// more of the rules.
+type Finding struct {
+	LineNumber   int
+	EndLine      int
+	Occurrences  int
+	OriginalText string
+	// Positions is where every occurrence starts, in the order of the text.
+	Positions []Position
+	// Applied is true if the replacement was written to the file.
+	Applied bool
+
+	// limited is true if some occurrences of the text were left out of the
+	// positions, i.e. by Options.MaxPerLine.
+	limited bool
+	// Member is the name of the file inside the archive, see Options.Archives,
+	// where the line is, or empty.
+	Member string
+
+	// located are the occurrences found by a parser instead of the rules,
+	// i.e. the keys of the data files, relative to the text.
+	located []RuleMatch
+}
+
+// Position is the location of the first character of one occurrence.
+type Position struct {
+	// Line is the 1-based line number.
+	Line int `json:"line"`
+	// Column is the 1-based byte offset in the line, as expected by vim.
+	Column int `json:"column"`
+	// Char is the 1-based character offset in the line.
+	Char int `json:"char"`
+}
+
+// positionIn returns the position of the byte offset of the line.
+func positionIn(line []byte, row int, offset int) Position {
+	return Position{
+		Line:   row,
+		Column: offset + 1,
+		Char:   utf8.RuneCount(line[:offset]) + 1,
+	}
+}
+
+// New compiles the rules and prepares the engine.
+func New(opts Options) (*Engine, error) {
+	for _, spec := range opts.Rules {
+		if strings.Contains(spec.Search, "\n") || (spec.Options(opts.defaults()).Regexp && strings.Contains(spec.Search, `\n`)) {
+			opts.Multiline = true
+		}
+
+		// the holes of the structural templates can span multiple lines.
+		if spec.Options(opts.defaults()).Structural {
+			opts.Multiline = true
+		}
+	}
+
+	e := &Engine{opts: opts}
+
+	if opts.Lang != "" && languageNamed(opts.Lang) == nil {
+		return nil, fmt.Errorf("unsupported language %q", opts.Lang)
+	}
+
+	if len(opts.Owners) > 0 && opts.CodeOwners == nil {
+		return nil, fmt.Errorf("the owners require the CODEOWNERS file")
+	}
+
+	if !validScope(opts.Only) || !validScope(opts.Skip) {
+		return nil, fmt.Errorf("unsupported scope, use %q, %q, %q or a syntax node, i.e. %q", ScopeComments, ScopeStrings, ScopeIdentifiers, NodeFunctionName)
+	}
+
+	if opts.Lang != "" {
+		if err := unsupportedNode(languageNamed(opts.Lang), opts.Only, opts.Skip); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Symbol {
+		if opts.Archives {
+			return nil, fmt.Errorf("symbol mode cannot be combined with archives")
+		}
+
+		if opts.Only != "" || opts.Skip != "" {
+			return nil, fmt.Errorf("symbol mode cannot be combined with a scope")
+		}
+
+		if opts.Lang != "go" {
+			return nil, fmt.Errorf("symbol mode is only supported for the go language")
+		}
+
+		if opts.Regexp || opts.Multiline || opts.PreserveCase || opts.Placeholders {
+			return nil, fmt.Errorf("symbol mode cannot be combined with regexp, multiline, preserve-case, structural or placeholders")
+		}
+
+		if len(opts.Lines) > 0 || opts.Unless != "" || opts.MapCommand != "" || opts.MaxPerLine > 0 || opts.FirstOnly {
+			return nil, fmt.Errorf("symbol mode cannot be combined with line ranges, unless, map-cmd, max-per-line or first-only")
+		}
+
+		e.symbols = newSymbolTable()
+		e.symbols.tags, e.symbols.tagsOnly = opts.Tags, opts.TagsOnly
+	}
+
+	if (len(opts.Tags) > 0 || opts.TagsOnly) && !opts.Symbol {
+		return nil, fmt.Errorf("the struct tags are only renamed in symbol mode")
+	}
+
+	if opts.TagsOnly && len(opts.Tags) == 0 {
+		return nil, fmt.Errorf("tags-only needs the keys of the tags to rename")
+	}
+
+	if opts.Keys || opts.Values {
+		if opts.Keys && opts.Values {
+			return nil, fmt.Errorf("the keys and the values cannot be replaced at the same time")
+		}
+
+		if opts.Symbol || opts.Only != "" || opts.Skip != "" {
+			return nil, fmt.Errorf("keys and values cannot be combined with symbol mode or a scope")
+		}
+
+		if opts.Regexp || opts.Multiline || opts.PreserveCase || opts.Structural || opts.Placeholders || opts.Normalize != "" || opts.MapCommand != "" {
+			return nil, fmt.Errorf("keys and values cannot be combined with regexp, multiline, preserve-case, structural, placeholders, normalize or map-cmd")
+		}
+
+		data, err := newDataPaths(opts.Lang, opts.Values)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e.locator = data
+	}
+
+	if opts.Imports {
+		if e.locator != nil || opts.Symbol || opts.Only != "" || opts.Skip != "" {
+			return nil, fmt.Errorf("imports cannot be combined with keys, values, symbol mode or a scope")
+		}
+
+		if opts.Regexp || opts.Multiline || opts.PreserveCase || opts.Structural || opts.Placeholders || opts.Normalize != "" || opts.MapCommand != "" {
+			return nil, fmt.Errorf("imports cannot be combined with regexp, multiline, preserve-case, structural, placeholders, normalize or map-cmd")
+		}
+
+		e.locator = importPaths{}
+	}
+
+	for _, spec := range opts.Rules {
+		var rule *Rule
+		var err error
+
+		if e.symbols != nil {
+			rule, err = e.symbols.compileSymbol(spec, opts.defaults())
+		} else if e.locator != nil {
+			rule, err = e.locator.compile(spec)
+		} else {
+			rule, err = spec.Compile(opts.defaults())
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("rule %s %s", spec.Search, err)
+		}
+
+		e.rules = append(e.rules, rule)
+	}
+
+	if opts.MapCommand != "" {
+		mapper, err := newCommandMap(opts.MapCommand)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e.mapper = mapper
+
+		for _, rule := range e.rules {
+			rule.mapper = e.mapper
+		}
+	}
+
+	if opts.Unless != "" {
+		unless, err := regexp.Compile(opts.Unless)
+
+		if err != nil {
+			return nil, fmt.Errorf("unless %s %s", opts.Unless, err)
+		}
+
+		e.unless = unless
+	}
+
+	ff, err := NewFileFilter(opts.Include, opts.Exclude)
+
+	if err != nil {
+		return nil, fmt.Errorf("glob.Compile %s", err)
+	}
+
+	e.filter = ff
+
+	if !opts.NoDefaultFilters {
+		if e.defaults, err = NewFileFilter(nil, DefaultExclude); err != nil {
+			return nil, fmt.Errorf("glob.Compile %s", err)
+		}
+	}
+
+	if opts.JournalDir != "" {
+		e.journal = newJournal(opts.JournalDir)
+	}
+
+	if opts.CacheDir != "" {
+		// the identifiers depend on the other files of the package.
+		if opts.Symbol {
+			return nil, fmt.Errorf("the cache cannot be combined with symbol mode")
+		}
+
+		e.cache = loadCache(opts.CacheDir)
+	}
+
+	if opts.Resume && opts.StateFile == "" {
+		return nil, fmt.Errorf("resume requires the state file")
+	}
+
+	if opts.StateFile != "" {
+		if e.state, err = loadState(opts.StateFile, stateKey(opts), opts.Resume); err != nil {
+			return nil, err
+		}
+	}
+
+	// the plugin starts once the options are valid, so it is never left
+	// running by a failure.
+	if opts.Plugin != "" {
+		if err := e.startPlugin(); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// startPlugin runs Options.Plugin. The rules of a matcher are compiled again,
+// literally, and the replacer computes the replacements of every rule.
+func (e *Engine) startPlugin() error {
+	opts := e.opts
+
+	if opts.Symbol || opts.MapCommand != "" {
+		return fmt.Errorf("the plugin cannot be combined with symbol mode or map-cmd")
+	}
+
+	p, err := startPlugin(opts.Plugin)
+
+	if err != nil {
+		return err
+	}
+
+	if p.matcher {
+		if e.locator != nil || opts.Only != "" || opts.Skip != "" {
+			p.close()
+			return fmt.Errorf("a matcher plugin cannot be combined with keys, values, imports or a scope")
+		}
+
+		if opts.Regexp || opts.IgnoreCase || opts.WholeWord || opts.Multiline || opts.PreserveCase || opts.Structural || opts.Placeholders || opts.Normalize != "" {
+			p.close()
+			return fmt.Errorf("a matcher plugin cannot be combined with regexp, ignore-case, whole-word, multiline, preserve-case, structural, placeholders or normalize")
+		}
+
+		e.locator, e.rules = p, nil
+
+		for _, spec := range opts.Rules {
+			rule, err := p.compile(spec)
+
+			if err != nil {
+				p.close()
+				return fmt.Errorf("rule %s %s", spec.Search, err)
+			}
+
+			e.rules = append(e.rules, rule)
+		}
+	}
+
+	if p.replacer {
+		for _, rule := range e.rules {
+			rule.mapper = pluginReplacer{plugin: p, rule: pluginRule{Search: rule.Search, Replace: rule.Replace}}
+		}
+	}
+
+	e.plugin = p
+
+	return nil
+}
+
+// Close stops Options.Plugin, if any. The engine cannot replace anything once
+// it is closed.
+func (e *Engine) Close() error {
+	if e.plugin == nil {
+		return nil
+	}
+
+	return e.plugin.close()
+}
+
+// defaults returns the matching options inherited by the rules.
+func (opts Options) defaults() RuleOptions {
+	return RuleOptions{
+		Regexp:       opts.Regexp,
+		IgnoreCase:   opts.IgnoreCase,
+		WholeWord:    opts.WholeWord,
+		PreserveCase: opts.PreserveCase,
+		Multiline:    opts.Multiline,
+		Structural:   opts.Structural,
+		Placeholders: opts.Placeholders,
+		Normalize:    opts.Normalize,
+	}
+}
+
+// Rules returns the compiled rules.
+func (e *Engine) Rules() RuleSet {
+	return e.rules
+}
+
+// Search finds the occurrences of the rules without modifying any file. The
+// results are sorted by file name and only include files with findings,
+// errors or skipped because of a limit.
+func (e *Engine) Search(ctx context.Context) ([]SearchResult, error) {
+	return e.collect(ctx, e.SearchFunc)
+}
+
+// Apply finds and replaces the occurrences of the rules in all the files.
+func (e *Engine) Apply(ctx context.Context) ([]SearchResult, error) {
+	return e.collect(ctx, e.ApplyFunc)
+}
+
+// SearchFunc is like Search but calls fn as soon as each file is processed,
+// instead of collecting the results. The files are processed concurrently but
+// the results are always reported in the order of the file names, and the
+// function is never called by more than one goroutine at the same time.
+func (e *Engine) SearchFunc(ctx context.Context, fn func(SearchResult)) error {
+	return e.run(ctx, false, fn)
+}
+
+// ApplyFunc is like Apply but calls fn as soon as each file is processed.
+func (e *Engine) ApplyFunc(ctx context.Context, fn func(SearchResult)) error {
+	return e.run(ctx, true, fn)
+}
+
+func (e *Engine) collect(ctx context.Context, walk func(context.Context, func(SearchResult)) error) ([]SearchResult, error) {
+	var results []SearchResult
+
+	err := walk(ctx, func(res SearchResult) {
+		results = append(results, res)
+	})
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Filename < results[j].Filename
+	})
+
+	return results, err
+}
+
+// run processes the files concurrently, as they are found, optionally
+// applying the changes. When the context is canceled no new files are
+// processed, but the files that are already being written are allowed to
+// finish so none of them is left in an inconsistent state. Those files are
+// still reported to the callback.
+func (e *Engine) run(ctx context.Context, apply bool, fn func(SearchResult)) (err error) {
+	if apply && e.state != nil {
+		if err := e.state.open(); err != nil {
+			return err
+		}
+
+		defer func() {
+			if serr := e.state.close(err != nil); err == nil {
+				err = serr
+			}
+		}()
+	}
+
+	// the packages are type-checked together, and the limit on the number of
+	// files must be verified before any of them is processed.
+	if e.symbols != nil || e.opts.MaxFiles > 0 {
+		files, err := e.files(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if err := e.process(ctx, files, apply, fn); err != nil {
+			return err
+		}
+
+		return e.saveCache()
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	files := make(chan string)
+	walked := make(chan error, 1)
+
+	go func() {
+		defer close(files)
+
+		walked <- e.eachFile(wctx, func(name string) error {
+			select {
+			case files <- name:
+				return nil
+			case <-wctx.Done():
+				return wctx.Err()
+			}
+		})
+	}()
+
+	err = e.pipeline(ctx, files, apply, fn)
+
+	// the walk is still running if the processing was canceled.
+	cancel()
+
+	if werr := <-walked; err == nil {
+		err = werr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return e.saveCache()
+}
+
+// process is like run but with a list of files.
+func (e *Engine) process(ctx context.Context, files []string, apply bool, fn func(SearchResult)) error {
+	sort.Strings(files)
+
+	if e.symbols != nil {
+		// the packages are loaded before any file is renamed, otherwise the
+		// references to the renamed identifiers could no longer be resolved.
+		e.symbols.prepare(files)
+	}
+
+	list := make(chan string)
+
+	go func() {
+		defer close(list)
+
+		for _, filename := range files {
+			select {
+			case list <- filename:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return e.pipeline(ctx, list, apply, fn)
+}
+
+// pipeline searches the files received from the channel, and optionally
+// applies the changes, while the next files are found. The results are
+// reported in the order of the files.
+func (e *Engine) pipeline(ctx context.Context, files <-chan string, apply bool, fn func(SearchResult)) error {
+	var wg sync.WaitGroup
+
+	sem := make(chan bool, e.concurrency())
+	result := make(chan indexedResult)
+
+	go func() {
+		var i int
+
+	loop:
+		for filename := range files {
+			e.queued(1)
+
+			select {
+			case <-ctx.Done():
+				break loop
+			case sem <- true:
+			}
+
+			wg.Add(1)
+
+			go func(i int, filename string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				if apply && e.state != nil {
+					e.state.queue(filename)
+				}
+
+				res, ok := e.searchFile(ctx, filename, apply)
+
+				if ok && apply && res.Err == nil && len(res.Findings) > 0 && ctx.Err() == nil {
+					res.Err = e.ApplyFile(&res, nil)
+				}
+
+				// the file is completed once its findings are replaced; the
+				// others are pending.
+				if apply && e.state != nil && ok && res.Err == nil && (len(res.Findings) == 0 || res.Modified || res.denied()) {
+					if err := e.state.complete(filename); err != nil {
+						res.Err = err
+					}
+				}
+
+				res.raw = nil
+
+				result <- indexedResult{index: i, res: res, ok: ok}
+			}(i, filename)
+
+			i++
+		}
+
+		e.found()
+		wg.Wait()
+		close(result)
+	}()
+
+	// the results that arrive before the ones of the previous files are kept
+	// until those are reported, so the output does not depend on the timing.
+	var next int
+
+	pending := map[int]indexedResult{}
+
+	report := func(item indexedResult) {
+		if item.ok && (item.res.Err != nil || item.res.Skipped != "" || len(item.res.Findings) > 0) {
+			fn(item.res)
+		}
+	}
+
+	for item := range result {
+		e.processed()
+		pending[item.index] = item
+
+		for {
+			item, ok := pending[next]
+
+			if !ok {
+				break
+			}
+
+			delete(pending, next)
+			report(item)
+			next++
+		}
+	}
+
+	// after a cancellation some files were never processed.
+	indexes := make([]int, 0, len(pending))
+
+	for i := range pending {
+		indexes = append(indexes, i)
+	}
+
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		report(pending[i])
+	}
+
+	return ctx.Err()
+}
+
+// indexedResult is the result of the file at the index of the list of files.
+type indexedResult struct {
+	index int
+	res   SearchResult
+	ok    bool
+}
+
+func (e *Engine) concurrency() int {
+	if e.opts.Concurrency > 0 {
+		return e.opts.Concurrency
+	}
+
+	return DefaultConcurrency
+}
+
+// files returns the list of files to process.
+func (e *Engine) files(ctx context.Context) ([]string, error) {
+	files := []string{}
+
+	err := e.eachFile(ctx, func(name string) error {
+		files = append(files, name)
+		return nil
+	})
+
+	return files, err
+}
+
+// eachFile calls the function with every file to process as they are found,
+// in the paths or the working directory. The walk stops at the first error
+// returned by the function.
+func (e *Engine) eachFile(ctx context.Context, fn func(name string) error) error {
+	var count int
+	var changed map[string]bool
+
+	if e.opts.ChangedSince != "" {
+		list, err := gitChanged(ctx, e.opts.ChangedSince)
+
+		if err != nil {
+			return err
+		}
+
+		changed = list
+	}
+
+	// the same file can be reached through multiple paths, hard links or
+	// symbolic links, and must not be searched, nor written, twice.
+	seen := map[string]bool{}
+
+	visit := func(name string) error {
+		id := cleanPath(name)
+
+		// the symbolic links that are not followed are never searched.
+		if fi, err := os.Lstat(name); err == nil {
+			id = fileID(name, fi)
+		}
+
+		if seen[id] {
+			return nil
+		}
+
+		seen[id] = true
+
+		// stop walking as soon as the limit is exceeded.
+		if count++; e.opts.MaxFiles > 0 && count > e.opts.MaxFiles {
+			return fmt.Errorf("%w: more than %d", ErrTooManyFiles, e.opts.MaxFiles)
+		}
+
+		if changed != nil && !changed[cleanPath(name)] {
+			return nil
+		}
+
+		if e.state != nil && e.state.done(name) {
+			return nil
+		}
+
+		if len(e.opts.Owners) > 0 && !e.opts.CodeOwners.OwnedBy(name, e.opts.Owners) {
+			return nil
+		}
+
+		return fn(name)
+	}
+
+	// If the user did not provide any specific files to search and replace,
+	// then assume they want to search and replace among all the files in the
+	// current folder (recursively).
+	if len(e.opts.Paths) == 0 {
+		return e.walk(ctx, ".", visit)
+	}
+
+	for _, filename := range e.opts.Paths {
+		// directories are walked the same way as the current folder.
+		if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+			if err := e.walk(ctx, filename, visit); err != nil {
+				return err
+			}
+		} else if e.filter.Allow(filename) {
+			if fi, err := os.Lstat(filename); err == nil && fi.Mode()&os.ModeSymlink != 0 && e.opts.Follow {
+				filename = resolveLink(filename)
+			}
+
+			if err := visit(filename); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// walk calls the function with the files in the folder, either from the git
+// index or walking the directory tree.
+func (e *Engine) walk(ctx context.Context, root string, fn func(name string) error) error {
+	if !e.opts.Git {
+		return e.walkFiles(ctx, root, fn)
+	}
+
+	files, err := e.gitFiles(ctx, root)
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		if err := fn(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parentDirs returns the folders between the working directory and the parent
+// of the relative path, or nothing if the path is outside the working
+// directory.
+func parentDirs(name string) []string {
+	name = cleanPath(name)
+
+	if name == "." || filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return nil
+	}
+
+	var dirs []string
+
+	for dir := filepath.Dir(name); dir != "."; dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+
+	return append([]string{"."}, dirs...)
+}
+
+// isBinary reports whether the data looks like the beginning of a binary file
+// using the same heuristic as git and grep: text files do not have NUL bytes.
+func isBinary(head []byte) bool {
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// searchFile reads the content of a file and finds the rules. The second
+// value is false if the file was skipped.
+func (e *Engine) searchFile(ctx context.Context, filename string, keep bool) (SearchResult, bool) {
+	res := SearchResult{Filename: filename}
+
+	if ctx.Err() != nil {
+		return res, false
+	}
+
+	// the rules apply to the members of the archives, not to the archives.
+	if e.opts.Archives {
+		if format := archiveFormat(filename); format != "" {
+			return e.searchArchive(ctx, filename, format, keep)
+		}
+	}
+
+	if res.Rules = e.rules.ForFile(filename); len(res.Rules) == 0 {
+		return res, false
+	}
+
+	if e.opts.Lang != "" {
+		if lang := LanguageFor(filename, nil); lang == nil || lang.Name != e.opts.Lang {
+			return res, false
+		}
+	}
+
+	if e.locator != nil && !e.locator.handles(filename) {
+		return res, false
+	}
+
+	fi, err := os.Lstat(filename)
+
+	if err != nil {
+		res.Err = err
+		return res, true
+	}
+
+	// skip files acting as symbolic links.
+	if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+		return res, false
+	}
+
+	res.size, res.modTime = fi.Size(), fi.ModTime()
+
+	if e.cache != nil && e.cached(filename, fi, res.Rules) {
+		e.hit()
+		return res, false
+	}
+
+	if e.opts.MaxFileSize > 0 && fi.Size() > e.opts.MaxFileSize {
+		res.Skipped = fmt.Sprintf("%d bytes, larger than the limit of %d", fi.Size(), e.opts.MaxFileSize)
+		e.skipped()
+		return res, true
+	}
+
+	file, err := os.Open(filename)
+
+	if err != nil {
+		res.Err = err
+		return res, true
+	}
+
+	defer file.Close()
+
+	var src io.Reader
+	var head, raw []byte
+
+	// the checksum of the content is recorded in the cache.
+	var input io.Reader = file
+	var sum hash.Hash
+
+	if e.cache != nil {
+		sum = sha256.New()
+		input = io.TeeReader(file, sum)
+	}
+
+	// text is the content converted to UTF-8, if it is already in memory.
+	var text []byte
+
+	// the content is kept, if requested, to replace the findings without
+	// reading the file again; the findings copy the text of the lines, so
+	// nothing refers to the mapped memory once the file is searched.
+	if keep = keep && !e.streams(fi.Size()); keep {
+		if raw, err = io.ReadAll(input); err != nil {
+			res.Err = err
+			return res, true
+		}
+		head = raw
+		if len(head) > sniffLength {
+			head = head[:sniffLength]
+		}
+		src, text = bytes.NewReader(raw), raw
+	} else if data, unmap, ok := e.mapLarge(file, fi.Size()); ok {
+		defer unmap()
+		head = data
+		if len(head) > sniffLength {
+			head = head[:sniffLength]
+		}
+		src, text = bytes.NewReader(data), data
+		if sum != nil {
+			sum.Write(data)
+		}
+	} else {
+		reader := bufio.NewReaderSize(input, sniffLength)
+		head, _ = reader.Peek(sniffLength)
+		src = reader
+	}
+
+	res.Encoding = detectEncoding(head)
+
+	// skip binary files, unless explicitly requested, because the search text
+	// may be part of the binary data and replacing it would corrupt the file.
+	if !e.opts.Binary && res.Encoding == UTF8 && isBinary(head) {
+		return res, false
+	}
+
+	if !e.opts.NoDefaultFilters && isGenerated(head) {
+		return res, false
+	}
+
+	if lang := LanguageFor(filename, head); e.scoped() && !e.supports(lang) {
+		if err := unsupportedNode(lang, e.opts.Only, e.opts.Skip); err != nil {
+			res.Err = fmt.Errorf("%s %s", filename, err)
+			return res, true
+		}
+
+		return res, false
+	}
+
+	e.scanned()
+
+	// files in other encodings are converted to UTF-8 in memory, otherwise
+	// the UTF-8 patterns would not match their bytes.
+	if res.Encoding != UTF8 {
+		content, err := io.ReadAll(src)
+
+		if err == nil {
+			content, err = res.Encoding.transcode(content)
+		}
+
+		if err != nil {
+			res.Err = fmt.Errorf("%s %s", filename, err)
+			return res, true
+		}
+
+		src, text = bytes.NewReader(content), content
+	}
+
+	eol := &eolCounter{r: src}
+	literals := res.Rules.literals()
+
+	if e.symbols != nil {
+		content, err := io.ReadAll(eol)
+
+		if err != nil {
+			res.Err = err
+			return res, true
+		}
+
+		edits, err := e.symbols.fileEdits(filename, res.Rules)
+
+		if err != nil {
+			res.Err = err
+			return res, true
+		}
+
+		res.Findings, text = findSymbols(content, edits), content
+	} else if e.locator != nil {
+		content, err := io.ReadAll(eol)
+
+		if err != nil {
+			res.Err = err
+			return res, true
+		}
+
+		matches, err := e.locator.matches(filename, res.Rules, content)
+
+		if err != nil {
+			res.Err = fmt.Errorf("%s %s", filename, err)
+			return res, true
+		}
+
+		res.Findings, text = locatedFindings(content, matches), content
+	} else if e.scoped() {
+		content, err := io.ReadAll(eol)
+
+		if err != nil {
+			res.Err = err
+			return res, true
+		}
+
+		res.Findings, text = groupMatches(content, e.scopedMatches(res.Rules, filename, content)), content
+	} else if e.opts.Multiline && e.streams(fi.Size()) {
+		res.Findings, res.Err = findStream(res.Rules, eol)
+	} else if e.opts.Multiline {
+		content, err := io.ReadAll(eol)
+
+		if err != nil {
+			res.Err = err
+			return res, true
+		}
+
+		res.Findings, text = findMultiline(res.Rules, content), content
+	} else if literals != nil && (text != nil || !e.streams(fi.Size())) {
+		if text == nil {
+			if text, err = io.ReadAll(src); err != nil {
+				res.Err = err
+				return res, true
+			}
+		}
+
+		res.Findings = findLiterals(res.Rules, literals, text)
+		eol.count(text)
+	} else {
+		var row int
+
+		scanner := bufio.NewScanner(eol)
+		scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), MaxLineLength)
+
+		for scanner.Scan() {
+			row++ /* line number */
+
+			if item, ok := findInLine(res.Rules, scanner.Text(), row); ok {
+				res.Findings = append(res.Findings, item)
+			}
+		}
+
+		if err := scanner.Err(); err == bufio.ErrTooLong {
+			res.Err = fmt.Errorf("%s line %d: %w, the rest of the file was not searched", filename, row+1, ErrLineTooLong)
+		} else if err != nil {
+			res.Err = err
+		}
+	}
+
+	if len(res.Findings) > 0 && res.Err == nil {
+		var n int
+
+		if res.Findings, n, err = suppress(filename, text, res.Findings); err != nil {
+			res.Err = err
+		}
+
+		e.suppressed(n)
+	}
+
+	res.Findings = e.limiter(filename).limit(res.Findings)
+
+	if e.mapper != nil && res.Err == nil {
+		if err := e.mapper.mapFindings(res.Rules, res.Findings); err != nil {
+			res.Err = fmt.Errorf("%s %s", filename, err)
+		}
+	}
+
+	if len(res.Findings) > 0 {
+		e.matched(res.Findings)
+
+		if keep {
+			res.raw = raw
+		}
+	}
+
+	if sum != nil && res.Err == nil {
+		if len(res.Findings) > 0 {
+			e.forget(filename)
+		} else {
+			e.recordClean(filename, fi, hex.EncodeToString(sum.Sum(nil)), res.Rules)
+		}
+	}
+
+	res.LineEndings = eol.LineEndings()
+
+	return res, true
+}
+
+// mapLarge maps the file in memory if it is larger than mmapThreshold.
+func (e *Engine) mapLarge(file *os.File, size int64) ([]byte, func(), bool) {
+	if size < mmapThreshold {
+		return nil, nil, false
+	}
+
+	return mapFile(file, size)
+}
+
+// textLength returns the length of the line without its terminator.
+func textLength(line []byte) int {
+	eol := len(line)
+
+	if bytes.HasSuffix(line, []byte("\n")) {
+		eol--
+	}
+
+	if eol > 0 && line[eol-1] == '\r' {
+		eol--
+	}
+
+	return eol
+}
+
+// replaceFindings replaces the occurrences of the findings at the positions
+// where they were found, so the lines that were not reported are never
+// modified. If the selection is not nil, only the selected line numbers are
+// modified. The line terminator of every line is kept as is.
+func replaceFindings(rs RuleSet, content []byte, findings []Finding, selected map[int]bool, newline string, p *placement) ([]byte, error) {
+	var out bytes.Buffer
+
+	rows := findingsByLine(findings, selected)
+
+	for i, line := range bytes.SplitAfter(content, []byte("\n")) {
+		item, ok := rows[i+1]
+
+		if !ok {
+			out.Write(line)
+			continue
+		}
+
+		line, err := replaceFinding(rs, line, item, newline, p)
+
+		if err != nil {
+			return nil, err
+		}
+
+		out.Write(line)
+	}
+
+	return out.Bytes(), nil
+}
+
+// findingsByLine indexes the selected findings by line number.
+func findingsByLine(findings []Finding, selected map[int]bool) map[int]Finding {
+	rows := make(map[int]Finding, len(findings))
+
+	for _, item := range findings {
+		if selected == nil || selected[item.LineNumber] {
+			rows[item.LineNumber] = item
+		}
+	}
+
+	return rows
+}
+
+// replaceFinding replaces the occurrences of the finding that start at the
+// recorded columns of the line, which must still be the line that was
+// searched.
+func replaceFinding(rs RuleSet, line []byte, item Finding, newline string, p *placement) ([]byte, error) {
+	eol := textLength(line)
+	text := line[:eol]
+
+	if string(text) != item.OriginalText {
+		return nil, fmt.Errorf("line %d %w", item.LineNumber, ErrChanged)
+	}
+
+	columns := make(map[int]bool, len(item.Positions))
+
+	for _, pos := range item.Positions {
+		columns[pos.Column] = true
+	}
+
+	var last int
+	var out bytes.Buffer
+
+	if p != nil {
+		p.line = item.LineNumber
+	}
+
+	for _, m := range rs.FindAll(text) {
+		if !columns[m.Loc[0]+1] {
+			continue
+		}
+
+		out.Write(text[last:m.Loc[0]])
+		out.Write(convertNewlines(m.Rule.expand(text, m.Loc, p), newline))
+		last = m.Loc[1]
+	}
+
+	out.Write(text[last:])
+	out.Write(line[eol:])
+
+	return out.Bytes(), nil
+}
+
+// ApplyFile replaces the selected findings in the file and writes the result
+// back to disk, recording the original content in the journal first. If the
+// selection is nil, all the findings are replaced. The findings that were
+// written are marked as applied.
+func (e *Engine) ApplyFile(res *SearchResult, selected map[int]bool) error {
+	if res.denied() {
+		return nil
+	}
+
+	fi, err := os.Stat(res.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	// the findings, and their line numbers, may no longer be in the file.
+	if !e.opts.Force && !res.modTime.IsZero() && (fi.Size() != res.size || !fi.ModTime().Equal(res.modTime)) {
+		return fmt.Errorf("%s %w, skipped", res.Filename, ErrChanged)
+	}
+
+	if e.opts.BackupSuffix != "" || e.opts.BackupDir != "" {
+		if err := e.backup(res.Filename); err != nil {
+			return fmt.Errorf("backup %s %s", res.Filename, err)
+		}
+	}
+
+	// only UTF-8 files can be streamed; the others are converted in memory.
+	if res.archive != "" {
+		err = e.applyArchive(res, fi.Mode().Perm())
+	} else if e.streams(fi.Size()) && res.Encoding == UTF8 && e.symbols == nil && e.locator == nil && !e.scoped() {
+		err = e.applyStream(res, selected)
+	} else {
+		err = e.applyBuffer(res, selected)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	res.Modified = true
+
+	for i, item := range res.Findings {
+		if selected == nil || selected[item.LineNumber] {
+			res.Findings[i].Applied = true
+		}
+	}
+
+	// the file is modified even if it cannot be formatted.
+	ferr := e.format(res.Filename)
+
+	// the size of the new version is reported in the statistics.
+	if fi, err = os.Stat(res.Filename); err != nil {
+		e.modified(0)
+	} else {
+		e.modified(fi.Size())
+		res.size, res.modTime = fi.Size(), fi.ModTime()
+	}
+
+	if ferr != nil {
+		return fmt.Errorf("format %s %s", res.Filename, ferr)
+	}
+
+	return nil
+}
+
+// denied reports whether all the findings of the result are of deny rules,
+// so the file is not written.
+func (res SearchResult) denied() bool {
+	for _, item := range res.Findings {
+		if !item.Denied(res.Rules) {
+			return false
+		}
+	}
+
+	return len(res.Findings) > 0
+}
+
+// applyBuffer replaces the findings with the entire file loaded in memory.
+func (e *Engine) applyBuffer(res *SearchResult, selected map[int]bool) error {
+	raw, content, err := e.Preview(*res, selected)
+
+	if err != nil {
+		return err
+	}
+
+	if e.journal != nil {
+		if err := e.journal.Record(res.Filename, raw, content); err != nil {
+			return fmt.Errorf("journal.Record %s %s", res.Filename, err)
+		}
+	}
+
+	return writeFileAtomic(res.Filename, content, 0644)
+}
+
+// Preview returns the original and the modified content of the file without
+// writing it. If the selection is not nil, only the selected findings are
+// replaced. The whole file is loaded in memory.
+func (e *Engine) Preview(res SearchResult, selected map[int]bool) ([]byte, []byte, error) {
+	// the members of the archives are replaced entirely.
+	if res.archive != "" {
+		return e.previewArchive(res)
+	}
+
+	var enc Encoding
+	var raw, content []byte
+	var err error
+
+	if res.raw != nil {
+		raw = res.raw
+		enc, content, err = decodeText(res.Filename, raw)
+	} else {
+		raw, enc, content, err = readText(res.Filename)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	original := content
+	newline := DetectLineEndings(content).Newline()
+	p := &placement{filename: res.Filename}
+
+	if e.symbols != nil {
+		edits, err := e.symbols.fileEdits(res.Filename, res.Rules)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// the lines without findings, i.e. exempted by the markers, are
+		// never modified.
+		if selected == nil {
+			selected = map[int]bool{}
+			for _, item := range res.Findings {
+				selected[item.LineNumber] = true
+			}
+		}
+
+		content = replaceSymbols(content, edits, selected)
+	} else if e.locator != nil {
+		matches, err := e.locator.matches(res.Filename, res.Rules, content)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s %s", res.Filename, err)
+		}
+
+		content = replaceMatches(content, matches, res.Findings, selected, newline, p)
+	} else if e.scoped() {
+		content = replaceMatches(content, e.scopedMatches(res.Rules, res.Filename, content), res.Findings, selected, newline, p)
+	} else if e.opts.Multiline {
+		content = replaceMultiline(res.Rules, content, res.Findings, selected, newline, p)
+	} else {
+		if content, err = replaceFindings(res.Rules, content, res.Findings, selected, newline, p); err != nil {
+			return nil, nil, fmt.Errorf("%s %w", res.Filename, err)
+		}
+	}
+
+	if p.err != nil {
+		return nil, nil, fmt.Errorf("%s %s", res.Filename, p.err)
+	}
+
+	content = preserveFinalNewline(original, content, newline)
+
+	if content, err = enc.encode(content); err != nil {
+		return nil, nil, fmt.Errorf("%s %s", res.Filename, err)
+	}
+
+	return raw, content, nil
+}
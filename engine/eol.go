@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+)
+
+// LineEndings describes the line terminators found in a file.
+type LineEndings struct {
+	LF   int
+	CRLF int
+	// FinalNewline is true if the last line ends with a line terminator.
+	FinalNewline bool
+}
+
+// DetectLineEndings counts the line terminators of the content.
+func DetectLineEndings(content []byte) LineEndings {
+	var c eolCounter
+	c.count(content)
+	return c.LineEndings()
+}
+
+// Mixed reports whether the file uses both kinds of line terminators.
+func (le LineEndings) Mixed() bool {
+	return le.LF > 0 && le.CRLF > 0
+}
+
+// Newline returns the dominant line terminator, "\n" on ties.
+func (le LineEndings) Newline() string {
+	if le.CRLF > le.LF {
+		return "\r\n"
+	}
+
+	return "\n"
+}
+
+// eolCounter counts the line terminators of the data read through it.
+type eolCounter struct {
+	r    io.Reader
+	le   LineEndings
+	last byte
+}
+
+func (c *eolCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count(p[:n])
+	return n, err
+}
+
+func (c *eolCounter) count(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	lf := bytes.Count(p, []byte("\n"))
+	crlf := bytes.Count(p, []byte("\r\n"))
+
+	// a terminator split between two reads.
+	if c.last == '\r' && p[0] == '\n' {
+		crlf++
+	}
+
+	c.le.LF += lf - crlf
+	c.le.CRLF += crlf
+	c.last = p[len(p)-1]
+}
+
+// LineEndings returns the line terminators counted so far.
+func (c *eolCounter) LineEndings() LineEndings {
+	le := c.le
+	le.FinalNewline = c.last == '\n'
+	return le
+}
+
+// convertNewlines uses the line terminator of the file for the line feeds
+// inserted by a replacement, so a rewrite does not mix line endings.
+func convertNewlines(text []byte, newline string) []byte {
+	if newline == "\n" || bytes.IndexByte(text, '\n') < 0 {
+		return text
+	}
+
+	var out bytes.Buffer
+
+	for i, c := range text {
+		if c == '\n' && (i == 0 || text[i-1] != '\r') {
+			out.WriteString(newline)
+			continue
+		}
+		out.WriteByte(c)
+	}
+
+	return out.Bytes()
+}
+
+// preserveFinalNewline adds or removes the line terminator at the end of the
+// modified content so it matches the original content.
+func preserveFinalNewline(original []byte, modified []byte, newline string) []byte {
+	if len(original) == 0 || len(modified) == 0 {
+		return modified
+	}
+
+	had := original[len(original)-1] == '\n'
+	has := modified[len(modified)-1] == '\n'
+
+	if had && !has {
+		return append(modified, newline...)
+	}
+
+	if !had && has {
+		modified = bytes.TrimSuffix(modified, []byte("\n"))
+		return bytes.TrimSuffix(modified, []byte("\r"))
+	}
+
+	return modified
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package engine
+
+import (
+	"os"
+)
+
+// fileID identifies the file by its absolute path with the symbolic links
+// resolved, on systems without inode numbers.
+func fileID(name string, fi os.FileInfo) string {
+	return realPath(name)
+}
@@ -0,0 +1,20 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileID identifies the file by its device and inode numbers, so the files
+// and directories reachable through multiple symbolic links are recognized.
+func fileID(name string, fi os.FileInfo) string {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+	}
+
+	return realPath(name)
+}
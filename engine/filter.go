@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Glob is a shell pattern where "*" matches any sequence of characters except
+// the path separator and "**" matches any sequence of characters, including
+// the path separator. Patterns without a slash are matched against the base
+// name of the file, otherwise they are matched against the entire path.
+type Glob struct {
+	re       *regexp.Regexp
+	basename bool
+}
+
+// NewGlob compiles a shell pattern.
+func NewGlob(pattern string) (Glob, error) {
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+	basename := !strings.Contains(pattern, "/")
+	re, err := regexp.Compile("^" + globToRegexp(pattern) + "$")
+
+	return Glob{re: re, basename: basename}, err
+}
+
+// Match reports whether the file path matches the pattern.
+func (g Glob) Match(name string) bool {
+	name = cleanPath(name)
+
+	if g.basename {
+		return g.re.MatchString(filepath.Base(name))
+	}
+
+	return g.re.MatchString(name)
+}
+
+// cleanPath normalizes the file path so it can be matched against patterns.
+func cleanPath(name string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)), "./")
+}
+
+// globToRegexp translates a shell pattern into a regular expression.
+func globToRegexp(pattern string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			out.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			out.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			out.WriteString(".*")
+			i++
+		case c == '*':
+			out.WriteString("[^/]*")
+		case c == '?':
+			out.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				out.WriteString(regexp.QuoteMeta(pattern[i:]))
+				return out.String()
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			out.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end
+		default:
+			out.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return out.String()
+}
+
+// FileFilter decides which files are processed based on include and exclude
+// patterns. Exclusions take precedence over inclusions.
+type FileFilter struct {
+	Include []Glob
+	Exclude []Glob
+}
+
+// NewFileFilter compiles the include and exclude patterns.
+func NewFileFilter(include []string, exclude []string) (*FileFilter, error) {
+	f := &FileFilter{}
+
+	for _, pattern := range include {
+		g, err := NewGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.Include = append(f.Include, g)
+	}
+
+	for _, pattern := range exclude {
+		g, err := NewGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.Exclude = append(f.Exclude, g)
+	}
+
+	return f, nil
+}
+
+// SkipDir reports whether the walker must not descend into the directory.
+func (f *FileFilter) SkipDir(name string) bool {
+	return matchAny(f.Exclude, name)
+}
+
+// Allow reports whether the file must be processed. The file is also skipped
+// if any of its parent directories is excluded, which covers files that were
+// explicitly listed by the user rather than found by the walker.
+func (f *FileFilter) Allow(name string) bool {
+	for dir := filepath.Dir(cleanPath(name)); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if f.SkipDir(dir) {
+			return false
+		}
+	}
+
+	if matchAny(f.Exclude, name) {
+		return false
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+
+	return matchAny(f.Include, name)
+}
+
+func matchAny(globs []Glob, name string) bool {
+	for _, g := range globs {
+		if g.Match(name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// realPath returns the absolute path of the file with the symbolic links
+// resolved, or the name itself if it cannot be resolved.
+func realPath(name string) string {
+	real, err := filepath.EvalSymlinks(name)
+
+	if err != nil {
+		return name
+	}
+
+	if abs, err := filepath.Abs(real); err == nil {
+		return abs
+	}
+
+	return real
+}
+
+// resolveLink returns the target of the symbolic link, relative to the
+// working directory if it is inside of it. The target is modified instead of
+// the link so the link is not replaced by a regular file.
+func resolveLink(name string) string {
+	real := realPath(name)
+
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, real); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return rel
+		}
+	}
+
+	return real
+}
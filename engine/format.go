@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Formatter is a command executed on every modified file with one of the
+// extensions, right after the file is written, i.e. "gofmt -w {}". The braces
+// are replaced with the name of the file, which is appended to the command
+// if they are missing. Without extensions, the command formats every file.
+type Formatter struct {
+	Command    string
+	Extensions []string
+}
+
+// GoFormatter formats the Go files with goimports, which also removes the
+// imports that are no longer used, or with gofmt if it is not installed.
+func GoFormatter() Formatter {
+	if _, err := exec.LookPath("goimports"); err == nil {
+		return Formatter{Command: "goimports -w {}", Extensions: []string{".go"}}
+	}
+
+	return Formatter{Command: "gofmt -w {}", Extensions: []string{".go"}}
+}
+
+// matches reports whether the formatter applies to the file.
+func (f Formatter) matches(filename string) bool {
+	if len(f.Extensions) == 0 {
+		return true
+	}
+
+	ext := filepath.Ext(filename)
+
+	for _, want := range f.Extensions {
+		if ext == "."+strings.TrimPrefix(want, ".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// run executes the command on the file. The error includes the output of the
+// command, if any.
+func (f Formatter) run(filename string) error {
+	args := strings.Fields(f.Command)
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	var found bool
+
+	for i, arg := range args {
+		if strings.Contains(arg, "{}") {
+			args[i] = strings.Replace(arg, "{}", filename, -1)
+			found = true
+		}
+	}
+
+	if !found {
+		args = append(args, filename)
+	}
+
+	var out bytes.Buffer
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%s %s: %s", args[0], err, msg)
+		}
+		return fmt.Errorf("%s %s", args[0], err)
+	}
+
+	return nil
+}
+
+// format runs the formatters of the file and updates the checksum recorded
+// in the journal so the formatted file can still be reverted with Undo.
+func (e *Engine) format(filename string) error {
+	var formatted bool
+
+	for _, f := range e.opts.Formatters {
+		if !f.matches(filename) {
+			continue
+		}
+
+		if err := f.run(filename); err != nil {
+			return err
+		}
+
+		formatted = true
+	}
+
+	if formatted && e.journal != nil {
+		if err := e.journal.Refresh(filename); err != nil {
+			return fmt.Errorf("journal.Refresh %s %s", filename, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// gitFiles lists the files tracked by git inside the folder, as reported by
+// the index, ignoring the files deleted from the working tree and the ones
+// outside of a sparse checkout. Include and exclude patterns still apply.
+func (e *Engine) gitFiles(ctx context.Context, root string) ([]string, error) {
+	out, err := git(ctx, "ls-files", "-z", "-t", "--", root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+
+	for _, entry := range bytes.Split(out, []byte{0}) {
+		// every entry is a status tag, a space and the path.
+		if len(entry) < 3 {
+			continue
+		}
+
+		// "S" marks the files with the skip-worktree bit (sparse checkout).
+		if entry[0] == 'S' {
+			continue
+		}
+
+		name := string(entry[2:])
+
+		// deleted files, symbolic links and submodules are not regular files.
+		if fi, err := os.Lstat(name); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+
+		if !e.tooDeep(root, name, false) && !e.skipHidden(root, name) && e.filter.Allow(name) && !e.skipDefault(root, name) {
+			files = append(files, name)
+		}
+	}
+
+	return files, nil
+}
+
+// gitChanged returns the files modified in the working tree, including the
+// staged changes, since the common ancestor of HEAD and the reference, so
+// only the changes of the current branch are considered. The deleted files
+// are ignored.
+func gitChanged(ctx context.Context, ref string) (map[string]bool, error) {
+	base, err := git(ctx, "merge-base", ref, "HEAD")
+
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := git(ctx, "diff", "--name-only", "-z", "--relative", "--diff-filter=d", strings.TrimSpace(string(base)), "--")
+
+	if err != nil {
+		return nil, err
+	}
+
+	changed := map[string]bool{}
+
+	for _, name := range bytes.Split(out, []byte{0}) {
+		if len(name) > 0 {
+			changed[cleanPath(string(name))] = true
+		}
+	}
+
+	return changed, nil
+}
+
+// git runs the command in the working directory and returns its output. The
+// error includes the message printed by git, if any.
+func git(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "git", args...).Output()
+
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+			err = fmt.Errorf("%s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, fmt.Errorf("git %s %s", args[0], err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ignoreRule is one single pattern from a .gitignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// gitignore keeps track of the .gitignore files found while walking the tree.
+// Rules are indexed by the directory containing the file because patterns are
+// relative to that location.
+type gitignore struct {
+	mu    sync.RWMutex
+	rules map[string][]ignoreRule
+}
+
+// newGitignore creates an empty set of ignore rules.
+func newGitignore() *gitignore {
+	return &gitignore{rules: map[string][]ignoreRule{}}
+}
+
+// Load reads the .gitignore file in the directory, if any.
+func (g *gitignore) Load(dir string) {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+
+	var rules []ignoreRule
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	if len(rules) > 0 {
+		g.mu.Lock()
+		g.rules[cleanPath(dir)] = rules
+		g.mu.Unlock()
+	}
+}
+
+// Ignored reports whether the path is excluded by the rules of any of its
+// parent directories. Rules in deeper directories take precedence, and the
+// last matching rule in a file wins, same as git.
+func (g *gitignore) Ignored(name string, isDir bool) bool {
+	name = cleanPath(name)
+
+	var dirs []string
+
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == "." || dir == "/" {
+			break
+		}
+	}
+
+	var ignored bool
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules := g.rules[dirs[i]]
+
+		if len(rules) == 0 {
+			continue
+		}
+
+		rel := name
+
+		if dirs[i] != "." {
+			rel = strings.TrimPrefix(name, dirs[i]+"/")
+		}
+
+		for _, rule := range rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	return ignored
+}
+
+// parseIgnoreRule converts one line of a .gitignore file into a rule.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var rule ignoreRule
+
+	line = strings.TrimRight(line, " \t")
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+
+	line = strings.TrimPrefix(line, `\`)
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if line == "" {
+		return rule, false
+	}
+
+	// patterns with a slash at the beginning or in the middle are relative to
+	// the directory of the .gitignore file; otherwise they match at any depth.
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+
+	if err != nil {
+		return rule, false
+	}
+
+	rule.re = re
+
+	return rule, true
+}
@@ -0,0 +1,212 @@
+package engine
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// imageManifest is an entry of the manifest.json of the images exported by
+// docker save, in both the legacy and the OCI layouts.
+type imageManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// SearchImage finds the rules in the files of the layers of a container image
+// exported by docker save, without modifying it. Every file of every layer is
+// reported, even if a later layer deletes it, since it is still part of the
+// published image. The filename of the results is the digest of the layer,
+// shortened to 12 characters, followed by MemberSeparator and the path of the
+// file, i.e. "sha256:0123456789ab!/etc/app.conf". The rules apply to the
+// paths of the files.
+func (e *Engine) SearchImage(ctx context.Context, image io.ReadSeeker, fn func(SearchResult)) error {
+	// the image is read twice, from the start, since it may have just been
+	// written by docker save.
+	if _, err := image.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	layers, err := imageLayers(image)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := image.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(image)
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("image %s", err)
+		}
+
+		name, ok := layers[path.Clean(hdr.Name)]
+
+		if !ok {
+			continue
+		}
+
+		if err := e.searchLayer(ctx, name, tr, fn); err != nil {
+			return fmt.Errorf("layer %s %s", name, err)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// imageLayers returns the names of the layers of the first image of the
+// manifest, by the path of the layer in the exported image.
+func imageLayers(image io.Reader) (map[string]string, error) {
+	tr := tar.NewReader(image)
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return nil, fmt.Errorf("the image has no manifest.json, export it with docker save")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("image %s", err)
+		}
+
+		if path.Clean(hdr.Name) != "manifest.json" {
+			continue
+		}
+
+		var manifests []imageManifest
+
+		if err := json.NewDecoder(tr).Decode(&manifests); err != nil {
+			return nil, fmt.Errorf("manifest.json %s", err)
+		}
+
+		if len(manifests) == 0 {
+			return nil, fmt.Errorf("manifest.json has no images")
+		}
+
+		layers := map[string]string{}
+
+		for _, layer := range manifests[0].Layers {
+			layers[path.Clean(layer)] = layerName(layer)
+		}
+
+		return layers, nil
+	}
+}
+
+// layerName returns the short digest of the layer, from its path in the OCI
+// layout, blobs/sha256/DIGEST, or in the legacy layout, DIGEST/layer.tar.
+func layerName(layer string) string {
+	digest := path.Base(layer)
+
+	if strings.HasPrefix(layer, "blobs/") {
+		digest = path.Base(path.Dir(layer)) + ":" + digest
+	} else {
+		digest = "sha256:" + path.Base(path.Dir(layer))
+	}
+
+	if k := strings.IndexByte(digest, ':'); k >= 0 && len(digest) > k+13 {
+		digest = digest[:k+13]
+	}
+
+	return digest
+}
+
+// searchLayer finds the rules in the regular files of the layer, which is a
+// tar file, compressed with gzip or not.
+func (e *Engine) searchLayer(ctx context.Context, name string, layer io.Reader, fn func(SearchResult)) error {
+	br := bufio.NewReader(layer)
+
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+
+		if err != nil {
+			return err
+		}
+
+		defer zr.Close()
+
+		layer = zr
+	} else {
+		layer = br
+	}
+
+	tr := tar.NewReader(layer)
+
+	for ctx.Err() == nil {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// the whiteouts mark the files deleted from the lower layers.
+		if hdr.Typeflag != tar.TypeReg || strings.HasPrefix(path.Base(hdr.Name), ".wh.") {
+			continue
+		}
+
+		filename := name + MemberSeparator + strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		res := SearchResult{Filename: filename, Rules: e.rules.ForFile(hdr.Name), size: hdr.Size, modTime: hdr.ModTime}
+
+		if len(res.Rules) == 0 {
+			continue
+		}
+
+		if e.opts.MaxFileSize > 0 && hdr.Size > e.opts.MaxFileSize {
+			res.Skipped = fmt.Sprintf("%d bytes, larger than the limit of %d", hdr.Size, e.opts.MaxFileSize)
+			e.skipped()
+			fn(res)
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+
+		if err != nil {
+			return err
+		}
+
+		e.scanned()
+
+		if res.Findings, err = e.searchMember(name, archiveMember{name: hdr.Name, data: data, regular: true}); err != nil {
+			res.Err = fmt.Errorf("%s %s", filename, err)
+		}
+
+		for i := range res.Findings {
+			res.Findings[i].Member = ""
+		}
+
+		if len(res.Findings) > 0 {
+			e.matched(res.Findings)
+		}
+
+		if len(res.Findings) > 0 || res.Err != nil {
+			res.LineEndings = DetectLineEndings(data)
+			fn(res)
+		}
+	}
+
+	return ctx.Err()
+}
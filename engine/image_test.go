@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSearchImage(t *testing.T) {
+	layer := string(buildArchive(t, archiveTar, map[string]string{
+		"etc/app.conf":    "host=old\n",
+		"etc/.wh.removed": "old\n",
+		"etc/keep.conf":   "keep\n",
+	}))
+
+	tests := []struct {
+		name     string
+		manifest string
+		layer    string
+		offset   int64
+		want     []string
+	}{
+		{
+			name:     "legacy layout",
+			manifest: `[{"Layers": ["0123456789abcdef0123/layer.tar"]}]`,
+			layer:    "0123456789abcdef0123/layer.tar",
+			want:     []string{"sha256:0123456789ab!/etc/app.conf"},
+		},
+		{
+			name:     "oci layout",
+			manifest: `[{"Layers": ["blobs/sha256/fedcba9876543210fedc"]}]`,
+			layer:    "blobs/sha256/fedcba9876543210fedc",
+			want:     []string{"sha256:fedcba987654!/etc/app.conf"},
+		},
+		{
+			name:     "positioned at the end",
+			manifest: `[{"Layers": ["0123456789abcdef0123/layer.tar"]}]`,
+			layer:    "0123456789abcdef0123/layer.tar",
+			offset:   -1,
+			want:     []string{"sha256:0123456789ab!/etc/app.conf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "image.tar")
+			data := buildArchive(t, archiveTar, map[string]string{"manifest.json": tt.manifest, tt.layer: layer})
+
+			if err := os.WriteFile(filename, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Open(filename)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer file.Close()
+
+			if tt.offset < 0 {
+				if _, err := file.Seek(0, io.SeekEnd); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			e, err := New(Options{Rules: []RuleSpec{{Search: "old", Replace: "new"}}})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+
+			err = e.SearchImage(context.Background(), file, func(res SearchResult) {
+				got = append(got, res.Filename)
+			})
+
+			if err != nil {
+				t.Fatalf("SearchImage %s", err)
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("SearchImage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// importPaths rewrites the paths of the Go modules and packages, see
+// Options.Imports. A path matches the search text if it is the same path or
+// a package inside of it, so the rest of the path is kept.
+type importPaths struct{}
+
+// handles reports whether the file is a Go file, go.mod or go.work.
+func (importPaths) handles(filename string) bool {
+	base := filepath.Base(filename)
+
+	return base == "go.mod" || base == "go.work" || strings.HasSuffix(base, ".go")
+}
+
+// compile compiles the rule of a module path, which is matched literally.
+func (importPaths) compile(spec RuleSpec) (*Rule, error) {
+	for _, path := range []string{spec.Search, spec.Replace} {
+		if path == "" || strings.ContainsAny(path, " \t\r\n\"`'\\") || strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") {
+			return nil, fmt.Errorf("%q is not a valid module path", path)
+		}
+	}
+
+	return spec.Compile(RuleOptions{})
+}
+
+// matches returns the module paths of the file matching the rules. The files
+// without name, i.e. the data of the filter, are parsed as Go files.
+func (importPaths) matches(filename string, rs RuleSet, content []byte) ([]RuleMatch, error) {
+	if base := filepath.Base(filename); base == "go.mod" || base == "go.work" {
+		return modMatches(rs, content), nil
+	}
+
+	return goImportMatches(rs, content)
+}
+
+// matchPath returns the rule whose search text is the path, or a parent of
+// it, or nil.
+func matchPath(rs RuleSet, path string) *Rule {
+	for _, rule := range rs {
+		if path == rule.Search || strings.HasPrefix(path, rule.Search+"/") {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// goImportMatches returns the import paths of the Go file matching the rules,
+// in the import declarations, in the import comment of the package clause and
+// in the go:generate directives, i.e. "go run example.com/mod/cmd/gen@v1".
+func goImportMatches(rs RuleSet, content []byte) ([]RuleMatch, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", content, parser.ImportsOnly|parser.ParseComments)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []RuleMatch
+
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+
+		if err != nil {
+			continue
+		}
+
+		if rule := matchPath(rs, path); rule != nil {
+			start := fset.Position(spec.Path.Pos()).Offset + 1
+			matches = append(matches, RuleMatch{Rule: rule, Loc: []int{start, start + len(rule.Search)}})
+		}
+	}
+
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			if fset.Position(c.Pos()).Line == fset.Position(file.Name.Pos()).Line && strings.HasPrefix(c.Text, "// import ") {
+				start := fset.Position(c.Pos()).Offset + len("// import ")
+				matches = append(matches, fieldMatches(rs, []byte(c.Text[len("// import "):]), start)...)
+			}
+		}
+	}
+
+	// the directives can be anywhere, but the file is parsed up to the
+	// imports only.
+	var start int
+
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("//go:generate ")) {
+			matches = append(matches, fieldMatches(rs, line, start)...)
+		}
+
+		start += len(line)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Loc[0] < matches[j].Loc[0]
+	})
+
+	return matches, nil
+}
+
+// modMatches returns the module paths of go.mod or go.work matching the
+// rules, in every directive, i.e. module, require and both sides of replace.
+// The versions and the comments are never modified.
+func modMatches(rs RuleSet, content []byte) []RuleMatch {
+	var matches []RuleMatch
+	var start int
+
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		text := line
+
+		if k := bytes.Index(text, []byte("//")); k >= 0 {
+			text = text[:k]
+		}
+
+		matches = append(matches, fieldMatches(rs, text, start)...)
+		start += len(line)
+	}
+
+	return matches
+}
+
+// fieldMatches returns the words of the text matching the rules, with the
+// offsets relative to the content, where the text begins at the offset. The
+// words can be quoted and followed by a version, i.e. example.com/mod@v1.
+func fieldMatches(rs RuleSet, text []byte, offset int) []RuleMatch {
+	var matches []RuleMatch
+
+	for i := 0; i < len(text); {
+		if c := text[i]; c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '"' || c == '(' || c == ')' {
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(text) && bytes.IndexByte([]byte(" \t\r\n\"()"), text[end]) < 0 {
+			end++
+		}
+
+		path := string(text[i:end])
+
+		if k := strings.IndexByte(path, '@'); k >= 0 {
+			path = path[:k]
+		}
+
+		if rule := matchPath(rs, path); rule != nil {
+			matches = append(matches, RuleMatch{Rule: rule, Loc: []int{offset + i, offset + i + len(rule.Search)}})
+		}
+
+		i = end
+	}
+
+	return matches
+}
@@ -0,0 +1,411 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateDir is the folder, relative to the working directory, where the engine
+// keeps its own files. It is never searched.
+const StateDir = ".refactor"
+
+// stateIgnore is the content of the .gitignore of StateDir, so the folder
+// never shows up as untracked in the repositories.
+const stateIgnore = "*\n"
+
+// DefaultJournalDir is the folder where the original content of the modified
+// files is stored so the changes can be reverted with Undo.
+var DefaultJournalDir = filepath.Join(StateDir, "undo")
+
+// manifestName is the name of the file describing the content of a journal.
+const manifestName = "manifest.json"
+
+// ErrNothingToUndo is returned by Undo when there are no journals left.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// ErrModified is reported by Undo for files that changed after the run.
+var ErrModified = errors.New("modified after the replacement")
+
+// journal records the original content of the files modified in one run.
+type journal struct {
+	sync.Mutex
+	Folder  string         `json:"-"`
+	Created time.Time      `json:"created"`
+	Entries []journalEntry `json:"entries"`
+}
+
+// journalEntry describes one single modified file.
+type journalEntry struct {
+	// Filename is the absolute path of the modified file.
+	Filename string `json:"filename"`
+	// Backup is the name of the file, inside the journal folder, containing
+	// the original content of the modified file.
+	Backup string `json:"backup"`
+	// Checksum is the SHA-256 of the modified content, used to detect if the
+	// file was changed again after the replacement.
+	Checksum string `json:"checksum"`
+	// RenamedFrom is the absolute path of the file before it was renamed to
+	// Filename. The entries of the renamed files have no backup.
+	RenamedFrom string `json:"renamed_from,omitempty"`
+	// Git is true if the file was renamed with git mv.
+	Git bool `json:"git,omitempty"`
+}
+
+// newJournal creates a journal in a new folder named after the current time.
+// The folder is not created until the first file is recorded.
+func newJournal(dir string) *journal {
+	now := time.Now()
+
+	return &journal{
+		Folder:  filepath.Join(dir, now.Format("20060102-150405.000000000")),
+		Created: now,
+	}
+}
+
+// Record saves the original content of the file before it is modified.
+func (j *journal) Record(filename string, original []byte, modified []byte) error {
+	return j.RecordStream(filename, bytes.NewReader(original), checksum(modified))
+}
+
+// RecordStream is like Record but copies the original content from a reader
+// so large files do not have to be loaded in memory. The checksum is the
+// SHA-256 of the modified content, in hexadecimal.
+func (j *journal) RecordStream(filename string, original io.Reader, sum string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	abspath, err := filepath.Abs(filename)
+
+	if err != nil {
+		return err
+	}
+
+	// a file modified again in the same run, i.e. in watch mode, keeps the
+	// content it had before the run; only the checksum is updated.
+	for i := range j.Entries {
+		if j.Entries[i].Filename == abspath && j.Entries[i].RenamedFrom == "" {
+			j.Entries[i].Checksum = sum
+			return j.writeManifest()
+		}
+	}
+
+	if err := makeDir(j.Folder); err != nil {
+		return err
+	}
+
+	entry := journalEntry{
+		Filename: abspath,
+		Backup:   fmt.Sprintf("%06d.orig", len(j.Entries)+1),
+		Checksum: sum,
+	}
+
+	if err := copyToFile(filepath.Join(j.Folder, entry.Backup), original, 0600); err != nil {
+		return err
+	}
+
+	j.Entries = append(j.Entries, entry)
+
+	// rewrite the manifest after every file so an interrupted run can still
+	// be reverted up to the last file that was modified.
+	return j.writeManifest()
+}
+
+// RecordRename saves the previous name of a file after it is renamed.
+func (j *journal) RecordRename(from string, to string, git bool) error {
+	j.Lock()
+	defer j.Unlock()
+
+	absfrom, err := filepath.Abs(from)
+
+	if err != nil {
+		return err
+	}
+
+	absto, err := filepath.Abs(to)
+
+	if err != nil {
+		return err
+	}
+
+	if err := makeDir(j.Folder); err != nil {
+		return err
+	}
+
+	j.Entries = append(j.Entries, journalEntry{
+		Filename:    absto,
+		RenamedFrom: absfrom,
+		Git:         git,
+	})
+
+	return j.writeManifest()
+}
+
+// Refresh updates the checksum of a recorded file that was modified again on
+// purpose, i.e. by a formatter, so it is not reported by Undo as changed.
+func (j *journal) Refresh(filename string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	abspath, err := filepath.Abs(filename)
+
+	if err != nil {
+		return err
+	}
+
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		if j.Entries[i].Filename != abspath || j.Entries[i].RenamedFrom != "" {
+			continue
+		}
+
+		sum, err := checksumFile(filename)
+
+		if err != nil {
+			return err
+		}
+
+		j.Entries[i].Checksum = sum
+
+		return j.writeManifest()
+	}
+
+	return nil
+}
+
+// writeManifest writes the description of the journal. The caller must hold
+// the lock.
+func (j *journal) writeManifest() error {
+	data, err := json.MarshalIndent(j, "", "\t")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(j.Folder, manifestName), data, 0600)
+}
+
+// makeDir creates the folder and its parents. If it is inside StateDir, a
+// .gitignore ignoring everything is added to StateDir first.
+func makeDir(dir string) error {
+	if clean := cleanPath(dir); clean == StateDir || strings.HasPrefix(clean, StateDir+"/") {
+		if err := os.MkdirAll(StateDir, 0755); err != nil {
+			return err
+		}
+
+		ignore := filepath.Join(StateDir, ".gitignore")
+
+		if _, err := os.Stat(ignore); os.IsNotExist(err) {
+			if err := os.WriteFile(ignore, []byte(stateIgnore), 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	return os.MkdirAll(dir, 0755)
+}
+
+// removeStateDir removes StateDir if nothing but its .gitignore is left.
+func removeStateDir() {
+	entries, err := os.ReadDir(StateDir)
+
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.Name() != ".gitignore" {
+			return
+		}
+	}
+
+	_ = os.RemoveAll(StateDir)
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumFile is like checksum but reads the data from a file.
+func checksumFile(filename string) (string, error) {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyToFile writes everything read from r into a new file.
+func copyToFile(filename string, r io.Reader, perm os.FileMode) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// lastJournal returns the folder of the most recent journal.
+func lastJournal(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+
+	if os.IsNotExist(err) {
+		return "", ErrNothingToUndo
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	if len(names) == 0 {
+		return "", ErrNothingToUndo
+	}
+
+	sort.Strings(names)
+
+	return filepath.Join(dir, names[len(names)-1]), nil
+}
+
+// UndoResult describes the outcome of restoring one single file.
+type UndoResult struct {
+	Filename string
+	Err      error
+}
+
+// Undo restores the files modified by the most recent run recorded in the
+// journal folder and deletes its journal, so calling it again reverts the run
+// before that one. Files that were modified after the replacement are left
+// untouched, and reported with ErrModified, unless force is true.
+func Undo(dir string, force bool) ([]UndoResult, error) {
+	folder, err := lastJournal(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(folder, manifestName))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var j journal
+
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+
+	var failed int
+	var results []UndoResult
+
+	// the entries are restored from the newest to the oldest, undoing the
+	// changes of the run in the opposite order they were made.
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		entry := j.Entries[i]
+		filename := entry.Filename
+
+		var err error
+
+		if entry.RenamedFrom != "" {
+			filename = entry.RenamedFrom
+			err = restoreRename(entry)
+		} else {
+			err = restoreEntry(folder, entry, force)
+		}
+
+		if err != nil {
+			failed++
+		}
+
+		results = append(results, UndoResult{Filename: filename, Err: err})
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("%d file(s) could not be restored; journal kept in %s", failed, folder)
+	}
+
+	return results, os.RemoveAll(folder)
+}
+
+// restoreEntry writes the original content of one file back to disk. Both
+// files are streamed because the engine may have processed very large files.
+func restoreEntry(folder string, entry journalEntry, force bool) error {
+	current, err := checksumFile(entry.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	if !force && current != entry.Checksum {
+		return ErrModified
+	}
+
+	original, err := os.Open(filepath.Join(folder, entry.Backup))
+
+	if err != nil {
+		return err
+	}
+
+	defer original.Close()
+
+	f, err := createAtomic(entry.Filename, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Abort()
+
+	if _, err := io.Copy(f, original); err != nil {
+		return err
+	}
+
+	return f.Commit()
+}
+
+// restoreRename moves a renamed file back to its previous name, unless
+// another file took that name after the rename.
+func restoreRename(entry journalEntry) error {
+	if _, err := os.Lstat(entry.RenamedFrom); err == nil {
+		return fmt.Errorf("%s %w", entry.RenamedFrom, ErrRenameCollision)
+	}
+
+	_, err := renameFile(context.Background(), entry.Filename, entry.RenamedFrom, entry.Git)
+
+	return err
+}
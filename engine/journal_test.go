@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles creates the files, relative to the folder, with their content.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFiles returns the content of every file in the folder, by their path
+// relative to it, except the files of the journal.
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && info.Name() == "journal" {
+			return filepath.SkipDir
+		}
+
+		if info.Mode().IsRegular() {
+			data, err := os.ReadFile(name)
+
+			if err != nil {
+				return err
+			}
+
+			rel, _ := filepath.Rel(dir, name)
+			files[filepath.ToSlash(rel)] = string(data)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+// modify writes the new content of the file, recording the change first.
+func modify(t *testing.T, j *journal, filename string, content string) {
+	t.Helper()
+
+	original, err := os.ReadFile(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Record(filename, original, []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		name string
+		// run modifies the files of the folder, recording the changes.
+		run func(t *testing.T, j *journal, dir string)
+		// after modifies the files once the run is complete.
+		after   func(t *testing.T, dir string)
+		force   bool
+		want    map[string]string
+		wantErr error
+	}{
+		{
+			name: "modified files",
+			run: func(t *testing.T, j *journal, dir string) {
+				modify(t, j, filepath.Join(dir, "a.txt"), "bar\n")
+				modify(t, j, filepath.Join(dir, "sub/b.txt"), "bar\n")
+			},
+			want: map[string]string{"a.txt": "foo\n", "sub/b.txt": "foo\n"},
+		},
+		{
+			name: "same file modified multiple times",
+			run: func(t *testing.T, j *journal, dir string) {
+				filename := filepath.Join(dir, "a.txt")
+				modify(t, j, filename, "bar\n")
+				// a generator writes the file again, in watch mode.
+				writeFiles(t, dir, map[string]string{"a.txt": "bar\nfoo\n"})
+				modify(t, j, filename, "bar\nbar\n")
+			},
+			want: map[string]string{"a.txt": "foo\n", "sub/b.txt": "foo\n"},
+		},
+		{
+			name: "modified after the run",
+			run: func(t *testing.T, j *journal, dir string) {
+				modify(t, j, filepath.Join(dir, "a.txt"), "bar\n")
+			},
+			after: func(t *testing.T, dir string) {
+				writeFiles(t, dir, map[string]string{"a.txt": "baz\n"})
+			},
+			want:    map[string]string{"a.txt": "baz\n", "sub/b.txt": "foo\n"},
+			wantErr: ErrModified,
+		},
+		{
+			name: "modified after the run with force",
+			run: func(t *testing.T, j *journal, dir string) {
+				modify(t, j, filepath.Join(dir, "a.txt"), "bar\n")
+			},
+			after: func(t *testing.T, dir string) {
+				writeFiles(t, dir, map[string]string{"a.txt": "baz\n"})
+			},
+			force: true,
+			want:  map[string]string{"a.txt": "foo\n", "sub/b.txt": "foo\n"},
+		},
+		{
+			name: "modified and renamed",
+			run: func(t *testing.T, j *journal, dir string) {
+				from, to := filepath.Join(dir, "sub/b.txt"), filepath.Join(dir, "new/c.txt")
+				modify(t, j, from, "bar\n")
+				if _, err := renameFile(context.Background(), from, to, false); err != nil {
+					t.Fatal(err)
+				}
+				if err := j.RecordRename(from, to, false); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]string{"a.txt": "foo\n", "sub/b.txt": "foo\n"},
+		},
+		{
+			name: "renamed over a new file",
+			run: func(t *testing.T, j *journal, dir string) {
+				from, to := filepath.Join(dir, "a.txt"), filepath.Join(dir, "c.txt")
+				if _, err := renameFile(context.Background(), from, to, false); err != nil {
+					t.Fatal(err)
+				}
+				if err := j.RecordRename(from, to, false); err != nil {
+					t.Fatal(err)
+				}
+			},
+			after: func(t *testing.T, dir string) {
+				writeFiles(t, dir, map[string]string{"a.txt": "new\n"})
+			},
+			want:    map[string]string{"a.txt": "new\n", "c.txt": "foo\n", "sub/b.txt": "foo\n"},
+			wantErr: ErrRenameCollision,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			journalDir := filepath.Join(dir, "journal")
+
+			writeFiles(t, dir, map[string]string{"a.txt": "foo\n", "sub/b.txt": "foo\n"})
+
+			tt.run(t, newJournal(journalDir), dir)
+
+			if tt.after != nil {
+				tt.after(t, dir)
+			}
+
+			results, err := Undo(journalDir, tt.force)
+
+			if got := readFiles(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("files after Undo = %q, want %q", got, tt.want)
+			}
+
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Undo %s", err)
+				}
+
+				if _, err := Undo(journalDir, tt.force); err != ErrNothingToUndo {
+					t.Fatalf("second Undo = %v, want %v", err, ErrNothingToUndo)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Undo succeeded, want %v", tt.wantErr)
+			}
+
+			for _, res := range results {
+				if errors.Is(res.Err, tt.wantErr) {
+					return
+				}
+			}
+
+			t.Fatalf("Undo results = %+v, want %v", results, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// jsonScanner walks a JSON document without decoding it, to locate the keys
+// and the scalar values in the original text. The comments and the trailing
+// commas of JSONC, i.e. tsconfig.json, are accepted.
+type jsonScanner struct {
+	content []byte
+	offset  int
+	tokens  []dataToken
+}
+
+// scanJSON returns the keys and the scalar values of the document.
+func scanJSON(content []byte) ([]dataToken, error) {
+	s := &jsonScanner{content: content}
+
+	s.skipSpace()
+
+	// the empty files have no tokens.
+	if s.offset == len(content) {
+		return nil, nil
+	}
+
+	if err := s.value(nil); err != nil {
+		return nil, err
+	}
+
+	if s.skipSpace(); s.offset < len(content) {
+		return nil, s.errorf("unexpected %q after the document", content[s.offset])
+	}
+
+	return s.tokens, nil
+}
+
+// errorf returns an error with the line of the current offset.
+func (s *jsonScanner) errorf(format string, args ...interface{}) error {
+	line := bytes.Count(s.content[:s.offset], []byte("\n")) + 1
+
+	return fmt.Errorf("invalid JSON on line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace moves the offset past the white space and the comments.
+func (s *jsonScanner) skipSpace() {
+	for s.offset < len(s.content) {
+		switch rest := s.content[s.offset:]; {
+		case rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\r' || rest[0] == '\n':
+			s.offset++
+		case bytes.HasPrefix(rest, []byte("//")):
+			s.offset = lineEnding(s.content, s.offset)
+		case bytes.HasPrefix(rest, []byte("/*")):
+			if k := bytes.Index(rest[2:], []byte("*/")); k >= 0 {
+				s.offset += 2 + k + 2
+			} else {
+				s.offset = len(s.content)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// value scans the value at the offset, which belongs to the location.
+func (s *jsonScanner) value(location []string) error {
+	if s.offset == len(s.content) {
+		return s.errorf("unexpected end of the document")
+	}
+
+	switch c := s.content[s.offset]; c {
+	case '{':
+		return s.object(location)
+	case '[':
+		return s.array(location)
+	case '"':
+		start := s.offset
+
+		if _, err := s.str(); err != nil {
+			return err
+		}
+
+		s.tokens = append(s.tokens, dataToken{start: start, end: s.offset, location: location})
+	default:
+		start := s.offset
+
+		for s.offset < len(s.content) && bytes.IndexByte([]byte(",:]} \t\r\n/"), s.content[s.offset]) < 0 {
+			s.offset++
+		}
+
+		if literal := s.content[start:s.offset]; len(literal) == 0 || !json.Valid(literal) {
+			s.offset = start
+			return s.errorf("unexpected %q", c)
+		}
+
+		s.tokens = append(s.tokens, dataToken{start: start, end: s.offset, location: location})
+	}
+
+	return nil
+}
+
+// object scans the members of the object at the offset.
+func (s *jsonScanner) object(location []string) error {
+	s.offset++
+
+	for {
+		if s.skipSpace(); s.offset < len(s.content) && s.content[s.offset] == '}' {
+			s.offset++
+			return nil
+		}
+
+		if s.offset == len(s.content) || s.content[s.offset] != '"' {
+			return s.errorf("expected a key")
+		}
+
+		start := s.offset
+		key, err := s.str()
+
+		if err != nil {
+			return err
+		}
+
+		// a new slice for every member, since the tokens keep their location.
+		member := append(append([]string(nil), location...), key)
+		s.tokens = append(s.tokens, dataToken{start: start, end: s.offset, location: member, key: true})
+
+		if s.skipSpace(); s.offset == len(s.content) || s.content[s.offset] != ':' {
+			return s.errorf("expected a colon after the key %q", key)
+		}
+
+		s.offset++
+		s.skipSpace()
+
+		if err := s.value(member); err != nil {
+			return err
+		}
+
+		if !s.next('}') {
+			return s.errorf("expected a comma or the end of the object")
+		}
+
+		if s.content[s.offset-1] == '}' {
+			return nil
+		}
+	}
+}
+
+// array scans the elements of the array at the offset.
+func (s *jsonScanner) array(location []string) error {
+	s.offset++
+
+	for i := 0; ; i++ {
+		if s.skipSpace(); s.offset < len(s.content) && s.content[s.offset] == ']' {
+			s.offset++
+			return nil
+		}
+
+		element := append(append([]string(nil), location...), "["+strconv.Itoa(i)+"]")
+
+		if err := s.value(element); err != nil {
+			return err
+		}
+
+		if !s.next(']') {
+			return s.errorf("expected a comma or the end of the array")
+		}
+
+		if s.content[s.offset-1] == ']' {
+			return nil
+		}
+	}
+}
+
+// next moves the offset past the comma or the closing delimiter following a
+// member or an element, and reports whether one of them was found.
+func (s *jsonScanner) next(closing byte) bool {
+	if s.skipSpace(); s.offset < len(s.content) && (s.content[s.offset] == ',' || s.content[s.offset] == closing) {
+		s.offset++
+		return true
+	}
+
+	return false
+}
+
+// str scans the string at the offset and returns its decoded value.
+func (s *jsonScanner) str() (string, error) {
+	start := s.offset
+	end := quotedEnd(s.content, start+1, '"', false)
+
+	var text string
+
+	if err := json.Unmarshal(s.content[start:end], &text); err != nil {
+		return "", s.errorf("invalid string %s", s.content[start:end])
+	}
+
+	s.offset = end
+
+	return text, nil
+}
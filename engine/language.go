@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Language is a programming language known by the scopes. The file is in the
+// language if its extension is one of the Extensions or, when the extension
+// is unknown, if the interpreter of its shebang line is one of Interpreters.
+type Language struct {
+	Name string
+	// Extensions include the dot, i.e. ".go", and are compared regardless
+	// of the case.
+	Extensions []string
+	// Interpreters are the names of the programs of the shebang lines, i.e.
+	// "python" for "#!/usr/bin/env python3", without the version.
+	Interpreters []string
+	// Tokenize finds the comments and the string literals of a file, sorted
+	// by offset. The identifiers are the words between them, so they are
+	// never returned by the tokenizer.
+	Tokenize func(content []byte) []Token
+	// Parse finds the syntax nodes of a file, with the kinds in Nodes, for
+	// the scopes restricted to them, i.e. "function_name". The nodes with
+	// the same kind cannot overlap. The languages without a parser have the
+	// scopes of the tokenizer only.
+	Parse func(content []byte) []Token
+	Nodes []string
+}
+
+// Token is a comment, a string literal or an identifier of a source file,
+// including its delimiters, or a syntax node. Kind is ScopeComments,
+// ScopeStrings or ScopeIdentifiers, or the kind of the node.
+type Token struct {
+	Start, End int
+	Kind       string
+}
+
+// languages are the registered languages. The latest registration wins if two
+// languages claim the same extension or interpreter.
+var languages = []*Language{
+	{
+		Name:       "go",
+		Extensions: []string{".go"},
+		Tokenize:   lexGo,
+		Parse:      parseGo,
+		Nodes: []string{
+			NodeFunctionName, NodeTypeName, NodeFieldName, NodeVariableName,
+			NodePackageName, NodeStringLiteral, NodeComment,
+		},
+	},
+	{
+		Name:         "javascript",
+		Extensions:   []string{".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx"},
+		Interpreters: []string{"node", "nodejs", "deno"},
+		Tokenize:     lexJS,
+		Parse:        parseJS,
+		Nodes: []string{
+			NodeFunctionName, NodeTypeName, NodeVariableName,
+			NodeStringLiteral, NodeComment,
+		},
+	},
+	{
+		Name:         "python",
+		Extensions:   []string{".py"},
+		Interpreters: []string{"python"},
+		Tokenize:     lexPython,
+		Parse:        parsePython,
+		Nodes:        []string{NodeFunctionName, NodeTypeName, NodeStringLiteral, NodeComment},
+	},
+	{
+		Name:       "json",
+		Extensions: []string{".json", ".jsonc"},
+		Tokenize:   lexJS,
+		Parse:      parseData(lexJS),
+		Nodes:      []string{NodeStringLiteral, NodeComment},
+	},
+	{
+		Name:       "yaml",
+		Extensions: []string{".yaml", ".yml"},
+		Tokenize:   lexYAML,
+		Parse:      parseData(lexYAML),
+		Nodes:      []string{NodeStringLiteral, NodeComment},
+	},
+	{
+		Name:         "shell",
+		Extensions:   []string{".sh", ".bash", ".zsh", ".ksh"},
+		Interpreters: []string{"sh", "bash", "zsh", "ksh", "dash"},
+		Tokenize:     lexShell,
+		Parse:        parseShell,
+		Nodes:        []string{NodeFunctionName, NodeStringLiteral, NodeComment},
+	},
+}
+
+// RegisterLanguage adds a language to the scopes, or replaces the language
+// with the same name. The languages must be registered before the engines
+// that use them are created, usually from an init function.
+func RegisterLanguage(lang Language) error {
+	if lang.Name == "" || lang.Tokenize == nil {
+		return fmt.Errorf("the language needs a name and a tokenizer")
+	}
+
+	if len(lang.Extensions) == 0 && len(lang.Interpreters) == 0 {
+		return fmt.Errorf("the language %s has no extensions or interpreters", lang.Name)
+	}
+
+	for i, known := range languages {
+		if known.Name == lang.Name {
+			languages = append(languages[:i], languages[i+1:]...)
+			break
+		}
+	}
+
+	languages = append(languages, &lang)
+
+	return nil
+}
+
+// languageNamed returns the registered language with the name, or nil.
+func languageNamed(name string) *Language {
+	for _, lang := range languages {
+		if lang.Name == name {
+			return lang
+		}
+	}
+
+	return nil
+}
+
+// LanguageFor returns the language of the file, detected from the extension
+// or from the shebang line at the beginning of the content, or nil if the
+// language is unknown.
+func LanguageFor(filename string, head []byte) *Language {
+	if ext := strings.ToLower(filepath.Ext(filename)); ext != "" {
+		for i := len(languages) - 1; i >= 0; i-- {
+			for _, known := range languages[i].Extensions {
+				if strings.ToLower(known) == ext {
+					return languages[i]
+				}
+			}
+		}
+	}
+
+	if name := interpreter(head); name != "" {
+		for i := len(languages) - 1; i >= 0; i-- {
+			for _, known := range languages[i].Interpreters {
+				if known == name {
+					return languages[i]
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// interpreter returns the name of the program of the shebang line, without
+// the directory and the version, following env, or an empty string.
+func interpreter(head []byte) string {
+	if !bytes.HasPrefix(head, []byte("#!")) {
+		return ""
+	}
+
+	line := head[2:]
+
+	if k := bytes.IndexByte(line, '\n'); k >= 0 {
+		line = line[:k]
+	}
+
+	fields := strings.Fields(string(line))
+
+	if len(fields) > 0 && filepath.Base(fields[0]) == "env" {
+		fields = fields[1:]
+
+		// the options of env, i.e. -S, and the variables it sets.
+		for len(fields) > 0 && (strings.HasPrefix(fields[0], "-") || strings.Contains(fields[0], "=")) {
+			fields = fields[1:]
+		}
+	}
+
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return strings.TrimRight(filepath.Base(fields[0]), "0123456789.")
+}
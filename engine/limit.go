@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LineRange is a range of lines of every file or, if the file name is not
+// empty, of that file.
+type LineRange struct {
+	Filename string
+	// First and Last are 1-based and inclusive. If Last is zero, the range
+	// continues until the end of the file.
+	First int
+	Last  int
+}
+
+// String formats the range as "FIRST-LAST", preceded by the file name and a
+// colon, if any.
+func (lr LineRange) String() string {
+	text := strconv.Itoa(lr.First) + "-"
+
+	if lr.Last > 0 {
+		text += strconv.Itoa(lr.Last)
+	}
+
+	if lr.Filename != "" {
+		return lr.Filename + ":" + text
+	}
+
+	return text
+}
+
+// contains reports whether the line number is in the range.
+func (lr LineRange) contains(line int) bool {
+	return line >= lr.First && (lr.Last == 0 || line <= lr.Last)
+}
+
+// limiter keeps the occurrences in the ranges of Options.Lines, on the lines
+// not matching Options.Unless, the first ones of every line, up to
+// Options.MaxPerLine, and with Options.FirstOnly the first one of the file.
+// The other occurrences are neither reported nor replaced.
+type limiter struct {
+	ranges  []LineRange
+	unless  *regexp.Regexp
+	perLine int
+	first   bool
+	// done is true once the first occurrence of the file was kept.
+	done bool
+}
+
+// limiter returns a new limiter for the file, or nil if its occurrences are
+// not limited. The ranges of the other files are ignored.
+func (e *Engine) limiter(filename string) *limiter {
+	var ranges []LineRange
+
+	for _, lr := range e.opts.Lines {
+		if lr.Filename == "" || (filename != "" && cleanPath(lr.Filename) == cleanPath(filename)) {
+			ranges = append(ranges, lr)
+		}
+	}
+
+	if len(ranges) == 0 && e.unless == nil && e.opts.MaxPerLine <= 0 && !e.opts.FirstOnly {
+		return nil
+	}
+
+	return &limiter{ranges: ranges, unless: e.unless, perLine: e.opts.MaxPerLine, first: e.opts.FirstOnly}
+}
+
+// inRange reports whether the line is in one of the ranges, or there are no
+// ranges at all.
+func (l *limiter) inRange(line int) bool {
+	for _, lr := range l.ranges {
+		if lr.contains(line) {
+			return true
+		}
+	}
+
+	return len(l.ranges) == 0
+}
+
+// limit removes the extra positions of the findings, found in the order of
+// the file, and the findings left without any. A nil limiter keeps all of
+// them.
+func (l *limiter) limit(findings []Finding) []Finding {
+	if l == nil {
+		return findings
+	}
+
+	var kept []Finding
+
+	for _, item := range findings {
+		var positions []Position
+		var lines []string
+
+		if l.unless != nil {
+			lines = strings.Split(item.OriginalText, "\n")
+		}
+
+		count := map[int]int{}
+
+		for _, pos := range item.Positions {
+			if l.unless != nil && l.unless.MatchString(lines[pos.Line-item.LineNumber]) {
+				continue
+			}
+
+			if l.done || !l.inRange(pos.Line) || (l.perLine > 0 && count[pos.Line] >= l.perLine) {
+				continue
+			}
+
+			count[pos.Line]++
+			positions = append(positions, pos)
+			l.done = l.first
+		}
+
+		if len(positions) == 0 {
+			continue
+		}
+
+		if len(positions) < len(item.Positions) {
+			item.Positions = positions
+			item.Occurrences = len(positions)
+			item.limited = true
+		}
+
+		kept = append(kept, item)
+	}
+
+	return kept
+}
+
+// Matches returns the occurrences of the rules in the text of the finding,
+// with the offsets relative to the text. Only the occurrences at the
+// positions of the finding are returned when they are limited, i.e. with
+// Options.MaxPerLine.
+func (item Finding) Matches(rs RuleSet) []RuleMatch {
+	matches := item.located
+
+	if matches == nil {
+		matches = rs.FindAll([]byte(item.OriginalText))
+	}
+
+	if !item.limited {
+		return matches
+	}
+
+	starts := make(map[[2]int]bool, len(item.Positions))
+
+	for _, pos := range item.Positions {
+		starts[[2]int{pos.Line, pos.Column}] = true
+	}
+
+	var kept []RuleMatch
+
+	line, begin, scanned := item.LineNumber, 0, 0
+
+	for _, m := range matches {
+		for ; scanned < m.Loc[0]; scanned++ {
+			if item.OriginalText[scanned] == '\n' {
+				line++
+				begin = scanned + 1
+			}
+		}
+
+		if starts[[2]int{line, m.Loc[0] - begin + 1}] {
+			kept = append(kept, m)
+		}
+	}
+
+	return kept
+}
+
+// Denied reports whether all the occurrences of the finding are of deny
+// rules, so there is nothing to replace.
+func (item Finding) Denied(rs RuleSet) bool {
+	matches := item.Matches(rs)
+
+	for _, m := range matches {
+		if !m.Rule.Deny {
+			return false
+		}
+	}
+
+	return len(matches) > 0
+}
+
+// Highlight is like RuleSet.Highlight, for the text of the finding, but only
+// wraps the occurrences returned by Matches.
+func (item Finding) Highlight(rs RuleSet, fn func(m RuleMatch) string) string {
+	return highlightMatches(item.OriginalText, item.Matches(rs), fn)
+}
+
+// Replaced returns the text of the finding with the occurrences returned by
+// Matches replaced.
+func (item Finding) Replaced(rs RuleSet) string {
+	return item.Highlight(rs, func(m RuleMatch) string {
+		return string(m.Rule.Expand([]byte(item.OriginalText), m.Loc))
+	})
+}
@@ -0,0 +1,268 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Relink is a link, or an image, of a Markdown file whose target moves with
+// the renames, or whose relative path changes because the file itself moves.
+type Relink struct {
+	// Filename is the path of the Markdown file once renamed.
+	Filename string
+	Line     int
+	From     string
+	To       string
+
+	// offset is where the target of the link begins in the file.
+	offset int
+}
+
+// markdownLink matches the targets of the inline links and images, i.e.
+// [text](path "title"), and of the reference definitions, i.e. [id]: path.
+var markdownLink = regexp.MustCompile(`(?m)\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)|^ {0,3}\[[^\]]+\]:[ \t]*<?([^\s>]+)>?`)
+
+// linkScheme matches the targets that are not relative paths, i.e. URLs.
+var linkScheme = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+// Relinks returns the links of the Markdown files that must be rewritten once
+// the files are renamed, sorted by file and offset. The relative paths are
+// recalculated from the new location of both the Markdown file and the
+// target. The renames with an error are ignored, since they will not happen.
+// Only the Markdown files among the files of the engine are considered.
+func (e *Engine) Relinks(ctx context.Context, renames []Rename) ([]Relink, error) {
+	moved := map[string]string{}
+
+	for _, r := range renames {
+		if r.Err == nil {
+			moved[cleanPath(r.From)] = cleanPath(r.To)
+		}
+	}
+
+	if len(moved) == 0 {
+		return nil, nil
+	}
+
+	files, err := e.files(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	var relinks []Relink
+
+	for _, name := range files {
+		if ext := strings.ToLower(filepath.Ext(name)); ext != ".md" && ext != ".markdown" {
+			continue
+		}
+
+		content, err := os.ReadFile(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		relinks = append(relinks, e.fileRelinks(cleanPath(name), content, moved)...)
+	}
+
+	return relinks, nil
+}
+
+// fileRelinks returns the links of the Markdown file that must be rewritten.
+// The links inside the fenced code blocks are ignored.
+func (e *Engine) fileRelinks(name string, content []byte, moved map[string]string) []Relink {
+	var relinks []Relink
+
+	newName, ok := moved[name]
+
+	if !ok {
+		newName = name
+	}
+
+	offsets := lineOffsets(content)
+	fences := fencedBlocks(content)
+
+	for _, m := range markdownLink.FindAllSubmatchIndex(content, -1) {
+		start, end := m[2], m[3]
+
+		if start < 0 {
+			start, end = m[4], m[5]
+		}
+
+		if inFence(fences, start) {
+			continue
+		}
+
+		to, ok := e.relink(string(content[start:end]), name, newName, moved)
+
+		if !ok || to == string(content[start:end]) {
+			continue
+		}
+
+		relinks = append(relinks, Relink{
+			Filename: newName,
+			Line:     lineAt(offsets, start),
+			From:     string(content[start:end]),
+			To:       to,
+			offset:   start,
+		})
+	}
+
+	return relinks
+}
+
+// relink returns the new target of the link of the Markdown file, or false
+// if it is not a relative path or neither the file nor the target move.
+func (e *Engine) relink(target string, name string, newName string, moved map[string]string) (string, bool) {
+	if target == "" || target[0] == '#' || target[0] == '/' || linkScheme.MatchString(target) {
+		return "", false
+	}
+
+	path, suffix := target, ""
+
+	if k := strings.IndexAny(path, "#?"); k >= 0 {
+		path, suffix = path[:k], path[k:]
+	}
+
+	decoded, err := url.PathUnescape(path)
+
+	if err != nil {
+		return "", false
+	}
+
+	dest := cleanPath(filepath.Join(filepath.Dir(name), decoded))
+	newDest, ok := moved[dest]
+
+	if !ok {
+		newDest = dest
+
+		// the directories are renamed with the files inside of them.
+		for from := range moved {
+			if strings.HasPrefix(from, dest+"/") {
+				if renamed, err := e.renamePath(dest); err == nil {
+					newDest = renamed
+				}
+				break
+			}
+		}
+	}
+
+	if newDest == dest && newName == name {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(newName), newDest)
+
+	if err != nil {
+		return "", false
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	if strings.HasSuffix(path, "/") && !strings.HasSuffix(rel, "/") {
+		rel += "/"
+	}
+
+	if decoded != path {
+		rel = (&url.URL{Path: rel}).EscapedPath()
+	}
+
+	return rel + suffix, true
+}
+
+// fencedBlocks returns the offsets of the fenced code blocks of the content.
+func fencedBlocks(content []byte) [][2]int {
+	var blocks [][2]int
+	var fence []byte
+	var start, offset int
+
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+
+		switch {
+		case fence == nil && (bytes.HasPrefix(trimmed, []byte("```")) || bytes.HasPrefix(trimmed, []byte("~~~"))):
+			fence, start = trimmed[:3], offset
+		case fence != nil && bytes.HasPrefix(trimmed, fence):
+			blocks = append(blocks, [2]int{start, offset + len(line)})
+			fence = nil
+		}
+
+		offset += len(line)
+	}
+
+	if fence != nil {
+		blocks = append(blocks, [2]int{start, len(content)})
+	}
+
+	return blocks
+}
+
+// inFence reports whether the offset is inside one of the blocks.
+func inFence(blocks [][2]int, offset int) bool {
+	for _, block := range blocks {
+		if offset >= block[0] && offset < block[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ApplyRelinks rewrites the links in the Markdown files, which must already
+// be renamed. The files that cannot be rewritten are returned with the error.
+func (e *Engine) ApplyRelinks(relinks []Relink) map[string]error {
+	errs := map[string]error{}
+	byFile := map[string][]Relink{}
+
+	for _, r := range relinks {
+		byFile[r.Filename] = append(byFile[r.Filename], r)
+	}
+
+	for name, list := range byFile {
+		fi, err := os.Stat(name)
+
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+
+		content, err := os.ReadFile(name)
+
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+
+		var out []byte
+		var last int
+
+		for _, r := range list {
+			if !bytes.HasPrefix(content[r.offset:], []byte(r.From)) {
+				errs[name] = ErrChanged
+				break
+			}
+
+			out = append(out, content[last:r.offset]...)
+			out = append(out, r.To...)
+			last = r.offset + len(r.From)
+		}
+
+		if errs[name] != nil {
+			continue
+		}
+
+		if err := writeFileAtomic(name, append(out, content[last:]...), fi.Mode().Perm()); err != nil {
+			errs[name] = err
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"bytes"
+)
+
+// literals returns the search texts of the rules if all of them are matched
+// literally, case-sensitively and without normalization, or nil otherwise.
+// Every occurrence of such rules contains the search text, even in
+// whole-word mode.
+func (rs RuleSet) literals() [][]byte {
+	var list [][]byte
+
+	for _, rule := range rs {
+		opts := rule.Options
+
+		if opts.Regexp || opts.IgnoreCase || opts.PreserveCase || opts.Structural || opts.Normalize != "" || rule.Search == "" {
+			return nil
+		}
+
+		list = append(list, []byte(rule.Search))
+	}
+
+	return list
+}
+
+// findLiterals searches the lines of the content like the line scanner, but
+// only the lines containing one of the literal search texts, located with
+// bytes.Index, are split and matched. The line numbers are counted from one
+// candidate line to the next.
+func findLiterals(rs RuleSet, literals [][]byte, content []byte) []Finding {
+	var findings []Finding
+
+	// next is the position of the next occurrence of every literal, which is
+	// searched again only once the scan goes past it.
+	next := make([]int, len(literals))
+
+	for i, literal := range literals {
+		next[i] = bytes.Index(content, literal)
+	}
+
+	row, counted := 1, 0
+
+	for start := 0; start < len(content); {
+		at := -1
+
+		for i, literal := range literals {
+			if next[i] >= 0 && next[i] < start {
+				if next[i] = bytes.Index(content[start:], literal); next[i] >= 0 {
+					next[i] += start
+				}
+			}
+
+			if next[i] >= 0 && (at < 0 || next[i] < at) {
+				at = next[i]
+			}
+		}
+
+		if at < 0 {
+			break
+		}
+
+		begin := bytes.LastIndexByte(content[:at], '\n') + 1
+		end := bytes.IndexByte(content[at:], '\n')
+
+		if end < 0 {
+			end = len(content)
+		} else {
+			end += at
+		}
+
+		row += bytes.Count(content[counted:begin], []byte("\n"))
+		counted = begin
+
+		// the scanner drops the carriage return of the Windows line endings.
+		line := string(bytes.TrimSuffix(content[begin:end], []byte("\r")))
+
+		if item, ok := findInLine(rs, line, row); ok {
+			findings = append(findings, item)
+		}
+
+		start = end + 1
+	}
+
+	return findings
+}
+
+// findInLine returns the finding with every match of the rules in the line.
+func findInLine(rs RuleSet, line string, row int) (Finding, bool) {
+	matches := rs.FindAll([]byte(line))
+
+	if len(matches) == 0 {
+		return Finding{}, false
+	}
+
+	positions := make([]Position, len(matches))
+
+	for i, m := range matches {
+		positions[i] = positionIn([]byte(line), row, m.Loc[0])
+	}
+
+	return Finding{
+		LineNumber:   row,
+		EndLine:      row,
+		Occurrences:  len(matches),
+		OriginalText: line,
+		Positions:    positions,
+	}, true
+}
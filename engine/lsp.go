@@ -0,0 +1,543 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// lspServers are the commands of the language servers used by LSPRename when
+// no command is given, by language.
+var lspServers = map[string][]string{
+	"go":         {"gopls"},
+	"javascript": {"typescript-language-server", "--stdio"},
+	"python":     {"pylsp"},
+	"shell":      {"bash-language-server", "start"},
+}
+
+// FileEdit is the new content of a file modified by a language server.
+type FileEdit struct {
+	Filename string
+	Original []byte
+	Modified []byte
+}
+
+// rpcMessage is a request, a response or a notification of the protocol.
+type rpcMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  interface{}      `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// lspPosition is a position of the protocol, where the character is counted
+// in UTF-16 code units.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// lspTextEdit replaces a range of a document.
+type lspTextEdit struct {
+	Range struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	} `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// lspWorkspaceEdit is the result of textDocument/rename, in either of the
+// two forms of the protocol.
+type lspWorkspaceEdit struct {
+	Changes         map[string][]lspTextEdit `json:"changes"`
+	DocumentChanges []struct {
+		Kind         string `json:"kind"`
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Edits []lspTextEdit `json:"edits"`
+	} `json:"documentChanges"`
+}
+
+// rpcClient talks JSON-RPC to a program over its standard input and output,
+// with the Content-Length headers of LSP, i.e. a language server or a plugin.
+type rpcClient struct {
+	in  io.Writer
+	out *bufio.Reader
+	id  int
+}
+
+// LSPRename asks a language server to rename the symbol at the line and the
+// column of the file, both starting at 1 and the column counted in bytes, and
+// returns the files to modify, without modifying them. The server is the
+// command, or the default server of the language of the file if it is empty.
+// The workspace is the working directory.
+func LSPRename(ctx context.Context, command []string, filename string, line int, column int, newName string) ([]FileEdit, error) {
+	content, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lang := LanguageFor(filename, content)
+
+	if len(command) == 0 {
+		if lang != nil {
+			command = lspServers[lang.Name]
+		}
+
+		if len(command) == 0 {
+			return nil, fmt.Errorf("no language server for %s, use a server command", filename)
+		}
+	}
+
+	position, err := lspPositionOf(content, line, column)
+
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.Getwd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	abs, err := filepath.Abs(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stderr = io.Discard
+
+	stdin, err := cmd.StdinPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("exec.Start %s %s", command[0], err)
+	}
+
+	defer func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}()
+
+	c := &rpcClient{in: stdin, out: bufio.NewReader(stdout)}
+
+	var languageID string
+
+	if lang != nil {
+		languageID = lang.Name
+	}
+
+	initialize := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   fileURI(root),
+		"workspaceFolders": []map[string]string{
+			{"uri": fileURI(root), "name": filepath.Base(root)},
+		},
+		"capabilities": map[string]interface{}{
+			"workspace": map[string]interface{}{
+				"workspaceEdit": map[string]interface{}{"documentChanges": true},
+			},
+		},
+	}
+
+	if _, err := c.call("initialize", initialize); err != nil {
+		return nil, err
+	}
+
+	if err := c.notify("initialized", map[string]interface{}{}); err != nil {
+		return nil, err
+	}
+
+	uri := fileURI(abs)
+
+	open := map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        uri,
+			"languageId": languageID,
+			"version":    1,
+			"text":       string(content),
+		},
+	}
+
+	if err := c.notify("textDocument/didOpen", open); err != nil {
+		return nil, err
+	}
+
+	result, err := c.call("textDocument/rename", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     position,
+		"newName":      newName,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the server exits on its own after the exit notification.
+	if _, err := c.call("shutdown", nil); err == nil {
+		_ = c.notify("exit", nil)
+	}
+
+	return workspaceFileEdits(result, root)
+}
+
+// workspaceFileEdits applies the edits of the workspace edit to the content of
+// the files, sorted by name, relative to the root when they are inside of it.
+func workspaceFileEdits(result json.RawMessage, root string) ([]FileEdit, error) {
+	var edit lspWorkspaceEdit
+
+	if len(result) == 0 || string(result) == "null" {
+		return nil, fmt.Errorf("the language server found nothing to rename")
+	}
+
+	if err := json.Unmarshal(result, &edit); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal %s", err)
+	}
+
+	byURI := map[string][]lspTextEdit{}
+
+	for uri, edits := range edit.Changes {
+		byURI[uri] = append(byURI[uri], edits...)
+	}
+
+	for _, change := range edit.DocumentChanges {
+		// the files to create, rename or delete.
+		if change.Kind != "" {
+			return nil, fmt.Errorf("the language server wants to %s a file, which is not supported", change.Kind)
+		}
+
+		byURI[change.TextDocument.URI] = append(byURI[change.TextDocument.URI], change.Edits...)
+	}
+
+	var files []FileEdit
+
+	for uri, edits := range byURI {
+		u, err := url.Parse(uri)
+
+		if err != nil || u.Scheme != "file" {
+			return nil, fmt.Errorf("unsupported document %s", uri)
+		}
+
+		name := filepath.FromSlash(u.Path)
+
+		if rel, err := filepath.Rel(root, name); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+
+		original, err := os.ReadFile(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		modified, err := applyTextEdits(original, edits)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s %s", name, err)
+		}
+
+		files = append(files, FileEdit{Filename: name, Original: original, Modified: modified})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Filename < files[j].Filename
+	})
+
+	return files, nil
+}
+
+// applyTextEdits replaces the ranges of the content, which cannot overlap.
+func applyTextEdits(content []byte, edits []lspTextEdit) ([]byte, error) {
+	type span struct {
+		start, end int
+		text       string
+	}
+
+	var spans []span
+
+	for _, edit := range edits {
+		start, err := lspOffset(content, edit.Range.Start)
+
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := lspOffset(content, edit.Range.End)
+
+		if err != nil {
+			return nil, err
+		}
+
+		spans = append(spans, span{start, end, edit.NewText})
+	}
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	var out []byte
+	var last int
+
+	for _, s := range spans {
+		if s.start < last || s.end < s.start {
+			return nil, fmt.Errorf("overlapping edits")
+		}
+
+		out = append(out, content[last:s.start]...)
+		out = append(out, s.text...)
+		last = s.end
+	}
+
+	return append(out, content[last:]...), nil
+}
+
+// lspPositionOf converts the line and the column in bytes, starting at 1, to
+// a position of the protocol.
+func lspPositionOf(content []byte, line int, column int) (lspPosition, error) {
+	offsets := lineOffsets(content)
+
+	if line < 1 || line > len(offsets) {
+		return lspPosition{}, fmt.Errorf("the line %d is out of range", line)
+	}
+
+	text := content[offsets[line-1]:lineEnding(content, offsets[line-1])]
+
+	if column < 1 || column > len(text)+1 {
+		return lspPosition{}, fmt.Errorf("the column %d is out of range", column)
+	}
+
+	return lspPosition{Line: line - 1, Character: utf16Len(text[:column-1])}, nil
+}
+
+// lspOffset converts a position of the protocol to an offset of the content.
+func lspOffset(content []byte, position lspPosition) (int, error) {
+	offsets := lineOffsets(content)
+
+	if position.Line == len(offsets) && position.Character == 0 {
+		return len(content), nil
+	}
+
+	if position.Line < 0 || position.Line >= len(offsets) {
+		return 0, fmt.Errorf("the line %d is out of range", position.Line+1)
+	}
+
+	offset := offsets[position.Line]
+	end := lineEnding(content, offset)
+
+	for units := 0; units < position.Character && offset < end; {
+		r, size := utf8.DecodeRune(content[offset:end])
+		units += utf16Len([]byte(string(r)))
+		offset += size
+	}
+
+	return offset, nil
+}
+
+// utf16Len returns the number of UTF-16 code units of the text.
+func utf16Len(text []byte) int {
+	var n int
+
+	for _, r := range string(text) {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+
+	return n
+}
+
+// fileURI returns the URI of the absolute path.
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// call sends a request and returns its result. The notifications received in
+// the meantime are ignored and the requests of the server get an empty result.
+func (c *rpcClient) call(method string, params interface{}) (json.RawMessage, error) {
+	c.id++
+
+	id := json.RawMessage(strconv.Itoa(c.id))
+
+	if err := c.send(rpcMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := c.receive()
+
+		if err != nil {
+			return nil, fmt.Errorf("%s %s", method, err)
+		}
+
+		switch {
+		case msg.ID == nil:
+			continue
+		case msg.Method != "":
+			if err := c.reply(msg); err != nil {
+				return nil, err
+			}
+		case string(*msg.ID) == string(id):
+			if msg.Error != nil {
+				return nil, fmt.Errorf("%s %s", method, msg.Error.Message)
+			}
+			return msg.Result, nil
+		}
+	}
+}
+
+// reply answers a request of the server, i.e. workspace/configuration, which
+// expects one result per item.
+func (c *rpcClient) reply(msg *rpcMessage) error {
+	result := json.RawMessage("null")
+
+	if msg.Method == "workspace/configuration" {
+		var params struct {
+			Items []json.RawMessage `json:"items"`
+		}
+
+		if data, err := json.Marshal(msg.Params); err == nil && json.Unmarshal(data, &params) == nil {
+			result = json.RawMessage("[" + strings.TrimSuffix(strings.Repeat("null,", len(params.Items)), ",") + "]")
+		}
+	}
+
+	return c.send(rpcMessage{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+// notify sends a notification, which has no response.
+func (c *rpcClient) notify(method string, params interface{}) error {
+	return c.send(rpcMessage{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// send writes the message with its header.
+func (c *rpcClient) send(msg rpcMessage) error {
+	data, err := json.Marshal(msg)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(data), data)
+
+	return err
+}
+
+// receive reads the next message of the server.
+func (c *rpcClient) receive() (*rpcMessage, error) {
+	length := -1
+
+	for {
+		line, err := c.out.ReadString('\n')
+
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			break
+		}
+
+		if k := strings.IndexByte(line, ':'); k >= 0 && strings.EqualFold(line[:k], "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[k+1:])); err != nil {
+				return nil, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	data := make([]byte, length)
+
+	if _, err := io.ReadFull(c.out, data); err != nil {
+		return nil, err
+	}
+
+	var msg rpcMessage
+
+	if err := json.Unmarshal(bytes.TrimSpace(data), &msg); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal %s", err)
+	}
+
+	return &msg, nil
+}
+
+// ApplyFileEdits writes the files modified by a language server and records
+// their original content in the journal folder, if not empty, so the changes
+// can be reverted with Undo. The files that changed since the edits were
+// computed are left untouched and reported with ErrChanged.
+func ApplyFileEdits(edits []FileEdit, journalDir string) []PatchResult {
+	var j *journal
+
+	if journalDir != "" {
+		j = newJournal(journalDir)
+	}
+
+	var results []PatchResult
+
+	for _, edit := range edits {
+		results = append(results, PatchResult{Filename: edit.Filename, Err: applyFileEdit(edit, j)})
+	}
+
+	return results
+}
+
+func applyFileEdit(edit FileEdit, j *journal) error {
+	fi, err := os.Stat(edit.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(edit.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(current, edit.Original) {
+		return ErrChanged
+	}
+
+	if j != nil {
+		if err := j.Record(edit.Filename, edit.Original, edit.Modified); err != nil {
+			return fmt.Errorf("journal.Record %s %s", edit.Filename, err)
+		}
+	}
+
+	return writeFileAtomic(edit.Filename, edit.Modified, fi.Mode().Perm())
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// occurrenceMapper computes the replacement of the text of an occurrence.
+type occurrenceMapper interface {
+	run(text []byte) ([]byte, error)
+}
+
+// commandMap replaces the occurrences with the output of the command of
+// Options.MapCommand, which reads the text of the occurrence from stdin. The
+// line terminator at the end of the output is removed. The command runs once
+// per distinct text and the output is reused for the other occurrences.
+type commandMap struct {
+	args []string
+
+	mu      sync.Mutex
+	outputs map[string]mapOutput
+}
+
+// mapOutput is the result of the command for one text.
+type mapOutput struct {
+	text []byte
+	err  error
+}
+
+// newCommandMap splits the command into its arguments.
+func newCommandMap(command string) (*commandMap, error) {
+	args := strings.Fields(command)
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the map command is empty")
+	}
+
+	return &commandMap{args: args, outputs: map[string]mapOutput{}}, nil
+}
+
+// run returns the output of the command for the text.
+func (c *commandMap) run(text []byte) ([]byte, error) {
+	c.mu.Lock()
+	out, ok := c.outputs[string(text)]
+	c.mu.Unlock()
+
+	if ok {
+		return out.text, out.err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(c.args[0], c.args[1:]...)
+	cmd.Stdin = bytes.NewReader(text)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			out.err = fmt.Errorf("map-cmd %s %s: %s", c.args[0], err, msg)
+		} else {
+			out.err = fmt.Errorf("map-cmd %s %s", c.args[0], err)
+		}
+	} else {
+		out.text = bytes.TrimSuffix(bytes.TrimSuffix(stdout.Bytes(), []byte("\n")), []byte("\r"))
+	}
+
+	c.mu.Lock()
+	c.outputs[string(text)] = out
+	c.mu.Unlock()
+
+	return out.text, out.err
+}
+
+// mapFindings runs the command for the occurrences of the findings, before
+// anything is replaced, so the failures are reported with the file instead
+// of interrupting its replacement.
+func (c *commandMap) mapFindings(rs RuleSet, findings []Finding) error {
+	for _, item := range findings {
+		for _, m := range item.Matches(rs) {
+			if m.Rule.Deny {
+				continue
+			}
+
+			if _, err := c.run([]byte(item.OriginalText[m.Loc[0]:m.Loc[1]])); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
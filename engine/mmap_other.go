@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package engine
+
+import (
+	"os"
+)
+
+// mapFile is not supported on this system; the files are read instead.
+func mapFile(file *os.File, size int64) ([]byte, func(), bool) {
+	return nil, nil, false
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package engine
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the content of the file in memory, read-only. The returned
+// function unmaps it; the content must not be used afterwards.
+func mapFile(file *os.File, size int64) ([]byte, func(), bool) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, nil, false
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return data, func() { _ = syscall.Munmap(data) }, true
+}
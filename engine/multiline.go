@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+)
+
+// lineOffsets returns the byte offset where every line of the content starts.
+func lineOffsets(content []byte) []int {
+	offsets := []int{0}
+
+	for i, c := range content {
+		if c == '\n' && i+1 < len(content) {
+			offsets = append(offsets, i+1)
+		}
+	}
+
+	return offsets
+}
+
+// lineAt returns the 1-based number of the line containing the byte offset.
+func lineAt(offsets []int, offset int) int {
+	return sort.Search(len(offsets), func(i int) bool { return offsets[i] > offset })
+}
+
+// findMultiline searches the entire content at once so the pattern can match
+// across line boundaries. Matches sharing one or more lines are reported as a
+// single finding spanning all of them.
+func findMultiline(rs RuleSet, content []byte) []Finding {
+	return groupMatches(content, rs.FindAll(content))
+}
+
+// groupMatches converts the matches into findings, grouping the matches that
+// share one or more lines.
+func groupMatches(content []byte, matches []RuleMatch) []Finding {
+	var findings []Finding
+
+	offsets := lineOffsets(content)
+
+	for _, match := range matches {
+		m := match.Loc
+		start := lineAt(offsets, m[0])
+		end := start
+
+		if m[1] > m[0] {
+			end = lineAt(offsets, m[1]-1)
+		}
+
+		pos := positionIn(content[offsets[start-1]:], start, m[0]-offsets[start-1])
+
+		if n := len(findings); n > 0 && findings[n-1].EndLine >= start {
+			findings[n-1].Occurrences++
+			findings[n-1].Positions = append(findings[n-1].Positions, pos)
+			if end > findings[n-1].EndLine {
+				findings[n-1].EndLine = end
+			}
+			continue
+		}
+
+		findings = append(findings, Finding{
+			LineNumber:  start,
+			EndLine:     end,
+			Occurrences: 1,
+			Positions:   []Position{pos},
+		})
+	}
+
+	for i, item := range findings {
+		last := len(content)
+
+		if item.EndLine < len(offsets) {
+			last = offsets[item.EndLine]
+		}
+
+		text := bytes.TrimSuffix(content[offsets[item.LineNumber-1]:last], []byte("\n"))
+		findings[i].OriginalText = string(bytes.TrimSuffix(text, []byte("\r")))
+	}
+
+	return findings
+}
+
+// replaceMultiline applies the replacement to the entire content at once. If
+// the selection is not nil, only the matches that belong to a selected finding
+// are replaced. The line feeds inserted by the replacements are converted to
+// the specified line terminator, and the placeholders are expanded for the
+// placement.
+func replaceMultiline(rs RuleSet, content []byte, findings []Finding, selected map[int]bool, newline string, p *placement) []byte {
+	return replaceMatches(content, rs.FindAll(content), findings, selected, newline, p)
+}
+
+// replaceMatches is like replaceMultiline but only replaces the matches that
+// start at the positions of the findings.
+func replaceMatches(content []byte, matches []RuleMatch, findings []Finding, selected map[int]bool, newline string, p *placement) []byte {
+	var last int
+	var out bytes.Buffer
+
+	offsets := lineOffsets(content)
+	starts := map[[2]int]bool{}
+
+	for _, item := range findings {
+		if selected == nil || selected[item.LineNumber] {
+			for _, pos := range item.Positions {
+				starts[[2]int{pos.Line, pos.Column}] = true
+			}
+		}
+	}
+
+	for _, m := range matches {
+		line := lineAt(offsets, m.Loc[0])
+
+		if !starts[[2]int{line, m.Loc[0] - offsets[line-1] + 1}] {
+			continue
+		}
+
+		if p != nil {
+			p.line = p.base + line
+		}
+
+		out.Write(content[last:m.Loc[0]])
+		out.Write(convertNewlines(m.Rule.expand(content, m.Loc, p), newline))
+		last = m.Loc[1]
+	}
+
+	out.Write(content[last:])
+
+	return out.Bytes()
+}
+
+// moveFindings returns a copy of the findings with the line numbers moved by
+// the number of lines.
+func moveFindings(findings []Finding, lines int) []Finding {
+	moved := make([]Finding, len(findings))
+
+	for i, item := range findings {
+		item.LineNumber += lines
+		item.EndLine += lines
+		item.Positions = append([]Position(nil), item.Positions...)
+		for j := range item.Positions {
+			item.Positions[j].Line += lines
+		}
+		moved[i] = item
+	}
+
+	return moved
+}
+
+// Lines formats the line numbers covered by the finding, i.e. "12" or "12-14"
+// when the match spans multiple lines.
+func (item Finding) Lines() string {
+	if item.EndLine > item.LineNumber {
+		return strconv.Itoa(item.LineNumber) + "-" + strconv.Itoa(item.EndLine)
+	}
+
+	return strconv.Itoa(item.LineNumber)
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// The normal forms of Options.Normalize.
+const (
+	NormalizeNFC = "nfc"
+	NormalizeNFD = "nfd"
+)
+
+// compositions is the inverse of decompositions.
+var compositions = func() map[string]rune {
+	m := make(map[string]rune, len(decompositions))
+
+	for r, d := range decompositions {
+		m[d] = r
+	}
+
+	return m
+}()
+
+// validNormalForm reports whether the value is empty or one of the forms.
+func validNormalForm(form string) bool {
+	return form == "" || form == NormalizeNFC || form == NormalizeNFD
+}
+
+// toNFD decomposes the precomposed letters of the text.
+func toNFD(text string) string {
+	var out strings.Builder
+
+	for _, r := range text {
+		if d, ok := decompositions[r]; ok {
+			out.WriteString(d)
+		} else {
+			out.WriteRune(r)
+		}
+	}
+
+	return out.String()
+}
+
+// toNFC composes the letters followed by combining marks of the text, when
+// there is a precomposed letter for them.
+func toNFC(text string) string {
+	var out strings.Builder
+
+	runes := []rune(toNFD(text))
+
+	for i := 0; i < len(runes); {
+		// the marks following the letter, composed as far as possible.
+		end := i + 1
+
+		for end < len(runes) && unicode.Is(unicode.Mn, runes[end]) {
+			end++
+		}
+
+		for ; end > i+1; end-- {
+			if r, ok := compositions[string(runes[i:end])]; ok {
+				out.WriteRune(r)
+				break
+			}
+		}
+
+		if end == i+1 {
+			out.WriteRune(runes[i])
+		}
+
+		i = end
+	}
+
+	return out.String()
+}
+
+// normalize converts the text to the normal form, if any.
+func normalize(text string, form string) string {
+	switch form {
+	case NormalizeNFC:
+		return toNFC(text)
+	case NormalizeNFD:
+		return toNFD(text)
+	}
+
+	return text
+}
+
+// normalizedPattern quotes the literal query so that every precomposed letter
+// also matches its decomposition, and the other way around.
+func normalizedPattern(query string) string {
+	var out strings.Builder
+
+	for _, r := range toNFC(query) {
+		if d, ok := decompositions[r]; ok {
+			out.WriteString("(?:" + regexp.QuoteMeta(string(r)) + "|" + regexp.QuoteMeta(d) + ")")
+		} else {
+			out.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	return out.String()
+}
@@ -0,0 +1,203 @@
+package engine
+
+// decompositions maps the precomposed Latin, Greek and Cyrillic letters to
+// their canonical decomposition, a base letter followed by combining marks,
+// from version 14.0.0 of the Unicode Character Database.
+var decompositions = map[rune]string{
+	0x00C0: "A\u0300", 0x00C1: "A\u0301", 0x00C2: "A\u0302", 0x00C3: "A\u0303",
+	0x00C4: "A\u0308", 0x00C5: "A\u030A", 0x00C7: "C\u0327", 0x00C8: "E\u0300",
+	0x00C9: "E\u0301", 0x00CA: "E\u0302", 0x00CB: "E\u0308", 0x00CC: "I\u0300",
+	0x00CD: "I\u0301", 0x00CE: "I\u0302", 0x00CF: "I\u0308", 0x00D1: "N\u0303",
+	0x00D2: "O\u0300", 0x00D3: "O\u0301", 0x00D4: "O\u0302", 0x00D5: "O\u0303",
+	0x00D6: "O\u0308", 0x00D9: "U\u0300", 0x00DA: "U\u0301", 0x00DB: "U\u0302",
+	0x00DC: "U\u0308", 0x00DD: "Y\u0301", 0x00E0: "a\u0300", 0x00E1: "a\u0301",
+	0x00E2: "a\u0302", 0x00E3: "a\u0303", 0x00E4: "a\u0308", 0x00E5: "a\u030A",
+	0x00E7: "c\u0327", 0x00E8: "e\u0300", 0x00E9: "e\u0301", 0x00EA: "e\u0302",
+	0x00EB: "e\u0308", 0x00EC: "i\u0300", 0x00ED: "i\u0301", 0x00EE: "i\u0302",
+	0x00EF: "i\u0308", 0x00F1: "n\u0303", 0x00F2: "o\u0300", 0x00F3: "o\u0301",
+	0x00F4: "o\u0302", 0x00F5: "o\u0303", 0x00F6: "o\u0308", 0x00F9: "u\u0300",
+	0x00FA: "u\u0301", 0x00FB: "u\u0302", 0x00FC: "u\u0308", 0x00FD: "y\u0301",
+	0x00FF: "y\u0308", 0x0100: "A\u0304", 0x0101: "a\u0304", 0x0102: "A\u0306",
+	0x0103: "a\u0306", 0x0104: "A\u0328", 0x0105: "a\u0328", 0x0106: "C\u0301",
+	0x0107: "c\u0301", 0x0108: "C\u0302", 0x0109: "c\u0302", 0x010A: "C\u0307",
+	0x010B: "c\u0307", 0x010C: "C\u030C", 0x010D: "c\u030C", 0x010E: "D\u030C",
+	0x010F: "d\u030C", 0x0112: "E\u0304", 0x0113: "e\u0304", 0x0114: "E\u0306",
+	0x0115: "e\u0306", 0x0116: "E\u0307", 0x0117: "e\u0307", 0x0118: "E\u0328",
+	0x0119: "e\u0328", 0x011A: "E\u030C", 0x011B: "e\u030C", 0x011C: "G\u0302",
+	0x011D: "g\u0302", 0x011E: "G\u0306", 0x011F: "g\u0306", 0x0120: "G\u0307",
+	0x0121: "g\u0307", 0x0122: "G\u0327", 0x0123: "g\u0327", 0x0124: "H\u0302",
+	0x0125: "h\u0302", 0x0128: "I\u0303", 0x0129: "i\u0303", 0x012A: "I\u0304",
+	0x012B: "i\u0304", 0x012C: "I\u0306", 0x012D: "i\u0306", 0x012E: "I\u0328",
+	0x012F: "i\u0328", 0x0130: "I\u0307", 0x0134: "J\u0302", 0x0135: "j\u0302",
+	0x0136: "K\u0327", 0x0137: "k\u0327", 0x0139: "L\u0301", 0x013A: "l\u0301",
+	0x013B: "L\u0327", 0x013C: "l\u0327", 0x013D: "L\u030C", 0x013E: "l\u030C",
+	0x0143: "N\u0301", 0x0144: "n\u0301", 0x0145: "N\u0327", 0x0146: "n\u0327",
+	0x0147: "N\u030C", 0x0148: "n\u030C", 0x014C: "O\u0304", 0x014D: "o\u0304",
+	0x014E: "O\u0306", 0x014F: "o\u0306", 0x0150: "O\u030B", 0x0151: "o\u030B",
+	0x0154: "R\u0301", 0x0155: "r\u0301", 0x0156: "R\u0327", 0x0157: "r\u0327",
+	0x0158: "R\u030C", 0x0159: "r\u030C", 0x015A: "S\u0301", 0x015B: "s\u0301",
+	0x015C: "S\u0302", 0x015D: "s\u0302", 0x015E: "S\u0327", 0x015F: "s\u0327",
+	0x0160: "S\u030C", 0x0161: "s\u030C", 0x0162: "T\u0327", 0x0163: "t\u0327",
+	0x0164: "T\u030C", 0x0165: "t\u030C", 0x0168: "U\u0303", 0x0169: "u\u0303",
+	0x016A: "U\u0304", 0x016B: "u\u0304", 0x016C: "U\u0306", 0x016D: "u\u0306",
+	0x016E: "U\u030A", 0x016F: "u\u030A", 0x0170: "U\u030B", 0x0171: "u\u030B",
+	0x0172: "U\u0328", 0x0173: "u\u0328", 0x0174: "W\u0302", 0x0175: "w\u0302",
+	0x0176: "Y\u0302", 0x0177: "y\u0302", 0x0178: "Y\u0308", 0x0179: "Z\u0301",
+	0x017A: "z\u0301", 0x017B: "Z\u0307", 0x017C: "z\u0307", 0x017D: "Z\u030C",
+	0x017E: "z\u030C", 0x01A0: "O\u031B", 0x01A1: "o\u031B", 0x01AF: "U\u031B",
+	0x01B0: "u\u031B", 0x01CD: "A\u030C", 0x01CE: "a\u030C", 0x01CF: "I\u030C",
+	0x01D0: "i\u030C", 0x01D1: "O\u030C", 0x01D2: "o\u030C", 0x01D3: "U\u030C",
+	0x01D4: "u\u030C", 0x01D5: "U\u0308\u0304", 0x01D6: "u\u0308\u0304", 0x01D7: "U\u0308\u0301",
+	0x01D8: "u\u0308\u0301", 0x01D9: "U\u0308\u030C", 0x01DA: "u\u0308\u030C", 0x01DB: "U\u0308\u0300",
+	0x01DC: "u\u0308\u0300", 0x01DE: "A\u0308\u0304", 0x01DF: "a\u0308\u0304", 0x01E0: "A\u0307\u0304",
+	0x01E1: "a\u0307\u0304", 0x01E2: "\u00C6\u0304", 0x01E3: "\u00E6\u0304", 0x01E6: "G\u030C",
+	0x01E7: "g\u030C", 0x01E8: "K\u030C", 0x01E9: "k\u030C", 0x01EA: "O\u0328",
+	0x01EB: "o\u0328", 0x01EC: "O\u0328\u0304", 0x01ED: "o\u0328\u0304", 0x01EE: "\u01B7\u030C",
+	0x01EF: "\u0292\u030C", 0x01F0: "j\u030C", 0x01F4: "G\u0301", 0x01F5: "g\u0301",
+	0x01F8: "N\u0300", 0x01F9: "n\u0300", 0x01FA: "A\u030A\u0301", 0x01FB: "a\u030A\u0301",
+	0x01FC: "\u00C6\u0301", 0x01FD: "\u00E6\u0301", 0x01FE: "\u00D8\u0301", 0x01FF: "\u00F8\u0301",
+	0x0200: "A\u030F", 0x0201: "a\u030F", 0x0202: "A\u0311", 0x0203: "a\u0311",
+	0x0204: "E\u030F", 0x0205: "e\u030F", 0x0206: "E\u0311", 0x0207: "e\u0311",
+	0x0208: "I\u030F", 0x0209: "i\u030F", 0x020A: "I\u0311", 0x020B: "i\u0311",
+	0x020C: "O\u030F", 0x020D: "o\u030F", 0x020E: "O\u0311", 0x020F: "o\u0311",
+	0x0210: "R\u030F", 0x0211: "r\u030F", 0x0212: "R\u0311", 0x0213: "r\u0311",
+	0x0214: "U\u030F", 0x0215: "u\u030F", 0x0216: "U\u0311", 0x0217: "u\u0311",
+	0x0218: "S\u0326", 0x0219: "s\u0326", 0x021A: "T\u0326", 0x021B: "t\u0326",
+	0x021E: "H\u030C", 0x021F: "h\u030C", 0x0226: "A\u0307", 0x0227: "a\u0307",
+	0x0228: "E\u0327", 0x0229: "e\u0327", 0x022A: "O\u0308\u0304", 0x022B: "o\u0308\u0304",
+	0x022C: "O\u0303\u0304", 0x022D: "o\u0303\u0304", 0x022E: "O\u0307", 0x022F: "o\u0307",
+	0x0230: "O\u0307\u0304", 0x0231: "o\u0307\u0304", 0x0232: "Y\u0304", 0x0233: "y\u0304",
+	0x0385: "\u00A8\u0301", 0x0386: "\u0391\u0301", 0x0388: "\u0395\u0301", 0x0389: "\u0397\u0301",
+	0x038A: "\u0399\u0301", 0x038C: "\u039F\u0301", 0x038E: "\u03A5\u0301", 0x038F: "\u03A9\u0301",
+	0x0390: "\u03B9\u0308\u0301", 0x03AA: "\u0399\u0308", 0x03AB: "\u03A5\u0308", 0x03AC: "\u03B1\u0301",
+	0x03AD: "\u03B5\u0301", 0x03AE: "\u03B7\u0301", 0x03AF: "\u03B9\u0301", 0x03B0: "\u03C5\u0308\u0301",
+	0x03CA: "\u03B9\u0308", 0x03CB: "\u03C5\u0308", 0x03CC: "\u03BF\u0301", 0x03CD: "\u03C5\u0301",
+	0x03CE: "\u03C9\u0301", 0x03D3: "\u03D2\u0301", 0x03D4: "\u03D2\u0308", 0x0400: "\u0415\u0300",
+	0x0401: "\u0415\u0308", 0x0403: "\u0413\u0301", 0x0407: "\u0406\u0308", 0x040C: "\u041A\u0301",
+	0x040D: "\u0418\u0300", 0x040E: "\u0423\u0306", 0x0419: "\u0418\u0306", 0x0439: "\u0438\u0306",
+	0x0450: "\u0435\u0300", 0x0451: "\u0435\u0308", 0x0453: "\u0433\u0301", 0x0457: "\u0456\u0308",
+	0x045C: "\u043A\u0301", 0x045D: "\u0438\u0300", 0x045E: "\u0443\u0306", 0x0476: "\u0474\u030F",
+	0x0477: "\u0475\u030F", 0x04C1: "\u0416\u0306", 0x04C2: "\u0436\u0306", 0x04D0: "\u0410\u0306",
+	0x04D1: "\u0430\u0306", 0x04D2: "\u0410\u0308", 0x04D3: "\u0430\u0308", 0x04D6: "\u0415\u0306",
+	0x04D7: "\u0435\u0306", 0x04DA: "\u04D8\u0308", 0x04DB: "\u04D9\u0308", 0x04DC: "\u0416\u0308",
+	0x04DD: "\u0436\u0308", 0x04DE: "\u0417\u0308", 0x04DF: "\u0437\u0308", 0x04E2: "\u0418\u0304",
+	0x04E3: "\u0438\u0304", 0x04E4: "\u0418\u0308", 0x04E5: "\u0438\u0308", 0x04E6: "\u041E\u0308",
+	0x04E7: "\u043E\u0308", 0x04EA: "\u04E8\u0308", 0x04EB: "\u04E9\u0308", 0x04EC: "\u042D\u0308",
+	0x04ED: "\u044D\u0308", 0x04EE: "\u0423\u0304", 0x04EF: "\u0443\u0304", 0x04F0: "\u0423\u0308",
+	0x04F1: "\u0443\u0308", 0x04F2: "\u0423\u030B", 0x04F3: "\u0443\u030B", 0x04F4: "\u0427\u0308",
+	0x04F5: "\u0447\u0308", 0x04F8: "\u042B\u0308", 0x04F9: "\u044B\u0308", 0x1E00: "A\u0325",
+	0x1E01: "a\u0325", 0x1E02: "B\u0307", 0x1E03: "b\u0307", 0x1E04: "B\u0323",
+	0x1E05: "b\u0323", 0x1E06: "B\u0331", 0x1E07: "b\u0331", 0x1E08: "C\u0327\u0301",
+	0x1E09: "c\u0327\u0301", 0x1E0A: "D\u0307", 0x1E0B: "d\u0307", 0x1E0C: "D\u0323",
+	0x1E0D: "d\u0323", 0x1E0E: "D\u0331", 0x1E0F: "d\u0331", 0x1E10: "D\u0327",
+	0x1E11: "d\u0327", 0x1E12: "D\u032D", 0x1E13: "d\u032D", 0x1E14: "E\u0304\u0300",
+	0x1E15: "e\u0304\u0300", 0x1E16: "E\u0304\u0301", 0x1E17: "e\u0304\u0301", 0x1E18: "E\u032D",
+	0x1E19: "e\u032D", 0x1E1A: "E\u0330", 0x1E1B: "e\u0330", 0x1E1C: "E\u0327\u0306",
+	0x1E1D: "e\u0327\u0306", 0x1E1E: "F\u0307", 0x1E1F: "f\u0307", 0x1E20: "G\u0304",
+	0x1E21: "g\u0304", 0x1E22: "H\u0307", 0x1E23: "h\u0307", 0x1E24: "H\u0323",
+	0x1E25: "h\u0323", 0x1E26: "H\u0308", 0x1E27: "h\u0308", 0x1E28: "H\u0327",
+	0x1E29: "h\u0327", 0x1E2A: "H\u032E", 0x1E2B: "h\u032E", 0x1E2C: "I\u0330",
+	0x1E2D: "i\u0330", 0x1E2E: "I\u0308\u0301", 0x1E2F: "i\u0308\u0301", 0x1E30: "K\u0301",
+	0x1E31: "k\u0301", 0x1E32: "K\u0323", 0x1E33: "k\u0323", 0x1E34: "K\u0331",
+	0x1E35: "k\u0331", 0x1E36: "L\u0323", 0x1E37: "l\u0323", 0x1E38: "L\u0323\u0304",
+	0x1E39: "l\u0323\u0304", 0x1E3A: "L\u0331", 0x1E3B: "l\u0331", 0x1E3C: "L\u032D",
+	0x1E3D: "l\u032D", 0x1E3E: "M\u0301", 0x1E3F: "m\u0301", 0x1E40: "M\u0307",
+	0x1E41: "m\u0307", 0x1E42: "M\u0323", 0x1E43: "m\u0323", 0x1E44: "N\u0307",
+	0x1E45: "n\u0307", 0x1E46: "N\u0323", 0x1E47: "n\u0323", 0x1E48: "N\u0331",
+	0x1E49: "n\u0331", 0x1E4A: "N\u032D", 0x1E4B: "n\u032D", 0x1E4C: "O\u0303\u0301",
+	0x1E4D: "o\u0303\u0301", 0x1E4E: "O\u0303\u0308", 0x1E4F: "o\u0303\u0308", 0x1E50: "O\u0304\u0300",
+	0x1E51: "o\u0304\u0300", 0x1E52: "O\u0304\u0301", 0x1E53: "o\u0304\u0301", 0x1E54: "P\u0301",
+	0x1E55: "p\u0301", 0x1E56: "P\u0307", 0x1E57: "p\u0307", 0x1E58: "R\u0307",
+	0x1E59: "r\u0307", 0x1E5A: "R\u0323", 0x1E5B: "r\u0323", 0x1E5C: "R\u0323\u0304",
+	0x1E5D: "r\u0323\u0304", 0x1E5E: "R\u0331", 0x1E5F: "r\u0331", 0x1E60: "S\u0307",
+	0x1E61: "s\u0307", 0x1E62: "S\u0323", 0x1E63: "s\u0323", 0x1E64: "S\u0301\u0307",
+	0x1E65: "s\u0301\u0307", 0x1E66: "S\u030C\u0307", 0x1E67: "s\u030C\u0307", 0x1E68: "S\u0323\u0307",
+	0x1E69: "s\u0323\u0307", 0x1E6A: "T\u0307", 0x1E6B: "t\u0307", 0x1E6C: "T\u0323",
+	0x1E6D: "t\u0323", 0x1E6E: "T\u0331", 0x1E6F: "t\u0331", 0x1E70: "T\u032D",
+	0x1E71: "t\u032D", 0x1E72: "U\u0324", 0x1E73: "u\u0324", 0x1E74: "U\u0330",
+	0x1E75: "u\u0330", 0x1E76: "U\u032D", 0x1E77: "u\u032D", 0x1E78: "U\u0303\u0301",
+	0x1E79: "u\u0303\u0301", 0x1E7A: "U\u0304\u0308", 0x1E7B: "u\u0304\u0308", 0x1E7C: "V\u0303",
+	0x1E7D: "v\u0303", 0x1E7E: "V\u0323", 0x1E7F: "v\u0323", 0x1E80: "W\u0300",
+	0x1E81: "w\u0300", 0x1E82: "W\u0301", 0x1E83: "w\u0301", 0x1E84: "W\u0308",
+	0x1E85: "w\u0308", 0x1E86: "W\u0307", 0x1E87: "w\u0307", 0x1E88: "W\u0323",
+	0x1E89: "w\u0323", 0x1E8A: "X\u0307", 0x1E8B: "x\u0307", 0x1E8C: "X\u0308",
+	0x1E8D: "x\u0308", 0x1E8E: "Y\u0307", 0x1E8F: "y\u0307", 0x1E90: "Z\u0302",
+	0x1E91: "z\u0302", 0x1E92: "Z\u0323", 0x1E93: "z\u0323", 0x1E94: "Z\u0331",
+	0x1E95: "z\u0331", 0x1E96: "h\u0331", 0x1E97: "t\u0308", 0x1E98: "w\u030A",
+	0x1E99: "y\u030A", 0x1E9B: "\u017F\u0307", 0x1EA0: "A\u0323", 0x1EA1: "a\u0323",
+	0x1EA2: "A\u0309", 0x1EA3: "a\u0309", 0x1EA4: "A\u0302\u0301", 0x1EA5: "a\u0302\u0301",
+	0x1EA6: "A\u0302\u0300", 0x1EA7: "a\u0302\u0300", 0x1EA8: "A\u0302\u0309", 0x1EA9: "a\u0302\u0309",
+	0x1EAA: "A\u0302\u0303", 0x1EAB: "a\u0302\u0303", 0x1EAC: "A\u0323\u0302", 0x1EAD: "a\u0323\u0302",
+	0x1EAE: "A\u0306\u0301", 0x1EAF: "a\u0306\u0301", 0x1EB0: "A\u0306\u0300", 0x1EB1: "a\u0306\u0300",
+	0x1EB2: "A\u0306\u0309", 0x1EB3: "a\u0306\u0309", 0x1EB4: "A\u0306\u0303", 0x1EB5: "a\u0306\u0303",
+	0x1EB6: "A\u0323\u0306", 0x1EB7: "a\u0323\u0306", 0x1EB8: "E\u0323", 0x1EB9: "e\u0323",
+	0x1EBA: "E\u0309", 0x1EBB: "e\u0309", 0x1EBC: "E\u0303", 0x1EBD: "e\u0303",
+	0x1EBE: "E\u0302\u0301", 0x1EBF: "e\u0302\u0301", 0x1EC0: "E\u0302\u0300", 0x1EC1: "e\u0302\u0300",
+	0x1EC2: "E\u0302\u0309", 0x1EC3: "e\u0302\u0309", 0x1EC4: "E\u0302\u0303", 0x1EC5: "e\u0302\u0303",
+	0x1EC6: "E\u0323\u0302", 0x1EC7: "e\u0323\u0302", 0x1EC8: "I\u0309", 0x1EC9: "i\u0309",
+	0x1ECA: "I\u0323", 0x1ECB: "i\u0323", 0x1ECC: "O\u0323", 0x1ECD: "o\u0323",
+	0x1ECE: "O\u0309", 0x1ECF: "o\u0309", 0x1ED0: "O\u0302\u0301", 0x1ED1: "o\u0302\u0301",
+	0x1ED2: "O\u0302\u0300", 0x1ED3: "o\u0302\u0300", 0x1ED4: "O\u0302\u0309", 0x1ED5: "o\u0302\u0309",
+	0x1ED6: "O\u0302\u0303", 0x1ED7: "o\u0302\u0303", 0x1ED8: "O\u0323\u0302", 0x1ED9: "o\u0323\u0302",
+	0x1EDA: "O\u031B\u0301", 0x1EDB: "o\u031B\u0301", 0x1EDC: "O\u031B\u0300", 0x1EDD: "o\u031B\u0300",
+	0x1EDE: "O\u031B\u0309", 0x1EDF: "o\u031B\u0309", 0x1EE0: "O\u031B\u0303", 0x1EE1: "o\u031B\u0303",
+	0x1EE2: "O\u031B\u0323", 0x1EE3: "o\u031B\u0323", 0x1EE4: "U\u0323", 0x1EE5: "u\u0323",
+	0x1EE6: "U\u0309", 0x1EE7: "u\u0309", 0x1EE8: "U\u031B\u0301", 0x1EE9: "u\u031B\u0301",
+	0x1EEA: "U\u031B\u0300", 0x1EEB: "u\u031B\u0300", 0x1EEC: "U\u031B\u0309", 0x1EED: "u\u031B\u0309",
+	0x1EEE: "U\u031B\u0303", 0x1EEF: "u\u031B\u0303", 0x1EF0: "U\u031B\u0323", 0x1EF1: "u\u031B\u0323",
+	0x1EF2: "Y\u0300", 0x1EF3: "y\u0300", 0x1EF4: "Y\u0323", 0x1EF5: "y\u0323",
+	0x1EF6: "Y\u0309", 0x1EF7: "y\u0309", 0x1EF8: "Y\u0303", 0x1EF9: "y\u0303",
+	0x1F00: "\u03B1\u0313", 0x1F01: "\u03B1\u0314", 0x1F02: "\u03B1\u0313\u0300", 0x1F03: "\u03B1\u0314\u0300",
+	0x1F04: "\u03B1\u0313\u0301", 0x1F05: "\u03B1\u0314\u0301", 0x1F06: "\u03B1\u0313\u0342", 0x1F07: "\u03B1\u0314\u0342",
+	0x1F08: "\u0391\u0313", 0x1F09: "\u0391\u0314", 0x1F0A: "\u0391\u0313\u0300", 0x1F0B: "\u0391\u0314\u0300",
+	0x1F0C: "\u0391\u0313\u0301", 0x1F0D: "\u0391\u0314\u0301", 0x1F0E: "\u0391\u0313\u0342", 0x1F0F: "\u0391\u0314\u0342",
+	0x1F10: "\u03B5\u0313", 0x1F11: "\u03B5\u0314", 0x1F12: "\u03B5\u0313\u0300", 0x1F13: "\u03B5\u0314\u0300",
+	0x1F14: "\u03B5\u0313\u0301", 0x1F15: "\u03B5\u0314\u0301", 0x1F18: "\u0395\u0313", 0x1F19: "\u0395\u0314",
+	0x1F1A: "\u0395\u0313\u0300", 0x1F1B: "\u0395\u0314\u0300", 0x1F1C: "\u0395\u0313\u0301", 0x1F1D: "\u0395\u0314\u0301",
+	0x1F20: "\u03B7\u0313", 0x1F21: "\u03B7\u0314", 0x1F22: "\u03B7\u0313\u0300", 0x1F23: "\u03B7\u0314\u0300",
+	0x1F24: "\u03B7\u0313\u0301", 0x1F25: "\u03B7\u0314\u0301", 0x1F26: "\u03B7\u0313\u0342", 0x1F27: "\u03B7\u0314\u0342",
+	0x1F28: "\u0397\u0313", 0x1F29: "\u0397\u0314", 0x1F2A: "\u0397\u0313\u0300", 0x1F2B: "\u0397\u0314\u0300",
+	0x1F2C: "\u0397\u0313\u0301", 0x1F2D: "\u0397\u0314\u0301", 0x1F2E: "\u0397\u0313\u0342", 0x1F2F: "\u0397\u0314\u0342",
+	0x1F30: "\u03B9\u0313", 0x1F31: "\u03B9\u0314", 0x1F32: "\u03B9\u0313\u0300", 0x1F33: "\u03B9\u0314\u0300",
+	0x1F34: "\u03B9\u0313\u0301", 0x1F35: "\u03B9\u0314\u0301", 0x1F36: "\u03B9\u0313\u0342", 0x1F37: "\u03B9\u0314\u0342",
+	0x1F38: "\u0399\u0313", 0x1F39: "\u0399\u0314", 0x1F3A: "\u0399\u0313\u0300", 0x1F3B: "\u0399\u0314\u0300",
+	0x1F3C: "\u0399\u0313\u0301", 0x1F3D: "\u0399\u0314\u0301", 0x1F3E: "\u0399\u0313\u0342", 0x1F3F: "\u0399\u0314\u0342",
+	0x1F40: "\u03BF\u0313", 0x1F41: "\u03BF\u0314", 0x1F42: "\u03BF\u0313\u0300", 0x1F43: "\u03BF\u0314\u0300",
+	0x1F44: "\u03BF\u0313\u0301", 0x1F45: "\u03BF\u0314\u0301", 0x1F48: "\u039F\u0313", 0x1F49: "\u039F\u0314",
+	0x1F4A: "\u039F\u0313\u0300", 0x1F4B: "\u039F\u0314\u0300", 0x1F4C: "\u039F\u0313\u0301", 0x1F4D: "\u039F\u0314\u0301",
+	0x1F50: "\u03C5\u0313", 0x1F51: "\u03C5\u0314", 0x1F52: "\u03C5\u0313\u0300", 0x1F53: "\u03C5\u0314\u0300",
+	0x1F54: "\u03C5\u0313\u0301", 0x1F55: "\u03C5\u0314\u0301", 0x1F56: "\u03C5\u0313\u0342", 0x1F57: "\u03C5\u0314\u0342",
+	0x1F59: "\u03A5\u0314", 0x1F5B: "\u03A5\u0314\u0300", 0x1F5D: "\u03A5\u0314\u0301", 0x1F5F: "\u03A5\u0314\u0342",
+	0x1F60: "\u03C9\u0313", 0x1F61: "\u03C9\u0314", 0x1F62: "\u03C9\u0313\u0300", 0x1F63: "\u03C9\u0314\u0300",
+	0x1F64: "\u03C9\u0313\u0301", 0x1F65: "\u03C9\u0314\u0301", 0x1F66: "\u03C9\u0313\u0342", 0x1F67: "\u03C9\u0314\u0342",
+	0x1F68: "\u03A9\u0313", 0x1F69: "\u03A9\u0314", 0x1F6A: "\u03A9\u0313\u0300", 0x1F6B: "\u03A9\u0314\u0300",
+	0x1F6C: "\u03A9\u0313\u0301", 0x1F6D: "\u03A9\u0314\u0301", 0x1F6E: "\u03A9\u0313\u0342", 0x1F6F: "\u03A9\u0314\u0342",
+	0x1F70: "\u03B1\u0300", 0x1F72: "\u03B5\u0300", 0x1F74: "\u03B7\u0300", 0x1F76: "\u03B9\u0300",
+	0x1F78: "\u03BF\u0300", 0x1F7A: "\u03C5\u0300", 0x1F7C: "\u03C9\u0300", 0x1F80: "\u03B1\u0313\u0345",
+	0x1F81: "\u03B1\u0314\u0345", 0x1F82: "\u03B1\u0313\u0300\u0345", 0x1F83: "\u03B1\u0314\u0300\u0345", 0x1F84: "\u03B1\u0313\u0301\u0345",
+	0x1F85: "\u03B1\u0314\u0301\u0345", 0x1F86: "\u03B1\u0313\u0342\u0345", 0x1F87: "\u03B1\u0314\u0342\u0345", 0x1F88: "\u0391\u0313\u0345",
+	0x1F89: "\u0391\u0314\u0345", 0x1F8A: "\u0391\u0313\u0300\u0345", 0x1F8B: "\u0391\u0314\u0300\u0345", 0x1F8C: "\u0391\u0313\u0301\u0345",
+	0x1F8D: "\u0391\u0314\u0301\u0345", 0x1F8E: "\u0391\u0313\u0342\u0345", 0x1F8F: "\u0391\u0314\u0342\u0345", 0x1F90: "\u03B7\u0313\u0345",
+	0x1F91: "\u03B7\u0314\u0345", 0x1F92: "\u03B7\u0313\u0300\u0345", 0x1F93: "\u03B7\u0314\u0300\u0345", 0x1F94: "\u03B7\u0313\u0301\u0345",
+	0x1F95: "\u03B7\u0314\u0301\u0345", 0x1F96: "\u03B7\u0313\u0342\u0345", 0x1F97: "\u03B7\u0314\u0342\u0345", 0x1F98: "\u0397\u0313\u0345",
+	0x1F99: "\u0397\u0314\u0345", 0x1F9A: "\u0397\u0313\u0300\u0345", 0x1F9B: "\u0397\u0314\u0300\u0345", 0x1F9C: "\u0397\u0313\u0301\u0345",
+	0x1F9D: "\u0397\u0314\u0301\u0345", 0x1F9E: "\u0397\u0313\u0342\u0345", 0x1F9F: "\u0397\u0314\u0342\u0345", 0x1FA0: "\u03C9\u0313\u0345",
+	0x1FA1: "\u03C9\u0314\u0345", 0x1FA2: "\u03C9\u0313\u0300\u0345", 0x1FA3: "\u03C9\u0314\u0300\u0345", 0x1FA4: "\u03C9\u0313\u0301\u0345",
+	0x1FA5: "\u03C9\u0314\u0301\u0345", 0x1FA6: "\u03C9\u0313\u0342\u0345", 0x1FA7: "\u03C9\u0314\u0342\u0345", 0x1FA8: "\u03A9\u0313\u0345",
+	0x1FA9: "\u03A9\u0314\u0345", 0x1FAA: "\u03A9\u0313\u0300\u0345", 0x1FAB: "\u03A9\u0314\u0300\u0345", 0x1FAC: "\u03A9\u0313\u0301\u0345",
+	0x1FAD: "\u03A9\u0314\u0301\u0345", 0x1FAE: "\u03A9\u0313\u0342\u0345", 0x1FAF: "\u03A9\u0314\u0342\u0345", 0x1FB0: "\u03B1\u0306",
+	0x1FB1: "\u03B1\u0304", 0x1FB2: "\u03B1\u0300\u0345", 0x1FB3: "\u03B1\u0345", 0x1FB4: "\u03B1\u0301\u0345",
+	0x1FB6: "\u03B1\u0342", 0x1FB7: "\u03B1\u0342\u0345", 0x1FB8: "\u0391\u0306", 0x1FB9: "\u0391\u0304",
+	0x1FBA: "\u0391\u0300", 0x1FBC: "\u0391\u0345", 0x1FC1: "\u00A8\u0342", 0x1FC2: "\u03B7\u0300\u0345",
+	0x1FC3: "\u03B7\u0345", 0x1FC4: "\u03B7\u0301\u0345", 0x1FC6: "\u03B7\u0342", 0x1FC7: "\u03B7\u0342\u0345",
+	0x1FC8: "\u0395\u0300", 0x1FCA: "\u0397\u0300", 0x1FCC: "\u0397\u0345", 0x1FCD: "\u1FBF\u0300",
+	0x1FCE: "\u1FBF\u0301", 0x1FCF: "\u1FBF\u0342", 0x1FD0: "\u03B9\u0306", 0x1FD1: "\u03B9\u0304",
+	0x1FD2: "\u03B9\u0308\u0300", 0x1FD6: "\u03B9\u0342", 0x1FD7: "\u03B9\u0308\u0342", 0x1FD8: "\u0399\u0306",
+	0x1FD9: "\u0399\u0304", 0x1FDA: "\u0399\u0300", 0x1FDD: "\u1FFE\u0300", 0x1FDE: "\u1FFE\u0301",
+	0x1FDF: "\u1FFE\u0342", 0x1FE0: "\u03C5\u0306", 0x1FE1: "\u03C5\u0304", 0x1FE2: "\u03C5\u0308\u0300",
+	0x1FE4: "\u03C1\u0313", 0x1FE5: "\u03C1\u0314", 0x1FE6: "\u03C5\u0342", 0x1FE7: "\u03C5\u0308\u0342",
+	0x1FE8: "\u03A5\u0306", 0x1FE9: "\u03A5\u0304", 0x1FEA: "\u03A5\u0300", 0x1FEC: "\u03A1\u0314",
+	0x1FED: "\u00A8\u0300", 0x1FF2: "\u03C9\u0300\u0345", 0x1FF3: "\u03C9\u0345", 0x1FF4: "\u03C9\u0301\u0345",
+	0x1FF6: "\u03C9\u0342", 0x1FF7: "\u03C9\u0342\u0345", 0x1FF8: "\u039F\u0300", 0x1FFA: "\u03A9\u0300",
+	0x1FFC: "\u03A9\u0345",
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package engine
+
+import (
+	"os"
+)
+
+// copyOwner is a no-op on systems without POSIX file ownership.
+func copyOwner(f *os.File, fi os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package engine
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner assigns the user and group of the original file to the new file.
+// Only privileged users can give away files, so a permission error is not
+// considered a failure: the file simply keeps the owner of the process.
+func copyOwner(f *os.File, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		return nil
+	}
+
+	if err := f.Chown(int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+		return err
+	}
+
+	return nil
+}
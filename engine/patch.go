@@ -0,0 +1,281 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FilePatch is the list of changes to one single file of a unified diff.
+type FilePatch struct {
+	Filename string
+	Hunks    []Hunk
+}
+
+// Hunk is a group of changes to consecutive lines. The lines keep the prefix
+// of the unified format: a space, a minus or a plus sign.
+type Hunk struct {
+	OldStart int
+	Lines    []string
+}
+
+// PatchResult describes the outcome of patching one single file.
+type PatchResult struct {
+	Filename string
+	Err      error
+}
+
+// ParsePatch reads a patch in the unified format, like the ones written by
+// UnifiedDiff, git diff or diff -u. The "a/" and "b/" prefixes are removed
+// from the file names.
+func ParsePatch(data []byte) ([]FilePatch, error) {
+	var patches []FilePatch
+	var hunk *Hunk
+	var oldLeft, newLeft int
+
+	lines := splitLines(data)
+
+	for n := 0; n < len(lines); n++ {
+		line := strings.TrimRight(lines[n], "\r\n")
+
+		// the lines of a hunk are counted, so a removed line starting with
+		// "--" is not confused with the header of the next file.
+		if hunk != nil && (oldLeft > 0 || newLeft > 0) {
+			if line == "" {
+				// some editors remove the space of empty context lines.
+				line, lines[n] = " ", " "+lines[n]
+			}
+
+			switch line[0] {
+			case ' ':
+				oldLeft--
+				newLeft--
+			case '-':
+				oldLeft--
+			case '+':
+				newLeft--
+			case '\\':
+				if k := len(hunk.Lines) - 1; k >= 0 {
+					hunk.Lines[k] = strings.TrimSuffix(hunk.Lines[k], "\n")
+				}
+				continue
+			default:
+				return nil, fmt.Errorf("line %d: unexpected %q in hunk", n+1, line)
+			}
+
+			hunk.Lines = append(hunk.Lines, lines[n])
+			continue
+		}
+
+		switch {
+		case hunk != nil && strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file" applies to the previous line.
+			if k := len(hunk.Lines) - 1; k >= 0 {
+				hunk.Lines[k] = strings.TrimSuffix(hunk.Lines[k], "\n")
+			}
+		case strings.HasPrefix(line, "--- ") && n+1 < len(lines) && strings.HasPrefix(lines[n+1], "+++ "):
+			name := patchFilename(strings.TrimRight(lines[n+1], "\r\n")[4:], "b/")
+			patches = append(patches, FilePatch{Filename: name})
+			hunk = nil
+			n++
+		case strings.HasPrefix(line, "@@ "):
+			if len(patches) == 0 {
+				return nil, fmt.Errorf("line %d: hunk without file header", n+1)
+			}
+
+			var err error
+			var start int
+
+			if start, oldLeft, newLeft, err = parseHunkHeader(line); err != nil {
+				return nil, fmt.Errorf("line %d: %s", n+1, err)
+			}
+
+			fp := &patches[len(patches)-1]
+			fp.Hunks = append(fp.Hunks, Hunk{OldStart: start})
+			hunk = &fp.Hunks[len(fp.Hunks)-1]
+		default:
+			hunk = nil
+		}
+	}
+
+	if oldLeft > 0 || newLeft > 0 {
+		return nil, fmt.Errorf("unexpected end of patch")
+	}
+
+	return patches, nil
+}
+
+// patchFilename strips the timestamp written by diff(1) and the prefix.
+func patchFilename(name string, prefix string) string {
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+
+	return strings.TrimPrefix(name, prefix)
+}
+
+// parseHunkHeader parses the ranges of the hunk header with the format
+// "@@ -start,count +start,count @@". The count is 1 if omitted.
+func parseHunkHeader(line string) (int, int, int, error) {
+	fields := strings.Fields(line)
+
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, 0, fmt.Errorf("invalid hunk header %q", line)
+	}
+
+	start, oldCount, err := parseRange(fields[1][1:])
+
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid hunk header %q", line)
+	}
+
+	_, newCount, err := parseRange(fields[2][1:])
+
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid hunk header %q", line)
+	}
+
+	return start, oldCount, newCount, nil
+}
+
+func parseRange(text string) (int, int, error) {
+	parts := strings.SplitN(text, ",", 2)
+	start, err := strconv.Atoi(parts[0])
+
+	if err != nil || len(parts) == 1 {
+		return start, 1, err
+	}
+
+	count, err := strconv.Atoi(parts[1])
+
+	return start, count, err
+}
+
+// Apply changes the content according to the hunks. Every hunk must match the
+// content exactly, although it may be found a few lines away from the line
+// recorded in the patch if the file changed since the patch was created.
+func (fp FilePatch) Apply(content []byte) ([]byte, error) {
+	var out []string
+	var pos, delta int
+
+	lines := splitLines(content)
+
+	for n, hunk := range fp.Hunks {
+		var old, new []string
+
+		for _, line := range hunk.Lines {
+			if line[0] != '+' {
+				old = append(old, line[1:])
+			}
+			if line[0] != '-' {
+				new = append(new, line[1:])
+			}
+		}
+
+		at := hunk.OldStart - 1 + delta
+
+		if len(old) == 0 {
+			at++ /* an empty range is identified by the line before it */
+		}
+
+		at, ok := findLines(lines, old, at, pos)
+
+		if !ok {
+			return nil, fmt.Errorf("hunk %d does not apply", n+1)
+		}
+
+		out = append(out, lines[pos:at]...)
+		out = append(out, new...)
+		pos = at + len(old)
+		delta = at - (hunk.OldStart - 1)
+	}
+
+	out = append(out, lines[pos:]...)
+
+	return []byte(strings.Join(out, "")), nil
+}
+
+// findLines finds the lines in the content, starting at the expected position
+// and moving away from it in both directions, without going before the
+// minimum position.
+func findLines(lines []string, want []string, at int, min int) (int, bool) {
+	match := func(i int) bool {
+		if i < min || i+len(want) > len(lines) {
+			return false
+		}
+		for k, line := range want {
+			if lines[i+k] != line {
+				return false
+			}
+		}
+		return true
+	}
+
+	for offset := 0; offset <= len(lines); offset++ {
+		if match(at - offset) {
+			return at - offset, true
+		}
+		if match(at + offset) {
+			return at + offset, true
+		}
+	}
+
+	return 0, false
+}
+
+// ApplyPatch applies the patch to the files in the working directory and
+// records their original content in the journal folder, if not empty, so the
+// changes can be reverted with Undo. Files that cannot be patched are left
+// untouched and reported in the results.
+func ApplyPatch(data []byte, journalDir string) ([]PatchResult, error) {
+	patches, err := ParsePatch(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var j *journal
+
+	if journalDir != "" {
+		j = newJournal(journalDir)
+	}
+
+	var results []PatchResult
+
+	for _, fp := range patches {
+		results = append(results, PatchResult{
+			Filename: fp.Filename,
+			Err:      applyFilePatch(fp, j),
+		})
+	}
+
+	return results, nil
+}
+
+func applyFilePatch(fp FilePatch, j *journal) error {
+	original, err := os.ReadFile(fp.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	content, err := fp.Apply(original)
+
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(content, original) {
+		return nil
+	}
+
+	if j != nil {
+		if err := j.Record(fp.Filename, original, content); err != nil {
+			return fmt.Errorf("journal.Record %s %s", fp.Filename, err)
+		}
+	}
+
+	return writeFileAtomic(fp.Filename, content, 0644)
+}
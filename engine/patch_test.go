@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns n lines "line 1" to "line n".
+func numberedLines(n int) string {
+	var lines []string
+
+	for i := 1; i <= n; i++ {
+		lines = append(lines, fmt.Sprintf("line %d\n", i))
+	}
+
+	return strings.Join(lines, "")
+}
+
+func TestPatchRoundTrip(t *testing.T) {
+	long := numberedLines(40)
+
+	tests := []struct {
+		name     string
+		original string
+		modified string
+	}{
+		{"one line", "foo\n", "bar\n"},
+		{"insert at the beginning", "a\nb\n", "x\na\nb\n"},
+		{"append at the end", "a\nb\n", "a\nb\nc\n"},
+		{"delete everything", "a\nb\n", ""},
+		{"create from nothing", "", "a\n"},
+		{"missing final newline", "a\nb", "a\nc"},
+		{"add final newline", "a\nb", "a\nb\n"},
+		{"windows line endings", "a\r\nb\r\n", "a\r\nc\r\n"},
+		{"separate hunks", long, strings.Replace(strings.Replace(long, "line 3\n", "three\n", 1), "line 35\n", "", 1)},
+		{"removed line starting with dashes", "-- a\n--- b\nc\n", "c\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := UnifiedDiff("dir/file.txt", []byte(tt.original), []byte(tt.modified))
+			patches, err := ParsePatch(diff)
+
+			if err != nil {
+				t.Fatalf("ParsePatch %s\n%s", err, diff)
+			}
+
+			if len(patches) != 1 || patches[0].Filename != "dir/file.txt" {
+				t.Fatalf("ParsePatch = %+v, want one patch of dir/file.txt\n%s", patches, diff)
+			}
+
+			got, err := patches[0].Apply([]byte(tt.original))
+
+			if err != nil {
+				t.Fatalf("Apply %s\n%s", err, diff)
+			}
+
+			if string(got) != tt.modified {
+				t.Fatalf("Apply = %q, want %q\n%s", got, tt.modified, diff)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffEqual(t *testing.T) {
+	if diff := UnifiedDiff("file.txt", []byte("a\n"), []byte("a\n")); len(diff) != 0 {
+		t.Fatalf("UnifiedDiff of equal contents = %q, want nothing", diff)
+	}
+}
+
+func TestPatchApplyMoved(t *testing.T) {
+	original := numberedLines(10)
+	modified := strings.Replace(original, "line 5\n", "five\n", 1)
+	patches, err := ParsePatch(UnifiedDiff("file.txt", []byte(original), []byte(modified)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{"lines added before", "new\n" + original, "new\n" + modified, false},
+		{"lines removed before", strings.Replace(original, "line 1\n", "", 1), strings.Replace(modified, "line 1\n", "", 1), false},
+		{"context changed", strings.Replace(original, "line 5\n", "LINE 5\n", 1), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := patches[0].Apply([]byte(tt.content))
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Apply succeeded, want an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Apply %s", err)
+			}
+
+			if string(got) != tt.want {
+				t.Fatalf("Apply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePatchGit(t *testing.T) {
+	data := "diff --git a/x.go b/x.go\nindex 1..2 100644\n--- a/x.go\n+++ b/x.go\n@@ -1,2 +1,2 @@\n-old\n+new\n keep\n"
+	patches, err := ParsePatch([]byte(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := patches[0].Apply([]byte("old\nkeep\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if patches[0].Filename != "x.go" || string(got) != "new\nkeep\n" {
+		t.Fatalf("Apply %s = %q, want x.go %q", patches[0].Filename, got, "new\nkeep\n")
+	}
+}
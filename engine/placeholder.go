@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// placeholderRef matches the placeholders of the replacements: {file},
+// {line}, {n} and {env:NAME}.
+var placeholderRef = regexp.MustCompile(`\{(file|line|n|env:[A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// templatePart is a piece of a replacement: either text, expanded like a
+// replacement without placeholders and then converted, if convert is not nil,
+// the literal value of an environment variable, or a placeholder that is
+// known only when the occurrence is replaced.
+type templatePart struct {
+	text        string
+	convert     func(string) string
+	literal     bool
+	placeholder string
+}
+
+// parsePlaceholders splits the replacement at the placeholders. The values
+// of the environment variables are read once, and they must be set.
+func parsePlaceholders(replace string) ([]templatePart, error) {
+	var parts []templatePart
+	var last int
+
+	for _, m := range placeholderRef.FindAllStringSubmatchIndex(replace, -1) {
+		// ${n} is a reference to a named group of the regular expression.
+		if m[0] > 0 && replace[m[0]-1] == '$' {
+			continue
+		}
+
+		name := replace[m[2]:m[3]]
+		parts = append(parts, templatePart{text: replace[last:m[0]]})
+
+		if len(name) > 4 && name[:4] == "env:" {
+			value, ok := os.LookupEnv(name[4:])
+
+			if !ok {
+				return nil, fmt.Errorf("{%s} refers to the environment variable %s, which is not set", name, name[4:])
+			}
+
+			parts = append(parts, templatePart{text: value, literal: true})
+		} else {
+			parts = append(parts, templatePart{placeholder: name})
+		}
+
+		last = m[1]
+	}
+
+	return append(parts, templatePart{text: replace[last:]}), nil
+}
+
+// placement is where the occurrences of one file are replaced, to expand the
+// placeholders.
+type placement struct {
+	filename string
+	// base is the number of lines before the content being replaced, when
+	// it is a segment of the file, and line the number of the line of the
+	// current occurrence.
+	base int
+	line int
+	// n is the number of occurrences replaced so far.
+	n int
+	// err is the first error found while the occurrences were replaced.
+	err error
+}
+
+// value returns the value of the placeholder for the current occurrence, or
+// the placeholder itself if the occurrence is not being replaced, i.e. when
+// it is displayed.
+func (p *placement) value(placeholder string) string {
+	if p == nil {
+		return "{" + placeholder + "}"
+	}
+
+	switch placeholder {
+	case "file":
+		return p.filename
+	case "line":
+		return strconv.Itoa(p.line)
+	}
+
+	return strconv.Itoa(p.n)
+}
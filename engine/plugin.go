@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PluginProtocol is the version of the protocol of the plugins, see
+// Options.Plugin.
+//
+// The plugin is an executable that speaks JSON-RPC 2.0 over its standard input
+// and output, with the Content-Length headers of LSP. The engine starts it
+// once and sends the requests one at a time:
+//
+//	initialize {"protocol": 1}
+//	  -> {"name": "proto", "protocol": 1, "matcher": true, "replacer": false, "include": ["*.proto"]}
+//	match {"filename": "a.proto", "content": "...", "rules": [{"search": "", "replace": ""}]}
+//	  -> {"matches": [{"rule": 0, "start": 10, "end": 15, "replacement": "..."}]}
+//	replace {"rule": {"search": "", "replace": ""}, "text": "..."}
+//	  -> {"text": "..."}
+//	shutdown
+//
+// A matcher finds the occurrences of the rules in the files it includes, all of
+// them if it includes none, with the byte offsets of the content; the
+// replacement is the one of the rule if it is missing. A replacer computes the
+// replacement of every occurrence found by the rules, or by the matcher, like
+// Options.MapCommand. The standard error of the plugin is the one of the
+// program.
+const PluginProtocol = 1
+
+// plugin is a running plugin.
+type plugin struct {
+	name     string
+	matcher  bool
+	replacer bool
+	include  []Glob
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu     sync.Mutex
+	client *rpcClient
+	// rules are the rules with the replacements of the matcher, by rule and
+	// replacement.
+	rules map[*Rule]map[string]*Rule
+}
+
+// pluginRule is a rule in the requests of the plugin.
+type pluginRule struct {
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+}
+
+// startPlugin runs the command of the plugin and completes the handshake.
+func startPlugin(command string) (*plugin, error) {
+	args := strings.Fields(command)
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the plugin command is empty")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin %s %s", args[0], err)
+	}
+
+	p := &plugin{
+		cmd:    cmd,
+		stdin:  stdin,
+		client: &rpcClient{in: stdin, out: bufio.NewReader(stdout)},
+		rules:  map[*Rule]map[string]*Rule{},
+	}
+
+	var hello struct {
+		Name     string   `json:"name"`
+		Protocol int      `json:"protocol"`
+		Matcher  bool     `json:"matcher"`
+		Replacer bool     `json:"replacer"`
+		Include  []string `json:"include"`
+	}
+
+	if err := p.call("initialize", map[string]int{"protocol": PluginProtocol}, &hello); err != nil {
+		p.close()
+		return nil, fmt.Errorf("plugin %s %s", args[0], err)
+	}
+
+	if hello.Protocol != PluginProtocol {
+		p.close()
+		return nil, fmt.Errorf("plugin %s speaks the protocol %d, not %d", args[0], hello.Protocol, PluginProtocol)
+	}
+
+	if !hello.Matcher && !hello.Replacer {
+		p.close()
+		return nil, fmt.Errorf("plugin %s is neither a matcher nor a replacer", args[0])
+	}
+
+	for _, pattern := range hello.Include {
+		g, err := NewGlob(pattern)
+
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("plugin %s %s", args[0], err)
+		}
+
+		p.include = append(p.include, g)
+	}
+
+	p.name, p.matcher, p.replacer = hello.Name, hello.Matcher, hello.Replacer
+
+	return p, nil
+}
+
+// call sends a request to the plugin and decodes its result. The requests of
+// the concurrent searches are sent one at a time.
+func (p *plugin) call(method string, params interface{}, result interface{}) error {
+	p.mu.Lock()
+	data, err := p.client.call(method, params)
+	p.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// close asks the plugin to stop and waits until it exits.
+func (p *plugin) close() error {
+	_ = p.call("shutdown", nil, nil)
+	_ = p.stdin.Close()
+
+	return p.cmd.Wait()
+}
+
+// handles reports whether the plugin is included for the file.
+func (p *plugin) handles(filename string) bool {
+	return len(p.include) == 0 || matchAny(p.include, filename)
+}
+
+// compile compiles the rule literally, since its meaning is known by the
+// plugin only.
+func (p *plugin) compile(spec RuleSpec) (*Rule, error) {
+	return spec.Compile(RuleOptions{})
+}
+
+// matches returns the occurrences of the rules found by the plugin.
+func (p *plugin) matches(filename string, rs RuleSet, content []byte) ([]RuleMatch, error) {
+	rules := make([]pluginRule, len(rs))
+
+	for i, rule := range rs {
+		rules[i] = pluginRule{Search: rule.Search, Replace: rule.Replace}
+	}
+
+	var result struct {
+		Matches []struct {
+			Rule        int     `json:"rule"`
+			Start       int     `json:"start"`
+			End         int     `json:"end"`
+			Replacement *string `json:"replacement"`
+		} `json:"matches"`
+	}
+
+	params := map[string]interface{}{"filename": filename, "content": string(content), "rules": rules}
+
+	if err := p.call("match", params, &result); err != nil {
+		return nil, fmt.Errorf("plugin %s %s", p.name, err)
+	}
+
+	var matches []RuleMatch
+
+	for _, m := range result.Matches {
+		if m.Rule < 0 || m.Rule >= len(rs) || m.Start < 0 || m.End < m.Start || m.End > len(content) {
+			return nil, fmt.Errorf("plugin %s returned an invalid match %d-%d of the rule %d", p.name, m.Start, m.End, m.Rule)
+		}
+
+		rule := rs[m.Rule]
+
+		if m.Replacement != nil {
+			var err error
+
+			if rule, err = p.replacement(rule, *m.Replacement); err != nil {
+				return nil, err
+			}
+		}
+
+		matches = append(matches, RuleMatch{Rule: rule, Loc: []int{m.Start, m.End}})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Loc[0] < matches[j].Loc[0]
+	})
+
+	for i := 1; i < len(matches); i++ {
+		if matches[i].Loc[0] < matches[i-1].Loc[1] {
+			return nil, fmt.Errorf("plugin %s returned overlapping matches at %d", p.name, matches[i].Loc[0])
+		}
+	}
+
+	return matches, nil
+}
+
+// replacement returns the rule replacing its occurrences with the text.
+func (p *plugin) replacement(rule *Rule, text string) (*Rule, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rules[rule] == nil {
+		p.rules[rule] = map[string]*Rule{}
+	}
+
+	if r, ok := p.rules[rule][text]; ok {
+		return r, nil
+	}
+
+	r, err := NewRule(rule.Search, text, RuleOptions{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.Filter = rule.Filter
+	p.rules[rule][text] = r
+
+	return r, nil
+}
+
+// pluginReplacer computes the replacements of the occurrences of a rule with
+// a replacer plugin.
+type pluginReplacer struct {
+	plugin *plugin
+	rule   pluginRule
+}
+
+// run returns the replacement of the text of the occurrence.
+func (r pluginReplacer) run(text []byte) ([]byte, error) {
+	var result struct {
+		Text string `json:"text"`
+	}
+
+	if err := r.plugin.call("replace", map[string]interface{}{"rule": r.rule, "text": string(text)}, &result); err != nil {
+		return nil, fmt.Errorf("plugin %s %s", r.plugin.name, err)
+	}
+
+	return []byte(result.Text), nil
+}
@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrRenameCollision is reported when the new name of a file already exists,
+// or when two files would get the same new name.
+var ErrRenameCollision = errors.New("already exists")
+
+// Rename is the new path of one file whose name, or the name of one of its
+// directories, matches the rules.
+type Rename struct {
+	From string
+	To   string
+	// Git is true if the file was moved with git mv.
+	Git bool
+	// Err is the reason why the file cannot be renamed, if any.
+	Err error
+}
+
+// Renames applies the rules to the names of the files and their directories
+// and returns the files that would be renamed, sorted by their current name.
+// The files whose new name collides with another file are reported with an
+// error and must not be renamed.
+func (e *Engine) Renames(ctx context.Context) ([]Rename, error) {
+	files, err := e.files(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	var renames []Rename
+
+	targets := map[string]string{}
+
+	for _, name := range files {
+		to, err := e.renamePath(name)
+
+		if err == nil && to == cleanPath(name) {
+			continue
+		}
+
+		r := Rename{From: name, To: to, Err: err}
+
+		if r.Err == nil {
+			if other, ok := targets[to]; ok {
+				r.Err = fmt.Errorf("%s %w, it is also the new name of %s", to, ErrRenameCollision, other)
+			} else if fi, err := os.Lstat(to); err == nil {
+				// renaming "User" to "user" is fine in case-insensitive systems.
+				if src, err := os.Lstat(name); err != nil || !os.SameFile(fi, src) {
+					r.Err = fmt.Errorf("%s %w", to, ErrRenameCollision)
+				}
+			}
+
+			targets[to] = name
+		}
+
+		renames = append(renames, r)
+	}
+
+	return renames, nil
+}
+
+// renamePath applies the rules to the elements of the path below the folder
+// from which the file was reached, leaving the folders above it untouched.
+func (e *Engine) renamePath(name string) (string, error) {
+	var prefix string
+
+	rs := e.rules.ForFile(name)
+	rel := cleanPath(name)
+
+	if root := e.renameRoot(rel); root != "." {
+		prefix = strings.TrimSuffix(root, "/") + "/"
+		rel = strings.TrimPrefix(rel, prefix)
+	}
+
+	parts := strings.Split(rel, "/")
+
+	for i, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+
+		if parts[i] = string(rs.Replace([]byte(part))); parts[i] == "" {
+			return "", fmt.Errorf("%s cannot be renamed to an empty name", name)
+		}
+	}
+
+	return prefix + strings.Join(parts, "/"), nil
+}
+
+// renameRoot returns the outermost of the folders to process containing the
+// clean path, the working directory if there are none, or the parent of the
+// path if it was passed as a file.
+func (e *Engine) renameRoot(name string) string {
+	if len(e.opts.Paths) == 0 {
+		return "."
+	}
+
+	root := path.Dir(name)
+
+	for _, dir := range e.opts.Paths {
+		dir = cleanPath(dir)
+
+		if isBelow(name, dir) && len(dir) < len(root) {
+			root = dir
+		}
+	}
+
+	return root
+}
+
+// isBelow reports whether the clean path is inside the clean folder.
+func isBelow(name string, dir string) bool {
+	if dir == "." {
+		return !filepath.IsAbs(name) && name != ".." && !strings.HasPrefix(name, "../")
+	}
+
+	return strings.HasPrefix(name, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// ApplyRenames moves the files to their new paths, creating the directories
+// if necessary and removing the old directories left empty. Inside a git
+// repository the files are moved with git mv so the index is updated too.
+// The renames are recorded in the journal so they can be reverted with Undo.
+// The renames that fail are updated with the error.
+func (e *Engine) ApplyRenames(ctx context.Context, renames []Rename) {
+	_, err := git(ctx, "rev-parse", "--is-inside-work-tree")
+	inGit := err == nil
+
+	for i, r := range renames {
+		if r.Err != nil {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			renames[i].Err = err
+			continue
+		}
+
+		renames[i].Git, renames[i].Err = renameFile(ctx, r.From, r.To, inGit)
+
+		if renames[i].Err == nil && e.journal != nil {
+			if err := e.journal.RecordRename(r.From, r.To, renames[i].Git); err != nil {
+				renames[i].Err = fmt.Errorf("journal.Record %s %s", r.To, err)
+			}
+		}
+	}
+}
+
+// renameFile moves the file and reports whether it was moved with git mv.
+func renameFile(ctx context.Context, from string, to string, inGit bool) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return false, err
+	}
+
+	var moved bool
+
+	// git refuses to move the files that are not tracked.
+	if inGit {
+		_, err := git(ctx, "mv", "--", from, to)
+		moved = err == nil
+	}
+
+	if !moved {
+		if err := os.Rename(from, to); err != nil {
+			return false, err
+		}
+	}
+
+	for dir := filepath.Dir(from); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break /* not empty */
+		}
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRenamePath(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		file  string
+		want  string
+	}{
+		{"working directory", nil, "user/user.txt", "acct/acct.txt"},
+		{"current folder", []string{"."}, "user/user.txt", "acct/acct.txt"},
+		{"folder argument is kept", []string{"/tmp/userA/proj"}, "/tmp/userA/proj/user/user.txt", "/tmp/userA/proj/acct/acct.txt"},
+		{"folder argument itself", []string{"user"}, "user/user.txt", "user/acct.txt"},
+		{"file argument", []string{"/tmp/user/user.txt"}, "/tmp/user/user.txt", "/tmp/user/acct.txt"},
+		{"outermost argument", []string{"src/user", "src"}, "src/user/user.txt", "src/acct/acct.txt"},
+		{"relative parent", []string{"../user"}, "../user/sub/user.txt", "../user/sub/acct.txt"},
+		{"no match", nil, "other/file.txt", "other/file.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(Options{Rules: []RuleSpec{{Search: "user", Replace: "acct"}}, Paths: tt.paths})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := e.renamePath(tt.file)
+
+			if err != nil {
+				t.Fatalf("renamePath %s", err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("renamePath(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRenamesUndo(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "userA", "proj")
+	journalDir := filepath.Join(dir, "journal")
+	files := map[string]string{
+		"userA/proj/user.txt":      "a\n",
+		"userA/proj/user/user.txt": "b\n",
+		"userA/proj/other.txt":     "c\n",
+	}
+
+	writeFiles(t, dir, files)
+
+	e, err := New(Options{
+		Rules:      []RuleSpec{{Search: "user", Replace: "acct"}},
+		Paths:      []string{root},
+		JournalDir: journalDir,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	renames, err := e.Renames(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.ApplyRenames(ctx, renames)
+
+	for _, r := range renames {
+		if r.Err != nil {
+			t.Fatalf("rename %s %s", r.From, r.Err)
+		}
+	}
+
+	want := map[string]string{
+		"userA/proj/acct.txt":      "a\n",
+		"userA/proj/acct/acct.txt": "b\n",
+		"userA/proj/other.txt":     "c\n",
+	}
+
+	if got := readFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Fatalf("files after ApplyRenames = %q, want %q", got, want)
+	}
+
+	if _, err := Undo(journalDir, false); err != nil {
+		t.Fatalf("Undo %s", err)
+	}
+
+	if got := readFiles(t, dir); !reflect.DeepEqual(got, files) {
+		t.Fatalf("files after Undo = %q, want %q", got, files)
+	}
+}
@@ -0,0 +1,376 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RuleOptions changes how the search text of a rule is interpreted.
+type RuleOptions struct {
+	Regexp       bool
+	IgnoreCase   bool
+	WholeWord    bool
+	PreserveCase bool
+	Multiline    bool
+	// Structural interprets the search text as a structural template, with
+	// holes like :[name] that are referenced by the replacement.
+	Structural bool
+	// Placeholders expands {file}, {line}, {n} and {env:NAME} in the
+	// replacement: the name of the file, the number of the line, the number
+	// of the occurrence in the file, from 1, and the environment variable.
+	Placeholders bool
+	// Normalize is NormalizeNFC or NormalizeNFD. If not empty, the accented
+	// letters of the search text match both their composed and decomposed
+	// forms, and the replacement is written in the normal form.
+	Normalize string
+}
+
+// The severities of the deny rules.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// Rule is one search and replace operation.
+type Rule struct {
+	// Name identifies the rule in the reports, or is empty.
+	Name string
+	// Description explains the purpose of the rule.
+	Description string
+	// Deny makes the rule a linting rule: its occurrences are reported with
+	// the message and the severity, and never replaced.
+	Deny     bool
+	Message  string
+	Severity string
+	// Search is the text, or regular expression, written by the user.
+	Search string
+	// Replace is the new text, or template in regular expression mode.
+	Replace string
+	// Pattern is the compiled form of the search text, or nil for the
+	// structural templates.
+	Pattern *regexp.Regexp
+	// Options changes how the search text is interpreted.
+	Options RuleOptions
+	// Filter restricts the rule to some files; nil means all files.
+	Filter *FileFilter
+
+	structure *structure
+	// parts is the replacement split at the placeholders, or nil.
+	parts []templatePart
+	// mapper computes the replacements instead of the template, or nil.
+	mapper occurrenceMapper
+}
+
+// NewRule compiles the search text and validates the replacement.
+func NewRule(search string, replace string, opts RuleOptions) (*Rule, error) {
+	if !validNormalForm(opts.Normalize) {
+		return nil, fmt.Errorf("unsupported normal form %q, use %q or %q", opts.Normalize, NormalizeNFC, NormalizeNFD)
+	}
+
+	if opts.Normalize != "" {
+		if opts.Regexp || opts.PreserveCase || opts.Structural {
+			return nil, fmt.Errorf("normalization cannot be combined with regexp, preserve-case or structural")
+		}
+
+		replace = normalize(replace, opts.Normalize)
+	}
+
+	if opts.Placeholders && (opts.Structural || opts.PreserveCase) {
+		return nil, fmt.Errorf("placeholders cannot be combined with structural or preserve-case")
+	}
+
+	if opts.Structural {
+		if opts.Regexp || opts.PreserveCase {
+			return nil, fmt.Errorf("structural templates cannot be combined with regexp or preserve-case")
+		}
+
+		s, err := compileStructure(search, replace, opts.IgnoreCase)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &Rule{Search: search, Replace: replace, Options: opts, structure: s}, nil
+	}
+
+	re, err := compilePattern(search, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Regexp {
+		if err := validateTemplate(re, replace); err != nil {
+			return nil, err
+		}
+	}
+
+	rule := &Rule{Search: search, Replace: replace, Pattern: re, Options: opts}
+
+	if rule.parts, err = templateParts(replace, opts); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// compilePattern converts the search query into a regular expression. Unless
+// the user asked for regular expression mode, the query is matched literally.
+func compilePattern(query string, opts RuleOptions) (*regexp.Regexp, error) {
+	begin, end := `\b`, `\b`
+
+	if !opts.Regexp {
+		// a word boundary next to a non-word character would require a word
+		// character on the other side, i.e. "foo(" followed by a letter.
+		if query == "" || !isWordChar(query[0]) {
+			begin = ""
+		}
+
+		if query == "" || !isWordChar(query[len(query)-1]) {
+			end = ""
+		}
+
+		if opts.PreserveCase {
+			query = smartCasePattern(query)
+		} else if opts.Normalize != "" {
+			query = normalizedPattern(query)
+		} else {
+			query = regexp.QuoteMeta(query)
+		}
+
+		// a line feed in the search text also matches the line terminator of
+		// files with Windows line endings.
+		if opts.Multiline {
+			query = strings.Replace(query, "\n", `\r?\n`, -1)
+		}
+	}
+
+	if opts.WholeWord {
+		query = begin + "(?:" + query + ")" + end
+	}
+
+	if opts.IgnoreCase {
+		query = "(?i)" + query
+	}
+
+	// the content is searched at once in multiline mode, but ^ and $ must keep
+	// matching at the beginning and end of every line.
+	if opts.Multiline {
+		query = "(?m)" + query
+	}
+
+	return regexp.Compile(query)
+}
+
+// isWordChar reports whether the byte is an ASCII letter, digit or underscore,
+// which is the definition of a word character used by the \b assertion.
+func isWordChar(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// Expand returns the replacement for one single match of the pattern. In
+// regular expression mode the replacement can reference capture groups, i.e.
+// $1, and in preserve-case mode it follows the naming convention of the match.
+// The placeholders are returned as they are written.
+func (r *Rule) Expand(text []byte, m []int) []byte {
+	return r.expand(text, m, nil)
+}
+
+// expand is like Expand but writes the values of the placeholders for the
+// occurrence of the placement, which is counted.
+func (r *Rule) expand(text []byte, m []int, p *placement) []byte {
+	if r.Deny {
+		return text[m[0]:m[1]]
+	}
+
+	if r.mapper != nil {
+		out, err := r.mapper.run(text[m[0]:m[1]])
+
+		if err != nil {
+			// the occurrence is kept and the error is reported once the
+			// content is replaced.
+			if p != nil && p.err == nil {
+				p.err = err
+			}
+			return text[m[0]:m[1]]
+		}
+
+		return out
+	}
+
+	if r.structure != nil {
+		return r.structure.expand(text, m)
+	}
+
+	if r.parts != nil {
+		var out []byte
+
+		if p != nil {
+			p.n++
+		}
+
+		for _, part := range r.parts {
+			switch {
+			case part.placeholder != "":
+				out = append(out, p.value(part.placeholder)...)
+			case r.Options.Regexp && !part.literal && part.convert != nil:
+				out = append(out, part.convert(string(r.Pattern.Expand(nil, []byte(part.text), text, m)))...)
+			case r.Options.Regexp && !part.literal:
+				out = r.Pattern.Expand(out, []byte(part.text), text, m)
+			default:
+				out = append(out, part.text...)
+			}
+		}
+
+		if r.Options.PreserveCase {
+			out = r.matchCase(text[m[0]:m[1]], out)
+		}
+
+		return out
+	}
+
+	repText := []byte(r.Replace)
+
+	if r.Options.Regexp {
+		repText = r.Pattern.Expand(nil, repText, text, m)
+	}
+
+	if r.Options.PreserveCase {
+		repText = r.matchCase(text[m[0]:m[1]], repText)
+	}
+
+	return repText
+}
+
+// matchCase rewrites the replacement following the naming convention of the
+// matched text. The replacement is kept as written when the text is written
+// exactly like the search text, so its initialisms are never lost.
+func (r *Rule) matchCase(original []byte, replacement []byte) []byte {
+	if string(original) == r.Search {
+		return replacement
+	}
+
+	return []byte(matchCase(string(original), string(replacement)))
+}
+
+// findAll returns the matches of the rule in the format of
+// regexp.FindAllSubmatchIndex.
+func (r *Rule) findAll(text []byte) [][]int {
+	if r.structure != nil {
+		return r.structure.findAll(text)
+	}
+
+	return r.Pattern.FindAllSubmatchIndex(text, -1)
+}
+
+// RuleMatch is one occurrence of a rule in the text.
+type RuleMatch struct {
+	Rule *Rule
+	// Loc holds the index pairs of the match and its capture groups.
+	Loc []int
+}
+
+// RuleSet is an ordered list of rules applied together in one single pass, so
+// each file is read and written once no matter how many rules are specified.
+type RuleSet []*Rule
+
+// ForFile returns the rules applicable to the file.
+func (rs RuleSet) ForFile(filename string) RuleSet {
+	var subset RuleSet
+
+	for _, rule := range rs {
+		if rule.Filter == nil || rule.Filter.Allow(filename) {
+			subset = append(subset, rule)
+		}
+	}
+
+	return subset
+}
+
+// FindAll returns the non-overlapping matches of all the rules in the text,
+// from left to right. Replacements are never searched again, so rules like
+// "a=b,b=a" swap the values. When two rules match at the same position, the
+// rule specified first wins.
+func (rs RuleSet) FindAll(text []byte) []RuleMatch {
+	if len(rs) == 1 {
+		var matches []RuleMatch
+		for _, m := range rs[0].findAll(text) {
+			matches = append(matches, RuleMatch{Rule: rs[0], Loc: m})
+		}
+		return matches
+	}
+
+	var all []RuleMatch
+
+	for _, rule := range rs {
+		for _, m := range rule.findAll(text) {
+			all = append(all, RuleMatch{Rule: rule, Loc: m})
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Loc[0] < all[j].Loc[0]
+	})
+
+	var last int
+	var matches []RuleMatch
+
+	for _, m := range all {
+		if len(matches) > 0 && m.Loc[0] < last {
+			continue
+		}
+		matches = append(matches, m)
+		last = m.Loc[1]
+	}
+
+	return matches
+}
+
+// Replace substitutes every match of the rules in the text.
+func (rs RuleSet) Replace(text []byte) []byte {
+	return rs.replace(text, "\n")
+}
+
+// replace is like Replace but uses the specified line terminator for the line
+// feeds inserted by the replacements.
+func (rs RuleSet) replace(text []byte, newline string) []byte {
+	var last int
+	var out bytes.Buffer
+
+	for _, m := range rs.FindAll(text) {
+		out.Write(text[last:m.Loc[0]])
+		out.Write(convertNewlines(m.Rule.Expand(text, m.Loc), newline))
+		last = m.Loc[1]
+	}
+
+	out.Write(text[last:])
+
+	return out.Bytes()
+}
+
+// Highlight wraps every match of the rules in the text with the output of the
+// callback function.
+func (rs RuleSet) Highlight(text string, fn func(m RuleMatch) string) string {
+	return highlightMatches(text, rs.FindAll([]byte(text)), fn)
+}
+
+// highlightMatches wraps the matches of the text with the output of the
+// callback function.
+func highlightMatches(text string, matches []RuleMatch, fn func(m RuleMatch) string) string {
+	var last int
+	var out strings.Builder
+
+	for _, m := range matches {
+		out.WriteString(text[last:m.Loc[0]])
+		out.WriteString(fn(m))
+		last = m.Loc[1]
+	}
+
+	out.WriteString(text[last:])
+
+	return out.String()
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRuleSetReplace(t *testing.T) {
+	tests := []struct {
+		name    string
+		search  string
+		replace string
+		opts    RuleOptions
+		input   string
+		want    string
+	}{
+		{"literal", "foo", "bar", RuleOptions{}, "foo food", "bar bard"},
+		{"literal metacharacters", "a.b", "c", RuleOptions{}, "a.b axb", "c axb"},
+		{"ignore case", "foo", "bar", RuleOptions{IgnoreCase: true}, "Foo FOO", "bar bar"},
+		{"whole word", "foo", "bar", RuleOptions{WholeWord: true}, "foo food foo_", "bar food foo_"},
+		{"whole word punctuation", "foo(", "bar(", RuleOptions{WholeWord: true}, "foo(x) xfoo(", "bar(x) xfoo("},
+		{"regexp groups", `(\w+)Client`, "${1}Caller", RuleOptions{Regexp: true}, "HTTPClient", "HTTPCaller"},
+		{"preserve case", "userName", "accountName", RuleOptions{PreserveCase: true}, "userName UserName user_name USER_NAME", "accountName AccountName account_name ACCOUNT_NAME"},
+		{"preserve case initialisms", "userID", "accountID", RuleOptions{PreserveCase: true}, "userID UserID user_id", "accountID AccountID account_id"},
+		{"preserve case exact", "HTTPClient", "APICaller", RuleOptions{PreserveCase: true}, "HTTPClient httpClient", "APICaller apiCaller"},
+		{"structural", "foo(:[args])", "bar(:[args])", RuleOptions{Structural: true}, "foo(a, b(c))", "bar(a, b(c))"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewRule(tt.search, tt.replace, tt.opts)
+
+			if err != nil {
+				t.Fatalf("NewRule %s", err)
+			}
+
+			if got := string(RuleSet{rule}.Replace([]byte(tt.input))); got != tt.want {
+				t.Fatalf("Replace(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRuleErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		search  string
+		replace string
+		opts    RuleOptions
+	}{
+		{"invalid regexp", "(", "", RuleOptions{Regexp: true}},
+		{"unknown group", "(a)", "$2", RuleOptions{Regexp: true}},
+		{"structural and regexp", "foo", "bar", RuleOptions{Structural: true, Regexp: true}},
+		{"structural and preserve case", "foo", "bar", RuleOptions{Structural: true, PreserveCase: true}},
+		{"unknown normal form", "foo", "bar", RuleOptions{Normalize: "NFKC"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRule(tt.search, tt.replace, tt.opts); err == nil {
+				t.Fatalf("NewRule(%q, %q) succeeded, want an error", tt.search, tt.replace)
+			}
+		})
+	}
+}
+
+func TestLoadRulesFile(t *testing.T) {
+	yes := true
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []RuleSpec
+		wantErr bool
+	}{
+		{
+			name: "rules",
+			data: `{"rules": [{"search": "(\\w+)Client", "replace": "${1}Caller", "regexp": true, "include": ["*.go"]}]}`,
+			want: []RuleSpec{{Search: `(\w+)Client`, Replace: "${1}Caller", Regexp: &yes, Include: []string{"*.go"}}},
+		},
+		{
+			name: "deny after rules",
+			data: `{"deny": [{"name": "no-client", "pattern": "Client", "message": "use Caller"}], "rules": [{"search": "a", "replace": "b"}]}`,
+			want: []RuleSpec{
+				{Search: "a", Replace: "b"},
+				{Name: "no-client", Search: "Client", Deny: true, Message: "use Caller"},
+			},
+		},
+		{
+			name:    "deny without pattern",
+			data:    `{"deny": [{"name": "empty"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			data:    `{"rules": [`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "rules.json")
+
+			if err := os.WriteFile(filename, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			specs, err := LoadRulesFile(filename)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadRulesFile succeeded, want an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("LoadRulesFile %s", err)
+			}
+
+			if !reflect.DeepEqual(specs, tt.want) {
+				t.Fatalf("LoadRulesFile = %+v, want %+v", specs, tt.want)
+			}
+		})
+	}
+}
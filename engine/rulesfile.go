@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// RulesFile is the content of a JSON file with an ordered list of rules:
+//
+//	{
+//	  "rules": [
+//	    {
+//	      "description": "rename the HTTP client",
+//	      "search": "(\\w+)Client",
+//	      "replace": "${1}Caller",
+//	      "regexp": true,
+//	      "include": ["*.go"],
+//	      "exclude": ["vendor/**"]
+//	    }
+//	  ],
+//	  "deny": [
+//	    {
+//	      "name": "no-http-client",
+//	      "pattern": "http.DefaultClient",
+//	      "message": "use the client with timeouts",
+//	      "severity": "error"
+//	    }
+//	  ]
+//	}
+//
+// The deny rules are applied after the other rules.
+type RulesFile struct {
+	Rules []RuleSpec `json:"rules"`
+	Deny  []DenySpec `json:"deny"`
+}
+
+// DenySpec describes a deny rule, a pattern whose occurrences are reported
+// with a message and never replaced. The severity is SeverityError,
+// SeverityWarning, the default, or SeverityInfo.
+type DenySpec struct {
+	Name       string   `json:"name"`
+	Pattern    string   `json:"pattern"`
+	Message    string   `json:"message"`
+	Severity   string   `json:"severity,omitempty"`
+	Regexp     *bool    `json:"regexp,omitempty"`
+	IgnoreCase *bool    `json:"ignore_case,omitempty"`
+	WholeWord  *bool    `json:"whole_word,omitempty"`
+	Include    []string `json:"include,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+}
+
+// RuleSpec returns the specification of the deny rule.
+func (d DenySpec) RuleSpec() RuleSpec {
+	return RuleSpec{
+		Name:       d.Name,
+		Search:     d.Pattern,
+		Deny:       true,
+		Message:    d.Message,
+		Severity:   d.Severity,
+		Regexp:     d.Regexp,
+		IgnoreCase: d.IgnoreCase,
+		WholeWord:  d.WholeWord,
+		Include:    d.Include,
+		Exclude:    d.Exclude,
+	}
+}
+
+// RuleSpec describes a rule before it is compiled. Matching options that are
+// not specified are inherited from the engine options.
+type RuleSpec struct {
+	Name         string   `json:"name,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	Search       string   `json:"search"`
+	Replace      string   `json:"replace"`
+	Deny         bool     `json:"deny,omitempty"`
+	Message      string   `json:"message,omitempty"`
+	Severity     string   `json:"severity,omitempty"`
+	Regexp       *bool    `json:"regexp,omitempty"`
+	IgnoreCase   *bool    `json:"ignore_case,omitempty"`
+	WholeWord    *bool    `json:"whole_word,omitempty"`
+	PreserveCase *bool    `json:"preserve_case,omitempty"`
+	Structural   *bool    `json:"structural,omitempty"`
+	Placeholders *bool    `json:"placeholders,omitempty"`
+	Include      []string `json:"include,omitempty"`
+	Exclude      []string `json:"exclude,omitempty"`
+}
+
+// LoadRulesFile reads the ordered list of rules from a JSON file.
+func LoadRulesFile(filename string) ([]RuleSpec, error) {
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rf RulesFile
+
+	if err := json.Unmarshal(data, &rf); err != nil {
+		return nil, err
+	}
+
+	specs := rf.Rules
+
+	for i, d := range rf.Deny {
+		if d.Pattern == "" {
+			return nil, fmt.Errorf("deny %d %q has no pattern", i+1, d.Name)
+		}
+
+		specs = append(specs, d.RuleSpec())
+	}
+
+	return specs, nil
+}
+
+// Options returns the matching options of the rule, using the default value
+// for the options that are not specified.
+func (spec RuleSpec) Options(defaults RuleOptions) RuleOptions {
+	return RuleOptions{
+		Regexp:       boolOr(spec.Regexp, defaults.Regexp),
+		IgnoreCase:   boolOr(spec.IgnoreCase, defaults.IgnoreCase),
+		WholeWord:    boolOr(spec.WholeWord, defaults.WholeWord),
+		PreserveCase: boolOr(spec.PreserveCase, defaults.PreserveCase),
+		Multiline:    defaults.Multiline,
+		Structural:   boolOr(spec.Structural, defaults.Structural),
+		Placeholders: boolOr(spec.Placeholders, defaults.Placeholders),
+		Normalize:    defaults.Normalize,
+	}
+}
+
+// Compile converts the specification into a rule.
+func (spec RuleSpec) Compile(defaults RuleOptions) (*Rule, error) {
+	opts := spec.Options(defaults)
+	severity := spec.Severity
+
+	if spec.Deny {
+		// the occurrences are kept as they are.
+		opts.PreserveCase, opts.Structural, opts.Placeholders = false, false, false
+		spec.Replace = ""
+
+		if severity == "" {
+			severity = SeverityWarning
+		}
+
+		if severity != SeverityError && severity != SeverityWarning && severity != SeverityInfo {
+			return nil, fmt.Errorf("unsupported severity %q, use %q, %q or %q", severity, SeverityError, SeverityWarning, SeverityInfo)
+		}
+	}
+
+	rule, err := NewRule(spec.Search, spec.Replace, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rule.Name = spec.Name
+	rule.Description = spec.Description
+
+	if spec.Deny {
+		rule.Deny, rule.Message, rule.Severity = true, spec.Message, severity
+	}
+
+	if len(spec.Include) > 0 || len(spec.Exclude) > 0 {
+		if rule.Filter, err = NewFileFilter(spec.Include, spec.Exclude); err != nil {
+			return nil, err
+		}
+	}
+
+	return rule, nil
+}
+
+func boolOr(value *bool, fallback bool) bool {
+	if value == nil {
+		return fallback
+	}
+
+	return *value
+}
@@ -0,0 +1,318 @@
+package engine
+
+import (
+	"bytes"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The scopes of Options.Only and Options.Skip.
+const (
+	ScopeComments    = "comments"
+	ScopeStrings     = "strings"
+	ScopeIdentifiers = "identifiers"
+)
+
+// validScope reports whether the value is empty, one of the scopes or the
+// kind of a syntax node of a registered language.
+func validScope(scope string) bool {
+	return scope == "" || scope == ScopeComments || scope == ScopeStrings || scope == ScopeIdentifiers || nodeKind(scope)
+}
+
+// tokenize returns the comments and the string literals found by the
+// tokenizer of a language, and the identifiers between them, sorted by
+// offset.
+func tokenize(lex func([]byte) []Token, content []byte) []Token {
+	var tokens []Token
+	var last int
+
+	for _, tok := range lex(content) {
+		tokens = append(tokens, identifiers(content, last, tok.Start)...)
+		tokens = append(tokens, tok)
+		last = tok.End
+	}
+
+	return append(tokens, identifiers(content, last, len(content))...)
+}
+
+// identifiers returns the words between the offsets that begin with a letter
+// or an underscore.
+func identifiers(content []byte, start int, end int) []Token {
+	var tokens []Token
+
+	for i := start; i < end; {
+		r, size := utf8.DecodeRune(content[i:end])
+
+		if !isWordRune(r) {
+			i += size
+			continue
+		}
+
+		k := i + size
+		for k < end {
+			next, n := utf8.DecodeRune(content[k:end])
+			if !isWordRune(next) {
+				break
+			}
+			k += n
+		}
+
+		if !unicode.IsDigit(r) {
+			tokens = append(tokens, Token{i, k, ScopeIdentifiers})
+		}
+
+		i = k
+	}
+
+	return tokens
+}
+
+// isWordRune reports whether the rune can be part of an identifier.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// scoped reports whether the findings are restricted by Only or Skip.
+func (e *Engine) scoped() bool {
+	return e.opts.Only != "" || e.opts.Skip != ""
+}
+
+// scopedMatches returns the matches of the rules that are in scope, searching
+// the whole content at once in multiline mode or one line at a time otherwise,
+// with the offsets relative to the beginning of the content.
+func (e *Engine) scopedMatches(rs RuleSet, filename string, content []byte) []RuleMatch {
+	var all []RuleMatch
+
+	if e.opts.Multiline {
+		all = rs.FindAll(content)
+	} else {
+		var start int
+
+		for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+			text := bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+
+			for _, m := range rs.FindAll(text) {
+				loc := make([]int, len(m.Loc))
+				for i, offset := range m.Loc {
+					if offset >= 0 {
+						offset += start
+					}
+					loc[i] = offset
+				}
+				all = append(all, RuleMatch{Rule: m.Rule, Loc: loc})
+			}
+
+			start += len(line)
+		}
+	}
+
+	lang := LanguageFor(filename, content)
+	only := scopeTokens(lang, e.opts.Only, content)
+	skip := only
+
+	if e.opts.Skip != e.opts.Only {
+		skip = scopeTokens(lang, e.opts.Skip, content)
+	}
+
+	var matches []RuleMatch
+
+	for _, m := range all {
+		if e.inScope(only, skip, m.Loc[0], m.Loc[1]) {
+			matches = append(matches, m)
+		}
+	}
+
+	return matches
+}
+
+// inScope reports whether the text between the offsets is entirely inside a
+// span of the Only scope and does not overlap a span of the Skip scope.
+func (e *Engine) inScope(only []Token, skip []Token, start int, end int) bool {
+	if e.opts.Only != "" {
+		// the first span ending after the start of the match.
+		i := sort.Search(len(only), func(i int) bool { return only[i].End > start })
+
+		if i == len(only) || only[i].Kind != e.opts.Only || only[i].Start > start || only[i].End < end {
+			return false
+		}
+	}
+
+	if e.opts.Skip != "" {
+		for i := sort.Search(len(skip), func(i int) bool { return skip[i].End > start }); i < len(skip) && skip[i].Start < end; i++ {
+			if skip[i].Kind == e.opts.Skip {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// lexGo recognizes the comments, the interpreted and raw string literals and
+// the rune literals of Go.
+func lexGo(content []byte) []Token {
+	return lexCLike(content, false)
+}
+
+// lexJS recognizes the comments and the string and template literals of
+// JavaScript and TypeScript. Every template literal is a single string, even
+// if it contains expressions.
+func lexJS(content []byte) []Token {
+	return lexCLike(content, true)
+}
+
+// lexCLike recognizes the comments of the C family and the strings delimited
+// by double quotes, single quotes and backquotes. The backslash escapes the
+// next character, except in Go raw strings.
+func lexCLike(content []byte, escapeBackquote bool) []Token {
+	var spans []Token
+
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			end := lineEnding(content, i)
+			spans = append(spans, Token{i, end, ScopeComments})
+			i = end - 1
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			end := len(content)
+			if k := bytes.Index(content[i+2:], []byte("*/")); k >= 0 {
+				end = i + 2 + k + 2
+			}
+			spans = append(spans, Token{i, end, ScopeComments})
+			i = end - 1
+		case c == '"' || c == '\'':
+			end := quotedEnd(content, i+1, c, false)
+			spans = append(spans, Token{i, end, ScopeStrings})
+			i = end - 1
+		case c == '`':
+			end := quotedEnd(content, i+1, c, escapeBackquote)
+			spans = append(spans, Token{i, end, ScopeStrings})
+			i = end - 1
+		}
+	}
+
+	return spans
+}
+
+// lexPython recognizes the comments and the string literals of Python,
+// including the triple-quoted strings and docstrings.
+func lexPython(content []byte) []Token {
+	var spans []Token
+
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '#':
+			end := lineEnding(content, i)
+			spans = append(spans, Token{i, end, ScopeComments})
+			i = end - 1
+		case c == '"' || c == '\'':
+			end := len(content)
+			if triple := bytes.Repeat([]byte{c}, 3); bytes.HasPrefix(content[i:], triple) {
+				if k := indexUnescaped(content[i+3:], triple); k >= 0 {
+					end = i + 3 + k + 3
+				}
+			} else {
+				end = quotedEnd(content, i+1, c, false)
+			}
+			spans = append(spans, Token{i, end, ScopeStrings})
+			i = end - 1
+		}
+	}
+
+	return spans
+}
+
+// lineEnding returns the offset of the line terminator following the offset,
+// or the length of the content.
+func lineEnding(content []byte, offset int) int {
+	if k := bytes.IndexByte(content[offset:], '\n'); k >= 0 {
+		return offset + k
+	}
+
+	return len(content)
+}
+
+// quotedEnd returns the offset following the closing quote. The strings that
+// are not terminated end with the line, except the ones delimited by
+// backquotes, which can span multiple lines and only have escapes if
+// escapeBackquote is true.
+func quotedEnd(content []byte, offset int, quote byte, escapeBackquote bool) int {
+	escapes := quote != '`' || escapeBackquote
+
+	for i := offset; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case '\n':
+			if quote != '`' {
+				return i
+			}
+		case quote:
+			return i + 1
+		}
+	}
+
+	return len(content)
+}
+
+// lexShell recognizes the comments and the quoted strings of the shell. The
+// number sign starts a comment only at the beginning of a word, i.e. not in
+// $# or ${#name}.
+func lexShell(content []byte) []Token {
+	var spans []Token
+
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '\\':
+			i++
+		case c == '#' && (i == 0 || bytes.IndexByte([]byte(" \t\n;|&("), content[i-1]) >= 0):
+			end := lineEnding(content, i)
+			spans = append(spans, Token{i, end, ScopeComments})
+			i = end - 1
+		case c == '"' || c == '\'':
+			end := shellQuotedEnd(content, i+1, c)
+			spans = append(spans, Token{i, end, ScopeStrings})
+			i = end - 1
+		}
+	}
+
+	return spans
+}
+
+// shellQuotedEnd returns the offset following the closing quote. The strings
+// of the shell can span multiple lines, and the backslash escapes the next
+// character only between double quotes.
+func shellQuotedEnd(content []byte, offset int, quote byte) int {
+	for i := offset; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i + 1
+		}
+	}
+
+	return len(content)
+}
+
+// indexUnescaped is like bytes.Index but ignores the occurrences preceded by a
+// backslash escape.
+func indexUnescaped(content []byte, sep []byte) int {
+	for i := 0; i < len(content); i++ {
+		if content[i] == '\\' {
+			i++
+			continue
+		}
+
+		if bytes.HasPrefix(content[i:], sep) {
+			return i
+		}
+	}
+
+	return -1
+}
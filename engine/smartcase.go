@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// caseStyle identifies the naming convention used by a piece of text.
+type caseStyle int
+
+const (
+	caseUnknown   caseStyle = iota
+	caseLower               // username
+	caseUpper               // USERNAME
+	caseTitle               // Username
+	caseCamel               // userName
+	casePascal              // UserName
+	caseSnake               // user_name
+	caseScreaming           // USER_NAME
+	caseKebab               // user-name
+)
+
+// splitWords breaks an identifier into its words using underscores, dashes,
+// spaces and lower-to-upper case transitions as boundaries, i.e. "HTTPServer"
+// becomes ["HTTP", "Server"] and "user_name" becomes ["user", "name"].
+func splitWords(text string) []string {
+	var words []string
+	var word []rune
+
+	runes := []rune(text)
+
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+
+	for i, r := range runes {
+		if r == '_' || r == '-' || r == ' ' {
+			flush()
+			continue
+		}
+
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			next := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && next) {
+				flush()
+			}
+		}
+
+		word = append(word, r)
+	}
+
+	flush()
+
+	return words
+}
+
+// detectCase returns the naming convention used by the text.
+func detectCase(text string) caseStyle {
+	hasLower := strings.ToUpper(text) != text
+	hasUpper := strings.ToLower(text) != text
+
+	if strings.Contains(text, "_") {
+		if !hasLower {
+			return caseScreaming
+		}
+		if !hasUpper {
+			return caseSnake
+		}
+		return caseUnknown
+	}
+
+	if strings.Contains(text, "-") {
+		if !hasUpper {
+			return caseKebab
+		}
+		return caseUnknown
+	}
+
+	if !hasUpper {
+		return caseLower
+	}
+
+	if !hasLower {
+		return caseUpper
+	}
+
+	words := splitWords(text)
+	first := []rune(words[0])
+
+	if unicode.IsUpper(first[0]) {
+		if len(words) == 1 {
+			return caseTitle
+		}
+		return casePascal
+	}
+
+	return caseCamel
+}
+
+// applyCase joins the words using the specified naming convention. In camel
+// and pascal case, the initialisms are kept in upper case, i.e. "ID" in
+// ["user", "ID"], unless every word is in upper case.
+func applyCase(words []string, style caseStyle) string {
+	out := make([]string, len(words))
+	mixed := strings.ToUpper(strings.Join(words, "")) != strings.Join(words, "")
+
+	for i, word := range words {
+		switch style {
+		case caseUpper, caseScreaming:
+			out[i] = strings.ToUpper(word)
+		case caseTitle:
+			if i == 0 {
+				out[i] = capitalize(word)
+			} else {
+				out[i] = strings.ToLower(word)
+			}
+		case caseCamel:
+			if i == 0 {
+				out[i] = strings.ToLower(word)
+			} else if mixed && isInitialism(word) {
+				out[i] = word
+			} else {
+				out[i] = capitalize(word)
+			}
+		case casePascal:
+			if mixed && isInitialism(word) {
+				out[i] = word
+			} else {
+				out[i] = capitalize(word)
+			}
+		default:
+			out[i] = strings.ToLower(word)
+		}
+	}
+
+	switch style {
+	case caseSnake, caseScreaming:
+		return strings.Join(out, "_")
+	case caseKebab:
+		return strings.Join(out, "-")
+	}
+
+	return strings.Join(out, "")
+}
+
+// isInitialism reports whether the word has more than one letter, all of
+// them in upper case, i.e. "ID" or "HTTP".
+func isInitialism(word string) bool {
+	return len([]rune(word)) > 1 && strings.ToUpper(word) == word && strings.ToLower(word) != word
+}
+
+// capitalize converts the first letter of the word to upper case and the rest
+// of the word to lower case.
+func capitalize(word string) string {
+	runes := []rune(strings.ToLower(word))
+
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+
+	return string(runes)
+}
+
+// smartCasePattern builds an expression matching the query written in any of
+// the supported naming conventions, i.e. "userName" also matches "UserName",
+// "user_name", "USER_NAME" and "user-name".
+func smartCasePattern(query string) string {
+	words := splitWords(query)
+
+	if len(words) == 0 {
+		return regexp.QuoteMeta(query)
+	}
+
+	seen := map[string]bool{query: true}
+	variants := []string{regexp.QuoteMeta(query)}
+	styles := []caseStyle{
+		caseCamel,
+		casePascal,
+		caseSnake,
+		caseScreaming,
+		caseKebab,
+		caseLower,
+		caseUpper,
+		caseTitle,
+	}
+
+	for _, style := range styles {
+		variant := applyCase(words, style)
+
+		if !seen[variant] {
+			seen[variant] = true
+			variants = append(variants, regexp.QuoteMeta(variant))
+		}
+	}
+
+	return "(?:" + strings.Join(variants, "|") + ")"
+}
+
+// matchCase rewrites the replacement following the naming convention used by
+// the original text. The replacement is returned unmodified when the original
+// text does not follow any recognizable convention.
+func matchCase(original string, replacement string) string {
+	style := detectCase(original)
+
+	if style == caseUnknown {
+		return replacement
+	}
+
+	words := splitWords(replacement)
+
+	if len(words) == 0 {
+		return replacement
+	}
+
+	return applyCase(words, style)
+}
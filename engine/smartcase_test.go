@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"userName", []string{"user", "Name"}},
+		{"HTTPServer", []string{"HTTP", "Server"}},
+		{"user_name", []string{"user", "name"}},
+		{"user-name", []string{"user", "name"}},
+		{"USER_NAME", []string{"USER", "NAME"}},
+		{"user2Name", []string{"user2", "Name"}},
+		{"userID", []string{"user", "ID"}},
+		{"APICaller", []string{"API", "Caller"}},
+		{"user", []string{"user"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := splitWords(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDetectCase(t *testing.T) {
+	tests := []struct {
+		text string
+		want caseStyle
+	}{
+		{"username", caseLower},
+		{"USERNAME", caseUpper},
+		{"Username", caseTitle},
+		{"userName", caseCamel},
+		{"UserName", casePascal},
+		{"user_name", caseSnake},
+		{"USER_NAME", caseScreaming},
+		{"user-name", caseKebab},
+		{"User_name", caseUnknown},
+		{"User-Name", caseUnknown},
+		{"userID", caseCamel},
+		{"HTTPClient", casePascal},
+		{"ID", caseUpper},
+	}
+
+	for _, tt := range tests {
+		if got := detectCase(tt.text); got != tt.want {
+			t.Errorf("detectCase(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMatchCase(t *testing.T) {
+	tests := []struct {
+		original    string
+		replacement string
+		want        string
+	}{
+		{"userName", "accountName", "accountName"},
+		{"UserName", "accountName", "AccountName"},
+		{"user_name", "accountName", "account_name"},
+		{"USER_NAME", "accountName", "ACCOUNT_NAME"},
+		{"user-name", "accountName", "account-name"},
+		{"username", "accountName", "accountname"},
+		{"USERNAME", "accountName", "ACCOUNTNAME"},
+		{"Username", "accountName", "Accountname"},
+		{"User_name", "accountName", "accountName"},
+		{"userID", "accountID", "accountID"},
+		{"UserID", "accountID", "AccountID"},
+		{"user_id", "accountID", "account_id"},
+		{"USER_ID", "accountID", "ACCOUNT_ID"},
+		{"HTTPClient", "APICaller", "APICaller"},
+		{"httpClient", "APICaller", "apiCaller"},
+		{"http_client", "APICaller", "api_caller"},
+	}
+
+	for _, tt := range tests {
+		if got := matchCase(tt.original, tt.replacement); got != tt.want {
+			t.Errorf("matchCase(%q, %q) = %q, want %q", tt.original, tt.replacement, got, tt.want)
+		}
+	}
+}
+
+func TestSmartCasePattern(t *testing.T) {
+	tests := []struct {
+		query string
+		text  string
+		want  bool
+	}{
+		{"userName", "userName", true},
+		{"userName", "UserName", true},
+		{"userName", "user_name", true},
+		{"userName", "USER_NAME", true},
+		{"userName", "user-name", true},
+		{"userName", "username", true},
+		{"userName", "USERNAME", true},
+		{"userName", "Username", true},
+		{"userName", "user.name", false},
+		{"userName", "uSeRnAmE", false},
+		{"userID", "UserID", true},
+		{"userID", "user_id", true},
+		{"userID", "USER_ID", true},
+		{"HTTPClient", "httpClient", true},
+		{"HTTPClient", "http_client", true},
+		{"API", "api", true},
+	}
+
+	for _, tt := range tests {
+		re := regexp.MustCompile(smartCasePattern(tt.query))
+
+		if got := re.FindString(tt.text) == tt.text; got != tt.want {
+			t.Errorf("smartCasePattern(%q) matches %q = %v, want %v", tt.query, tt.text, got, tt.want)
+		}
+	}
+}
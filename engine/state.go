@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultStateFile is the file where the progress of the replacements is
+// recorded, so an interrupted run can be resumed.
+var DefaultStateFile = filepath.Join(StateDir, "state.json")
+
+// stateVersion changes when the format of the state file changes.
+const stateVersion = 1
+
+// ErrNothingToResume is returned by New with Options.Resume when the state
+// file does not exist, i.e. because the previous run completed.
+var ErrNothingToResume = errors.New("nothing to resume")
+
+// runState records the progress of the replacements in a file, one JSON
+// object per line, appended as the files are completed, so it is never lost
+// by an interruption:
+//
+//	{"version": 1, "key": "...", "started": "2006-01-02T15:04:05Z"}
+//	{"done": "/abs/path/a.go"}
+//	{"pending": "/abs/path/b.go"}
+//
+// The files that were not completed when the run ended, because it was
+// canceled or they failed, are recorded as pending. The key identifies the
+// rules, which must be the same to resume.
+type runState struct {
+	filename string
+	key      string
+	resume   bool
+	// resumed are the files completed by the previous runs.
+	resumed map[string]bool
+
+	mu     sync.Mutex
+	file   *os.File
+	queued map[string]bool
+}
+
+// stateHeader is the first line of the state file.
+type stateHeader struct {
+	Version int       `json:"version"`
+	Key     string    `json:"key"`
+	Started time.Time `json:"started"`
+}
+
+// stateEntry is one of the following lines of the state file.
+type stateEntry struct {
+	Done    string `json:"done,omitempty"`
+	Pending string `json:"pending,omitempty"`
+}
+
+// stateKey identifies the rules and their options.
+func stateKey(opts Options) string {
+	data, _ := json.Marshal(struct {
+		Rules    []RuleSpec
+		Defaults RuleOptions
+	}{opts.Rules, opts.defaults()})
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// loadState prepares the state of the run and, to resume it, reads the files
+// completed by the previous runs with the same rules.
+func loadState(filename string, key string, resume bool) (*runState, error) {
+	s := &runState{filename: filename, key: key, resume: resume, resumed: map[string]bool{}}
+
+	if !resume {
+		return s, nil
+	}
+
+	file, err := os.Open(filename)
+
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w, %s does not exist", ErrNothingToResume, filename)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+
+	var header stateHeader
+
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &header) != nil || header.Version != stateVersion {
+		return nil, fmt.Errorf("%s is not a state file", filename)
+	}
+
+	if header.Key != key {
+		return nil, fmt.Errorf("%s was written by a run with other rules, it cannot be resumed", filename)
+	}
+
+	for scanner.Scan() {
+		var entry stateEntry
+
+		// the last line is incomplete if the program was killed.
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Done != "" {
+			s.resumed[entry.Done] = true
+		}
+	}
+
+	return s, scanner.Err()
+}
+
+// open starts recording the run, after the files of the previous runs if it
+// is resumed.
+func (s *runState) open() error {
+	if err := makeDir(filepath.Dir(s.filename)); err != nil {
+		return fmt.Errorf("os.MkdirAll %s", err)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+
+	if !s.resume {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(s.filename, flags, 0644)
+
+	if err != nil {
+		return fmt.Errorf("state %s", err)
+	}
+
+	s.file, s.queued = file, map[string]bool{}
+
+	if !s.resume {
+		return s.write(stateHeader{Version: stateVersion, Key: s.key, Started: time.Now().UTC()})
+	}
+
+	return nil
+}
+
+// write appends one line to the state file.
+func (s *runState) write(v interface{}) error {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(data, '\n'))
+
+	return err
+}
+
+// stateName is the name of the file in the state file.
+func stateName(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+
+	return filename
+}
+
+// done reports whether the file was completed by the previous runs.
+func (s *runState) done(filename string) bool {
+	return s.resumed[stateName(filename)]
+}
+
+// queue records that the file is being processed, if the run is recorded.
+func (s *runState) queue(filename string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != nil {
+		s.queued[stateName(filename)] = true
+	}
+}
+
+// complete records that the file was processed and its findings replaced.
+func (s *runState) complete(filename string) error {
+	name := stateName(filename)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	delete(s.queued, name)
+
+	return s.write(stateEntry{Done: name})
+}
+
+// close records the pending files, if the run was interrupted, or removes
+// the state file if every file was completed.
+func (s *runState) close(interrupted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(s.queued))
+
+	for name := range s.queued {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := s.write(stateEntry{Pending: name}); err != nil {
+			break
+		}
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	if !interrupted && len(s.queued) == 0 {
+		if err := os.Remove(s.filename); err != nil {
+			return err
+		}
+
+		removeStateDir()
+
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("state %s", err)
+	}
+
+	return nil
+}
+
+// Resumed returns the number of files completed by the previous runs, which
+// are skipped, with Options.Resume.
+func (e *Engine) Resumed() int {
+	if e.state == nil {
+		return 0
+	}
+
+	return len(e.state.resumed)
+}
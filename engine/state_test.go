@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// key is the key of the state file; the key of the rules if empty.
+		key     string
+		done    []string
+		noState bool
+		want    map[string]string
+		wantErr error
+	}{
+		{
+			name: "completed files are skipped",
+			done: []string{"a.txt"},
+			want: map[string]string{"a.txt": "foo\n", "b.txt": "bar\n"},
+		},
+		{
+			name: "nothing completed",
+			want: map[string]string{"a.txt": "bar\n", "b.txt": "bar\n"},
+		},
+		{
+			name:    "other rules",
+			key:     "other",
+			done:    []string{"a.txt"},
+			wantErr: errors.New("other rules"),
+		},
+		{
+			name:    "no state file",
+			noState: true,
+			wantErr: ErrNothingToResume,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			stateFile := filepath.Join(dir, "state", "state.json")
+
+			writeFiles(t, dir, map[string]string{"a.txt": "foo\n", "b.txt": "foo\n"})
+
+			opts := Options{
+				Rules:     []RuleSpec{{Search: "foo", Replace: "bar"}},
+				Paths:     []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")},
+				StateFile: stateFile,
+				Resume:    true,
+			}
+
+			if !tt.noState {
+				key := tt.key
+
+				if key == "" {
+					key = stateKey(opts)
+				}
+
+				lines := []interface{}{stateHeader{Version: stateVersion, Key: key}}
+
+				for _, name := range tt.done {
+					lines = append(lines, stateEntry{Done: filepath.Join(dir, name)})
+				}
+
+				var data []byte
+
+				for _, line := range lines {
+					b, _ := json.Marshal(line)
+					data = append(append(data, b...), '\n')
+				}
+
+				writeFiles(t, dir, map[string]string{"state/state.json": string(data)})
+			}
+
+			e, err := New(opts)
+
+			if tt.wantErr != nil {
+				if err == nil || (!errors.Is(err, tt.wantErr) && !strings.Contains(err.Error(), tt.wantErr.Error())) {
+					t.Fatalf("New = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := e.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			got := readFiles(t, dir)
+
+			if _, ok := got["state/state.json"]; ok {
+				t.Fatalf("the state file was not removed after the run completed")
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("files after Apply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state.json")
+
+	s, err := loadState(stateFile, "key", false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+
+	s.queue(a)
+	s.queue(b)
+
+	if err := s.complete(a); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.close(true); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := loadState(stateFile, "key", true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !resumed.done(a) || resumed.done(b) {
+		t.Fatalf("resumed files = %v, want only %s", resumed.resumed, a)
+	}
+}
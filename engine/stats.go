@@ -0,0 +1,115 @@
+package engine
+
+// Stats counts the files and occurrences processed by the engine.
+type Stats struct {
+	FilesScanned  int `json:"files_scanned"`
+	FilesMatched  int `json:"files_matched"`
+	FilesModified int `json:"files_modified"`
+	Findings      int `json:"findings"`
+	Occurrences   int `json:"occurrences"`
+	// FilesSkipped counts the files that were not searched because of a
+	// limit, like Options.MaxFileSize, and were reported as skipped.
+	FilesSkipped int `json:"files_skipped"`
+	// FilesCached counts the files that were not searched because the cache
+	// recorded that they did not match, and they were not modified since.
+	FilesCached int `json:"files_cached"`
+	// Suppressed counts the occurrences exempted by the IgnoreMarker and
+	// IgnoreNextMarker comments, which are not included in Occurrences.
+	Suppressed int `json:"suppressed"`
+	// BytesWritten is the total size of the modified files.
+	BytesWritten int64 `json:"bytes_written"`
+}
+
+// Stats returns a copy of the statistics collected so far.
+func (e *Engine) Stats() Stats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.stats
+}
+
+// scanned counts one file that was searched.
+func (e *Engine) scanned() {
+	e.mu.Lock()
+	e.stats.FilesScanned++
+	e.mu.Unlock()
+}
+
+// skipped counts one file that was reported as skipped.
+func (e *Engine) skipped() {
+	e.mu.Lock()
+	e.stats.FilesSkipped++
+	e.mu.Unlock()
+}
+
+// hit counts one file skipped because of the cache.
+func (e *Engine) hit() {
+	e.mu.Lock()
+	e.stats.FilesCached++
+	e.mu.Unlock()
+}
+
+// matched counts one file containing the specified findings.
+func (e *Engine) matched(findings []Finding) {
+	e.mu.Lock()
+	e.stats.FilesMatched++
+	e.stats.Findings += len(findings)
+	for _, item := range findings {
+		e.stats.Occurrences += item.Occurrences
+	}
+	e.mu.Unlock()
+}
+
+// suppressed counts the occurrences exempted by the markers.
+func (e *Engine) suppressed(n int) {
+	e.mu.Lock()
+	e.stats.Suppressed += n
+	e.mu.Unlock()
+}
+
+// modified counts one file that was rewritten with the specified size.
+func (e *Engine) modified(size int64) {
+	e.mu.Lock()
+	e.stats.FilesModified++
+	e.stats.BytesWritten += size
+	e.mu.Unlock()
+}
+
+// Progress is the number of files processed so far, out of the files found
+// by the walk, to estimate the remaining time of long executions.
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+	// Complete is true once the walk finished and Total no longer grows.
+	Complete bool `json:"complete"`
+}
+
+// Progress returns a copy of the progress of the execution.
+func (e *Engine) Progress() Progress {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.progress
+}
+
+// queued counts the files found by the walk.
+func (e *Engine) queued(n int) {
+	e.mu.Lock()
+	e.progress.Total += n
+	e.progress.Complete = false
+	e.mu.Unlock()
+}
+
+// found records that the walk finished.
+func (e *Engine) found() {
+	e.mu.Lock()
+	e.progress.Complete = true
+	e.mu.Unlock()
+}
+
+// processed counts one file that was searched, or ignored, and replaced.
+func (e *Engine) processed() {
+	e.mu.Lock()
+	e.progress.Done++
+	e.mu.Unlock()
+}
@@ -0,0 +1,352 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultStreamThreshold is the file size, in bytes, above which the files are
+// processed in chunks instead of being loaded in memory when the value of
+// Options.StreamThreshold is zero.
+const DefaultStreamThreshold = 64 << 20
+
+// chunkSize is the amount of data read at once when a file is streamed.
+const chunkSize = 1 << 20
+
+// chunkOverlap is the amount of data kept from one chunk to the next so that
+// multiline matches can cross chunk boundaries. Matches longer than this may
+// not be found when a file is streamed.
+const chunkOverlap = 64 << 10
+
+// streams reports whether a file of the specified size must be streamed.
+func (e *Engine) streams(size int64) bool {
+	threshold := e.opts.StreamThreshold
+
+	if threshold == 0 {
+		threshold = DefaultStreamThreshold
+	}
+
+	return threshold > 0 && size > threshold
+}
+
+// forEachSegment splits the data into segments that end at a line boundary
+// and are never crossed by a match, so each one can be searched on its own.
+// The function receives every segment and the number of lines before it.
+func forEachSegment(rs RuleSet, r io.Reader, fn func(segment []byte, line int) error) error {
+	var line int
+	var eof bool
+
+	buf := make([]byte, 0, chunkSize+chunkOverlap)
+
+	for !eof || len(buf) > 0 {
+		if !eof {
+			n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		cut, ok := segmentEnd(rs, buf, eof)
+
+		if !ok {
+			// the buffer is full but there is no safe place to cut it, most
+			// likely because of a very long line, so it has to grow.
+			grown := make([]byte, len(buf), 2*cap(buf))
+			copy(grown, buf)
+			buf = grown
+			continue
+		}
+
+		if err := fn(buf[:cut], line); err != nil {
+			return err
+		}
+
+		line += bytes.Count(buf[:cut], []byte("\n"))
+		buf = buf[:copy(buf, buf[cut:])]
+	}
+
+	return nil
+}
+
+// segmentEnd finds where the buffered data can be cut. The last chunkOverlap
+// bytes are kept for the next segment, unless it is the end of the data, and
+// the cut is moved forward, to the end of the line, past any match crossing
+// it. The second value is false if there is no place to cut the buffer yet.
+func segmentEnd(rs RuleSet, buf []byte, eof bool) (int, bool) {
+	if eof {
+		return len(buf), true
+	}
+
+	cut := len(buf) - chunkOverlap
+
+	if cut <= 0 {
+		return 0, false
+	}
+
+	cut, ok := lineEnd(buf, cut)
+
+	if !ok {
+		return 0, false
+	}
+
+	for _, m := range rs.FindAll(buf) {
+		if m.Loc[0] >= cut {
+			break
+		}
+
+		if m.Loc[1] > cut {
+			if cut, ok = lineEnd(buf, m.Loc[1]); !ok {
+				return 0, false
+			}
+		}
+	}
+
+	return cut, true
+}
+
+// lineEnd returns the offset following the end of the line containing the
+// byte before the offset, or false if the line is not complete.
+func lineEnd(buf []byte, offset int) (int, bool) {
+	i := bytes.IndexByte(buf[offset-1:], '\n')
+
+	if i < 0 {
+		return 0, false
+	}
+
+	return offset + i, true
+}
+
+// findStream is like findMultiline but reads the data in segments.
+func findStream(rs RuleSet, r io.Reader) ([]Finding, error) {
+	var findings []Finding
+
+	err := forEachSegment(rs, r, func(segment []byte, line int) error {
+		findings = append(findings, moveFindings(findMultiline(rs, segment), line)...)
+		return nil
+	})
+
+	return findings, err
+}
+
+// replaceStream is like replaceMultiline but reads the data in segments and
+// writes the result as soon as each segment is processed. The function
+// receives the findings of every segment, with the line numbers of the data,
+// and returns the ones to replace. The occurrences exempted by the markers of
+// the segment are never replaced.
+func replaceStream(rs RuleSet, r io.Reader, w io.Writer, keep func(found []Finding) []Finding, p *placement) error {
+	return forEachSegment(rs, r, func(segment []byte, line int) error {
+		found, _, err := suppress("", segment, findMultiline(rs, segment))
+
+		if err != nil {
+			return err
+		}
+
+		findings := keep(moveFindings(found, line))
+
+		newline := DetectLineEndings(segment).Newline()
+		p.base = line
+		_, err = w.Write(replaceMultiline(rs, segment, moveFindings(findings, -line), nil, newline, p))
+		return err
+	})
+}
+
+// replaceLinesStream reads, replaces and writes one line at a time, the same
+// way the scanner searches the files, so that patterns cannot match across
+// lines. The occurrences left out by the limiter, or exempted by the markers,
+// are not replaced.
+func replaceLinesStream(rs RuleSet, r *bufio.Reader, w io.Writer, l *limiter, p *placement) error {
+	var prev []byte
+
+	for row := 1; ; row++ {
+		line, err := r.ReadBytes('\n')
+
+		if len(line) > 0 {
+			newline := DetectLineEndings(line).Newline()
+			text := line[:textLength(line)]
+			ignored := suppressedLine(prev, text)
+			prev = text
+
+			if item, ok := findInLine(rs, string(text), row); ok && !ignored {
+				for _, item := range l.limit([]Finding{item}) {
+					var rerr error
+
+					if line, rerr = replaceFinding(rs, line, item, newline, p); rerr != nil {
+						return rerr
+					}
+				}
+			}
+
+			if _, werr := w.Write(line); werr != nil {
+				return werr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// replaceFindingsStream is like replaceFindings but reads and writes one line
+// at a time.
+func replaceFindingsStream(rs RuleSet, r *bufio.Reader, w io.Writer, findings []Finding, selected map[int]bool, p *placement) error {
+	rows := findingsByLine(findings, selected)
+
+	for row := 1; ; row++ {
+		line, err := r.ReadBytes('\n')
+
+		if item, ok := rows[row]; ok && len(line) > 0 {
+			var rerr error
+
+			if line, rerr = replaceFinding(rs, line, item, DetectLineEndings(line).Newline(), p); rerr != nil {
+				return rerr
+			}
+		}
+
+		if len(line) > 0 {
+			if _, werr := w.Write(line); werr != nil {
+				return werr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// applyStream is like ApplyFile but never loads the whole file in memory. The
+// result is written to a temporary file, and the original content is copied
+// into the journal, before the temporary file replaces the original.
+func (e *Engine) applyStream(res *SearchResult, selected map[int]bool) error {
+	in, err := os.Open(res.Filename)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := createAtomic(res.Filename, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Abort()
+
+	hash := sha256.New()
+	p := &placement{filename: res.Filename}
+	r := bufio.NewReaderSize(in, chunkSize)
+	w := bufio.NewWriterSize(io.MultiWriter(out, hash), chunkSize)
+
+	if e.opts.Multiline {
+		rows := findingsByLine(res.Findings, selected)
+		err = replaceStream(res.Rules, r, w, func(found []Finding) []Finding {
+			var findings []Finding
+			for _, item := range found {
+				if item, ok := rows[item.LineNumber]; ok {
+					findings = append(findings, item)
+				}
+			}
+			return findings
+		}, p)
+	} else {
+		err = replaceFindingsStream(res.Rules, r, w, res.Findings, selected, p)
+	}
+
+	if err == nil {
+		err = p.err
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s %w", res.Filename, err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if e.journal != nil {
+		if _, err := in.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := e.journal.RecordStream(res.Filename, in, hex.EncodeToString(hash.Sum(nil))); err != nil {
+			return fmt.Errorf("journal.Record %s %s", res.Filename, err)
+		}
+	}
+
+	return out.Commit()
+}
+
+// Filter applies all the rules to the data read from r, as if it were the
+// content of a file, and writes the result to w. Nothing is recorded in the
+// journal because no file is modified.
+func (e *Engine) Filter(r io.Reader, w io.Writer) error {
+	in := bufio.NewReaderSize(r, chunkSize)
+	out := bufio.NewWriterSize(w, chunkSize)
+
+	var err error
+
+	l := e.limiter("")
+	p := &placement{}
+
+	if e.locator != nil {
+		err = e.filterLocated(in, out, l, p)
+	} else if e.opts.Multiline {
+		err = replaceStream(e.rules, in, out, l.limit, p)
+	} else {
+		err = replaceLinesStream(e.rules, in, out, l, p)
+	}
+
+	if err == nil {
+		err = p.err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return out.Flush()
+}
+
+// filterLocated replaces the occurrences found by the locator in the data
+// read from r, which is parsed at once.
+func (e *Engine) filterLocated(r io.Reader, w io.Writer, l *limiter, p *placement) error {
+	content, err := io.ReadAll(r)
+
+	if err != nil {
+		return err
+	}
+
+	matches, err := e.locator.matches("", e.rules, content)
+
+	if err != nil {
+		return err
+	}
+
+	findings := l.limit(locatedFindings(content, matches))
+	content = replaceMatches(content, matches, findings, nil, DetectLineEndings(content).Newline(), p)
+
+	_, err = w.Write(content)
+
+	return err
+}
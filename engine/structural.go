@@ -0,0 +1,355 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// holeStart and holeEnd delimit the holes of the structural templates, i.e.
+// ":[args]" in "foo(:[args])".
+const (
+	holeStart = ":["
+	holeEnd   = "]"
+)
+
+// closers are the delimiters that must be balanced inside the holes.
+var closers = map[byte]byte{'(': ')', '[': ']', '{': '}'}
+
+// structure is a compiled structural template. The search text is split in
+// tokens: words, punctuation characters and holes. The whitespace between the
+// tokens is optional and matches any amount of whitespace, including line
+// breaks, and every hole matches the shortest text with balanced parentheses,
+// brackets, braces and quotes that lets the rest of the template match.
+type structure struct {
+	tokens     []structToken
+	holes      int
+	ignoreCase bool
+	// replace is the replacement split in literal text and hole references.
+	replace []structToken
+}
+
+// structToken is a literal text, if hole is negative, or a hole. The same
+// name can be used by multiple holes, which must then match the same text;
+// first is the index of the first hole with the name.
+type structToken struct {
+	text  string
+	hole  int
+	first int
+}
+
+// compileStructure parses the search and the replacement templates.
+func compileStructure(search string, replace string, ignoreCase bool) (*structure, error) {
+	s := &structure{ignoreCase: ignoreCase}
+	names := map[string]int{}
+
+	for i := 0; i < len(search); {
+		c := search[i]
+
+		switch {
+		case strings.HasPrefix(search[i:], holeStart):
+			name, n, err := parseHole(search[i:])
+
+			if err != nil {
+				return nil, err
+			}
+
+			first, ok := names[name]
+
+			if !ok || name == "_" {
+				first = s.holes
+				names[name] = first
+			}
+
+			if k := len(s.tokens) - 1; k >= 0 && s.tokens[k].hole >= 0 {
+				return nil, fmt.Errorf("holes %s and %s must be separated by some text", s.tokens[k].text, name)
+			}
+
+			s.tokens = append(s.tokens, structToken{text: name, hole: s.holes, first: first})
+			s.holes++
+			i += n
+		case isSpace(c):
+			i++
+		case isWordChar(c):
+			n := i + 1
+			for n < len(search) && isWordChar(search[n]) {
+				n++
+			}
+			s.tokens = append(s.tokens, structToken{text: search[i:n], hole: -1})
+			i = n
+		default:
+			s.tokens = append(s.tokens, structToken{text: search[i : i+1], hole: -1})
+			i++
+		}
+	}
+
+	if len(s.tokens) == s.holes {
+		return nil, fmt.Errorf("the template must contain some text besides the holes")
+	}
+
+	for i := 0; i < len(replace); {
+		k := strings.Index(replace[i:], holeStart)
+
+		if k < 0 {
+			s.replace = append(s.replace, structToken{text: replace[i:], hole: -1})
+			break
+		}
+
+		if k > 0 {
+			s.replace = append(s.replace, structToken{text: replace[i : i+k], hole: -1})
+		}
+
+		name, n, err := parseHole(replace[i+k:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		first, ok := names[name]
+
+		if !ok || name == "_" {
+			return nil, fmt.Errorf("%s%s%s is not a hole of the search template", holeStart, name, holeEnd)
+		}
+
+		s.replace = append(s.replace, structToken{text: name, hole: first, first: first})
+		i += k + n
+	}
+
+	return s, nil
+}
+
+// parseHole returns the name of the hole at the beginning of the text and the
+// length of the hole, including the delimiters.
+func parseHole(text string) (string, int, error) {
+	k := strings.Index(text, holeEnd)
+
+	if k < 0 {
+		return "", 0, fmt.Errorf("unterminated hole %q", text)
+	}
+
+	name := text[len(holeStart):k]
+
+	if name == "" {
+		return "", 0, fmt.Errorf("hole without name in %q", text)
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !isWordChar(name[i]) {
+			return "", 0, fmt.Errorf("invalid hole name %q", name)
+		}
+	}
+
+	return name, k + len(holeEnd), nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// skipSpace returns the offset of the first character that is not a space.
+func skipSpace(text []byte, pos int) int {
+	for pos < len(text) && isSpace(text[pos]) {
+		pos++
+	}
+
+	return pos
+}
+
+// trimSpace returns the end of the text between the offsets, without the
+// trailing spaces.
+func trimSpace(text []byte, start int, end int) int {
+	for end > start && isSpace(text[end-1]) {
+		end--
+	}
+
+	return end
+}
+
+// findAll returns the non-overlapping matches of the template, in the format
+// of regexp.FindAllSubmatchIndex: the offsets of the match followed by the
+// offsets of every hole.
+func (s *structure) findAll(text []byte) [][]int {
+	var matches [][]int
+
+	for start := 0; start < len(text); start++ {
+		if first := s.tokens[0]; first.hole < 0 && !s.ignoreCase {
+			k := bytes.Index(text[start:], []byte(first.text))
+
+			if k < 0 {
+				break
+			}
+
+			start += k
+		} else if isSpace(text[start]) {
+			continue
+		}
+
+		loc := make([]int, 2+2*s.holes)
+
+		if end := s.match(text, 0, start, loc[2:]); end > start {
+			loc[0], loc[1] = start, end
+			matches = append(matches, loc)
+			start = end - 1
+		}
+	}
+
+	return matches
+}
+
+// match matches the tokens, starting with the kth one, at the offset of the
+// text. It returns the end of the match, or -1, and records the offsets of
+// the holes.
+func (s *structure) match(text []byte, k int, pos int, holes []int) int {
+	if k == len(s.tokens) {
+		return pos
+	}
+
+	if k > 0 {
+		pos = skipSpace(text, pos)
+	}
+
+	tok := s.tokens[k]
+
+	if tok.hole < 0 {
+		if !s.literalAt(text, pos, tok.text) {
+			return -1
+		}
+
+		return s.match(text, k+1, pos+len(tok.text), holes)
+	}
+
+	if k == len(s.tokens)-1 {
+		// a hole at the end of the template takes the rest of the line.
+		end := pos
+
+		for end < len(text) && text[end] != '\n' {
+			next, ok := balancedStep(text, end)
+			if !ok {
+				break
+			}
+			end = next
+		}
+
+		holes[2*tok.hole], holes[2*tok.hole+1] = pos, trimSpace(text, pos, end)
+
+		if !s.sameHole(text, tok, holes) {
+			return -1
+		}
+
+		return holes[2*tok.hole+1]
+	}
+
+	for end := pos; ; {
+		holes[2*tok.hole], holes[2*tok.hole+1] = pos, trimSpace(text, pos, end)
+
+		if s.sameHole(text, tok, holes) {
+			if n := s.match(text, k+1, end, holes); n >= 0 {
+				return n
+			}
+		}
+
+		if end == len(text) {
+			return -1
+		}
+
+		next, ok := balancedStep(text, end)
+
+		if !ok {
+			return -1
+		}
+
+		end = next
+	}
+}
+
+// literalAt reports whether the literal text is at the offset, without being
+// part of a longer word.
+func (s *structure) literalAt(text []byte, pos int, literal string) bool {
+	end := pos + len(literal)
+
+	if end > len(text) {
+		return false
+	}
+
+	if s.ignoreCase {
+		if !bytes.EqualFold(text[pos:end], []byte(literal)) {
+			return false
+		}
+	} else if string(text[pos:end]) != literal {
+		return false
+	}
+
+	if isWordChar(literal[0]) && pos > 0 && isWordChar(text[pos-1]) {
+		return false
+	}
+
+	if isWordChar(literal[len(literal)-1]) && end < len(text) && isWordChar(text[end]) {
+		return false
+	}
+
+	return true
+}
+
+// sameHole reports whether the hole matches the same text as the first hole
+// with the same name.
+func (s *structure) sameHole(text []byte, tok structToken, holes []int) bool {
+	if tok.first == tok.hole {
+		return true
+	}
+
+	a := text[holes[2*tok.first]:holes[2*tok.first+1]]
+	b := text[holes[2*tok.hole]:holes[2*tok.hole+1]]
+
+	return bytes.Equal(a, b)
+}
+
+// balancedStep returns the offset following the character at the offset, or
+// following the whole group if it opens a group or a quoted string. It fails
+// on the closing delimiters without a group.
+func balancedStep(text []byte, pos int) (int, bool) {
+	c := text[pos]
+
+	switch c {
+	case ')', ']', '}':
+		return 0, false
+	case '"', '\'', '`':
+		return quotedEnd(text, pos+1, c, true), true
+	}
+
+	closer, ok := closers[c]
+
+	if !ok {
+		return pos + 1, true
+	}
+
+	for i := pos + 1; i < len(text); {
+		if text[i] == closer {
+			return i + 1, true
+		}
+
+		next, ok := balancedStep(text, i)
+
+		if !ok {
+			return 0, false
+		}
+
+		i = next
+	}
+
+	return 0, false
+}
+
+// expand returns the replacement for the match, with the text of the holes.
+func (s *structure) expand(text []byte, m []int) []byte {
+	var out []byte
+
+	for _, tok := range s.replace {
+		if tok.hole < 0 {
+			out = append(out, tok.text...)
+		} else {
+			out = append(out, text[m[2+2*tok.hole]:m[3+2*tok.hole]]...)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,98 @@
+package engine
+
+import "testing"
+
+func TestStructuralReplace(t *testing.T) {
+	tests := []struct {
+		name    string
+		search  string
+		replace string
+		input   string
+		want    string
+	}{
+		{
+			name:    "balanced arguments",
+			search:  "foo(:[args])",
+			replace: "bar(:[args])",
+			input:   "x := foo(a, g(b, c))",
+			want:    "x := bar(a, g(b, c))",
+		},
+		{
+			name:    "any whitespace",
+			search:  "if :[cond] { return :[v] }",
+			replace: "return :[v] if :[cond]",
+			input:   "if ok {\n\treturn 1\n}",
+			want:    "return 1 if ok",
+		},
+		{
+			name:    "swapped holes",
+			search:  "assert(:[a], :[b])",
+			replace: "assert(:[b], :[a])",
+			input:   "assert(got, want) assert(f(x, y), z)",
+			want:    "assert(want, got) assert(z, f(x, y))",
+		},
+		{
+			name:    "repeated hole must match the same text",
+			search:  "eq(:[x], :[x])",
+			replace: "true",
+			input:   "eq(a, a) eq(a, b)",
+			want:    "true eq(a, b)",
+		},
+		{
+			name:    "trailing hole takes the rest of the line",
+			search:  "return :[v]",
+			replace: "yield :[v]",
+			input:   "return f(a, b)  \nx := 1",
+			want:    "yield f(a, b)  \nx := 1",
+		},
+		{
+			name:    "quotes are balanced",
+			search:  "log(:[msg])",
+			replace: "logf(:[msg])",
+			input:   `log("a)b")`,
+			want:    `logf("a)b")`,
+		},
+		{
+			name:    "no match",
+			search:  "foo(:[args])",
+			replace: "bar(:[args])",
+			input:   "foobar(x)",
+			want:    "foobar(x)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewRule(tt.search, tt.replace, RuleOptions{Structural: true})
+
+			if err != nil {
+				t.Fatalf("NewRule %s", err)
+			}
+
+			if got := string(RuleSet{rule}.Replace([]byte(tt.input))); got != tt.want {
+				t.Fatalf("Replace(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileStructureErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		search  string
+		replace string
+	}{
+		{"only holes", ":[a]", ":[a]"},
+		{"adjacent holes", "f(:[a]:[b])", ""},
+		{"unknown hole in the replacement", "f(:[a])", "g(:[b])"},
+		{"anonymous hole in the replacement", "f(:[_])", "g(:[_])"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileStructure(tt.search, tt.replace, false); err == nil {
+				t.Fatalf("compileStructure(%q, %q) succeeded, want an error", tt.search, tt.replace)
+			}
+		})
+	}
+}
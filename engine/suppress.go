@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// IgnoreMarker is the comment that exempts the occurrences of its line from
+// the replacements, and IgnoreNextMarker the ones of the next line.
+const (
+	IgnoreMarker     = "refactor:ignore"
+	IgnoreNextMarker = "refactor:ignore-next-line"
+)
+
+// hasIgnoreMarker reports whether the line contains IgnoreMarker on its own,
+// not as the beginning of IgnoreNextMarker.
+func hasIgnoreMarker(line []byte) bool {
+	for {
+		i := bytes.Index(line, []byte(IgnoreMarker))
+
+		if i < 0 {
+			return false
+		}
+
+		if !bytes.HasPrefix(line[i:], []byte(IgnoreNextMarker)) {
+			return true
+		}
+
+		line = line[i+len(IgnoreNextMarker):]
+	}
+}
+
+// suppressedLine reports whether the occurrences of the line are exempted by
+// a marker on it or on the previous line.
+func suppressedLine(prev, line []byte) bool {
+	return bytes.Contains(prev, []byte(IgnoreNextMarker)) || hasIgnoreMarker(line)
+}
+
+// suppressedLines returns the numbers of the lines exempted by the markers.
+func suppressedLines(r io.Reader) (map[int]bool, error) {
+	var prev []byte
+
+	lines := map[int]bool{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), MaxLineLength)
+
+	for row := 1; scanner.Scan(); row++ {
+		if suppressedLine(prev, scanner.Bytes()) {
+			lines[row] = true
+		}
+
+		prev = append(prev[:0], scanner.Bytes()...)
+	}
+
+	return lines, scanner.Err()
+}
+
+// suppress removes the positions of the findings on the lines exempted by the
+// markers, and the findings left without any. The content of the file is read
+// again if it is not in memory. The second value is the number of positions
+// removed.
+func suppress(filename string, content []byte, findings []Finding) ([]Finding, int, error) {
+	var lines map[int]bool
+	var err error
+
+	if content != nil {
+		if !bytes.Contains(content, []byte(IgnoreMarker)) {
+			return findings, 0, nil
+		}
+
+		lines, err = suppressedLines(bytes.NewReader(content))
+	} else {
+		var file *os.File
+
+		if file, err = os.Open(filename); err != nil {
+			return findings, 0, err
+		}
+
+		defer file.Close()
+
+		lines, err = suppressedLines(file)
+	}
+
+	if err != nil || len(lines) == 0 {
+		return findings, 0, err
+	}
+
+	kept, removed := suppressLines(findings, lines)
+
+	return kept, removed, nil
+}
+
+// suppressLines removes the positions of the findings on the lines, and the
+// findings left without any. The second value is the number of positions
+// removed.
+func suppressLines(findings []Finding, lines map[int]bool) ([]Finding, int) {
+	var kept []Finding
+	var removed int
+
+	for _, item := range findings {
+		var positions []Position
+
+		for _, pos := range item.Positions {
+			if lines[pos.Line] {
+				removed++
+				continue
+			}
+
+			positions = append(positions, pos)
+		}
+
+		if len(positions) == 0 {
+			continue
+		}
+
+		if len(positions) < len(item.Positions) {
+			item.Positions = positions
+			item.Occurrences = len(positions)
+			item.limited = true
+		}
+
+		kept = append(kept, item)
+	}
+
+	return kept, removed
+}
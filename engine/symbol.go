@@ -0,0 +1,409 @@
+package engine
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// symbolEdit is one identifier that must be renamed.
+type symbolEdit struct {
+	offset int
+	rule   *Rule
+	// tag renames the value of a struct tag of the field instead of the
+	// identifier, or nil.
+	tag *Rule
+}
+
+// rename returns the rule replacing the text of the edit.
+func (edit symbolEdit) rename() *Rule {
+	if edit.tag != nil {
+		return edit.tag
+	}
+
+	return edit.rule
+}
+
+// symbolTable finds the identifiers to rename in the Go files. The packages
+// are parsed and type-checked once per directory, the first time one of
+// their files is searched, so the references to the identifiers declared in
+// other packages are recognized too.
+type symbolTable struct {
+	sync.Mutex
+	fset       *token.FileSet
+	importer   types.Importer
+	qualifiers map[*Rule]string
+	loaded     map[string]bool
+	edits      map[string][]symbolEdit
+	errs       map[string]error
+	// tags are the keys of the struct tags renamed with the fields, and
+	// tagsOnly leaves the fields untouched, see Options.Tags.
+	tags     []string
+	tagsOnly bool
+	tagRules map[string]*Rule
+}
+
+func newSymbolTable() *symbolTable {
+	fset := token.NewFileSet()
+
+	return &symbolTable{
+		fset:       fset,
+		importer:   importer.ForCompiler(fset, "source", nil),
+		qualifiers: map[*Rule]string{},
+		loaded:     map[string]bool{},
+		edits:      map[string][]symbolEdit{},
+		errs:       map[string]error{},
+		tagRules:   map[string]*Rule{},
+	}
+}
+
+// splitSymbol separates the optional package qualifier from the identifier,
+// i.e. "api.Client" or "github.com/acme/api.Client".
+func splitSymbol(search string) (string, string) {
+	if i := strings.LastIndexByte(search, '.'); i >= 0 {
+		return search[:i], search[i+1:]
+	}
+
+	return "", search
+}
+
+// compileSymbol compiles the rule of the symbol mode. The pattern matches the
+// identifier as a whole word, which is only used to describe the findings;
+// the identifiers are located with the syntax tree.
+func (t *symbolTable) compileSymbol(spec RuleSpec, defaults RuleOptions) (*Rule, error) {
+	qualifier, name := splitSymbol(spec.Search)
+
+	if !token.IsIdentifier(name) || !token.IsIdentifier(spec.Replace) {
+		return nil, fmt.Errorf("%q and %q must be Go identifiers", spec.Search, spec.Replace)
+	}
+
+	spec.Search = name
+	defaults.WholeWord = true
+
+	rule, err := spec.Compile(defaults)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.qualifiers[rule] = qualifier
+
+	return rule, nil
+}
+
+// prepare loads the packages of all the files.
+func (t *symbolTable) prepare(files []string) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, filename := range files {
+		if dir := filepath.Dir(filename); !t.loaded[dir] {
+			t.loaded[dir] = true
+			t.load(dir)
+		}
+	}
+}
+
+// fileEdits returns the identifiers of the file that must be renamed by the
+// rules, sorted by offset.
+func (t *symbolTable) fileEdits(filename string, rs RuleSet) ([]symbolEdit, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if dir := filepath.Dir(filename); !t.loaded[dir] {
+		t.loaded[dir] = true
+		t.load(dir)
+	}
+
+	name := cleanPath(filename)
+
+	if err := t.errs[name]; err != nil {
+		return nil, err
+	}
+
+	var edits []symbolEdit
+
+	for _, edit := range t.edits[name] {
+		for _, rule := range rs {
+			if edit.rule == rule {
+				edits = append(edits, edit)
+				break
+			}
+		}
+	}
+
+	return edits, nil
+}
+
+// load parses and type-checks the packages of the directory. The type errors,
+// usually caused by dependencies that cannot be imported, are ignored: the
+// identifiers that cannot be resolved are renamed if the rule does not have a
+// package qualifier.
+func (t *symbolTable) load(dir string) {
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return
+	}
+
+	packages := map[string][]*ast.File{}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".go") {
+			continue
+		}
+
+		filename := filepath.Join(dir, entry.Name())
+		file, err := parser.ParseFile(t.fset, filename, nil, 0)
+
+		if err != nil {
+			t.errs[cleanPath(filename)] = err
+			continue
+		}
+
+		// the external test package is type-checked on its own.
+		packages[file.Name.Name] = append(packages[file.Name.Name], file)
+	}
+
+	for name, files := range packages {
+		info := &types.Info{
+			Defs: map[*ast.Ident]types.Object{},
+			Uses: map[*ast.Ident]types.Object{},
+		}
+
+		conf := types.Config{Importer: t.importer, FakeImportC: true, Error: func(error) {}}
+		conf.Check(name, t.fset, files, info)
+
+		for _, file := range files {
+			t.collect(file, info)
+		}
+	}
+}
+
+// collect finds the identifiers of the file matching the rules.
+func (t *symbolTable) collect(file *ast.File, info *types.Info) {
+	filename := cleanPath(t.fset.Position(file.Pos()).Filename)
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		if field, ok := n.(*ast.Field); ok && field.Tag != nil && len(t.tags) > 0 {
+			t.collectTags(filename, field, info)
+		}
+
+		id, ok := n.(*ast.Ident)
+
+		if !ok || id == file.Name || t.tagsOnly {
+			return true
+		}
+
+		for rule, qualifier := range t.qualifiers {
+			if id.Name == rule.Search && qualifies(info.ObjectOf(id), qualifier) {
+				t.edits[filename] = append(t.edits[filename], symbolEdit{
+					offset: t.fset.Position(id.Pos()).Offset,
+					rule:   rule,
+				})
+			}
+		}
+
+		return true
+	})
+
+	sort.Slice(t.edits[filename], func(i, j int) bool {
+		return t.edits[filename][i].offset < t.edits[filename][j].offset
+	})
+}
+
+// collectTags finds the values of the struct tags of the field matching the
+// name of the field renamed by a rule, in any case, i.e. json:"user_id" for
+// the field UserID.
+func (t *symbolTable) collectTags(filename string, field *ast.Field, info *types.Info) {
+	raw := field.Tag.Value
+
+	// the tags in double quotes would need the escapes to be preserved.
+	if !strings.HasPrefix(raw, "`") {
+		return
+	}
+
+	for _, id := range field.Names {
+		for rule, qualifier := range t.qualifiers {
+			if id.Name != rule.Search || !qualifies(info.ObjectOf(id), qualifier) {
+				continue
+			}
+
+			base := t.fset.Position(field.Tag.Pos()).Offset
+
+			for _, value := range tagValues(raw, t.tags) {
+				name := raw[value[0]:value[1]]
+
+				if !sameWords(name, rule.Search) {
+					continue
+				}
+
+				// a value written exactly like the field takes the new name
+				// as written, keeping its initialisms.
+				replace := rule.Replace
+
+				if name != rule.Search {
+					replace = matchCase(name, rule.Replace)
+				}
+
+				t.edits[filename] = append(t.edits[filename], symbolEdit{
+					offset: base + value[0],
+					rule:   rule,
+					tag:    t.tagRule(name, replace),
+				})
+			}
+		}
+	}
+}
+
+// tagRule returns the rule renaming the value of a tag, which describes the
+// findings.
+func (t *symbolTable) tagRule(search string, replace string) *Rule {
+	key := search + "\x00" + replace
+
+	if rule, ok := t.tagRules[key]; ok {
+		return rule
+	}
+
+	rule, _ := NewRule(search, replace, RuleOptions{})
+	t.tagRules[key] = rule
+
+	return rule
+}
+
+// tagValues returns the offsets of the names in the values of the keys of the
+// struct tag, i.e. user_id in `json:"user_id,omitempty"`, in the raw
+// literal. The names "-" and the empty ones are never returned.
+func tagValues(raw string, keys []string) [][2]int {
+	var values [][2]int
+
+	for i := 1; i < len(raw)-1; {
+		for i < len(raw) && raw[i] == ' ' {
+			i++
+		}
+
+		colon := strings.IndexByte(raw[i:], ':')
+
+		if colon <= 0 || i+colon+1 >= len(raw) || raw[i+colon+1] != '"' {
+			break
+		}
+
+		key := raw[i : i+colon]
+		start := i + colon + 2
+		end := strings.IndexByte(raw[start:], '"')
+
+		if end < 0 {
+			break
+		}
+
+		name := raw[start : start+end]
+
+		if k := strings.IndexByte(name, ','); k >= 0 {
+			name = name[:k]
+		}
+
+		for _, want := range keys {
+			if key == want && name != "" && name != "-" {
+				values = append(values, [2]int{start, start + len(name)})
+			}
+		}
+
+		i = start + end + 1
+	}
+
+	return values
+}
+
+// sameWords reports whether the texts have the same words regardless of the
+// naming convention, i.e. user_id and UserID.
+func sameWords(a string, b string) bool {
+	return strings.EqualFold(strings.Join(splitWords(a), ""), strings.Join(splitWords(b), ""))
+}
+
+// qualifies reports whether the object belongs to the package, identified by
+// its name or import path. Without qualifier, every object qualifies.
+func qualifies(obj types.Object, qualifier string) bool {
+	if qualifier == "" {
+		return true
+	}
+
+	if obj == nil || obj.Pkg() == nil {
+		return false
+	}
+
+	pkg := obj.Pkg()
+
+	return pkg.Name() == qualifier || pkg.Path() == qualifier || strings.HasSuffix(pkg.Path(), "/"+qualifier)
+}
+
+// findSymbols groups the identifiers to rename by line.
+func findSymbols(content []byte, edits []symbolEdit) []Finding {
+	var findings []Finding
+
+	offsets := lineOffsets(content)
+
+	for _, edit := range edits {
+		row := lineAt(offsets, edit.offset)
+		start := offsets[row-1]
+		pos := positionIn(content[start:], row, edit.offset-start)
+
+		// the values of the tags are not found by the patterns of the rules.
+		rule := edit.rename()
+		located := RuleMatch{Rule: rule, Loc: []int{edit.offset - start, edit.offset - start + len(rule.Search)}}
+
+		if n := len(findings); n > 0 && findings[n-1].LineNumber == row {
+			findings[n-1].Occurrences++
+			findings[n-1].Positions = append(findings[n-1].Positions, pos)
+			findings[n-1].located = append(findings[n-1].located, located)
+			continue
+		}
+
+		end, ok := lineEnd(content, start+1)
+
+		if !ok {
+			end = len(content)
+		}
+
+		text := strings.TrimRight(string(content[start:end]), "\r\n")
+
+		findings = append(findings, Finding{
+			LineNumber:   row,
+			EndLine:      row,
+			Occurrences:  1,
+			OriginalText: text,
+			Positions:    []Position{pos},
+			located:      []RuleMatch{located},
+		})
+	}
+
+	return findings
+}
+
+// replaceSymbols renames the identifiers in the selected lines, or in every
+// line if the selection is nil.
+func replaceSymbols(content []byte, edits []symbolEdit, selected map[int]bool) []byte {
+	var out []byte
+	var last int
+
+	offsets := lineOffsets(content)
+
+	for _, edit := range edits {
+		if selected != nil && !selected[lineAt(offsets, edit.offset)] {
+			continue
+		}
+
+		out = append(out, content[last:edit.offset]...)
+		out = append(out, edit.rename().Replace...)
+		last = edit.offset + len(edit.rename().Search)
+	}
+
+	return append(out, content[last:]...)
+}
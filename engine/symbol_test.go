@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymbolTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		search   string
+		replace  string
+		tagsOnly bool
+		content  string
+		want     string
+	}{
+		{
+			name:    "field and tags",
+			search:  "UserName",
+			replace: "AccountName",
+			content: "package p\n\ntype T struct {\n\tUserName string `json:\"user_name\" db:\"userName\"`\n}\n",
+			want:    "package p\n\ntype T struct {\n\tAccountName string `json:\"account_name\" db:\"accountName\"`\n}\n",
+		},
+		{
+			name:    "initialism",
+			search:  "UserID",
+			replace: "AccountID",
+			content: "package p\n\ntype T struct {\n\tUserID int `json:\"user_id\" db:\"UserID\"`\n}\n",
+			want:    "package p\n\ntype T struct {\n\tAccountID int `json:\"account_id\" db:\"AccountID\"`\n}\n",
+		},
+		{
+			name:     "tags only",
+			search:   "UserID",
+			replace:  "AccountID",
+			tagsOnly: true,
+			content:  "package p\n\ntype T struct {\n\tUserID int `json:\"userID\" db:\"-\"`\n}\n",
+			want:     "package p\n\ntype T struct {\n\tUserID int `json:\"accountID\" db:\"-\"`\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "p.go")
+
+			if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := New(Options{
+				Rules:    []RuleSpec{{Search: tt.search, Replace: tt.replace}},
+				Lang:     "go",
+				Symbol:   true,
+				Tags:     []string{"json", "db"},
+				TagsOnly: tt.tagsOnly,
+				Paths:    []string{filename},
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := e.Apply(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(filename)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Fatalf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+)
+
+// The syntax nodes, see Language.Nodes. The names are the names in the
+// declarations, not the references to them. The Go files are parsed with
+// go/parser; the names of the other languages are the ones following the
+// keywords of their declarations, i.e. def in Python, see declarations.
+const (
+	NodeFunctionName  = "function_name"
+	NodeTypeName      = "type_name"
+	NodeFieldName     = "field_name"
+	NodeVariableName  = "variable_name"
+	NodePackageName   = "package_name"
+	NodeStringLiteral = "string_literal"
+	NodeComment       = "comment"
+)
+
+// nodeKind reports whether the scope is a syntax node of a registered
+// language, rather than one of the scopes every language has.
+func nodeKind(scope string) bool {
+	for _, lang := range languages {
+		if lang.hasNode(scope) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasNode reports whether the parser of the language finds the syntax node.
+func (lang *Language) hasNode(kind string) bool {
+	for _, known := range lang.Nodes {
+		if known == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// supports reports whether the scopes of the engine apply to the files of the
+// language, which must have a parser for the syntax nodes of the scopes.
+func (e *Engine) supports(lang *Language) bool {
+	if lang == nil {
+		return false
+	}
+
+	for _, scope := range []string{e.opts.Only, e.opts.Skip} {
+		if nodeKind(scope) && (lang.Parse == nil || !lang.hasNode(scope)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unsupportedNode returns an error if one of the scopes is a syntax node that
+// the parser of the language does not find, or the language is unknown.
+func unsupportedNode(lang *Language, scopes ...string) error {
+	for _, scope := range scopes {
+		if !nodeKind(scope) {
+			continue
+		}
+
+		if lang == nil {
+			return fmt.Errorf("the language is unknown, it has no %s syntax nodes", scope)
+		}
+
+		if lang.Parse == nil || !lang.hasNode(scope) {
+			return fmt.Errorf("%s files have no %s syntax nodes", lang.Name, scope)
+		}
+	}
+
+	return nil
+}
+
+// scopeTokens returns the tokens of the content for the scope, the syntax
+// nodes of the parser with the kind of the scope or the tokens of the
+// tokenizer.
+func scopeTokens(lang *Language, scope string, content []byte) []Token {
+	if !nodeKind(scope) {
+		return tokenize(lang.Tokenize, content)
+	}
+
+	var nodes []Token
+
+	for _, node := range lang.Parse(content) {
+		if node.Kind == scope {
+			nodes = append(nodes, node)
+		}
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Start < nodes[j].Start
+	})
+
+	return nodes
+}
+
+// parseGo returns the syntax nodes of a Go file. The nodes of the files with
+// syntax errors are the ones found before the first error.
+func parseGo(content []byte) []Token {
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, "", content, parser.ParseComments)
+
+	if file == nil {
+		return nil
+	}
+
+	var nodes []Token
+
+	add := func(kind string, node ast.Node) {
+		if node != nil && node.Pos().IsValid() && node.End().IsValid() {
+			nodes = append(nodes, Token{fset.Position(node.Pos()).Offset, fset.Position(node.End()).Offset, kind})
+		}
+	}
+
+	names := func(kind string, idents []*ast.Ident) {
+		for _, ident := range idents {
+			add(kind, ident)
+		}
+	}
+
+	fields := func(kind string, list *ast.FieldList) {
+		if list != nil {
+			for _, field := range list.List {
+				names(kind, field.Names)
+			}
+		}
+	}
+
+	add(NodePackageName, file.Name)
+
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			add(NodeComment, c)
+		}
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BasicLit:
+			if n.Kind == token.STRING {
+				add(NodeStringLiteral, n)
+			}
+		case *ast.FuncDecl:
+			add(NodeFunctionName, n.Name)
+			fields(NodeVariableName, n.Recv)
+		case *ast.FuncType:
+			fields(NodeVariableName, n.Params)
+			fields(NodeVariableName, n.Results)
+		case *ast.TypeSpec:
+			add(NodeTypeName, n.Name)
+		case *ast.StructType:
+			fields(NodeFieldName, n.Fields)
+		case *ast.InterfaceType:
+			fields(NodeFunctionName, n.Methods)
+		case *ast.ValueSpec:
+			names(NodeVariableName, n.Names)
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE {
+				for _, lhs := range n.Lhs {
+					if ident, ok := lhs.(*ast.Ident); ok {
+						add(NodeVariableName, ident)
+					}
+				}
+			}
+		case *ast.RangeStmt:
+			if n.Tok == token.DEFINE {
+				add(NodeVariableName, n.Key)
+				add(NodeVariableName, n.Value)
+			}
+		}
+
+		return true
+	})
+
+	return nodes
+}
+
+// The keywords declaring the names of the languages parsed by declarations,
+// and the kind of the names.
+var (
+	jsKeywords = map[string]string{
+		"function":  NodeFunctionName,
+		"class":     NodeTypeName,
+		"interface": NodeTypeName,
+		"type":      NodeTypeName,
+		"enum":      NodeTypeName,
+		"const":     NodeVariableName,
+		"let":       NodeVariableName,
+		"var":       NodeVariableName,
+	}
+	pythonKeywords = map[string]string{"def": NodeFunctionName, "class": NodeTypeName}
+	shellKeywords  = map[string]string{"function": NodeFunctionName}
+)
+
+// parseJS returns the syntax nodes of a JavaScript or TypeScript file.
+func parseJS(content []byte) []Token {
+	return declarations(lexJS, jsKeywords, content)
+}
+
+// parsePython returns the syntax nodes of a Python file.
+func parsePython(content []byte) []Token {
+	return declarations(lexPython, pythonKeywords, content)
+}
+
+// parseShell returns the syntax nodes of a shell script. The functions are
+// declared with the function keyword or with parentheses, i.e. "name() {".
+func parseShell(content []byte) []Token {
+	nodes := declarations(lexShell, shellKeywords, content)
+
+	for _, tok := range tokenize(lexShell, content) {
+		line := content[bytes.LastIndexByte(content[:tok.Start], '\n')+1 : tok.Start]
+
+		if tok.Kind != ScopeIdentifiers || len(bytes.Trim(line, " \t")) > 0 {
+			continue
+		}
+
+		if rest := bytes.TrimLeft(content[tok.End:], " \t"); bytes.HasPrefix(rest, []byte("()")) {
+			nodes = append(nodes, Token{tok.Start, tok.End, NodeFunctionName})
+		}
+	}
+
+	return nodes
+}
+
+// parseData returns the syntax nodes of a JSON or YAML file, which has no
+// declarations.
+func parseData(lex func([]byte) []Token) func([]byte) []Token {
+	return func(content []byte) []Token {
+		return declarations(lex, nil, content)
+	}
+}
+
+// declarations returns the comments and the string literals found by the
+// tokenizer as syntax nodes, and the names following the keywords with the
+// kind of the keyword, i.e. "name" in "def name(". The name must be separated
+// from the keyword by spaces, or the asterisk of the generators of
+// JavaScript, and the keywords following a dot are properties.
+func declarations(lex func([]byte) []Token, keywords map[string]string, content []byte) []Token {
+	var nodes []Token
+	var kind string
+	var end int
+
+	for _, tok := range tokenize(lex, content) {
+		switch tok.Kind {
+		case ScopeComments:
+			nodes = append(nodes, Token{tok.Start, tok.End, NodeComment})
+			kind = ""
+		case ScopeStrings:
+			nodes = append(nodes, Token{tok.Start, tok.End, NodeStringLiteral})
+			kind = ""
+		default:
+			gap := content[end:tok.Start]
+
+			if kind != "" && len(bytes.Trim(gap, " \t*")) == 0 {
+				nodes = append(nodes, Token{tok.Start, tok.End, kind})
+				kind = ""
+				break
+			}
+
+			kind = keywords[string(content[tok.Start:tok.End])]
+
+			if bytes.HasSuffix(bytes.TrimRight(gap, " \t"), []byte(".")) {
+				kind = ""
+			}
+		}
+
+		end = tok.End
+	}
+
+	return nodes
+}
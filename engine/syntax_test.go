@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyntaxNodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		only    string
+		content string
+		want    string
+	}{
+		{
+			name:    "go function name",
+			file:    "a.go",
+			only:    NodeFunctionName,
+			content: "package a\n\nfunc foo() { foo() }\n",
+			want:    "package a\n\nfunc bar() { foo() }\n",
+		},
+		{
+			name:    "javascript function and class",
+			file:    "a.js",
+			only:    NodeFunctionName,
+			content: "function foo() {}\nasync function* fooGen() {}\nfoo();\nobj.function = foo;\n",
+			want:    "function bar() {}\nasync function* barGen() {}\nfoo();\nobj.function = foo;\n",
+		},
+		{
+			name:    "typescript type",
+			file:    "a.ts",
+			only:    NodeTypeName,
+			content: "type foo = string;\ninterface fooProps {}\nlet x: foo;\nevent.type === foo;\n",
+			want:    "type bar = string;\ninterface barProps {}\nlet x: foo;\nevent.type === foo;\n",
+		},
+		{
+			name:    "javascript variable",
+			file:    "a.js",
+			only:    NodeVariableName,
+			content: "const foo = 1;\nlet fooBar = foo;\n",
+			want:    "const bar = 1;\nlet barBar = foo;\n",
+		},
+		{
+			name:    "python def and class",
+			file:    "a.py",
+			only:    NodeFunctionName,
+			content: "def foo():\n    return foo()\n\nclass foo:\n    pass\n",
+			want:    "def bar():\n    return foo()\n\nclass foo:\n    pass\n",
+		},
+		{
+			name:    "python string literal",
+			file:    "a.py",
+			only:    NodeStringLiteral,
+			content: "foo = 'foo'  # foo\n",
+			want:    "foo = 'bar'  # foo\n",
+		},
+		{
+			name:    "shell functions",
+			file:    "a.sh",
+			only:    NodeFunctionName,
+			content: "foo() {\n  echo foo\n}\nfunction fooAll {\n  foo\n}\n",
+			want:    "bar() {\n  echo foo\n}\nfunction barAll {\n  foo\n}\n",
+		},
+		{
+			name:    "yaml comment",
+			file:    "a.yaml",
+			only:    NodeComment,
+			content: "foo: 1 # foo\n",
+			want:    "foo: 1 # bar\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), tt.file)
+
+			if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := New(Options{
+				Rules: []RuleSpec{{Search: "foo", Replace: "bar"}},
+				Only:  tt.only,
+				Paths: []string{filename},
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results, err := e.Apply(context.Background())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, res := range results {
+				if res.Err != nil {
+					t.Fatal(res.Err)
+				}
+			}
+
+			got, err := os.ReadFile(filename)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Fatalf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyntaxNodesUnsupported(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		lang    string
+		only    string
+		skip    string
+		wantErr string
+	}{
+		{name: "python field", file: "a.py", only: NodeFieldName, wantErr: "python files have no field_name syntax nodes"},
+		{name: "shell type", file: "a.sh", skip: NodeTypeName, wantErr: "shell files have no type_name syntax nodes"},
+		{name: "unknown language", file: "a.txt", only: NodeFunctionName, wantErr: "the language is unknown"},
+		{name: "selected language", file: "a.py", lang: "python", only: NodePackageName, wantErr: "python files have no package_name"},
+		{name: "common scope", file: "a.txt", only: ScopeComments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), tt.file)
+
+			if err := os.WriteFile(filename, []byte("foo\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := New(Options{
+				Rules: []RuleSpec{{Search: "foo", Replace: "bar"}},
+				Lang:  tt.lang,
+				Only:  tt.only,
+				Skip:  tt.skip,
+				Paths: []string{filename},
+			})
+
+			if err == nil {
+				var results []SearchResult
+
+				if results, err = e.Search(context.Background()); err == nil {
+					for _, res := range results {
+						if res.Err != nil {
+							err = res.Err
+						}
+					}
+				}
+			}
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %s", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// templateRef matches the group references supported by regexp.Expand, i.e.
+// $1, ${1}, $name, ${name}, as well as the $$ escape sequence.
+var templateRef = regexp.MustCompile(`\$(\$|\{([^}]*)\}|([a-zA-Z0-9_]+))`)
+
+// templateParts splits the replacement at the placeholders and at the case
+// conversions of the regular expressions. It returns nil if the replacement
+// has none of them.
+func templateParts(replace string, opts RuleOptions) ([]templatePart, error) {
+	parts := []templatePart{{text: replace}}
+
+	if opts.Placeholders {
+		var err error
+
+		if parts, err = parsePlaceholders(replace); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Regexp {
+		var split []templatePart
+
+		for _, part := range parts {
+			if part.literal || part.placeholder != "" {
+				split = append(split, part)
+			} else {
+				split = append(split, parseCaseFilters(part.text)...)
+			}
+		}
+
+		parts = split
+	}
+
+	if len(parts) == 1 && parts[0].convert == nil && parts[0].placeholder == "" {
+		return nil, nil
+	}
+
+	return parts, nil
+}
+
+// validateTemplate verifies that every group referenced by the replacement
+// exists in the pattern. regexp.Expand silently replaces unknown groups with
+// an empty string, which is a common mistake when a reference is followed by
+// a letter, i.e. "$1Handler" refers to a group named "1Handler".
+func validateTemplate(re *regexp.Regexp, template string) error {
+	names := map[string]bool{}
+
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			names[name] = true
+		}
+	}
+
+	for _, m := range templateRef.FindAllStringSubmatch(template, -1) {
+		if m[1] == "$" {
+			continue
+		}
+
+		name := m[2]
+
+		if name == "" {
+			name = m[3]
+		}
+
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > re.NumSubexp() {
+				return fmt.Errorf("%s refers to group %d but the pattern has %d group(s)", m[0], n, re.NumSubexp())
+			}
+			continue
+		}
+
+		if !names[name] {
+			if m[2] == "" {
+				return fmt.Errorf("%s refers to an unknown group %q, use ${name} to delimit the reference", m[0], name)
+			}
+			return fmt.Errorf("%s refers to an unknown group %q", m[0], name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,329 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// maxReadAhead is the number of directories that can be read ahead of the
+// visit, so the entries kept in memory do not grow with the size of the tree.
+const maxReadAhead = 256
+
+// walker lists the files of a directory tree. The directories are read by
+// multiple goroutines, ahead of the visit, but the files are visited by one
+// single goroutine in the same order as filepath.Walk, so the results do not
+// depend on the timing.
+type walker struct {
+	e      *Engine
+	ctx    context.Context
+	root   string
+	ignore *gitignore
+	// visited prevents the loops created by symbolic links with -follow. It
+	// is only used by the visit.
+	visited map[string]bool
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []*dirNode
+	closed bool
+	// ahead is the number of directories queued and not visited yet, which
+	// never exceeds limit; the others are read by the visit itself.
+	ahead int
+	limit int
+}
+
+// dirNode is one directory of the tree, which is read once by a worker or by
+// the visit, whichever comes first.
+type dirNode struct {
+	path    string
+	started int32
+	done    chan struct{}
+	entries []dirEntry
+	err     error
+	// subdirs are the directories of the entries already queued to be read.
+	subdirs map[string]*dirNode
+}
+
+// dirEntry is one file or directory found in a directory.
+type dirEntry struct {
+	name string
+	info os.FileInfo
+	err  error
+}
+
+// walkFiles calls the function with every file of the tree rooted at the
+// folder that is not excluded by the filters. The walk stops at the first
+// error returned by the function.
+func (e *Engine) walkFiles(ctx context.Context, root string, fn func(name string) error) error {
+	return e.newWalker(ctx, root).walk(fn)
+}
+
+// newWalker returns the walker of the tree rooted at the folder.
+func (e *Engine) newWalker(ctx context.Context, root string) *walker {
+	w := &walker{
+		e:       e,
+		ctx:     ctx,
+		root:    root,
+		ignore:  newGitignore(),
+		visited: map[string]bool{},
+		limit:   maxReadAhead,
+	}
+
+	w.cond = sync.NewCond(&w.mu)
+
+	return w
+}
+
+// walk visits the tree, see walkFiles.
+func (w *walker) walk(fn func(name string) error) error {
+	e, root := w.e, w.root
+
+	// the .gitignore files of the parent directories, up to the working
+	// directory, also apply when the folder is inside of it.
+	if !e.opts.NoIgnore {
+		for _, dir := range parentDirs(root) {
+			w.ignore.Load(dir)
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < e.concurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+
+	defer func() {
+		w.mu.Lock()
+		w.closed = true
+		w.mu.Unlock()
+		w.cond.Broadcast()
+		wg.Wait()
+	}()
+
+	info, err := os.Lstat(root)
+
+	if err != nil {
+		return err
+	}
+
+	return w.visit(root, info, nil, fn)
+}
+
+// work reads the queued directories until the walk finishes.
+func (w *walker) work() {
+	for {
+		w.mu.Lock()
+
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+
+		if w.closed {
+			w.mu.Unlock()
+			return
+		}
+
+		node := w.queue[0]
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		w.read(node)
+	}
+}
+
+// read lists the entries of the directory, unless it was already started,
+// and queues the subdirectories that will be visited.
+func (w *walker) read(node *dirNode) {
+	if !atomic.CompareAndSwapInt32(&node.started, 0, 1) {
+		return
+	}
+
+	defer close(node.done)
+
+	if w.ctx.Err() != nil {
+		node.err = w.ctx.Err()
+		return
+	}
+
+	// the rules of the directory apply to the entries checked below.
+	if !w.e.opts.NoIgnore {
+		w.ignore.Load(node.path)
+	}
+
+	file, err := os.Open(node.path)
+
+	if err != nil {
+		node.err = err
+		return
+	}
+
+	names, err := file.Readdirnames(-1)
+	file.Close()
+
+	if err != nil {
+		node.err = err
+		return
+	}
+
+	sort.Strings(names)
+
+	node.entries = make([]dirEntry, len(names))
+	node.subdirs = map[string]*dirNode{}
+
+	var queue []*dirNode
+
+	for i, name := range names {
+		path := filepath.Join(node.path, name)
+		info, err := os.Lstat(path)
+		node.entries[i] = dirEntry{name: name, info: info, err: err}
+
+		// the symbolic links are resolved by the visit, which prevents loops.
+		if err == nil && info.IsDir() && !w.skipDir(path) && w.reserve() {
+			child := &dirNode{path: path, done: make(chan struct{})}
+			node.subdirs[name] = child
+			queue = append(queue, child)
+		}
+	}
+
+	if len(queue) > 0 {
+		w.mu.Lock()
+		w.queue = append(w.queue, queue...)
+		w.mu.Unlock()
+		w.cond.Broadcast()
+	}
+}
+
+// reserve reports whether one more directory can be read ahead of the visit,
+// and counts it.
+func (w *walker) reserve() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.ahead >= w.limit {
+		return false
+	}
+
+	w.ahead++
+
+	return true
+}
+
+// release stops counting the directory queued by reserve, once the visit
+// reached it.
+func (w *walker) release() {
+	w.mu.Lock()
+	w.ahead--
+	w.mu.Unlock()
+}
+
+// skipDir reports whether the directory, and everything inside of it, is
+// excluded. The decision only depends on the path, unlike with -follow.
+func (w *walker) skipDir(s string) bool {
+	e := w.e
+
+	if cleanPath(s) == StateDir || (e.opts.BackupDir != "" && cleanPath(s) == cleanPath(e.opts.BackupDir)) {
+		return true
+	}
+
+	if e.tooDeep(w.root, s, true) {
+		return true
+	}
+
+	return cleanPath(s) != cleanPath(w.root) && (e.skipHidden(w.root, s) || e.filter.SkipDir(s) || e.skipDefault(w.root, s) || (!e.opts.NoIgnore && w.ignore.Ignored(s, true)))
+}
+
+// visit calls the function with the file, or with the files of the
+// directory. The node is the directory already queued, if any.
+func (w *walker) visit(s string, info os.FileInfo, node *dirNode, fn func(name string) error) error {
+	e := w.e
+
+	if node != nil {
+		w.release()
+	}
+
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	name := s
+
+	if e.opts.Follow && info.Mode()&os.ModeSymlink != 0 {
+		var err error
+
+		if info, err = os.Stat(s); err != nil {
+			return nil /* broken link */
+		}
+
+		if !info.IsDir() {
+			name = resolveLink(s)
+		}
+	}
+
+	if info.IsDir() {
+		if w.skipDir(s) {
+			return nil
+		}
+
+		if e.opts.Follow {
+			id := fileID(s, info)
+			if w.visited[id] {
+				return nil
+			}
+			w.visited[id] = true
+		}
+
+		if e.watchDir != nil {
+			e.watchDir(s)
+		}
+
+		if node == nil {
+			node = &dirNode{path: s, done: make(chan struct{})}
+		}
+
+		// the directory is read right away if no worker started yet.
+		w.read(node)
+		<-node.done
+
+		if node.err != nil {
+			return node.err
+		}
+
+		for _, entry := range node.entries {
+			if entry.err != nil {
+				return entry.err
+			}
+
+			if err := w.visit(filepath.Join(s, entry.name), entry.info, node.subdirs[entry.name], fn); err != nil {
+				return err
+			}
+		}
+
+		// the entries are no longer needed once visited.
+		node.entries, node.subdirs = nil, nil
+
+		return nil
+	}
+
+	if e.tooDeep(w.root, s, false) || e.skipHidden(w.root, s) || !e.filter.Allow(s) || e.skipDefault(w.root, s) || (!e.opts.NoIgnore && w.ignore.Ignored(s, false)) {
+		return nil
+	}
+
+	if e.opts.Follow {
+		id := fileID(s, info)
+		if w.visited[id] {
+			return nil
+		}
+		w.visited[id] = true
+	}
+
+	return fn(name)
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalkReadAhead(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{}
+
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 3; j++ {
+			files[fmt.Sprintf("d%02d/s%d/file.txt", i, j)] = "foo\n"
+		}
+		files[fmt.Sprintf("d%02d/file.txt", i)] = "foo\n"
+	}
+
+	writeFiles(t, root, files)
+
+	var want []string
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			want = append(want, path)
+		}
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int{0, 1, 4, maxReadAhead} {
+		t.Run(fmt.Sprint(limit), func(t *testing.T) {
+			e, err := New(Options{Rules: []RuleSpec{{Search: "foo", Replace: "bar"}}, Concurrency: 8})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := e.newWalker(context.Background(), root)
+			w.limit = limit
+
+			var got []string
+			peak := 0
+
+			err = w.walk(func(name string) error {
+				w.mu.Lock()
+				if w.ahead > peak {
+					peak = w.ahead
+				}
+				w.mu.Unlock()
+
+				got = append(got, name)
+				return nil
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if peak > limit {
+				t.Fatalf("%d directories read ahead, more than %d", peak, limit)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("walk = %q, want %q", got, want)
+			}
+		})
+	}
+}
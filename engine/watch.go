@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultWatchInterval is the time between two checks of the files in watch
+// mode when the interval is not specified.
+const DefaultWatchInterval = time.Second
+
+// errNoNotifications is returned by newNotifier on the systems without file
+// system notifications.
+var errNoNotifications = errors.New("file system notifications are not supported")
+
+// fileState is what identifies a version of a file in watch mode.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// notifier reports the paths created, modified or deleted in the watched
+// directories, see newNotifier.
+type notifier interface {
+	// watch adds the directory, if it is not watched yet.
+	watch(dir string) error
+	// changes returns the paths changed since the previous call, without
+	// waiting, and whether the events of a new directory, or lost events,
+	// require to scan the tree again.
+	changes() (paths []string, rescan bool, err error)
+	close() error
+}
+
+// Watch keeps processing the files that are created or modified, until the
+// context is canceled, optionally applying the changes. The changes are
+// checked at every interval. The directories of the tree are watched with the
+// notifications of the file system, inotify on Linux, so only the files that
+// were modified are checked, and the tree is only scanned again when files or
+// folders are created. Without notifications, or when they cannot be set up,
+// the tree is scanned at every interval, comparing the size and modification
+// time of every file with the previous scan. The files that exist when the
+// function is called are not processed.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration, apply bool, fn func(SearchResult)) error {
+	n, err := newNotifier()
+
+	if err != nil {
+		return e.watch(ctx, nil, interval, apply, fn)
+	}
+
+	defer n.close()
+
+	return e.watch(ctx, n, interval, apply, fn)
+}
+
+// watch is Watch with the notifier of the directories, or nil to scan the
+// tree at every interval.
+func (e *Engine) watch(ctx context.Context, n notifier, interval time.Duration, apply bool, fn func(SearchResult)) error {
+	if e.symbols != nil {
+		return errors.New("watch mode cannot be combined with symbol mode")
+	}
+
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	if n != nil {
+		e.watchDir = func(dir string) {
+			// a directory that cannot be watched is left to the scans
+			// triggered by the others.
+			_ = n.watch(dir)
+		}
+
+		defer func() { e.watchDir = nil }()
+	}
+
+	seen := map[string]fileState{}
+
+	if _, err := e.changedFiles(ctx, seen); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		var changed []string
+		var err error
+
+		if n == nil {
+			changed, err = e.changedFiles(ctx, seen)
+		} else {
+			changed, err = e.notifiedFiles(ctx, n, seen)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(changed) == 0 {
+			continue
+		}
+
+		err = e.process(ctx, changed, apply, func(res SearchResult) {
+			// the files modified by the engine are not processed again,
+			// otherwise a replacement containing the search text would
+			// be applied over and over.
+			if res.Modified {
+				if fi, err := os.Stat(res.Filename); err == nil {
+					seen[res.Filename] = fileState{fi.Size(), fi.ModTime()}
+				}
+			}
+			fn(res)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// changedFiles returns the files that are new or different since the last
+// call, and updates the state of the files.
+func (e *Engine) changedFiles(ctx context.Context, seen map[string]fileState) ([]string, error) {
+	files, err := e.files(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+
+	present := map[string]bool{}
+
+	for _, filename := range files {
+		fi, err := os.Stat(filename)
+
+		if err != nil {
+			continue
+		}
+
+		// the files listed by git, or in the paths, are not walked.
+		if e.watchDir != nil {
+			e.watchDir(filepath.Dir(filename))
+		}
+
+		present[filename] = true
+		state := fileState{fi.Size(), fi.ModTime()}
+
+		if old, ok := seen[filename]; !ok || old.size != state.size || !old.modTime.Equal(state.modTime) {
+			seen[filename] = state
+			changed = append(changed, filename)
+		}
+	}
+
+	for filename := range seen {
+		if !present[filename] {
+			delete(seen, filename)
+		}
+	}
+
+	return changed, nil
+}
+
+// notifiedFiles is like changedFiles but only checks the files reported by
+// the notifier. The tree is scanned again, with changedFiles, if one of them
+// is new, since only the walk knows whether it is processed.
+func (e *Engine) notifiedFiles(ctx context.Context, n notifier, seen map[string]fileState) ([]string, error) {
+	paths, rescan, err := n.changes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+
+	for _, filename := range paths {
+		fi, err := os.Stat(filename)
+		old, ok := seen[filename]
+
+		if !ok {
+			// the temporary files are already gone.
+			if err == nil {
+				rescan = true
+			}
+			continue
+		}
+
+		if err != nil {
+			delete(seen, filename)
+			continue
+		}
+
+		if state := (fileState{fi.Size(), fi.ModTime()}); old.size != state.size || !old.modTime.Equal(state.modTime) {
+			seen[filename] = state
+			changed = append(changed, filename)
+		}
+	}
+
+	if !rescan {
+		return changed, nil
+	}
+
+	// the files above are already recorded as seen.
+	more, err := e.changedFiles(ctx, seen)
+
+	return append(changed, more...), err
+}
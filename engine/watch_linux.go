@@ -0,0 +1,122 @@
+//go:build linux
+// +build linux
+
+package engine
+
+import (
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask are the events of the watched directories: the files written,
+// touched, created, moved and deleted.
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE |
+	syscall.IN_ONLYDIR
+
+// inotify watches the directories with the inotify API of Linux. The events
+// are read without blocking when the changes are requested.
+type inotify struct {
+	fd   int
+	dirs map[int32]string
+	wds  map[string]int32
+	buf  []byte
+}
+
+// newNotifier returns the notifier of the directories.
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &inotify{
+		fd:   fd,
+		dirs: map[int32]string{},
+		wds:  map[string]int32{},
+		buf:  make([]byte, 64*1024),
+	}, nil
+}
+
+func (n *inotify) watch(dir string) error {
+	if _, ok := n.wds[dir]; ok {
+		return nil
+	}
+
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, inotifyMask)
+
+	if err != nil {
+		return err
+	}
+
+	// the same directory, reached through another path or moved, has the
+	// same descriptor and the events use the latest path.
+	n.dirs[int32(wd)] = dir
+	n.wds[dir] = int32(wd)
+
+	return nil
+}
+
+func (n *inotify) changes() ([]string, bool, error) {
+	var paths []string
+	var rescan bool
+
+	seen := map[string]bool{}
+
+	for {
+		size, err := syscall.Read(n.fd, n.buf)
+
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return paths, rescan, nil
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= size; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&n.buf[offset]))
+			name := n.buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			switch {
+			case event.Mask&syscall.IN_Q_OVERFLOW != 0:
+				rescan = true
+			case event.Mask&syscall.IN_IGNORED != 0:
+				// the directory was deleted, or moved away.
+				if dir, ok := n.dirs[event.Wd]; ok {
+					delete(n.dirs, event.Wd)
+					delete(n.wds, dir)
+				}
+			case event.Mask&syscall.IN_ISDIR != 0:
+				// the files of the folders created, moved or deleted are
+				// only known by the walk.
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO|syscall.IN_MOVED_FROM|syscall.IN_DELETE) != 0 {
+					rescan = true
+				}
+			default:
+				dir, ok := n.dirs[event.Wd]
+
+				if !ok {
+					continue
+				}
+
+				// the name is padded with null bytes.
+				for len(name) > 0 && name[len(name)-1] == 0 {
+					name = name[:len(name)-1]
+				}
+
+				if path := filepath.Join(dir, string(name)); !seen[path] {
+					seen[path] = true
+					paths = append(paths, path)
+				}
+			}
+		}
+	}
+}
+
+func (n *inotify) close() error {
+	return syscall.Close(n.fd)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package engine
+
+// newNotifier returns errNoNotifications, the files are scanned instead.
+func newNotifier() (notifier, error) {
+	return nil, errNoNotifications
+}
@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeNotifier reports the same changes once.
+type fakeNotifier struct {
+	paths  []string
+	rescan bool
+}
+
+func (n *fakeNotifier) watch(dir string) error { return nil }
+func (n *fakeNotifier) close() error           { return nil }
+
+func (n *fakeNotifier) changes() ([]string, bool, error) {
+	paths, rescan := n.paths, n.rescan
+	n.paths, n.rescan = nil, false
+	return paths, rescan, nil
+}
+
+func TestNotifiedFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  map[string]string
+		paths  []string
+		rescan bool
+		want   []string
+	}{
+		{
+			name:  "modified",
+			write: map[string]string{"a.txt": "foo foo\n"},
+			paths: []string{"a.txt"},
+			want:  []string{"a.txt"},
+		},
+		{
+			name:  "only the notified files",
+			write: map[string]string{"a.txt": "foo foo\n", "b.txt": "foo foo\n"},
+			paths: []string{"a.txt"},
+			want:  []string{"a.txt"},
+		},
+		{
+			name:  "unchanged",
+			paths: []string{"a.txt"},
+		},
+		{
+			name:  "new file",
+			write: map[string]string{"c.txt": "foo\n"},
+			paths: []string{"c.txt"},
+			want:  []string{"c.txt"},
+		},
+		{
+			name:  "temporary file",
+			paths: []string{".a.txt.tmp"},
+		},
+		{
+			name:   "modified and new folder",
+			write:  map[string]string{"a.txt": "foo foo\n", "sub/d.txt": "foo\n"},
+			paths:  []string{"a.txt"},
+			rescan: true,
+			want:   []string{"a.txt", "d.txt"},
+		},
+		{
+			name:   "lost events",
+			write:  map[string]string{"b.txt": "foo foo\n"},
+			rescan: true,
+			want:   []string{"b.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": "foo\n", "b.txt": "foo\n"})
+
+			e, err := New(Options{Rules: []RuleSpec{{Search: "foo", Replace: "bar"}}, Paths: []string{dir}})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seen := map[string]fileState{}
+
+			if _, err := e.changedFiles(context.Background(), seen); err != nil {
+				t.Fatal(err)
+			}
+
+			writeFiles(t, dir, tt.write)
+
+			n := &fakeNotifier{rescan: tt.rescan}
+
+			for _, path := range tt.paths {
+				n.paths = append(n.paths, filepath.Join(dir, path))
+			}
+
+			changed, err := e.notifiedFiles(context.Background(), n, seen)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+
+			for _, filename := range changed {
+				got = append(got, filepath.Base(filename))
+			}
+
+			sort.Strings(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("notifiedFiles = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		notifications bool
+	}{
+		{name: "scans"},
+		{name: "notifications", notifications: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n notifier
+
+			if tt.notifications {
+				var err error
+
+				if n, err = newNotifier(); err != nil {
+					t.Skip(err)
+				}
+
+				defer n.close()
+			}
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": "keep\n", "old.txt": "foo\n"})
+
+			e, err := New(Options{Rules: []RuleSpec{{Search: "foo", Replace: "bar"}}, Paths: []string{dir}})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			results := make(chan string, 10)
+			done := make(chan error, 1)
+
+			go func() {
+				done <- e.watch(ctx, n, 10*time.Millisecond, true, func(res SearchResult) {
+					if res.Modified {
+						results <- filepath.Base(res.Filename)
+					}
+				})
+			}()
+
+			// the files that exist when the watch starts are not processed.
+			time.Sleep(100 * time.Millisecond)
+			writeFiles(t, dir, map[string]string{"a.txt": "foo\n", "sub/b.txt": "foo\n"})
+
+			var got []string
+
+			for len(got) < 2 {
+				select {
+				case name := <-results:
+					got = append(got, name)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("modified %q, want a.txt and b.txt", got)
+				}
+			}
+
+			cancel()
+
+			if err := <-done; err != context.Canceled {
+				t.Fatalf("watch %v", err)
+			}
+
+			sort.Strings(got)
+
+			if want := []string{"a.txt", "b.txt"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("modified %q, want %q", got, want)
+			}
+
+			if want := map[string]string{"a.txt": "bar\n", "old.txt": "foo\n", "sub/b.txt": "bar\n"}; !reflect.DeepEqual(readFiles(t, dir), want) {
+				t.Fatalf("files = %q, want %q", readFiles(t, dir), want)
+			}
+		})
+	}
+}
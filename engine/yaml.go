@@ -0,0 +1,492 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The kinds of the frames of the YAML scanner.
+const (
+	// yamlNode is a key whose value is on the next lines, and yamlItem a
+	// sequence item.
+	yamlNode = iota
+	yamlItem
+	yamlMapping
+	yamlSequence
+)
+
+// yamlFrame is a collection, or a pending value, enclosing the current line.
+type yamlFrame struct {
+	indent   int
+	location []string
+	kind     int
+	// next is the index of the next item of a sequence.
+	next int
+}
+
+// yamlScanner walks the block and flow collections of YAML documents, line by
+// line, to locate the keys and the scalar values in the original text, so the
+// comments, the anchors and the formatting are preserved. The structure is
+// derived from the indentation; the block scalars, | and >, and the aliases
+// are never selected since they cannot be replaced by a single scalar.
+type yamlScanner struct {
+	content []byte
+	frames  []yamlFrame
+	tokens  []dataToken
+	// block is the indentation of the key of the block scalar being
+	// skipped, or -1.
+	block int
+}
+
+// scanYAML returns the keys and the scalar values of the documents. The paths
+// of every document, separated by ---, start from the root.
+func scanYAML(content []byte) ([]dataToken, error) {
+	s := &yamlScanner{content: content}
+	s.reset()
+
+	for offset := 0; offset < len(content); {
+		end := lineEnding(content, offset)
+		line := content[offset:end]
+		body := bytes.TrimLeft(line, " ")
+		indent := len(line) - len(body)
+
+		switch blank := len(bytes.TrimSpace(body)) == 0; {
+		case s.block >= 0 && (blank || indent > s.block):
+		case blank || body[0] == '#':
+		case isDocumentMarker(line):
+			s.reset()
+		default:
+			s.block = -1
+			consumed, err := s.node(offset+indent, indent)
+
+			if err != nil {
+				return nil, err
+			}
+
+			end = lineEnding(content, consumed)
+		}
+
+		offset = end + 1
+	}
+
+	return s.tokens, nil
+}
+
+// reset starts a new document.
+func (s *yamlScanner) reset() {
+	s.frames = []yamlFrame{{indent: -1, kind: yamlNode}}
+	s.block = -1
+}
+
+func (s *yamlScanner) top() *yamlFrame {
+	return &s.frames[len(s.frames)-1]
+}
+
+func (s *yamlScanner) push(frame yamlFrame) *yamlFrame {
+	s.frames = append(s.frames, frame)
+	return s.top()
+}
+
+func (s *yamlScanner) pop() {
+	if len(s.frames) > 1 {
+		s.frames = s.frames[:len(s.frames)-1]
+	}
+}
+
+// errorf returns an error with the line of the offset.
+func (s *yamlScanner) errorf(offset int, format string, args ...interface{}) error {
+	line := bytes.Count(s.content[:offset], []byte("\n")) + 1
+
+	return fmt.Errorf("invalid YAML on line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// node scans the content of the line at the offset, which is at the column,
+// and returns the offset where the scan stopped.
+func (s *yamlScanner) node(offset int, col int) (int, error) {
+	end := lineEnding(s.content, offset)
+	item := s.content[offset] == '-' && (offset+1 == end || s.content[offset+1] == ' ' || s.content[offset+1] == '\r')
+	key, keyEnd, after, isKey := s.key(offset, end)
+
+	if !item && !isKey {
+		for len(s.frames) > 1 && s.top().indent > col {
+			s.pop()
+		}
+
+		// the value of a key on the next line, or the continuation of a
+		// scalar, which is ignored.
+		if top := s.top(); (top.kind == yamlNode || top.kind == yamlItem) && top.indent < col {
+			location, indent := top.location, top.indent
+			s.pop()
+			return s.value(offset, end, location, indent)
+		}
+
+		return end, nil
+	}
+
+	top := s.container(col, item)
+
+	if top == nil {
+		return end, nil
+	}
+
+	if item {
+		location := appendLocation(top.location, "["+strconv.Itoa(top.next)+"]")
+		top.next++
+
+		rest := offset + 1
+		for rest < end && s.content[rest] == ' ' {
+			rest++
+		}
+
+		s.push(yamlFrame{indent: col, location: location, kind: yamlItem})
+
+		if rest == end || s.content[rest] == '\r' || s.content[rest] == '#' {
+			return end, nil
+		}
+
+		return s.node(rest, col+rest-offset)
+	}
+
+	location := appendLocation(top.location, key)
+	s.tokens = append(s.tokens, dataToken{start: offset, end: keyEnd, location: location, key: true})
+
+	rest := skipSpaces(s.content, after, end)
+
+	if rest == end || s.content[rest] == '\r' || s.content[rest] == '#' {
+		s.push(yamlFrame{indent: col, location: location, kind: yamlNode})
+		return end, nil
+	}
+
+	return s.value(rest, end, location, col)
+}
+
+// container returns the collection of the key or the sequence item at the
+// column, after leaving the deeper collections, or nil if the line does not
+// belong to a collection.
+func (s *yamlScanner) container(col int, item bool) *yamlFrame {
+	for {
+		top := s.top()
+
+		switch {
+		case top.indent > col:
+			s.pop()
+		case top.indent == col && top.kind == yamlMapping && !item, top.indent == col && top.kind == yamlSequence && item:
+			return top
+		case top.indent == col && top.kind == yamlNode && item:
+			// the items of a sequence can be at the same indentation as its key.
+			return s.push(yamlFrame{indent: col, location: top.location, kind: yamlSequence})
+		case top.indent == col:
+			if len(s.frames) == 1 {
+				return nil
+			}
+			s.pop()
+		case top.kind == yamlNode || top.kind == yamlItem:
+			kind := yamlMapping
+			if item {
+				kind = yamlSequence
+			}
+			return s.push(yamlFrame{indent: col, location: top.location, kind: kind})
+		default:
+			return nil
+		}
+	}
+}
+
+// key returns the key of the mapping entry at the offset, the offset where
+// the key ends and the offset following the colon.
+func (s *yamlScanner) key(offset int, end int) (string, int, int, bool) {
+	c := s.content[offset]
+
+	if c == '"' || c == '\'' {
+		name, keyEnd := s.quoted(offset)
+
+		if after := skipSpaces(s.content, keyEnd, end); after < end && s.content[after] == ':' && isYAMLSeparator(s.content, after+1, end) {
+			return name, keyEnd, after + 1, true
+		}
+
+		return "", 0, 0, false
+	}
+
+	if strings.IndexByte("[]{}#&*!|>%@`,?", c) >= 0 {
+		return "", 0, 0, false
+	}
+
+	for i := offset; i < end; i++ {
+		switch {
+		case s.content[i] == '#' && s.content[i-1] == ' ':
+			return "", 0, 0, false
+		case s.content[i] == ':' && isYAMLSeparator(s.content, i+1, end):
+			name := strings.TrimRight(string(s.content[offset:i]), " \t")
+			return name, offset + len(name), i + 1, true
+		}
+	}
+
+	return "", 0, 0, false
+}
+
+// value scans the value at the offset, which belongs to the location. The
+// column is the indentation of its key, used to skip the block scalars.
+func (s *yamlScanner) value(offset int, end int, location []string, col int) (int, error) {
+	// the anchors and the tags of the value.
+	for offset < end && (s.content[offset] == '&' || s.content[offset] == '!') {
+		for offset < end && s.content[offset] != ' ' {
+			offset++
+		}
+		offset = skipSpaces(s.content, offset, end)
+	}
+
+	if offset == end || s.content[offset] == '\r' || s.content[offset] == '#' {
+		s.push(yamlFrame{indent: col, location: location, kind: yamlNode})
+		return end, nil
+	}
+
+	switch s.content[offset] {
+	case '|', '>':
+		s.block = col
+	case '*':
+	case '[', '{':
+		return s.flow(offset, location)
+	case '"', '\'':
+		_, valueEnd := s.quoted(offset)
+		s.tokens = append(s.tokens, dataToken{start: offset, end: valueEnd, location: location})
+	default:
+		valueEnd := offset
+		for i := offset; i < end && !(s.content[i] == '#' && s.content[i-1] == ' '); i++ {
+			if s.content[i] != ' ' && s.content[i] != '\t' && s.content[i] != '\r' {
+				valueEnd = i + 1
+			}
+		}
+		s.tokens = append(s.tokens, dataToken{start: offset, end: valueEnd, location: location})
+	}
+
+	return end, nil
+}
+
+// flow scans the flow collection at the offset, which can span multiple
+// lines, and returns the offset following it.
+func (s *yamlScanner) flow(offset int, location []string) (int, error) {
+	start := offset
+	closing := byte(']')
+
+	if s.content[offset] == '{' {
+		closing = '}'
+	}
+
+	offset++
+
+	for i := 0; ; i++ {
+		if offset = s.skipFlowSpace(offset); offset == len(s.content) {
+			return 0, s.errorf(start, "the flow collection is not terminated")
+		}
+
+		if s.content[offset] == closing {
+			return offset + 1, nil
+		}
+
+		element := appendLocation(location, "["+strconv.Itoa(i)+"]")
+
+		if closing == '}' {
+			keyStart := offset
+			name, keyEnd := s.flowScalar(offset)
+			element = appendLocation(location, name)
+			s.tokens = append(s.tokens, dataToken{start: keyStart, end: keyEnd, location: element, key: true})
+
+			if offset = s.skipFlowSpace(keyEnd); offset < len(s.content) && s.content[offset] == ':' {
+				offset = s.skipFlowSpace(offset + 1)
+			}
+		}
+
+		if offset == len(s.content) {
+			return 0, s.errorf(start, "the flow collection is not terminated")
+		}
+
+		switch c := s.content[offset]; {
+		case c == '[' || c == '{':
+			var err error
+			if offset, err = s.flow(offset, element); err != nil {
+				return 0, err
+			}
+		case c == ',' || c == closing:
+		case c == '*':
+			_, offset = s.flowScalar(offset)
+		default:
+			valueStart := offset
+			_, offset = s.flowScalar(offset)
+			s.tokens = append(s.tokens, dataToken{start: valueStart, end: offset, location: element})
+		}
+
+		if offset = s.skipFlowSpace(offset); offset < len(s.content) && s.content[offset] == ',' {
+			offset++
+		} else if offset == len(s.content) || s.content[offset] != closing {
+			return 0, s.errorf(start, "expected a comma or the end of the flow collection")
+		}
+	}
+}
+
+// flowScalar returns the value of the scalar of a flow collection at the
+// offset, and the offset where it ends.
+func (s *yamlScanner) flowScalar(offset int) (string, int) {
+	if c := s.content[offset]; c == '"' || c == '\'' {
+		return s.quoted(offset)
+	}
+
+	end := offset
+
+	for i := offset; i < len(s.content); i++ {
+		c := s.content[i]
+
+		if c == ',' || c == ']' || c == '}' || c == '\n' || (c == '#' && s.content[i-1] == ' ') {
+			break
+		}
+
+		if c == ':' && (i+1 == len(s.content) || strings.IndexByte(" \r\n,]}", s.content[i+1]) >= 0) {
+			break
+		}
+
+		if c != ' ' && c != '\t' && c != '\r' {
+			end = i + 1
+		}
+	}
+
+	return string(s.content[offset:end]), end
+}
+
+// skipFlowSpace moves the offset past the white space, the line terminators
+// and the comments of a flow collection.
+func (s *yamlScanner) skipFlowSpace(offset int) int {
+	for offset < len(s.content) {
+		switch c := s.content[offset]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			offset++
+		case c == '#':
+			offset = lineEnding(s.content, offset)
+		default:
+			return offset
+		}
+	}
+
+	return offset
+}
+
+// quoted returns the value of the quoted scalar at the offset and the offset
+// following the closing quote. Two single quotes are one quote in the single
+// quoted scalars, and the double quoted ones have the escapes of JSON.
+func (s *yamlScanner) quoted(offset int) (string, int) {
+	if s.content[offset] == '"' {
+		end := quotedEnd(s.content, offset+1, '"', false)
+		raw := s.content[offset:end]
+
+		var text string
+
+		if err := json.Unmarshal(raw, &text); err != nil {
+			text = strings.Trim(string(raw), `"`)
+		}
+
+		return text, end
+	}
+
+	end := offset + 1
+
+	for end < len(s.content) && s.content[end] != '\n' {
+		if s.content[end] == '\'' {
+			if end+1 < len(s.content) && s.content[end+1] == '\'' {
+				end += 2
+				continue
+			}
+
+			end++
+			break
+		}
+
+		end++
+	}
+
+	return strings.Replace(strings.Trim(string(s.content[offset:end]), "'"), "''", "'", -1), end
+}
+
+// isDocumentMarker reports whether the line begins or ends a document.
+func isDocumentMarker(line []byte) bool {
+	line = bytes.TrimRight(line, " \t\r")
+
+	return bytes.Equal(line, []byte("---")) || bytes.Equal(line, []byte("...")) || bytes.HasPrefix(line, []byte("--- "))
+}
+
+// lexYAML recognizes the comments and the quoted scalars of YAML. The quotes
+// begin a scalar only at the beginning of a value, not in it's.
+func lexYAML(content []byte) []Token {
+	var spans []Token
+
+	for i := 0; i < len(content); i++ {
+		begins := i == 0 || bytes.IndexByte([]byte(" \t\n[{,"), content[i-1]) >= 0
+
+		switch c := content[i]; {
+		case c == '#' && begins:
+			end := lineEnding(content, i)
+			spans = append(spans, Token{i, end, ScopeComments})
+			i = end - 1
+		case (c == '"' || c == '\'') && begins:
+			end := quotedEnd(content, i+1, c, false)
+			if c == '\'' {
+				end = shellQuotedEnd(content, i+1, c)
+			}
+			spans = append(spans, Token{i, end, ScopeStrings})
+			i = end - 1
+		}
+	}
+
+	return spans
+}
+
+// isYAMLSeparator reports whether the colon before the offset separates a
+// key from its value: it must be followed by a space or the end of the line.
+func isYAMLSeparator(content []byte, offset int, end int) bool {
+	return offset == end || content[offset] == ' ' || content[offset] == '\t' || content[offset] == '\r'
+}
+
+// skipSpaces returns the offset of the first character that is not a space,
+// up to the end.
+func skipSpaces(content []byte, offset int, end int) int {
+	for offset < end && (content[offset] == ' ' || content[offset] == '\t') {
+		offset++
+	}
+
+	return offset
+}
+
+// appendLocation returns a copy of the location with one more element, since
+// the tokens keep their locations.
+func appendLocation(location []string, element string) []string {
+	return append(append([]string(nil), location...), element)
+}
+
+// yamlText returns the text if it is a plain scalar, or a quoted scalar
+// written by the user, or the text in double quotes otherwise, i.e. when it
+// contains ": " or begins with an indicator.
+func yamlText(text string) string {
+	if n := len(text); n >= 2 && text[0] == '\'' && text[n-1] == '\'' {
+		return text
+	}
+
+	if json.Valid([]byte(text)) && strings.HasPrefix(text, `"`) {
+		return text
+	}
+
+	plain := text != "" && strings.TrimSpace(text) == text &&
+		strings.IndexByte("-?:,[]{}#&*!|>'\"%@`", text[0]) < 0 &&
+		!strings.Contains(text, ": ") && !strings.Contains(text, " #") &&
+		!strings.HasSuffix(text, ":") && !strings.ContainsAny(text, "\n\r\t")
+
+	// a minus sign begins a plain scalar, i.e. -1, unless it is a sequence item.
+	if !plain && len(text) > 1 && text[0] == '-' && text[1] != ' ' {
+		plain = !strings.Contains(text, ": ") && !strings.Contains(text, " #") && !strings.ContainsAny(text, "\n\r\t")
+	}
+
+	if plain {
+		return text
+	}
+
+	return jsonString(text)
+}
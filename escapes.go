@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// unescape interprets the escape sequences \xNN, \n, \r, \t, \0 and \\ of
+// the text for -escapes. The other backslashes are kept, so the escapes of
+// the regular expressions, i.e. \d, still work. The result must be valid
+// UTF-8 because the files are matched as text.
+func unescape(text string) (string, error) {
+	var out strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i+1 == len(text) {
+			out.WriteByte(text[i])
+			continue
+		}
+
+		switch c := text[i+1]; c {
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 't':
+			out.WriteByte('\t')
+		case '0':
+			out.WriteByte(0)
+		case '\\':
+			out.WriteByte('\\')
+		case 'x':
+			if i+4 > len(text) {
+				return "", fmt.Errorf("incomplete escape %q", text[i:])
+			}
+
+			n, err := strconv.ParseUint(text[i+2:i+4], 16, 8)
+
+			if err != nil {
+				return "", fmt.Errorf("invalid escape %q", text[i:i+4])
+			}
+
+			out.WriteByte(byte(n))
+			i += 2
+		default:
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		}
+
+		i++
+	}
+
+	if !utf8.ValidString(out.String()) {
+		return "", fmt.Errorf("%q is not valid UTF-8 once unescaped", text)
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// execCommands runs the -exec command once per modified file and then the
+// -exec-batch command once with all of them. The failures are reported as
+// errors. The files removed by a rename are not passed to the commands.
+func execCommands(modified []string) {
+	var files []string
+
+	for _, filename := range uniqueStrings(modified) {
+		if _, err := os.Lstat(filename); err == nil {
+			files = append(files, filename)
+		}
+	}
+
+	if len(files) == 0 {
+		return
+	}
+
+	if flagExec != "" {
+		for _, filename := range files {
+			if err := runCommand(flagExec, []string{filename}); err != nil {
+				reportError(filename, err)
+			}
+		}
+	}
+
+	if flagExecBatch != "" {
+		if err := runCommand(flagExecBatch, files); err != nil {
+			reportError("", err)
+		}
+	}
+}
+
+// tidyModules runs go mod tidy in the folder of every modified go.mod, so
+// go.sum refers to the new module paths. The failures are reported as errors.
+func tidyModules(modified []string) {
+	for _, filename := range uniqueStrings(modified) {
+		if filepath.Base(filename) != "go.mod" {
+			continue
+		}
+
+		cmd := exec.Command("go", "mod", "tidy")
+		cmd.Dir = filepath.Dir(filename)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			reportError(filename, fmt.Errorf("go mod tidy %s", err))
+		}
+	}
+}
+
+// runCommand executes the command with the braces replaced by the files, or
+// with the files appended if there are no braces. The output of the command
+// goes to stderr in JSON mode so it does not mix with the records.
+func runCommand(command string, files []string) error {
+	var args []string
+	var found bool
+
+	for _, arg := range strings.Fields(command) {
+		if arg == "{}" {
+			args = append(args, files...)
+			found = true
+		} else {
+			args = append(args, arg)
+		}
+	}
+
+	if !found {
+		args = append(args, files...)
+	}
+
+	var stdout io.Writer = os.Stdout
+
+	if flagJSON {
+		stdout = os.Stderr
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("exec %s %s", args[0], err)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "github.com/cixtor/refactor/engine"
+
+// The exit status tells the scripts what happened during the execution.
+const (
+	// exitMatches means there were matches and, with -x, they were replaced.
+	exitMatches = 0
+	// exitNoMatches means nothing matched the rules, like grep(1).
+	exitNoMatches = 1
+	// exitUsage means the flags or the rules are invalid.
+	exitUsage = 2
+	// exitFailure means some files could not be read or written, or nothing
+	// was written because -max-changes or -max-occurrences was exceeded.
+	exitFailure = 3
+)
+
+// The exit status of -check, where the matches fail the hook.
+const (
+	// exitClean means none of the forbidden patterns were found.
+	exitClean = 0
+	// exitViolations means some forbidden patterns were found.
+	exitViolations = 1
+)
+
+// exitStatus returns the exit status for the outcome of the execution. The
+// renamed files count as matches.
+func exitStatus(failed bool, stats engine.Stats, renamed int) int {
+	if failed {
+		return exitFailure
+	}
+
+	if stats.FilesMatched == 0 && renamed == 0 {
+		return exitNoMatches
+	}
+
+	return exitMatches
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// readFileList reads a list of paths, one per line or separated by NUL bytes,
+// from a file or, if the name is "-", from the standard input. Empty entries
+// are ignored.
+func readFileList(name string, nul bool) ([]string, error) {
+	var r io.Reader = os.Stdin
+
+	if name != "-" {
+		file, err := os.Open(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer file.Close()
+
+		r = file
+	}
+
+	var delim byte = '\n'
+
+	if nul {
+		delim = 0
+	}
+
+	var files []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, delim); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	for scanner.Scan() {
+		entry := scanner.Text()
+
+		if !nul {
+			entry = strings.TrimSuffix(entry, "\r")
+		}
+
+		if entry != "" {
+			files = append(files, entry)
+		}
+	}
+
+	return files, scanner.Err()
+}
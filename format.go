@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// formatters returns the commands executed on every modified file, according
+// to the -format, -post-cmd and -post-cmd-ext flags.
+func formatters() []engine.Formatter {
+	var list []engine.Formatter
+
+	if flagFormat {
+		list = append(list, engine.GoFormatter())
+	}
+
+	if flagPostCmd != "" {
+		f := engine.Formatter{Command: flagPostCmd}
+
+		for _, ext := range strings.Split(flagPostCmdExt, ",") {
+			if ext = strings.TrimSpace(ext); ext != "" {
+				f.Extensions = append(f.Extensions, ext)
+			}
+		}
+
+		list = append(list, f)
+	}
+
+	return list
+}
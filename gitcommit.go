@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// commitFiles creates a git commit with exactly the specified files, leaving
+// any other change in the working tree or the index untouched. If the branch
+// is not empty, it is created from the current HEAD before the commit.
+func commitFiles(files []string, message string, branch string) (string, error) {
+	var pathspec, existing bytes.Buffer
+
+	for _, filename := range files {
+		pathspec.WriteString(filename)
+		pathspec.WriteByte(0)
+
+		// the files moved by git mv are already removed from the index.
+		if _, err := os.Lstat(filename); err == nil {
+			existing.WriteString(filename)
+			existing.WriteByte(0)
+		}
+	}
+
+	if branch != "" {
+		if err := runGit(nil, "checkout", "-q", "-b", branch); err != nil {
+			return "", err
+		}
+	}
+
+	// the list of files is passed through stdin because it can be too long
+	// for the command line.
+	if err := runGit(existing.Bytes(), "add", "--pathspec-from-file=-", "--pathspec-file-nul"); err != nil {
+		return "", err
+	}
+
+	if err := runGit(pathspec.Bytes(), "commit", "-q", "-m", message, "--pathspec-from-file=-", "--pathspec-file-nul"); err != nil {
+		return "", err
+	}
+
+	out, err := exec.Command("git", "rev-parse", "--short", "HEAD").Output()
+
+	return strings.TrimSpace(string(out)), err
+}
+
+// branchExists reports whether the git branch already exists, so the error
+// can be reported before any file is modified.
+func branchExists(branch string) bool {
+	return runGit(nil, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch) == nil
+}
+
+// runGit executes the git command with the data as its standard input. The
+// error includes the message printed by git, if any.
+func runGit(stdin []byte, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s %s", args[0], strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
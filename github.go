@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// githubEscape escapes the data of a workflow command for GitHub Actions.
+var githubEscape = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+// githubPropertyEscape escapes the values of the properties of a workflow
+// command, which are also delimited by commas and colons.
+var githubPropertyEscape = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+
+// printGitHubFinding writes the finding as a warning annotation of GitHub
+// Actions, which is displayed in the diff of the pull request.
+func printGitHubFinding(filename string, item engine.Finding, rs engine.RuleSet) {
+	var column int
+	var messages []string
+
+	if len(item.Positions) > 0 {
+		column = item.Positions[0].Column
+	}
+
+	text := []byte(item.OriginalText)
+	level := "notice"
+
+	for _, m := range item.Matches(rs) {
+		messages = append(messages, replacementMessage(text, m))
+
+		// the annotation has the highest severity of the occurrences.
+		if m.Rule.Severity == engine.SeverityError {
+			level = "error"
+		} else if level == "notice" && m.Rule.Severity != engine.SeverityInfo {
+			level = "warning"
+		}
+	}
+
+	if len(messages) == 0 {
+		level = "warning"
+	}
+
+	if len(messages) == 0 {
+		messages = append(messages, fmt.Sprintf("%q should be replaced with %q", rs[0].Search, rs[0].Replace))
+	}
+
+	fmt.Printf(
+		"::%s file=%s,line=%d,endLine=%d,col=%d,title=refactor::%s\n",
+		level,
+		githubPropertyEscape.Replace(sarifURI(filename)),
+		item.LineNumber,
+		item.EndLine,
+		column,
+		githubEscape.Replace(strings.Join(uniqueStrings(messages), "\n")),
+	)
+}
+
+// printGitHubError writes the error as an error annotation.
+func printGitHubError(item fileError) {
+	if item.Filename == "" {
+		fmt.Printf("::error title=refactor::%s\n", githubEscape.Replace(item.Err.Error()))
+		return
+	}
+
+	fmt.Printf("::error file=%s,title=refactor::%s\n", githubPropertyEscape.Replace(sarifURI(item.Filename)), githubEscape.Replace(item.Err.Error()))
+}
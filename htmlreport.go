@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// reportFile is one file of the HTML report.
+type reportFile struct {
+	Filename    string
+	Modified    bool
+	Occurrences int
+	Findings    []reportFinding
+}
+
+// reportFinding is one finding of the HTML report, with the occurrences of
+// the old text and the replacements already marked up.
+type reportFinding struct {
+	Lines  string
+	Before template.HTML
+	After  template.HTML
+}
+
+// reportFiles collects the files with findings for -report.
+var reportFiles []reportFile
+
+// collectReport records the findings of the file for the HTML report.
+func collectReport(res engine.SearchResult, modified bool) {
+	if flagReport == "" || len(res.Findings) == 0 {
+		return
+	}
+
+	file := reportFile{Filename: res.Filename, Modified: modified}
+
+	for _, item := range res.Findings {
+		text := item.OriginalText
+		matches := item.Matches(res.Rules)
+		file.Occurrences += item.Occurrences
+		file.Findings = append(file.Findings, reportFinding{
+			Lines: item.Lines(),
+			Before: markChanges(text, matches, func(m engine.RuleMatch) string {
+				return "<del>" + html.EscapeString(text[m.Loc[0]:m.Loc[1]]) + "</del>"
+			}),
+			After: markChanges(text, matches, func(m engine.RuleMatch) string {
+				return "<ins>" + html.EscapeString(string(m.Rule.Expand([]byte(text), m.Loc))) + "</ins>"
+			}),
+		})
+	}
+
+	reportFiles = append(reportFiles, file)
+}
+
+// markChanges escapes the text and replaces every match with the markup
+// returned by the callback function.
+func markChanges(text string, matches []engine.RuleMatch, fn func(m engine.RuleMatch) string) template.HTML {
+	var last int
+	var out strings.Builder
+
+	for _, m := range matches {
+		out.WriteString(html.EscapeString(text[last:m.Loc[0]]))
+		out.WriteString(fn(m))
+		last = m.Loc[1]
+	}
+
+	out.WriteString(html.EscapeString(text[last:]))
+
+	return template.HTML(out.String())
+}
+
+// writeReport renders the collected files as a self-contained HTML page.
+func writeReport(filename string, stats engine.Stats, elapsed time.Duration) error {
+	sort.Slice(reportFiles, func(i, j int) bool {
+		return reportFiles[i].Filename < reportFiles[j].Filename
+	})
+
+	out, err := os.Create(filename)
+
+	if err != nil {
+		return fmt.Errorf("os.Create %s %s", filename, err)
+	}
+
+	err = reportTemplate.Execute(out, struct {
+		Command string
+		Applied bool
+		Stats   engine.Stats
+		Errors  int
+		Elapsed time.Duration
+		Files   []reportFile
+	}{
+		Command: strings.Join(os.Args, " "),
+		Applied: flagCommitChanges,
+		Stats:   stats,
+		Errors:  len(failures),
+		Elapsed: elapsed.Round(time.Millisecond),
+		Files:   reportFiles,
+	})
+
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("template.Execute %s %s", filename, err)
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("os.Close %s %s", filename, err)
+	}
+
+	return nil
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>refactor report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #1f2328; }
+code, pre { font-family: ui-monospace, Menlo, Consolas, monospace; font-size: 13px; }
+table.summary td { padding: 2px 12px 2px 0; }
+details { border: 1px solid #d0d7de; border-radius: 6px; margin: 8px 0; }
+summary { cursor: pointer; padding: 8px 12px; background: #f6f8fa; }
+summary .count { color: #59636e; }
+.modified { color: #1a7f37; font-weight: bold; }
+table.diff { border-collapse: collapse; width: 100%; }
+table.diff td { vertical-align: top; padding: 0 8px; }
+table.diff td.line { color: #59636e; text-align: right; white-space: nowrap; }
+table.diff pre { margin: 0; white-space: pre-wrap; }
+tr.before { background: #ffebe9; }
+tr.after { background: #dafbe1; }
+del { background: #ff818266; text-decoration: line-through; }
+ins { background: #4fc96b66; text-decoration: none; }
+</style>
+</head>
+<body>
+<h1>refactor report</h1>
+<p><code>{{.Command}}</code></p>
+<table class="summary">
+<tr><td>Mode</td><td>{{if .Applied}}replaced{{else}}preview{{end}}</td></tr>
+<tr><td>Files scanned</td><td>{{.Stats.FilesScanned}}</td></tr>
+<tr><td>Files skipped</td><td>{{.Stats.FilesSkipped}}</td></tr>
+<tr><td>Files matched</td><td>{{.Stats.FilesMatched}}</td></tr>
+<tr><td>Files modified</td><td>{{.Stats.FilesModified}}</td></tr>
+<tr><td>Findings</td><td>{{.Stats.Findings}}</td></tr>
+<tr><td>Occurrences</td><td>{{.Stats.Occurrences}}</td></tr>
+<tr><td>Errors</td><td>{{.Errors}}</td></tr>
+<tr><td>Elapsed</td><td>{{.Elapsed}}</td></tr>
+</table>
+{{range .Files}}
+<details>
+<summary><code>{{.Filename}}</code> <span class="count">{{len .Findings}} finding(s), {{.Occurrences}} occurrence(s)</span>{{if .Modified}} <span class="modified">modified</span>{{end}}</summary>
+<table class="diff">
+{{range .Findings}}<tr class="before"><td class="line">{{.Lines}}</td><td>-</td><td><pre>{{.Before}}</pre></td></tr>
+<tr class="after"><td class="line"></td><td>+</td><td><pre>{{.After}}</pre></td></tr>
+{{end}}</table>
+</details>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// searchImage searches the layers of the container image, which is a file
+// written by docker save or a reference exported with docker save, pulling the
+// image first if it is not available locally.
+func searchImage(ctx context.Context, e *engine.Engine, ref string, fn func(engine.SearchResult)) error {
+	if fi, err := os.Stat(ref); err == nil && fi.Mode().IsRegular() {
+		file, err := os.Open(ref)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		return e.SearchImage(ctx, file, fn)
+	}
+
+	// docker would read it as an option.
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("%q is not a valid image reference", ref)
+	}
+
+	file, err := os.CreateTemp("", "refactor-image-*.tar")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := exec.CommandContext(ctx, "docker", "image", "inspect", "--", ref).Run(); err != nil {
+		pull := exec.CommandContext(ctx, "docker", "pull", "--", ref)
+		pull.Stdout = os.Stderr
+		pull.Stderr = os.Stderr
+
+		if err := pull.Run(); err != nil {
+			return fmt.Errorf("docker pull %s %s", ref, err)
+		}
+	}
+
+	save := exec.CommandContext(ctx, "docker", "save", "--", ref)
+	save.Stdout = file
+	save.Stderr = os.Stderr
+
+	if err := save.Run(); err != nil {
+		return fmt.Errorf("docker save %s %s", ref, err)
+	}
+
+	return e.SearchImage(ctx, file, fn)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// contextLines is the number of lines printed around every finding when the
+// user is asked to confirm the replacement.
+const contextLines = 2
+
+// prompt serializes the confirmation dialogs because the files are modified by
+// multiple goroutines at the same time but there is only one terminal.
+var prompt struct {
+	sync.Mutex
+	input *bufio.Reader
+	quit  bool
+}
+
+// confirmFindings asks the user which findings must be replaced and returns
+// the line numbers that were accepted. The answers are:
+//
+//	y - replace this finding
+//	n - skip this finding
+//	a - replace this finding and all the remaining findings in the file
+//	q - skip this finding and everything else, including other files
+func confirmFindings(res engine.SearchResult, content []byte, rs engine.RuleSet) map[int]bool {
+	prompt.Lock()
+	defer prompt.Unlock()
+
+	selected := map[int]bool{}
+
+	if prompt.quit {
+		return selected
+	}
+
+	if prompt.input == nil {
+		prompt.input = bufio.NewReader(os.Stdin)
+	}
+
+	lines := strings.Split(string(bytes.TrimSuffix(content, []byte("\n"))), "\n")
+
+	for i, item := range res.Findings {
+		fmt.Println()
+
+		for row := item.LineNumber - contextLines; row < item.EndLine+contextLines+1; row++ {
+			if row < 1 || row > len(lines) {
+				continue
+			}
+
+			if row == item.LineNumber {
+				fmt.Println(formatReplacement(res.Filename, item, rs))
+				row = item.EndLine
+				continue
+			}
+
+			fmt.Printf("%s-%s-%s\n", paint("0;35", res.Filename), paint("0;32", strconv.Itoa(row)), strings.TrimSuffix(lines[row-1], "\r"))
+		}
+
+		switch askUser("Replace this occurrence? [y,n,a,q] ") {
+		case "y":
+			selected[item.LineNumber] = true
+		case "a":
+			for _, rest := range res.Findings[i:] {
+				selected[rest.LineNumber] = true
+			}
+			return selected
+		case "q":
+			prompt.quit = true
+			return selected
+		}
+	}
+
+	return selected
+}
+
+// askUser prints the question and reads answers until a valid one is found.
+// An unreadable input, i.e. the end of the stream, is treated as a request to
+// quit the program so nothing else is modified without confirmation.
+func askUser(question string) string {
+	for {
+		fmt.Print(question)
+
+		answer, err := prompt.input.ReadString('\n')
+
+		if err != nil && answer == "" {
+			fmt.Println()
+			return "q"
+		}
+
+		switch answer = strings.ToLower(strings.TrimSpace(answer)); answer {
+		case "y", "n", "a", "q":
+			return answer
+		}
+	}
+}
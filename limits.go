@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// errLimitExceeded is returned by applyWithLimits when the changes exceed
+// -max-changes or -max-occurrences and nothing was modified.
+var errLimitExceeded = errors.New("nothing was modified")
+
+// applyWithLimits searches all the files first and only modifies them if the
+// number of files and occurrences are within -max-changes and -max-occurrences.
+// Otherwise, it prints what would have been modified and returns an error
+// wrapping errLimitExceeded without writing anything.
+func applyWithLimits(ctx context.Context, e *engine.Engine, fn func(engine.SearchResult)) error {
+	results, err := e.Search(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	files, occurrences := countChanges(results)
+	limit := exceededLimit(files, occurrences, flagMaxChanges, flagMaxOccurrences)
+
+	if limit != "" {
+		for _, res := range results {
+			printThisFile(res)
+		}
+
+		return fmt.Errorf("aborted: %d file(s) and %d occurrence(s) would be modified, more than %s; %w", files, occurrences, limit, errLimitExceeded)
+	}
+
+	for _, res := range results {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if res.Err == nil && len(res.Findings) > 0 {
+			res.Err = e.ApplyFile(&res, nil)
+		}
+
+		fn(res)
+	}
+
+	return ctx.Err()
+}
+
+// countChanges returns the number of files and occurrences that the results
+// would modify.
+func countChanges(results []engine.SearchResult) (int, int) {
+	var files, occurrences int
+
+	for _, res := range results {
+		if res.Err != nil || len(res.Findings) == 0 {
+			continue
+		}
+
+		files++
+
+		for _, item := range res.Findings {
+			occurrences += item.Occurrences
+		}
+	}
+
+	return files, occurrences
+}
+
+// exceededLimit returns the flag of the limit exceeded by the changes, if
+// any; the limits are disabled with zero.
+func exceededLimit(files int, occurrences int, maxChanges int, maxOccurrences int) string {
+	if maxChanges > 0 && files > maxChanges {
+		return fmt.Sprintf("-max-changes %d", maxChanges)
+	}
+
+	if maxOccurrences > 0 && occurrences > maxOccurrences {
+		return fmt.Sprintf("-max-occurrences %d", maxOccurrences)
+	}
+
+	return ""
+}
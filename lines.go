@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// flagLineRanges collects the ranges of -lines and -range.
+var flagLineRanges []engine.LineRange
+
+// lineRanges is a repeatable flag with a range of lines, "100-250", or, if
+// file is true, a file and a range of its lines, "file.go:100-250".
+type lineRanges struct {
+	list *[]engine.LineRange
+	file bool
+}
+
+func (r lineRanges) String() string {
+	if r.list == nil {
+		return ""
+	}
+
+	var ranges []string
+
+	for _, lr := range *r.list {
+		if (lr.Filename != "") == r.file {
+			ranges = append(ranges, lr.String())
+		}
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+func (r lineRanges) Set(value string) error {
+	var lr engine.LineRange
+	var err error
+
+	text := value
+
+	if r.file {
+		i := strings.LastIndexByte(value, ':')
+
+		if i <= 0 {
+			return fmt.Errorf("invalid range %q, use FILE:FIRST-LAST", value)
+		}
+
+		lr.Filename, text = value[:i], value[i+1:]
+	}
+
+	if lr.First, lr.Last, err = parseLineRange(text); err != nil {
+		return err
+	}
+
+	*r.list = append(*r.list, lr)
+
+	return nil
+}
+
+// parseLineRange parses "FIRST-LAST", "FIRST-" until the end of the file, or
+// one single line number.
+func parseLineRange(text string) (int, int, error) {
+	first, last := text, text
+
+	if i := strings.IndexByte(text, '-'); i >= 0 {
+		first, last = text[:i], text[i+1:]
+	}
+
+	a, err := strconv.Atoi(strings.TrimSpace(first))
+
+	if err != nil || a < 1 {
+		return 0, 0, fmt.Errorf("invalid line range %q", text)
+	}
+
+	if strings.TrimSpace(last) == "" {
+		return a, 0, nil
+	}
+
+	b, err := strconv.Atoi(strings.TrimSpace(last))
+
+	if err != nil || b < a {
+		return 0, 0, fmt.Errorf("invalid line range %q", text)
+	}
+
+	return a, b, nil
+}
+
+// rangedFiles returns the files of the -range flags.
+func rangedFiles() []string {
+	var files []string
+
+	for _, lr := range flagLineRanges {
+		if lr.Filename != "" {
+			files = append(files, lr.Filename)
+		}
+	}
+
+	return uniqueStrings(files)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// lspRenameCommand renames the symbol at a position with a language server,
+// printing the changes as a unified diff, or applying them with -x.
+func lspRenameCommand(args []string) {
+	fs := flag.NewFlagSet("lsp-rename", flag.ExitOnError)
+	server := fs.String("server", "", "Command of the language server (default gopls, pylsp, typescript-language-server or bash-language-server)")
+	execute := fs.Bool("x", false, "Execute the rename (default is preview-only)")
+	timeout := fs.Duration("timeout", time.Minute, "Stop waiting for the language server after the duration")
+
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage:\n  refactor lsp-rename [-x] [-server CMD] FILE:LINE:COLUMN NEWNAME")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	filename, line, column, err := parsePosition(fs.Arg(0))
+
+	if err != nil {
+		fmt.Println("lsp-rename:", err)
+		os.Exit(exitUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	edits, err := engine.LSPRename(ctx, strings.Fields(*server), filename, line, column, fs.Arg(1))
+
+	if err != nil {
+		fmt.Println("lsp-rename:", err)
+		os.Exit(exitFailure)
+	}
+
+	if !*execute {
+		for _, edit := range edits {
+			os.Stdout.Write(engine.UnifiedDiff(edit.Filename, edit.Original, edit.Modified))
+		}
+
+		fmt.Fprintf(os.Stderr, "%d file(s) to modify, use -x to apply\n", len(edits))
+		return
+	}
+
+	var failed int
+
+	for _, res := range engine.ApplyFileEdits(edits, engine.DefaultJournalDir) {
+		if res.Err != nil {
+			fmt.Println("skip", res.Filename, res.Err)
+			failed++
+			continue
+		}
+
+		fmt.Println("modified", res.Filename)
+	}
+
+	if failed > 0 {
+		fmt.Printf("lsp-rename: %d file(s) could not be modified\n", failed)
+		os.Exit(exitFailure)
+	}
+}
+
+// parsePosition splits FILE:LINE:COLUMN, where the name can contain colons.
+func parsePosition(text string) (string, int, int, error) {
+	parts := strings.Split(text, ":")
+
+	if len(parts) < 3 {
+		return "", 0, 0, fmt.Errorf("%q is not FILE:LINE:COLUMN", text)
+	}
+
+	line, err := strconv.Atoi(parts[len(parts)-2])
+
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("%q is not FILE:LINE:COLUMN", text)
+	}
+
+	column, err := strconv.Atoi(parts[len(parts)-1])
+
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("%q is not FILE:LINE:COLUMN", text)
+	}
+
+	return strings.Join(parts[:len(parts)-2], ":"), line, column, nil
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// mcpProtocolVersion is the revision of the Model Context Protocol spoken by
+// the server.
+const mcpProtocolVersion = "2024-11-05"
+
+// The error codes of JSON-RPC.
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+// mcpSearchSchema is the JSON schema of the arguments of the search, preview
+// and apply tools, see searchRequest.
+const mcpSearchSchema = `{
+	"type": "object",
+	"properties": {
+		"paths": {"type": "array", "items": {"type": "string"}, "description": "Files and folders to search, relative to the working directory of the server; defaults to the working directory"},
+		"rules": {"type": "array", "items": {"type": "object", "properties": {"search": {"type": "string"}, "replace": {"type": "string"}, "deny": {"type": "boolean", "description": "Only report the occurrences, with the message"}, "message": {"type": "string"}, "severity": {"enum": ["error", "warning", "info"]}}, "required": ["search"]}, "description": "Texts to find and their replacements"},
+		"regexp": {"type": "boolean", "description": "Treat the search texts as regular expressions"},
+		"ignore_case": {"type": "boolean"},
+		"whole_word": {"type": "boolean"},
+		"preserve_case": {"type": "boolean", "description": "Keep the case of every occurrence in its replacement"},
+		"include": {"type": "array", "items": {"type": "string"}, "description": "Only process the files matching these globs"},
+		"exclude": {"type": "array", "items": {"type": "string"}, "description": "Skip the files matching these globs"}
+	},
+	"required": ["rules"]
+}`
+
+// mcpTools are the tools offered by the server.
+var mcpTools = []mcpTool{
+	{
+		Name:        "search",
+		Description: "Find the occurrences of the rules without modifying any file. Returns the findings, with the line of every occurrence before and after the replacement.",
+		InputSchema: json.RawMessage(mcpSearchSchema),
+	},
+	{
+		Name:        "preview",
+		Description: "Return the unified diff that apply would write, without modifying any file.",
+		InputSchema: json.RawMessage(mcpSearchSchema),
+	},
+	{
+		Name:        "apply",
+		Description: "Replace the occurrences of the rules in the files. Nothing is modified if the changes exceed the limits of the server. The original files are recorded, so the changes can be reverted with undo.",
+		InputSchema: json.RawMessage(mcpSearchSchema),
+	},
+	{
+		Name:        "undo",
+		Description: "Revert the files modified by the most recent apply, or the most recent execution of refactor. The files modified since then are skipped unless force is true.",
+		InputSchema: json.RawMessage(`{"type": "object", "properties": {"force": {"type": "boolean"}}}`),
+	},
+}
+
+// mcpMessage is a request, a notification or a response of JSON-RPC.
+type mcpMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *mcpError       `json:"error,omitempty"`
+}
+
+// mcpError is the error of a failed request.
+type mcpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpTool describes a tool in the response of tools/list.
+type mcpTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+// mcpContent is a block of the result of a tool.
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mcpToolResult is the result of tools/call. The failures of the tools are
+// results too, so the agent can read them.
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+// mcpSearchResult is the text of the results of the search and apply tools.
+type mcpSearchResult struct {
+	Findings []JSONFinding `json:"findings"`
+	Stats    engine.Stats  `json:"stats"`
+	Errors   []string      `json:"errors,omitempty"`
+}
+
+// mcpServer runs the tools for a client connected to the standard input and
+// output.
+type mcpServer struct {
+	// root is the folder where the paths of the tools must be.
+	root           string
+	maxChanges     int
+	maxOccurrences int
+}
+
+// mcpCommand runs the Model Context Protocol server over the standard input
+// and output, so coding agents can search, preview and apply replacements
+// with the same journal and limits as the command line.
+func mcpCommand(args []string) {
+	fs := flag.NewFlagSet("mcp", flag.ExitOnError)
+	maxChanges := fs.Int("max-changes", 0, "Make apply modify nothing if more than N files would be modified")
+	maxOccurrences := fs.Int("max-occurrences", 0, "Make apply modify nothing if more than N occurrences would be replaced")
+
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage:\n  refactor mcp [-max-changes N] [-max-occurrences N]")
+		fmt.Fprintln(os.Stderr, `
+The tools search, preview, apply and undo work on the files inside the
+working directory; every apply can be reverted with undo.
+
+flags:`)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	root, err := os.Getwd()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcp:", err)
+		os.Exit(exitFailure)
+	}
+
+	s := &mcpServer{root: root, maxChanges: *maxChanges, maxOccurrences: *maxOccurrences}
+
+	if err := s.serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "mcp:", err)
+		os.Exit(exitFailure)
+	}
+}
+
+// serve answers the requests, one JSON-RPC message per line, until the end
+// of the input.
+func (s *mcpServer) serve(r io.Reader, w io.Writer) error {
+	in := bufio.NewReader(r)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	for {
+		line, err := in.ReadBytes('\n')
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			if res := s.handle(line); res != nil {
+				if err := enc.Encode(res); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle returns the response to the message, or nil for the notifications.
+func (s *mcpServer) handle(line []byte) *mcpMessage {
+	var req mcpMessage
+
+	if err := json.Unmarshal(line, &req); err != nil {
+		return &mcpMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &mcpError{Code: rpcParseError, Message: err.Error()}}
+	}
+
+	if len(req.ID) == 0 {
+		// notifications/initialized and notifications/cancelled need no
+		// answer, the tools run one at a time.
+		return nil
+	}
+
+	res := &mcpMessage{JSONRPC: "2.0", ID: req.ID}
+
+	switch req.Method {
+	case "initialize":
+		res.Result = map[string]interface{}{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "refactor", "version": "1"},
+		}
+	case "ping":
+		res.Result = map[string]interface{}{}
+	case "tools/list":
+		res.Result = map[string]interface{}{"tools": mcpTools}
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			res.Error = &mcpError{Code: rpcInvalidParams, Message: err.Error()}
+			break
+		}
+
+		result, err := s.call(params.Name, params.Arguments)
+
+		if err != nil {
+			res.Error = &mcpError{Code: rpcInvalidParams, Message: err.Error()}
+			break
+		}
+
+		res.Result = result
+	default:
+		res.Error = &mcpError{Code: rpcMethodNotFound, Message: "unknown method " + req.Method}
+	}
+
+	return res
+}
+
+// call runs the tool. The error is returned for unknown tools and invalid
+// arguments only.
+func (s *mcpServer) call(name string, arguments json.RawMessage) (mcpToolResult, error) {
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+
+	if name == "undo" {
+		var args struct {
+			Force bool `json:"force"`
+		}
+
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return mcpToolResult{}, err
+		}
+
+		return s.undo(args.Force), nil
+	}
+
+	var req searchRequest
+
+	if err := json.Unmarshal(arguments, &req); err != nil {
+		return mcpToolResult{}, err
+	}
+
+	switch name {
+	case "search", "preview", "apply":
+	default:
+		return mcpToolResult{}, fmt.Errorf("unknown tool %s", name)
+	}
+
+	e, err := req.engine(s.root)
+
+	if err != nil {
+		return toolError(err.Error()), nil
+	}
+
+	defer e.Close()
+
+	results, err := e.Search(context.Background())
+
+	if err != nil {
+		return toolError(err.Error()), nil
+	}
+
+	switch name {
+	case "preview":
+		return s.preview(e, results), nil
+	case "apply":
+		return s.apply(e, results), nil
+	}
+
+	return toolJSON(mcpSearchResult{Findings: jsonFindings(results), Stats: e.Stats(), Errors: resultErrors(results)}), nil
+}
+
+// preview returns the unified diff of the results.
+func (s *mcpServer) preview(e *engine.Engine, results []engine.SearchResult) mcpToolResult {
+	var patch bytes.Buffer
+
+	errors := resultErrors(results)
+
+	for _, res := range results {
+		if res.Err != nil || res.Skipped != "" || len(res.Findings) == 0 {
+			continue
+		}
+
+		original, modified, err := e.Preview(res, nil)
+
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s %s", res.Filename, err))
+			continue
+		}
+
+		patch.Write(engine.UnifiedDiff(res.Filename, original, modified))
+	}
+
+	if patch.Len() == 0 {
+		patch.WriteString("no changes\n")
+	}
+
+	result := mcpToolResult{Content: []mcpContent{{Type: "text", Text: patch.String()}}}
+
+	if len(errors) > 0 {
+		result.Content = append(result.Content, mcpContent{Type: "text", Text: strings.Join(errors, "\n")})
+	}
+
+	return result
+}
+
+// apply replaces the findings of the results, unless they exceed the limits
+// of the server.
+func (s *mcpServer) apply(e *engine.Engine, results []engine.SearchResult) mcpToolResult {
+	files, occurrences := countChanges(results)
+
+	if limit := exceededLimit(files, occurrences, s.maxChanges, s.maxOccurrences); limit != "" {
+		return toolError(fmt.Sprintf("aborted: %d file(s) and %d occurrence(s) would be modified, more than %s; nothing was modified", files, occurrences, limit))
+	}
+
+	for i := range results {
+		if results[i].Err == nil && results[i].Skipped == "" && len(results[i].Findings) > 0 {
+			results[i].Err = e.ApplyFile(&results[i], nil)
+		}
+	}
+
+	return toolJSON(mcpSearchResult{Findings: jsonFindings(results), Stats: e.Stats(), Errors: resultErrors(results)})
+}
+
+// undo reverts the files modified by the most recent execution.
+func (s *mcpServer) undo(force bool) mcpToolResult {
+	var lines []string
+
+	results, err := engine.Undo(engine.DefaultJournalDir, force)
+	failed := err != nil
+
+	for _, res := range results {
+		switch {
+		case res.Err == engine.ErrModified:
+			lines = append(lines, "skip "+res.Filename+" (modified after the replacement, use force to override)")
+			failed = true
+		case res.Err != nil:
+			lines = append(lines, res.Err.Error())
+			failed = true
+		default:
+			lines = append(lines, "restored "+res.Filename)
+		}
+	}
+
+	if err != nil {
+		lines = append(lines, "undo: "+err.Error())
+	}
+
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: strings.Join(lines, "\n")}}, IsError: failed}
+}
+
+// resultErrors returns the errors and the skipped files of the results.
+func resultErrors(results []engine.SearchResult) []string {
+	var errors []string
+
+	for _, res := range results {
+		if res.Skipped != "" {
+			errors = append(errors, fmt.Sprintf("skip %s (%s)", res.Filename, res.Skipped))
+		}
+
+		if res.Err != nil {
+			errors = append(errors, res.Err.Error())
+		}
+	}
+
+	return errors
+}
+
+// toolJSON returns the value as the text of the result of a tool.
+func toolJSON(v interface{}) mcpToolResult {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return toolError("json.Encode " + err.Error())
+	}
+
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: buf.String()}}}
+}
+
+// toolError returns the failure of a tool.
+func toolError(message string) mcpToolResult {
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: message}}, IsError: true}
+}
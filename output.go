@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// setupOutputFormat validates the value of -output-format. Only the text
+// format can be combined with the interactive modes.
+func setupOutputFormat(format string) error {
+	if flagSuggest && (flagJSON || (format != "text" && format != "rdjsonl")) {
+		return fmt.Errorf("-suggest cannot be combined with -json or another -output-format")
+	}
+
+	switch format {
+	case "text":
+		if flagSuggest {
+			format, flagOutputFormat = "rdjsonl", "rdjsonl"
+			break
+		}
+		if flagJSON {
+			flagOutputFormat = "json"
+		}
+		return nil
+	case "json":
+		flagJSON = true
+	case "sarif", "github", "vimgrep":
+	case "rdjsonl":
+		flagSuggest = true
+	default:
+		return fmt.Errorf("unsupported output format %q, use text, json, sarif, github, vimgrep or rdjsonl", format)
+	}
+
+	if flagInteractive || flagTUI || flagPatch != "" {
+		return fmt.Errorf("-output-format %s cannot be combined with -interactive, -tui or -patch", format)
+	}
+
+	// the suggestions are for the lines that were not replaced yet.
+	if flagSuggest && flagCommitChanges {
+		return fmt.Errorf("-suggest cannot be combined with -x")
+	}
+
+	return nil
+}
+
+// jsonOutput serializes the records printed by multiple goroutines.
+var jsonOutput struct {
+	sync.Mutex
+	enc *json.Encoder
+}
+
+// JSONFinding is the machine-readable representation of one finding.
+type JSONFinding struct {
+	Type        string `json:"type"`
+	File        string `json:"file"`
+	Line        int    `json:"line"`
+	EndLine     int    `json:"end_line"`
+	Column      int    `json:"column"`
+	Occurrences int    `json:"occurrences"`
+	Before      string `json:"before"`
+	After       string `json:"after"`
+	Applied     bool   `json:"applied"`
+	// Positions is the location of every occurrence of the finding.
+	Positions []engine.Position `json:"positions"`
+	// Messages are the messages of the deny rules matching in the finding.
+	Messages []JSONMessage `json:"messages,omitempty"`
+	// Owners are the owners of the file with -owner and -group-by.
+	Owners []string `json:"owners,omitempty"`
+}
+
+// JSONMessage is the message of a deny rule.
+type JSONMessage struct {
+	Rule     string `json:"rule,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// JSONSummary is the machine-readable representation of the statistics
+// printed at the end of the execution.
+type JSONSummary struct {
+	Type string `json:"type"`
+	engine.Stats
+	ElapsedMS int64 `json:"elapsed_ms"`
+}
+
+// printJSON writes one record per line to the standard output.
+func printJSON(v interface{}) {
+	jsonOutput.Lock()
+	defer jsonOutput.Unlock()
+
+	if jsonOutput.enc == nil {
+		jsonOutput.enc = json.NewEncoder(os.Stdout)
+		jsonOutput.enc.SetEscapeHTML(false)
+	}
+
+	if err := jsonOutput.enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, "json.Encode", err)
+	}
+}
+
+// printJSONFinding writes the finding as a JSON record. The column is the
+// 1-based byte offset of the first occurrence in the line.
+func printJSONFinding(filename string, item engine.Finding, rs engine.RuleSet) {
+	var column int
+
+	if len(item.Positions) > 0 {
+		column = item.Positions[0].Column
+	}
+
+	printJSON(JSONFinding{
+		Type:        "finding",
+		File:        filename,
+		Line:        item.LineNumber,
+		EndLine:     item.EndLine,
+		Column:      column,
+		Occurrences: item.Occurrences,
+		Before:      item.OriginalText,
+		After:       item.Replaced(rs),
+		Applied:     item.Applied,
+		Positions:   item.Positions,
+		Messages:    denyMessages(item, rs),
+		Owners:      fileOwners(filename),
+	})
+}
+
+// denyMessages returns the messages of the deny rules matching in the
+// finding, once per rule.
+func denyMessages(item engine.Finding, rs engine.RuleSet) []JSONMessage {
+	var messages []JSONMessage
+
+	seen := map[*engine.Rule]bool{}
+
+	for _, m := range item.Matches(rs) {
+		if !m.Rule.Deny || seen[m.Rule] {
+			continue
+		}
+
+		seen[m.Rule] = true
+		messages = append(messages, JSONMessage{Rule: m.Rule.Name, Severity: m.Rule.Severity, Message: ruleMessage(m.Rule)})
+	}
+
+	return messages
+}
+
+// ruleMessage returns the message of the deny rule, its description if it has
+// none, or the pattern.
+func ruleMessage(rule *engine.Rule) string {
+	if rule.Message != "" {
+		return rule.Message
+	}
+
+	if rule.Description != "" {
+		return rule.Description
+	}
+
+	return fmt.Sprintf("%q is denied", rule.Search)
+}
+
+// formatMessages renders the messages of the deny rules after a finding.
+func formatMessages(item engine.Finding, rs engine.RuleSet) string {
+	var text string
+
+	for _, msg := range denyMessages(item, rs) {
+		note := msg.Severity + ": " + msg.Message
+
+		if msg.Rule != "" {
+			note += " (" + msg.Rule + ")"
+		}
+
+		text += "  " + paint(severityColor(msg.Severity), "# "+note)
+	}
+
+	return text
+}
+
+// severityColor returns the color of the messages of the severity.
+func severityColor(severity string) string {
+	switch severity {
+	case engine.SeverityError:
+		return "0;31"
+	case engine.SeverityWarning:
+		return "0;33"
+	}
+
+	return "0;36"
+}
+
+// printVimgrepFinding writes one file:line:col:text line per occurrence,
+// without colors, in the format of grep -n and the quickfix list of vim. The
+// text is the line where the occurrence starts, or the message of the deny
+// rule.
+func printVimgrepFinding(filename string, item engine.Finding, rs engine.RuleSet) {
+	lines := strings.Split(strings.TrimSuffix(item.OriginalText, "\n"), "\n")
+	matches := item.Matches(rs)
+
+	for k, pos := range item.Positions {
+		var text string
+
+		if i := pos.Line - item.LineNumber; i >= 0 && i < len(lines) {
+			text = strings.TrimSuffix(lines[i], "\r")
+		}
+
+		// the message of a deny rule is more useful in the quickfix list.
+		if len(matches) == len(item.Positions) && matches[k].Rule.Deny {
+			text = matches[k].Rule.Severity + ": " + ruleMessage(matches[k].Rule)
+		}
+
+		fmt.Printf("%s:%d:%d:%s\n", filename, pos.Line, pos.Column, text)
+	}
+}
+
+// printJSONSummary writes the statistics of the execution as a JSON record.
+func printJSONSummary(stats engine.Stats, elapsed time.Duration) {
+	printJSON(JSONSummary{
+		Type:      "summary",
+		Stats:     stats,
+		ElapsedMS: elapsed.Milliseconds(),
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// unowned is the group of the files without owners in -group-by owner.
+const unowned = "(unowned)"
+
+// codeOwners is the CODEOWNERS file of the repository, loaded for -owner and
+// -group-by owner, or nil.
+var codeOwners *engine.CodeOwners
+
+// setupOwners validates -owner and -group-by, and loads the CODEOWNERS file
+// of the repository if they are used.
+func setupOwners() error {
+	if flagGroupBy != "" && flagGroupBy != "owner" {
+		return fmt.Errorf("unsupported -group-by %q, use owner", flagGroupBy)
+	}
+
+	if flagGroupBy != "" && (flagCommitChanges || flagInteractive || flagTUI || flagPatch != "" || flagWatch || flagRename || flagCheck) {
+		return fmt.Errorf("-group-by cannot be combined with -x, -interactive, -tui, -patch, -watch, -rename or -check")
+	}
+
+	if flagGroupBy != "" && flagOutputFormat != "text" && flagOutputFormat != "json" {
+		return fmt.Errorf("-group-by cannot be combined with -output-format %s", flagOutputFormat)
+	}
+
+	if flagGroupBy == "" && len(flagOwner) == 0 {
+		return nil
+	}
+
+	owners, err := engine.FindCodeOwners(".")
+
+	if err != nil {
+		return fmt.Errorf("owners: %s", err)
+	}
+
+	codeOwners = owners
+
+	return nil
+}
+
+// fileOwners returns the owners of the file, or of the archive with the
+// member, if the CODEOWNERS file is loaded.
+func fileOwners(filename string) []string {
+	if codeOwners == nil {
+		return nil
+	}
+
+	return codeOwners.Owners(strings.SplitN(filename, engine.MemberSeparator, 2)[0])
+}
+
+// printByOwner writes the results grouped by the owners of the files, sorted
+// by name, and the files without owners at the end. Each group starts with a
+// line with the owners and the number of changes, except in JSON, where every
+// finding has the owners of its file.
+func printByOwner(results []engine.SearchResult) {
+	groups := map[string][]engine.SearchResult{}
+
+	for _, res := range results {
+		if len(res.Findings) == 0 {
+			printThisFile(res)
+			countFile(res, false)
+			continue
+		}
+
+		key := strings.Join(fileOwners(res.Filename), " ")
+
+		if key == "" {
+			key = unowned
+		}
+
+		groups[key] = append(groups[key], res)
+	}
+
+	var keys []string
+
+	for key := range groups {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if (keys[i] == unowned) != (keys[j] == unowned) {
+			return keys[j] == unowned
+		}
+		return keys[i] < keys[j]
+	})
+
+	for i, key := range keys {
+		group := groups[key]
+
+		sort.Slice(group, func(a, b int) bool {
+			return group[a].Filename < group[b].Filename
+		})
+
+		if !flagJSON && !flagQuiet {
+			if i > 0 {
+				fmt.Println()
+			}
+
+			files, occurrences := countChanges(group)
+			fmt.Printf("%s %d file(s), %d occurrence(s)\n", paint("1;34", key), files, occurrences)
+		}
+
+		for _, res := range group {
+			printThisFile(res)
+			countFile(res, false)
+		}
+	}
+}
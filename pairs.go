@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stringList is a flag that can be specified multiple times.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// splitList splits a comma-separated list, ignoring the empty items.
+func splitList(text string) []string {
+	var list []string
+
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// parsePairs splits a list of search and replace pairs with the format
+// "old1=new1,old2=new2". A backslash escapes the next character, so commas
+// and equal signs can be part of the text, i.e. "a\,b=c".
+func parsePairs(text string) ([][2]string, error) {
+	var pairs [][2]string
+	var field strings.Builder
+	var pair [2]string
+	var side int
+
+	flush := func() error {
+		pair[side] = field.String()
+		field.Reset()
+		if side == 0 {
+			return fmt.Errorf("missing '=' in pair %q", pair[0])
+		}
+		pairs = append(pairs, pair)
+		pair, side = [2]string{}, 0
+		return nil
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && i+1 < len(text):
+			i++
+			field.WriteByte(text[i])
+		case c == '=' && side == 0:
+			pair[0] = field.String()
+			field.Reset()
+			side = 1
+		case c == ',':
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return pairs, nil
+}
+
+// backupFlag is the suffix of the backup files. It can be used as a boolean
+// flag, i.e. "-backup", to select the default suffix, or with a value, i.e.
+// "-backup=.orig".
+type backupFlag string
+
+func (b *backupFlag) String() string {
+	return string(*b)
+}
+
+func (b *backupFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*b = ".bak"
+	case "false":
+		*b = ""
+	default:
+		*b = backupFlag(value)
+	}
+	return nil
+}
+
+func (b *backupFlag) IsBoolFlag() bool {
+	return true
+}
+
+// uniqueStrings removes the duplicates from the list, keeping the order.
+func uniqueStrings(list []string) []string {
+	var out []string
+
+	seen := map[string]bool{}
+
+	for _, item := range list {
+		if !seen[item] {
+			seen[item] = true
+			out = append(out, item)
+		}
+	}
+
+	return out
+}
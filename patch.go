@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// writePatch saves the proposed changes as a unified diff, without modifying
+// any file, or prints it if the name is "-". With -split-by, the changes are
+// saved in multiple numbered patch files.
+func writePatch(ctx context.Context, e *engine.Engine, filename string) error {
+	var patch bytes.Buffer
+	var files int
+
+	diffs := map[string][]byte{}
+
+	results, err := e.Search(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	for _, res := range results {
+		if res.Skipped != "" {
+			reportSkip(res.Filename, res.Skipped)
+			continue
+		}
+
+		if res.Err != nil {
+			reportError(res.Filename, res.Err)
+			continue
+		}
+
+		original, modified, err := e.Preview(res, nil)
+
+		if err != nil {
+			reportError(res.Filename, err)
+			continue
+		}
+
+		if diff := engine.UnifiedDiff(res.Filename, original, modified); len(diff) > 0 {
+			patch.Write(diff)
+			diffs[res.Filename] = diff
+			files++
+		}
+	}
+
+	if flagSplitBy.enabled() {
+		return writeChunks(filename, diffs)
+	}
+
+	if filename == "-" {
+		_, err := os.Stdout.Write(patch.Bytes())
+		return err
+	}
+
+	if err := os.WriteFile(filename, patch.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d file(s) written to %s\n", files, filename)
+
+	return nil
+}
+
+// writeChunks saves the diffs of the files in one patch file per chunk.
+func writeChunks(filename string, diffs map[string][]byte) error {
+	var names []string
+
+	for name := range diffs {
+		names = append(names, name)
+	}
+
+	chunks := flagSplitBy.split(names)
+
+	for i, c := range chunks {
+		var patch bytes.Buffer
+
+		for _, name := range c.files {
+			patch.Write(diffs[name])
+		}
+
+		name := chunkName(filename, filepath.Ext(filename), i, len(chunks))
+
+		if err := os.WriteFile(name, patch.Bytes(), 0644); err != nil {
+			return err
+		}
+
+		if c.name != "" {
+			fmt.Fprintf(os.Stderr, "%d file(s) of %s written to %s\n", len(c.files), c.name, name)
+		} else {
+			fmt.Fprintf(os.Stderr, "%d file(s) written to %s\n", len(c.files), name)
+		}
+	}
+
+	return nil
+}
+
+// applyCommand applies a patch written with -patch, or any other patch in the
+// unified format, recording the changes so they can be reverted with undo.
+func applyCommand(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage:\n  refactor apply PATCH")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var data []byte
+	var err error
+
+	if fs.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(0))
+	}
+
+	if err != nil {
+		fmt.Println("apply:", err)
+		os.Exit(exitFailure)
+	}
+
+	results, err := engine.ApplyPatch(data, engine.DefaultJournalDir)
+
+	if err != nil {
+		fmt.Println("apply:", err)
+		os.Exit(exitUsage)
+	}
+
+	var failed int
+
+	for _, res := range results {
+		if res.Err != nil {
+			fmt.Println("skip", res.Filename, res.Err)
+			failed++
+			continue
+		}
+
+		fmt.Println("patched", res.Filename)
+	}
+
+	if failed > 0 {
+		fmt.Printf("apply: %d file(s) could not be patched\n", failed)
+		os.Exit(exitFailure)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// profiling holds the files written by -cpuprofile and -trace while the
+// program runs.
+var profiling struct {
+	cpu   *os.File
+	trace *os.File
+}
+
+// startProfiling starts the CPU profile and the execution trace, if they
+// were requested.
+func startProfiling() error {
+	if flagCPUProfile != "" {
+		out, err := os.Create(flagCPUProfile)
+
+		if err != nil {
+			return fmt.Errorf("os.Create %s %s", flagCPUProfile, err)
+		}
+
+		if err := pprof.StartCPUProfile(out); err != nil {
+			out.Close()
+			return fmt.Errorf("pprof.StartCPUProfile %s", err)
+		}
+
+		profiling.cpu = out
+	}
+
+	if flagTrace != "" {
+		out, err := os.Create(flagTrace)
+
+		if err != nil {
+			return fmt.Errorf("os.Create %s %s", flagTrace, err)
+		}
+
+		if err := trace.Start(out); err != nil {
+			out.Close()
+			return fmt.Errorf("trace.Start %s", err)
+		}
+
+		profiling.trace = out
+	}
+
+	return nil
+}
+
+// stopProfiling flushes the CPU profile and the execution trace, and writes
+// the heap profile. It can be called multiple times.
+func stopProfiling() {
+	if profiling.cpu != nil {
+		pprof.StopCPUProfile()
+		profiling.cpu.Close()
+		profiling.cpu = nil
+	}
+
+	if profiling.trace != nil {
+		trace.Stop()
+		profiling.trace.Close()
+		profiling.trace = nil
+	}
+
+	if flagMemProfile != "" {
+		if err := writeHeapProfile(flagMemProfile); err != nil {
+			fmt.Fprintln(os.Stderr, "memprofile:", err)
+		}
+		flagMemProfile = ""
+	}
+}
+
+// writeHeapProfile writes the allocations of the execution after a garbage
+// collection, so the profile only includes the live objects.
+func writeHeapProfile(filename string) error {
+	out, err := os.Create(filename)
+
+	if err != nil {
+		return fmt.Errorf("os.Create %s %s", filename, err)
+	}
+
+	runtime.GC()
+
+	if err := pprof.WriteHeapProfile(out); err != nil {
+		out.Close()
+		return fmt.Errorf("pprof.WriteHeapProfile %s", err)
+	}
+
+	return out.Close()
+}
+
+// exit stops the profiles, which os.Exit would truncate, and terminates the
+// program with the status.
+func exit(code int) {
+	stopProfiling()
+	os.Exit(code)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// progressDelay is how long the execution runs before the status line is
+// displayed, so the short executions do not flicker.
+const progressDelay = time.Second
+
+// progressInterval is how often the status line is refreshed.
+const progressInterval = 250 * time.Millisecond
+
+// progressLine is the status line written to stderr while the files are
+// processed. It is cleared before anything else is printed.
+var progressLine struct {
+	sync.Mutex
+	shown bool
+}
+
+// showProgress reports whether the status line can be displayed: stderr is a
+// terminal and the output is not consumed by another program or interface.
+func showProgress() bool {
+	if flagNoProgress || flagQuiet || flagList || flagInteractive || flagTUI || flagOutputFormat != "text" {
+		return false
+	}
+
+	fi, err := os.Stderr.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// startProgress refreshes the status line until the returned function is
+// called, which also clears it.
+func startProgress(e *engine.Engine) func() {
+	if !showProgress() {
+		return func() {}
+	}
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-done:
+			return
+		case <-time.After(progressDelay):
+		}
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		var started time.Time
+
+		for {
+			p := e.Progress()
+
+			// the estimate starts with the first file found.
+			if started.IsZero() && p.Total > 0 {
+				started = time.Now()
+			}
+
+			printProgress(p, e.Stats(), time.Since(started))
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		clearProgress()
+	}
+}
+
+// printProgress writes the status line with the number of files processed,
+// matched and modified, and the estimated remaining time.
+func printProgress(p engine.Progress, stats engine.Stats, elapsed time.Duration) {
+	line := fmt.Sprintf("%d/%d file(s), %d matched, %d modified", p.Done, p.Total, stats.FilesMatched, stats.FilesModified)
+
+	// the remaining time is unknown until every file was found.
+	if !p.Complete {
+		line += ", walking..."
+	} else if p.Done > 0 && p.Done < p.Total {
+		eta := elapsed * time.Duration(p.Total-p.Done) / time.Duration(p.Done)
+		line += ", " + eta.Round(time.Second).String() + " left"
+	}
+
+	progressLine.Lock()
+	defer progressLine.Unlock()
+
+	fmt.Fprint(os.Stderr, "\r\x1b[K"+line)
+	progressLine.shown = true
+}
+
+// clearProgress erases the status line, if displayed, so the next output
+// starts at the beginning of an empty line.
+func clearProgress() {
+	progressLine.Lock()
+	defer progressLine.Unlock()
+
+	if progressLine.shown {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		progressLine.shown = false
+	}
+}
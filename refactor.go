@@ -1,14 +1,18 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cixtor/refactor/engine"
 )
 
 // Refactor defines the interface to process the files.
@@ -29,14 +33,224 @@ type Match struct {
 	LineNumber int
 }
 
-var flagOldText string
-var flagNewText string
+var flagOldText stringList
+var flagNewText stringList
+var flagPairs string
+var flagEscapes bool
+var flagRules string
 var flagCommitChanges bool
+var flagRegexp bool
+var flagIgnoreCase bool
+var flagPreserveCase bool
+var flagStructural bool
+var flagPlaceholders bool
+var flagNormalize string
+var flagWholeWord bool
+var flagInclude stringList
+var flagExclude stringList
+var flagNoIgnore bool
+var flagNoDefaultFilters bool
+var flagInteractive bool
+var flagJSON bool
+var flagOutputFormat string
+var flagSuggest bool
+var flagTUI bool
+var flagBinary bool
+var flagArchives bool
+var flagMultiline bool
+var flagJobs int
+var flagStats bool
+var flagReport string
+var flagNoProgress bool
+var flagCPUProfile string
+var flagMemProfile string
+var flagTrace string
+var flagCache string
+var flagResume bool
+var flagForce bool
+var flagMaxChanges int
+var flagUnless string
+var flagMapCmd string
+var flagPlugin string
+var flagMaxPerLine int
+var flagFirstOnly bool
+var flagMaxOccurrences int
+var flagContext contextFlags
+var flagColor string
+var flagColumn bool
+var flagQuiet bool
+var flagCheck bool
+var flagList bool
+var flagGit bool
+var flagFollow bool
+var flagRename bool
+var flagFixLinks bool
+var flagLang string
+var flagSymbol bool
+var flagTags string
+var flagTagsOnly bool
+var flagKey string
+var flagValue string
+var flagTo string
+var flagFrom string
+var flagTidy bool
+var flagOnly string
+var flagSkip string
+var flagHidden bool
+var flagMaxDepth int
+var flagMaxFiles int
+var flagMaxFileSize byteSize
+var flagPatch string
+var flagBackup backupFlag
+var flagBackupDir string
+var flagFormat bool
+var flagPostCmd string
+var flagPostCmdExt string
+var flagExec string
+var flagExecBatch string
+var flagWatch bool
+var flagWatchInterval time.Duration
+var flagProfile string
+var flagCommit string
+var flagBranch string
+var flagSplitBy splitFlag
+var flagChangedSince string
+var flagOwner stringList
+var flagGroupBy string
+var flagFilesFrom string
+var flagNullData bool
+var flagStreamThreshold = byteSize(engine.DefaultStreamThreshold)
 
 func main() {
-	flag.StringVar(&flagOldText, "a", "", "Old text to search in all files")
-	flag.StringVar(&flagNewText, "b", "", "New text to replace [OLD] with")
+	var command string
+
+	args := os.Args[1:]
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "undo":
+			undoCommand(args[1:])
+			return
+		case "apply":
+			applyCommand(args[1:])
+			return
+		case "rules":
+			rulesCommand(args[1:])
+			return
+		case "lsp-rename":
+			lspRenameCommand(args[1:])
+			return
+		case "serve":
+			serveCommand(args[1:])
+			return
+		case "mcp":
+			mcpCommand(args[1:])
+			return
+		case "imports":
+			// imports is search, or replace with -x, of the module paths
+			// of -from in the Go files, go.mod and go.work.
+			command, args = args[0], args[1:]
+		case "image":
+			// image is search in the layers of a container image.
+			command, args = args[0], args[1:]
+		case "search", "replace":
+			// the bare form, without subcommand, is the same as search
+			// and becomes replace with -x.
+			command, args = args[0], args[1:]
+		}
+	}
+
+	flag.Var(&flagOldText, "a", "Old text to search in all files (repeatable)")
+	flag.Var(&flagNewText, "b", "New text to replace [OLD] with (repeatable, one per -a)")
+	flag.StringVar(&flagPairs, "pairs", "", "Comma-separated list of old=new pairs, i.e. old1=new1,old2=new2")
+	flag.BoolVar(&flagEscapes, "escapes", false, "Interpret \\xNN, \\n, \\r, \\t, \\0 and \\\\ in [OLD] and [NEW], i.e. -a 'a\\tb'")
+	flag.StringVar(&flagRules, "rules", "", "JSON file with an ordered list of rules to apply")
 	flag.BoolVar(&flagCommitChanges, "x", false, "Execute the replacement operation (default is preview-only)")
+	flag.BoolVar(&flagRegexp, "e", false, "Interpret [OLD] as a regular expression (RE2 syntax) and expand $1, ${name} and $$ in [NEW]")
+	flag.BoolVar(&flagIgnoreCase, "i", false, "Perform case-insensitive matching")
+	flag.BoolVar(&flagWholeWord, "w", false, "Match [OLD] only as a whole word")
+	flag.BoolVar(&flagPreserveCase, "p", false, "Match every naming convention of [OLD] and preserve it in [NEW]")
+	flag.BoolVar(&flagStructural, "structural", false, "Interpret [OLD] as a template with :[holes] matching balanced code, ignoring whitespace, and reuse them in [NEW]")
+	flag.BoolVar(&flagPlaceholders, "placeholders", false, "Expand {file}, {line}, {n}, the number of the occurrence in the file, and {env:NAME} in [NEW]")
+	flag.StringVar(&flagNormalize, "normalize", "", "Match the composed and decomposed forms of the accented letters of [OLD] and write [NEW] in the normal form: nfc or nfd")
+	flag.Var(&flagInclude, "include", "Search only files matching the glob pattern (repeatable)")
+	flag.Var(&flagExclude, "exclude", "Skip files and directories matching the glob pattern (repeatable)")
+	flag.BoolVar(&flagInteractive, "interactive", false, "Confirm every replacement before it is executed")
+	flag.BoolVar(&flagForce, "force", false, "Replace the findings even if the file was modified after it was searched")
+	flag.BoolVar(&flagTUI, "tui", false, "Review the findings in a terminal interface before applying them")
+	flag.BoolVar(&flagJSON, "json", false, "Print one JSON record per finding and a final summary")
+	flag.StringVar(&flagOutputFormat, "output-format", "text", "Print the findings as text, json (the same as -json), sarif, github annotations, vimgrep or rdjsonl (the same as -suggest)")
+	flag.BoolVar(&flagSuggest, "suggest", false, "Print the findings with their replacements as suggestions in the reviewdog rdjsonl format, without modifying any file")
+	flag.BoolVar(&flagMultiline, "multiline", false, "Allow [OLD] to match across lines (implied if [OLD] contains a newline)")
+	flag.BoolVar(&flagBinary, "binary", false, "Search binary files (skipped by default)")
+	flag.BoolVar(&flagArchives, "archives", false, "Search inside the zip, jar, tar, tar.gz and gz files, rewriting an archive if any member changed")
+	flag.BoolVar(&flagNoIgnore, "no-ignore", false, "Search files and directories ignored by .gitignore")
+	flag.BoolVar(&flagNoDefaultFilters, "no-default-filters", false, "Search .git, vendor, node_modules, dist, *.min.js and generated files")
+	flag.Var(&flagStreamThreshold, "stream-threshold", "Process files larger than this size (i.e. 512K, 64M, 2G) in chunks")
+	flag.IntVar(&flagMaxDepth, "max-depth", 0, "Descend at most N directories below the folders (1 searches only the files inside them)")
+	flag.Var(&flagMaxFileSize, "max-filesize", "Skip and report the files larger than this size (i.e. 512K, 10M)")
+	flag.IntVar(&flagMaxFiles, "max-files", 0, "Abort before processing anything if there are more than N files")
+	flag.BoolVar(&flagHidden, "hidden", false, "Search hidden files and directories, whose name starts with a dot")
+	flag.StringVar(&flagLang, "lang", "", "Process only the files of the language: go, javascript, python, shell, json or yaml")
+	flag.BoolVar(&flagSymbol, "symbol", false, "With -lang go, rename the identifiers [OLD] or pkg.[OLD] using the type checker, leaving strings and comments untouched")
+	flag.StringVar(&flagTags, "tags", "", "With -symbol, also rename the values of the struct tags of the fields, i.e. json,yaml,db, in the same case")
+	flag.BoolVar(&flagTagsOnly, "tags-only", false, "With -tags, rename the values of the struct tags but not the fields")
+	flag.StringVar(&flagKey, "key", "", "With -lang json or yaml, rename the keys at the path, i.e. a.b or $.items[*].name, to -to")
+	flag.StringVar(&flagValue, "value", "", "With -lang json or yaml, replace the scalar values at the path with -to, quoted if it is not a valid scalar")
+	flag.StringVar(&flagTo, "to", "", "The new name of the keys of -key, the new value of -value, or the new module path of -from")
+	flag.StringVar(&flagFrom, "from", "", "With the imports command, the Go module or package path to rewrite in the imports, go.mod and go.work")
+	flag.BoolVar(&flagTidy, "tidy", false, "With the imports command and -x, run go mod tidy in the folders of the modified go.mod files")
+	flag.StringVar(&flagOnly, "only", "", "Replace only inside the comments, the strings or the identifiers of Go, JavaScript, Python and shell files, or inside a syntax node, i.e. function_name, of Go, JavaScript, Python, shell, JSON and YAML files")
+	flag.StringVar(&flagSkip, "skip", "", "Replace everywhere except inside the comments, the strings or the identifiers of Go, JavaScript, Python and shell files, or a syntax node of Go, JavaScript, Python, shell, JSON and YAML files")
+	flag.BoolVar(&flagRename, "rename", false, "Also replace [OLD] in the names of the files and directories, with git mv inside a repository")
+	flag.BoolVar(&flagFixLinks, "fix-links", false, "With -rename, rewrite the relative Markdown links and images pointing to the renamed files, or broken by moving the Markdown files")
+	flag.BoolVar(&flagFollow, "follow", false, "Follow symbolic links to directories and modify the targets of symbolic links to files")
+	flag.BoolVar(&flagGit, "git", false, "Search only the files tracked by git instead of walking the directories")
+	flag.StringVar(&flagPatch, "patch", "", "Write the changes to a patch file, or stdout if -, without modifying any file")
+	flag.Var(&flagBackup, "backup", "Copy every file to FILE.bak, or -backup=SUFFIX, before it is modified")
+	flag.StringVar(&flagBackupDir, "backup-dir", "", "Copy every file, before it is modified, to the same path under the folder")
+	flag.BoolVar(&flagFormat, "format", false, "Format every modified Go file with goimports, or gofmt if it is not installed")
+	flag.StringVar(&flagPostCmd, "post-cmd", "", "Run the command on every modified file, i.e. 'gofmt -w {}'")
+	flag.StringVar(&flagPostCmdExt, "post-cmd-ext", "", "Comma-separated list of extensions of the files for -post-cmd, i.e. go,mod (default all files)")
+	flag.StringVar(&flagExec, "exec", "", "Run the command once per modified file, at the end, i.e. 'golint {}'")
+	flag.StringVar(&flagExecBatch, "exec-batch", "", "Run the command once with all the modified files, at the end, i.e. 'go vet {}'")
+	flag.BoolVar(&flagWatch, "watch", false, "Keep running and process the files as they are created or modified, until interrupted; the folders are watched with inotify on Linux and scanned at every -watch-interval on the other systems")
+	flag.StringVar(&flagProfile, "profile", "", "Apply the settings of the [profile.NAME] section of the configuration files")
+	flag.DurationVar(&flagWatchInterval, "watch-interval", engine.DefaultWatchInterval, "Time between two checks of the changes with -watch")
+	flag.StringVar(&flagCommit, "commit", "", "Commit the modified files to git with the message")
+	flag.StringVar(&flagBranch, "branch", "", "Create the -commit in a new git branch")
+	flag.Var(&flagSplitBy, "split-by", "Split the -patch or the -commit, and the -branch, in chunks by folder, dir or dir=DEPTH, or by number of files, count=N")
+	flag.StringVar(&flagChangedSince, "changed-since", "", "Process only the files modified since the git branch or commit")
+	flag.Var(&flagOwner, "owner", "Process only the files owned by the team or user in CODEOWNERS, like @org/team (repeatable)")
+	flag.StringVar(&flagGroupBy, "group-by", "", "Print the findings grouped by owner, according to CODEOWNERS")
+	flag.StringVar(&flagFilesFrom, "files-from", "", "Read the list of files from a file, or from stdin if the name is -")
+	flag.BoolVar(&flagNullData, "0", false, "The list of files of -files-from is separated by NUL instead of newlines")
+	flag.BoolVar(&flagCheck, "check", false, "Only check the files, for the pre-commit hooks; exit with status 1 if the rules, the forbidden patterns, match")
+	flag.BoolVar(&flagQuiet, "q", false, "Print nothing; exit with status 1 if there are no matches")
+	flag.BoolVar(&flagList, "l", false, "Print only the names of the files with matches; exit with status 1 if there are none")
+	flag.BoolVar(&flagColumn, "column", false, "Print the column of the first occurrence after the line number, as in file:line:col")
+	flag.StringVar(&flagColor, "color", "auto", "Color the output: auto, always or never (auto honors NO_COLOR)")
+	flag.IntVar(&flagContext.both, "C", 0, "Print N lines of context around every finding in preview mode")
+	flag.IntVar(&flagContext.after, "A", -1, "Print N lines of context after every finding in preview mode")
+	flag.IntVar(&flagContext.before, "B", -1, "Print N lines of context before every finding in preview mode")
+	flag.Var(lineRanges{list: &flagLineRanges}, "lines", "Replace only in the range of lines FIRST-LAST of every file (repeatable)")
+	flag.Var(lineRanges{list: &flagLineRanges, file: true}, "range", "Replace only in the range of lines of the file, FILE:FIRST-LAST, which is searched if no files are specified (repeatable)")
+	flag.StringVar(&flagUnless, "unless", "", "Skip the lines also matching the regular expression, i.e. 'nolint|TODO'")
+	flag.StringVar(&flagPlugin, "plugin", "", "Find the occurrences or compute the replacements with the plugin command, see engine.PluginProtocol")
+	flag.StringVar(&flagMapCmd, "map-cmd", "", "Replace every occurrence with the output of the command, which reads the occurrence from stdin, i.e. 'base64'")
+	flag.IntVar(&flagMaxPerLine, "max-per-line", 0, "Replace only the first N occurrences of every line")
+	flag.BoolVar(&flagFirstOnly, "first-only", false, "Replace only the first occurrence of every file")
+	flag.IntVar(&flagMaxChanges, "max-changes", 0, "With -x, modify nothing if more than N files would be modified")
+	flag.IntVar(&flagMaxOccurrences, "max-occurrences", 0, "With -x, modify nothing if more than N occurrences would be replaced")
+	flag.BoolVar(&flagStats, "stats", false, "Print the number of findings and occurrences of every file")
+	flag.StringVar(&flagReport, "report", "", "Write the findings as a self-contained HTML page with the diff of every file")
+	flag.BoolVar(&flagNoProgress, "no-progress", false, "Do not display the status line of the execution on stderr")
+	flag.StringVar(&flagCPUProfile, "cpuprofile", "", "Write a CPU profile to the file, for go tool pprof")
+	flag.StringVar(&flagMemProfile, "memprofile", "", "Write a heap profile to the file at the end of the execution, for go tool pprof")
+	flag.StringVar(&flagTrace, "trace", "", "Write an execution trace to the file, for go tool trace")
+	flag.StringVar(&flagCache, "cache", "", "Skip the unmodified files that did not match the same search texts before, recorded in the folder, i.e. "+engine.DefaultCacheDir)
+	flag.BoolVar(&flagResume, "resume", false, "With -x, skip the files completed by the interrupted run recorded in "+engine.DefaultStateFile)
+	flag.IntVar(&flagJobs, "j", engine.DefaultConcurrency, "Number of files to search and modify at the same time")
 
 	flag.Usage = func() {
 		fmt.Print(`refactor
@@ -47,189 +261,856 @@ the program simply prints the files and occurrences that will be replace but
 does not execute the replacement operation until the flag -x is also specified.
 
 usage:
+  refactor [flags] [FILE...]
+  refactor [flags] [-] < INPUT
+  refactor [flags] ssh://[USER@]HOST[:PORT]/PATH...
+  refactor search [flags] [FILE...]
+  refactor replace [flags] [FILE...]
+  refactor imports -from OLD -to NEW [flags] [FILE...]
+  refactor image [flags] IMAGE|FILE.tar
+  refactor undo [-f]
+  refactor apply PATCH
+  refactor rules FILE
+  refactor lsp-rename [-x] [-server CMD] FILE:LINE:COLUMN NEWNAME
+  refactor serve [-listen ADDR] [-token TOKEN]
+  refactor mcp [-max-changes N] [-max-occurrences N]
+  refactor completion bash|zsh|fish
+
+flags:
 `)
 
 		flag.PrintDefaults()
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 
-	flag.Parse()
+	// the completion scripts are generated from the flags defined above.
+	if command == "" && len(args) > 0 && args[0] == "completion" {
+		completionCommand(args[1:])
+		return
+	}
 
-	if flagOldText == flagNewText {
+	_ = flag.CommandLine.Parse(args)
+
+	switch {
+	case command == "replace":
+		flagCommitChanges = true
+	case command == "search" && flagCommitChanges:
+		fmt.Println("search never modifies the files, use replace instead of -x")
+		os.Exit(exitUsage)
+	case command == "imports" && (flagFrom == "" || flagTo == ""):
+		fmt.Println("imports requires -from and -to")
+		os.Exit(exitUsage)
+	case command != "imports" && (flagFrom != "" || flagTidy):
+		fmt.Println("-from and -tidy require the imports command")
+		os.Exit(exitUsage)
+	case flagFixLinks && !flagRename:
+		fmt.Println("-fix-links requires -rename")
+		os.Exit(exitUsage)
+	case command == "image" && (flag.NArg() != 1 || flagCommitChanges || flagInteractive || flagTUI || flagPatch != "" || flagRename || flagWatch):
+		fmt.Println("image requires one image and never modifies it")
+		os.Exit(exitUsage)
+	case flagArchives && (flagInteractive || flagTUI || flagPatch != ""):
+		fmt.Println("-archives cannot be combined with -interactive, -tui or -patch")
+		os.Exit(exitUsage)
+	}
+
+	// the ssh:// paths are processed by a copy of the program on the host.
+	for _, path := range flag.Args() {
+		if !strings.HasPrefix(path, "ssh://") {
+			continue
+		}
+
+		target, remoteArgs, err := remotePaths(args)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(exitUsage)
+		}
+
+		if command != "" {
+			remoteArgs = append([]string{command}, remoteArgs...)
+		}
+
+		os.Exit(target.run(remoteArgs))
+	}
+
+	if err := loadConfig(flagProfile); err != nil {
+		fmt.Println("config:", err)
+		os.Exit(exitUsage)
+	}
+
+	if err := setupColor(flagColor); err != nil {
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
+
+	specs, err := ruleSpecs()
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
+
+	if isNoop(specs) {
 		fmt.Println("noop (A == B)")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
-	files := flag.Args()
+	if err := setupOutputFormat(flagOutputFormat); err != nil {
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
 
-	// If the user did not provide any specific files to search and replace,
-	// then assume they want to search and replace among all the files in the
-	// current folder (recursively).
-	if flag.NArg() == 0 {
-		files = findFilesRecursively()
+	if flagJSON && flagInteractive {
+		fmt.Println("-json and -interactive are mutually exclusive")
+		os.Exit(exitUsage)
 	}
 
-	var wg sync.WaitGroup
-	sem := make(chan bool, 50)
-	result := make(chan SearchResult)
+	if flagTUI && (flagJSON || flagInteractive) {
+		fmt.Println("-tui cannot be combined with -json or -interactive")
+		os.Exit(exitUsage)
+	}
 
-	wg.Add(len(files))
+	if flagInteractive && flagFilesFrom == "-" {
+		fmt.Println("-interactive reads the answers from stdin, use -files-from with a file")
+		os.Exit(exitUsage)
+	}
+
+	paths := flag.Args()
+
+	// a single "-" applies the rules to stdin and prints the result, like
+	// sed(1), and so does a piped stdin without paths, see below.
+	filter := len(paths) == 1 && paths[0] == "-"
+
+	// these flags need the files themselves, not their content.
+	needsFiles := command == "image" || flagFilesFrom != "" || flagGit || flagChangedSince != "" || flagCommitChanges || flagInteractive || flagTUI || flagCheck || flagRename || flagSymbol || flagWatch || flagPatch != "" || flagCommit != "" || flagJSON || flagOutputFormat != "text" || flagGroupBy != ""
 
-	for _, filename := range files {
-		go searchThisFile(sem, &wg, result, filename, flagOldText)
+	if filter && needsFiles {
+		fmt.Println("- reads stdin as a filter, it cannot be combined with -x, -interactive, -tui, -check, -rename, -symbol, -watch, -patch, -commit, -json, -output-format, -group-by, -files-from, -git or -changed-since")
+		os.Exit(exitUsage)
 	}
 
-	go func() {
-		wg.Wait()
-		close(result)
-	}()
+	for _, path := range paths {
+		if path == "-" && !filter {
+			fmt.Println("- reads stdin as a filter, it cannot be combined with other paths")
+			os.Exit(exitUsage)
+		}
+	}
 
-	for res := range result {
-		if len(res.Findings) == 0 {
-			continue
+	if filter {
+		paths = nil
+	} else if len(paths) == 0 && flagFilesFrom == "" {
+		paths = rangedFiles()
+		filter = len(paths) == 0 && !needsFiles && stdinIsPiped()
+	}
+
+	if flagFilesFrom != "" {
+		list, err := readFileList(flagFilesFrom, flagNullData)
+
+		if err != nil {
+			fmt.Println("files-from", err)
+			os.Exit(exitUsage)
+		}
+
+		// an empty list must not fall back to the whole working directory.
+		if len(list) == 0 && len(paths) == 0 {
+			if flagCheck {
+				os.Exit(exitClean)
+			}
+			os.Exit(exitNoMatches)
+		}
+
+		paths = append(paths, list...)
+	}
+
+	if flagCheck && (flagCommitChanges || flagInteractive || flagTUI || flagPatch != "" || flagRename || flagWatch || flagCommit != "") {
+		fmt.Println("-check never modifies the files, it cannot be combined with -x, -interactive, -tui, -patch, -rename, -watch or -commit")
+		os.Exit(exitUsage)
+	}
+
+	// the hooks run the command without files when none of them changed,
+	// which must not check the whole working directory.
+	if flagCheck && len(paths) == 0 && flagFilesFrom == "" && !flagGit && flagChangedSince == "" {
+		os.Exit(exitClean)
+	}
+
+	if err := setupOwners(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
+
+	if (flagQuiet || flagList) && (flagJSON || flagInteractive || flagTUI) {
+		fmt.Println("-q and -l cannot be combined with -json, -interactive or -tui")
+		os.Exit(exitUsage)
+	}
+
+	if flagRename && (flagPatch != "" || flagInteractive || flagTUI) {
+		fmt.Println("-rename cannot be combined with -patch, -interactive or -tui")
+		os.Exit(exitUsage)
+	}
+
+	if flagWatch && (flagPatch != "" || flagInteractive || flagTUI || flagRename || flagCommit != "") {
+		fmt.Println("-watch cannot be combined with -patch, -interactive, -tui, -rename or -commit")
+		os.Exit(exitUsage)
+	}
+
+	if flagPatch != "" && (flagCommitChanges || flagInteractive || flagTUI || flagJSON) {
+		fmt.Println("-patch cannot be combined with -x, -interactive, -tui or -json")
+		os.Exit(exitUsage)
+	}
+
+	if flagSplitBy.enabled() && flagCommit == "" && (flagPatch == "" || flagPatch == "-") {
+		fmt.Println("-split-by requires -patch with a file name or -commit")
+		os.Exit(exitUsage)
+	}
+
+	if flagSplitBy.enabled() && flagBranch != "" && splitBranchesExist(flagBranch) {
+		fmt.Printf("-branch %s-N already exists\n", flagBranch)
+		os.Exit(exitUsage)
+	}
+
+	if flagBranch != "" && flagCommit == "" {
+		fmt.Println("-branch requires -commit")
+		os.Exit(exitUsage)
+	}
+
+	if flagCommit != "" && !flagCommitChanges && !flagInteractive && !flagTUI {
+		fmt.Println("-commit requires -x, -interactive or -tui")
+		os.Exit(exitUsage)
+	}
+
+	if flagBranch != "" && !flagSplitBy.enabled() && branchExists(flagBranch) {
+		fmt.Printf("-branch %s already exists\n", flagBranch)
+		os.Exit(exitUsage)
+	}
+
+	if flagResume && !flagCommitChanges {
+		fmt.Println("-resume requires -x")
+		os.Exit(exitUsage)
+	}
+
+	if flagResume && (flagMaxChanges > 0 || flagMaxOccurrences > 0 || flagWatch || flagRename || flagCommit != "") {
+		fmt.Println("-resume cannot be combined with -max-changes, -max-occurrences, -watch, -rename or -commit")
+		os.Exit(exitUsage)
+	}
+
+	if flagJobs < 1 {
+		fmt.Println("-j must be greater than zero")
+		os.Exit(exitUsage)
+	}
+
+	opts := engine.Options{
+		Rules:            specs,
+		Regexp:           flagRegexp,
+		IgnoreCase:       flagIgnoreCase,
+		WholeWord:        flagWholeWord,
+		PreserveCase:     flagPreserveCase,
+		Structural:       flagStructural,
+		Placeholders:     flagPlaceholders,
+		Normalize:        flagNormalize,
+		Multiline:        flagMultiline,
+		Paths:            paths,
+		Include:          flagInclude,
+		Exclude:          flagExclude,
+		NoIgnore:         flagNoIgnore,
+		NoDefaultFilters: flagNoDefaultFilters,
+		Git:              flagGit,
+		Follow:           flagFollow,
+		Lang:             flagLang,
+		Symbol:           flagSymbol,
+		Tags:             splitList(flagTags),
+		TagsOnly:         flagTagsOnly,
+		Keys:             flagKey != "",
+		Values:           flagValue != "",
+		Imports:          flagFrom != "",
+		Only:             flagOnly,
+		Skip:             flagSkip,
+		Hidden:           flagHidden,
+		MaxDepth:         flagMaxDepth,
+		MaxFiles:         flagMaxFiles,
+		MaxFileSize:      int64(flagMaxFileSize),
+		ChangedSince:     flagChangedSince,
+		Owners:           flagOwner,
+		CodeOwners:       codeOwners,
+		BackupSuffix:     string(flagBackup),
+		BackupDir:        flagBackupDir,
+		Formatters:       formatters(),
+		Binary:           flagBinary,
+		Archives:         flagArchives,
+		Concurrency:      flagJobs,
+		StreamThreshold:  int64(flagStreamThreshold),
+		CacheDir:         flagCache,
+		Force:            flagForce,
+		Lines:            flagLineRanges,
+		Unless:           flagUnless,
+		MapCommand:       flagMapCmd,
+		Plugin:           flagPlugin,
+		MaxPerLine:       flagMaxPerLine,
+		FirstOnly:        flagFirstOnly,
+	}
+
+	// zero selects the default threshold in the engine, but the user wants to
+	// stream every file; a file with one single byte cannot be streamed anyway.
+	if opts.StreamThreshold == 0 {
+		opts.StreamThreshold = 1
+	}
+
+	if flagCommitChanges || flagInteractive || flagTUI {
+		opts.JournalDir = engine.DefaultJournalDir
+	}
+
+	// the progress of -x is recorded so an interrupted run can be resumed; the
+	// limits and -watch apply the changes in other ways.
+	if flagCommitChanges && flagMaxChanges == 0 && flagMaxOccurrences == 0 && !flagWatch {
+		opts.StateFile = engine.DefaultStateFile
+		opts.Resume = flagResume
+	}
+
+	if err := startProfiling(); err != nil {
+		fmt.Println(err)
+		os.Exit(exitUsage)
+	}
+
+	defer stopProfiling()
+
+	e, err := engine.New(opts)
+
+	if err != nil {
+		fmt.Println(err)
+		exit(exitUsage)
+	}
+
+	if n := e.Resumed(); n > 0 {
+		fmt.Fprintf(os.Stderr, "resuming; %d file(s) already done\n", n)
+	}
+
+	// the plugin, if any, also stops when the program exits.
+	defer e.Close()
+
+	if filter {
+		if err := e.Filter(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "filter:", err)
+			exit(exitFailure)
+		}
+		return
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	defer cancel()
+
+	handleSignals(cancel)
+
+	var modified []string
+
+	stopProgress := startProgress(e)
+
+	switch {
+	case command == "image":
+		err = searchImage(ctx, e, flag.Arg(0), func(res engine.SearchResult) {
+			printThisFile(res)
+			countFile(res, false)
+		})
+	case flagPatch != "":
+		err = writePatch(ctx, e, flagPatch)
+	case flagCheck:
+		err = e.SearchFunc(ctx, func(res engine.SearchResult) {
+			if flagJSON || flagOutputFormat != "text" || flagQuiet || flagList {
+				printThisFile(res)
+			} else {
+				printCheckFile(res)
+			}
+			countFile(res, false)
+		})
+	case flagTUI:
+		// the terminal interface needs every finding before it can start.
+		found, err := e.Search(ctx)
+		if err != nil {
+			reportError("", err)
+		}
+		var results []engine.SearchResult
+		for _, res := range found {
+			if res.Skipped != "" {
+				reportSkip(res.Filename, res.Skipped)
+				continue
+			}
+			warnLineEndings(res)
+			results = append(results, res)
+		}
+		if modified, err = runTUI(e, results); err != nil {
+			fmt.Println("tui:", err)
+			exit(exitFailure)
+		}
+		done := map[string]bool{}
+		for _, filename := range modified {
+			done[filename] = true
+		}
+		for _, res := range results {
+			if res.Err != nil {
+				reportError(res.Filename, res.Err)
+			}
+			countFile(res, done[res.Filename])
+		}
+	case flagInteractive:
+		err = e.SearchFunc(ctx, func(res engine.SearchResult) {
+			ok, err := confirmThisFile(e, res)
+			if err != nil {
+				reportError(res.Filename, err)
+			}
+			if ok {
+				modified = append(modified, res.Filename)
+			}
+			countFile(res, ok)
+		})
+	case flagCommitChanges:
+		applied := func(res engine.SearchResult) {
+			printThisFile(res)
+			if res.Modified {
+				modified = append(modified, res.Filename)
+			}
+			countFile(res, res.Modified)
+		}
+		if flagMaxChanges > 0 || flagMaxOccurrences > 0 {
+			err = applyWithLimits(ctx, e, applied)
+		} else {
+			err = e.ApplyFunc(ctx, applied)
+		}
+	case flagGroupBy != "":
+		// the groups are printed once every file is searched.
+		var results []engine.SearchResult
+		results, err = e.Search(ctx)
+		printByOwner(results)
+	default:
+		err = e.SearchFunc(ctx, func(res engine.SearchResult) {
+			printThisFile(res)
+			countFile(res, false)
+		})
+	}
+
+	stopProgress()
+
+	if flagWatch && err == nil {
+		fmt.Fprintln(os.Stderr, "watching for changes, press Ctrl+C to stop")
+		err = e.Watch(ctx, flagWatchInterval, flagCommitChanges, func(res engine.SearchResult) {
+			printThisFile(res)
+			if res.Modified {
+				modified = append(modified, res.Filename)
+			}
+			countFile(res, res.Modified)
+		})
+	}
+
+	var renamed int
+
+	if flagRename && err == nil {
+		var paths []string
+		renamed, paths = renameFiles(ctx, e, flagCommitChanges)
+		if flagCommitChanges {
+			modified = append(modified, paths...)
+		}
+		err = ctx.Err()
+	}
+
+	if err == context.Canceled {
+		// the same file can be modified multiple times in watch mode.
+		modified = uniqueStrings(modified)
+		printSkips()
+		printErrors()
+		fmt.Fprintf(os.Stderr, "interrupted; %d file(s) modified\n", len(modified))
+		for _, filename := range modified {
+			fmt.Fprintln(os.Stderr, "  "+filename)
+		}
+		if opts.StateFile != "" {
+			fmt.Fprintln(os.Stderr, "run the same command with -resume to process the remaining files")
+		}
+		exit(130)
+	}
+
+	if err != nil {
+		reportError("", err)
+	}
+
+	if flagExec != "" || flagExecBatch != "" {
+		execCommands(modified)
+	}
+
+	if flagTidy && flagCommitChanges {
+		tidyModules(modified)
+	}
+
+	if flagReport != "" {
+		if err := writeReport(flagReport, e.Stats(), time.Since(start)); err != nil {
+			reportError("", err)
 		}
+	}
 
-		wg.Add(1)
+	printSkips()
+	printErrors()
 
-		go modifyThisFile(sem, &wg, res, flagOldText, flagNewText)
+	if flagJSON {
+		printJSONSummary(e.Stats(), time.Since(start))
+	} else if flagCheck && flagOutputFormat == "text" {
+		if !flagQuiet && !flagList {
+			printCheckSummary(e.Stats())
+		}
+	} else if !flagQuiet && !flagList {
+		if flagOutputFormat == "sarif" {
+			printSARIF(e.Rules())
+		}
+		printFileCounts()
+		printSummary(e.Stats(), time.Since(start))
+	}
+
+	failed := len(failures) > 0
+
+	if flagCheck {
+		exit(checkStatus(failed, e.Stats()))
 	}
 
-	wg.Wait()
+	if flagCommit != "" && len(modified) > 0 {
+		if failed {
+			fmt.Println("commit: skipped because some files could not be modified")
+			exit(exitFailure)
+		}
+
+		// a file can be modified and then renamed.
+		modified = uniqueStrings(modified)
+
+		if flagSplitBy.enabled() {
+			if err := commitChunks(modified, flagCommit, flagBranch); err != nil {
+				fmt.Println("commit:", err)
+				exit(exitFailure)
+			}
+
+			exit(exitStatus(failed, e.Stats(), renamed))
+		}
+
+		hash, err := commitFiles(modified, flagCommit, flagBranch)
+
+		if err != nil {
+			fmt.Println("commit:", err)
+			exit(exitFailure)
+		}
+
+		fmt.Fprintf(os.Stderr, "committed %d file(s) in %s\n", len(modified), hash)
+	}
+
+	exit(exitStatus(failed, e.Stats(), renamed))
 }
 
-type SearchResult struct {
-	Filename string
-	Findings []Finding
+// stdinIsPiped reports whether the standard input is a pipe or a redirected
+// file. A terminal, /dev/null or a socket is not, so the programs started
+// without a terminal, i.e. in CI, search the working directory.
+func stdinIsPiped() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && (fi.Mode()&os.ModeNamedPipe != 0 || fi.Mode().IsRegular())
 }
 
-type Finding struct {
-	LineNumber   int
-	Occurrences  int
-	OriginalText string
+// handleSignals cancels the execution on SIGINT or SIGTERM, letting the files
+// that are being written finish. A second signal terminates the program.
+func handleSignals(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 2)
+
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sigs
+		fmt.Fprintln(os.Stderr, "interrupted; waiting for pending writes (press Ctrl-C again to abort)")
+		cancel()
+		<-sigs
+		os.Exit(130)
+	}()
 }
 
-func findFilesRecursively() []string {
-	filelist := []string{}
-	if err := filepath.Walk(".", func(s string, info os.FileInfo, err error) error {
+// ruleSpecs collects the search and replace pairs from the command line and
+// the rules file. The -a and -b flags are matched by position; a single -a
+// without -b replaces the text with an empty string, same as before the flags
+// were repeatable.
+func ruleSpecs() ([]engine.RuleSpec, error) {
+	var specs []engine.RuleSpec
+
+	if flagFrom != "" {
+		if len(flagOldText) > 0 || flagPairs != "" || flagRules != "" || flagRename {
+			return nil, fmt.Errorf("imports cannot be combined with -a, -pairs, -rules or -rename")
+		}
+
+		return []engine.RuleSpec{{Search: flagFrom, Replace: flagTo}}, nil
+	}
+
+	if flagKey != "" || flagValue != "" {
+		if len(flagOldText) > 0 || flagPairs != "" || flagRules != "" || flagRename {
+			return nil, fmt.Errorf("-key and -value cannot be combined with -a, -pairs, -rules or -rename")
+		}
+
+		if flagKey != "" && flagValue != "" {
+			return nil, fmt.Errorf("-key and -value cannot be combined")
+		}
+
+		return []engine.RuleSpec{{Search: flagKey + flagValue, Replace: flagTo}}, nil
+	}
+
+	if len(flagOldText) == 1 && len(flagNewText) == 0 {
+		flagNewText = append(flagNewText, "")
+	}
+
+	if len(flagOldText) != len(flagNewText) {
+		return nil, fmt.Errorf("every -a flag needs a matching -b flag (%d vs %d)", len(flagOldText), len(flagNewText))
+	}
+
+	for i := range flagOldText {
+		spec := engine.RuleSpec{Search: flagOldText[i], Replace: flagNewText[i]}
+
+		if flagEscapes {
+			var err error
+
+			if spec.Search, err = unescape(spec.Search); err != nil {
+				return nil, fmt.Errorf("-a: %s", err)
+			}
+
+			if spec.Replace, err = unescape(spec.Replace); err != nil {
+				return nil, fmt.Errorf("-b: %s", err)
+			}
+		}
+
+		specs = append(specs, spec)
+	}
+
+	if flagPairs != "" {
+		pairs, err := parsePairs(flagPairs)
+
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("-pairs: %s", err)
 		}
-		if info.IsDir() {
-			return nil
+
+		for _, pair := range pairs {
+			specs = append(specs, engine.RuleSpec{Search: pair[0], Replace: pair[1]})
+		}
+	}
+
+	if flagRules != "" {
+		more, err := engine.LoadRulesFile(flagRules)
+
+		if err != nil {
+			return nil, fmt.Errorf("-rules: %s", err)
 		}
-		filelist = append(filelist, s)
-		return nil
-	}); err != nil {
-		fmt.Println("filepath.Walk", err)
+
+		specs = append(specs, more...)
+	}
+
+	if len(specs) == 0 {
+		specs = append(specs, engine.RuleSpec{})
 	}
-	return filelist
+
+	return specs, nil
 }
 
-// searchThisFile reads the content of a file and finds the query.
-func searchThisFile(sem chan bool, wg *sync.WaitGroup, result chan SearchResult, filename string, query string) {
-	sem <- true
-	defer wg.Done()
-	defer func() { <-sem }()
+// isNoop reports whether none of the rules would change anything.
+func isNoop(specs []engine.RuleSpec) bool {
+	for _, spec := range specs {
+		if spec.Search != spec.Replace {
+			return false
+		}
+	}
 
-	fi, err := os.Lstat(filename)
+	return true
+}
+
+// undoCommand reverts the files modified by the most recent execution.
+func undoCommand(args []string) {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	force := fs.Bool("f", false, "Restore files even if they changed after the replacement")
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(exitUsage)
+	}
+
+	results, err := engine.Undo(engine.DefaultJournalDir, *force)
+
+	for _, res := range results {
+		switch {
+		case res.Err == engine.ErrModified:
+			fmt.Println("skip", res.Filename, "(modified after the replacement, use -f to override)")
+		case res.Err != nil:
+			fmt.Println(res.Err)
+		default:
+			fmt.Println("restored", res.Filename)
+		}
+	}
 
 	if err != nil {
-		fmt.Println("os.lstat:", filename, err)
-		return
+		fmt.Println("undo:", err)
+		os.Exit(exitFailure)
+	}
+
+	for _, res := range results {
+		if res.Err != nil {
+			os.Exit(exitFailure)
+		}
+	}
+}
+
+// formatReplacement renders a finding with the old text struck through and
+// followed by the new text.
+func formatReplacement(filename string, item engine.Finding, rs engine.RuleSet) string {
+	return fmt.Sprintf(
+		"%s:%s:%s",
+		paint("0;35", filename),
+		paint("0;32", location(item)),
+		item.Highlight(rs, func(m engine.RuleMatch) string {
+			oldText := item.OriginalText[m.Loc[0]:m.Loc[1]]
+			if m.Rule.Deny {
+				return paint("1;31", oldText)
+			}
+			repText := string(m.Rule.Expand([]byte(item.OriginalText), m.Loc))
+			return paintChange(oldText, repText)
+		}),
+	) + formatMessages(item, rs)
+}
+
+// location returns the line numbers of the finding and, with -column, the
+// column of the first occurrence.
+func location(item engine.Finding) string {
+	if flagColumn && len(item.Positions) > 0 {
+		return item.Lines() + ":" + strconv.Itoa(item.Positions[0].Column)
 	}
 
-	// skip files acting as symbolic links.
-	if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+	return item.Lines()
+}
+
+// printThisFile prints the findings of the file, highlighting the matches in
+// preview mode or the replacements once the file was modified.
+func printThisFile(res engine.SearchResult) {
+	clearProgress()
+
+	if res.Skipped != "" {
+		reportSkip(res.Filename, res.Skipped)
 		return
 	}
 
-	file, err := os.Open(filename)
+	if res.Err != nil {
+		reportError(res.Filename, res.Err)
+	}
 
-	if err != nil {
-		fmt.Println("os.Open", filename, err)
+	if flagQuiet {
 		return
 	}
 
-	defer func() {
-		if err := file.Close(); err != nil {
-			fmt.Println("file.Close", err)
+	if flagList {
+		if len(res.Findings) > 0 {
+			fmt.Println(res.Filename)
 		}
-	}()
+		return
+	}
 
-	var row int
-	var line string
-	var findings []Finding
+	warnLineEndings(res)
 
-	scanner := bufio.NewScanner(file)
+	for _, item := range res.Findings {
+		filename := res.Filename
 
-	for scanner.Scan() {
-		row++ /* line number */
-		line = scanner.Text()
+		// the findings of the archives are in their members.
+		if item.Member != "" {
+			filename += engine.MemberSeparator + item.Member
+		}
 
-		if n := strings.Count(line, query); n > 0 {
-			findings = append(findings, Finding{
-				LineNumber:   row,
-				Occurrences:  n,
-				OriginalText: line,
-			})
+		if flagJSON {
+			printJSONFinding(filename, item, res.Rules)
+			continue
 		}
-	}
 
-	result <- SearchResult{Filename: filename, Findings: findings}
-}
+		if flagOutputFormat == "sarif" {
+			collectSARIF(filename, item, res.Rules)
+			continue
+		}
 
-// modifyThisFile changes the content of the specified file.
-func modifyThisFile(sem chan bool, wg *sync.WaitGroup, res SearchResult, oldText string, newText string) {
-	sem <- true
-	defer wg.Done()
-	defer func() { <-sem }()
-
-	// preview changes and exit.
-	if !flagCommitChanges {
-		for _, item := range res.Findings {
-			fmt.Printf(
-				"\x1b[0;35m%s\x1b[0m:\x1b[0;32m%d\x1b[0m:%s\n",
-				res.Filename,
-				item.LineNumber,
-				strings.Replace(
-					item.OriginalText,
-					oldText,
-					"\x1b[1;31m"+oldText+"\x1b[0m",
-					item.Occurrences,
-				),
-			)
+		if flagOutputFormat == "github" {
+			printGitHubFinding(filename, item, res.Rules)
+			continue
 		}
 
-		return
+		if flagOutputFormat == "vimgrep" {
+			printVimgrepFinding(filename, item, res.Rules)
+			continue
+		}
+
+		if flagOutputFormat == "rdjsonl" {
+			printSuggestion(filename, item, res.Rules)
+			continue
+		}
+
+		if res.Modified {
+			fmt.Println(formatReplacement(filename, item, res.Rules))
+			continue
+		}
+
+		if res.Err != nil {
+			continue
+		}
+
+		if flagContext.enabled() && item.Member == "" {
+			printWithContext(res)
+			return
+		}
+
+		fmt.Println(formatMatch(filename, item, res.Rules))
 	}
+}
 
-	content, err := os.ReadFile(res.Filename)
+// formatMatch renders a finding with the occurrences highlighted.
+func formatMatch(filename string, item engine.Finding, rs engine.RuleSet) string {
+	return fmt.Sprintf(
+		"%s:%s:%s",
+		paint("0;35", filename),
+		paint("0;32", location(item)),
+		item.Highlight(rs, func(m engine.RuleMatch) string {
+			return paint("1;31", item.OriginalText[m.Loc[0]:m.Loc[1]])
+		}),
+	) + formatMessages(item, rs)
+}
 
-	if err != nil {
-		fmt.Println("ioutil.ReadFile", res.Filename, err)
+// warnLineEndings reports files with both Unix and Windows line endings
+// because the replacements use the dominant one and may add to the mix.
+func warnLineEndings(res engine.SearchResult) {
+	if flagQuiet {
 		return
 	}
 
-	var totalOccurrences int
+	if le := res.LineEndings; le.Mixed() && len(res.Findings) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s has mixed line endings (%d LF, %d CRLF)\n", res.Filename, le.LF, le.CRLF)
+	}
+}
+
+// confirmThisFile asks the user which findings of the file must be replaced
+// and then modifies the file accordingly. It returns true if the file was
+// modified, or the error that prevented it.
+func confirmThisFile(e *engine.Engine, res engine.SearchResult) (bool, error) {
+	if res.Skipped != "" {
+		reportSkip(res.Filename, res.Skipped)
+		return false, nil
+	}
 
-	for _, item := range res.Findings {
-		fmt.Printf(
-			"\x1b[0;35m%s\x1b[0m:\x1b[0;32m%d\x1b[0m:%s\n",
-			res.Filename,
-			item.LineNumber,
-			strings.Replace(
-				item.OriginalText,
-				oldText,
-				"\x1b[0;9m"+oldText+"\x1b[0m\x1b[1;34m"+newText+"\x1b[0m",
-				item.Occurrences,
-			),
-		)
-		totalOccurrences += item.Occurrences
+	if res.Err != nil {
+		return false, res.Err
 	}
 
-	content = bytes.Replace(content, []byte(oldText), []byte(newText), totalOccurrences)
+	warnLineEndings(res)
 
-	if err := os.WriteFile(res.Filename, content, 0644); err != nil {
-		fmt.Println("ioutil.WriteFile", res.Filename, err)
+	content, err := engine.ReadText(res.Filename)
+
+	if err != nil {
+		return false, fmt.Errorf("engine.ReadText %s %s", res.Filename, err)
+	}
+
+	selected := confirmFindings(res, content, res.Rules)
+
+	if len(selected) == 0 {
+		return false, nil
+	}
+
+	// the file may be modified even if it cannot be formatted.
+	if err := e.ApplyFile(&res, selected); err != nil {
+		return res.Modified, err
 	}
+
+	return true, nil
 }
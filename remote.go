@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// remoteTarget is the host of the ssh:// paths, where the program is copied
+// and executed with the same arguments.
+type remoteTarget struct {
+	host string
+	port string
+}
+
+// remotePaths returns the host of the paths that are ssh:// URLs, and the
+// arguments with those paths replaced by the paths on the host. The paths
+// starting with /~/ are relative to the home folder. Every URL must refer to
+// the same host.
+func remotePaths(args []string) (*remoteTarget, []string, error) {
+	var target *remoteTarget
+
+	out := make([]string, len(args))
+
+	for i, arg := range args {
+		out[i] = arg
+
+		if !strings.HasPrefix(arg, "ssh://") {
+			continue
+		}
+
+		u, err := url.Parse(arg)
+
+		if err != nil || u.Host == "" {
+			return nil, nil, fmt.Errorf("%q is not ssh://[USER@]HOST[:PORT]/PATH", arg)
+		}
+
+		host := u.Hostname()
+
+		if u.User != nil {
+			host = u.User.Username() + "@" + host
+		}
+
+		// ssh(1) would read them as options, i.e. -oProxyCommand=...
+		if strings.HasPrefix(host, "-") || strings.HasPrefix(u.Hostname(), "-") {
+			return nil, nil, fmt.Errorf("%q is not a valid host", host)
+		}
+
+		if target != nil && (target.host != host || target.port != u.Port()) {
+			return nil, nil, fmt.Errorf("the ssh:// paths must refer to the same host")
+		}
+
+		target = &remoteTarget{host: host, port: u.Port()}
+
+		switch path := u.Path; {
+		case path == "" || path == "/~" || path == "/~/":
+			out[i] = "."
+		case strings.HasPrefix(path, "/~/"):
+			out[i] = path[len("/~/"):]
+		default:
+			out[i] = path
+		}
+	}
+
+	return target, out, nil
+}
+
+// ssh returns the command running the script on the host with ssh(1).
+func (t *remoteTarget) ssh(script string) *exec.Cmd {
+	args := []string{"-o", "BatchMode=yes"}
+
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+
+	return exec.Command("ssh", append(args, "--", t.host, script)...)
+}
+
+// run copies the executable of the program to a temporary file of the host,
+// runs it there with the arguments and removes it. The output is streamed to
+// the local terminal and the exit status is the one of the remote program.
+// The files of the other flags, i.e. -rules, are the ones of the host.
+func (t *remoteTarget) run(args []string) int {
+	arch, err := t.ssh("uname -sm").Output()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ssh %s %s\n", t.host, err)
+		return exitFailure
+	}
+
+	if want := runtime.GOOS + " " + unameMachine(); !strings.EqualFold(strings.TrimSpace(string(arch)), want) {
+		fmt.Fprintf(os.Stderr, "ssh %s is %s, but this program is built for %s/%s\n", t.host, strings.TrimSpace(string(arch)), runtime.GOOS, runtime.GOARCH)
+		return exitFailure
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "os.Executable", err)
+		return exitFailure
+	}
+
+	binary, err := os.Open(executable)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "os.Open", err)
+		return exitFailure
+	}
+
+	defer binary.Close()
+
+	upload := t.ssh(`f=$(mktemp) && cat > "$f" && chmod 700 "$f" && echo "$f"`)
+	upload.Stdin = binary
+	upload.Stderr = os.Stderr
+
+	helper, err := upload.Output()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ssh %s upload %s\n", t.host, err)
+		return exitFailure
+	}
+
+	quoted := []string{shellQuote(string(bytes.TrimSpace(helper)))}
+
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+
+	script := strings.Join(quoted, " ") + "; status=$?; rm -f " + quoted[0] + "; exit $status"
+
+	cmd := t.ssh(script)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return exit.ExitCode()
+		}
+
+		fmt.Fprintf(os.Stderr, "ssh %s %s\n", t.host, err)
+		return exitFailure
+	}
+
+	return exitMatches
+}
+
+// unameMachine returns the machine name printed by uname -m on the systems
+// with the architecture of the program.
+func unameMachine() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		if runtime.GOOS == "darwin" {
+			return "arm64"
+		}
+		return "aarch64"
+	}
+
+	return runtime.GOARCH
+}
+
+// shellQuote quotes the text for a POSIX shell.
+func shellQuote(text string) string {
+	return "'" + strings.Replace(text, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRemotePaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    *remoteTarget
+		wantOut []string
+		wantErr bool
+	}{
+		{
+			name:    "absolute path",
+			args:    []string{"-a", "old", "ssh://deploy@web1/etc/app"},
+			want:    &remoteTarget{host: "deploy@web1"},
+			wantOut: []string{"-a", "old", "/etc/app"},
+		},
+		{
+			name:    "home folder and port",
+			args:    []string{"ssh://web1:2222/~/app", "ssh://web1:2222/~"},
+			want:    &remoteTarget{host: "web1", port: "2222"},
+			wantOut: []string{"app", "."},
+		},
+		{name: "different hosts", args: []string{"ssh://web1/a", "ssh://web2/b"}, wantErr: true},
+		{name: "no host", args: []string{"ssh:///etc"}, wantErr: true},
+		{name: "host as an option", args: []string{"ssh://-oProxyCommand=touch/path"}, wantErr: true},
+		{name: "user as an option", args: []string{"ssh://-oProxyCommand=x@web1/path"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, out, err := remotePaths(tt.args)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("remotePaths(%q) = %+v, want an error", tt.args, target)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("remotePaths %s", err)
+			}
+
+			if !reflect.DeepEqual(target, tt.want) || !reflect.DeepEqual(out, tt.wantOut) {
+				t.Fatalf("remotePaths(%q) = %+v %q, want %+v %q", tt.args, target, out, tt.want, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestRemoteSSHArgs(t *testing.T) {
+	cmd := (&remoteTarget{host: "web1", port: "22"}).ssh("true")
+	want := []string{"ssh", "-o", "BatchMode=yes", "-p", "22", "--", "web1", "true"}
+
+	if !reflect.DeepEqual(cmd.Args, want) {
+		t.Fatalf("ssh args = %q, want %q", cmd.Args, want)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// JSONRename is the machine-readable representation of one renamed file.
+type JSONRename struct {
+	Type    string `json:"type"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Applied bool   `json:"applied"`
+}
+
+// JSONRelink is the machine-readable representation of one rewritten link.
+type JSONRelink struct {
+	Type    string `json:"type"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Applied bool   `json:"applied"`
+}
+
+// renameFiles applies the rules to the names of the files, printing the new
+// names, and renames them if apply is true. It returns the number of files
+// and the paths to commit: the new ones, and the old ones moved by git.
+func renameFiles(ctx context.Context, e *engine.Engine, apply bool) (int, []string) {
+	renames, err := e.Renames(ctx)
+
+	if err != nil {
+		reportError("", err)
+		return 0, nil
+	}
+
+	var relinks []engine.Relink
+
+	// the links are found before the files move.
+	if flagFixLinks {
+		if relinks, err = e.Relinks(ctx, renames); err != nil {
+			reportError("", err)
+		}
+	}
+
+	if apply {
+		e.ApplyRenames(ctx, renames)
+	}
+
+	var count int
+	var paths []string
+
+	for _, r := range renames {
+		if r.Err != nil {
+			reportError(r.From, r.Err)
+			continue
+		}
+
+		count++
+		paths = append(paths, r.To)
+
+		if r.Git {
+			paths = append(paths, r.From)
+		}
+
+		switch {
+		case flagJSON:
+			printJSON(JSONRename{Type: "rename", From: r.From, To: r.To, Applied: apply})
+		case flagQuiet:
+		case flagList:
+			fmt.Println(r.From)
+		default:
+			fmt.Printf("%s %s -> %s\n", paint("0;33", "rename"), paint("0;35", r.From), paint("0;35", r.To))
+		}
+	}
+
+	return count, append(paths, fixLinks(e, relinks, apply)...)
+}
+
+// fixLinks prints the links of the Markdown files pointing to the renamed
+// files, and rewrites them if apply is true. It returns the files to commit.
+func fixLinks(e *engine.Engine, relinks []engine.Relink, apply bool) []string {
+	var errs map[string]error
+	var paths []string
+
+	if apply {
+		errs = e.ApplyRelinks(relinks)
+	}
+
+	for _, r := range relinks {
+		if err := errs[r.Filename]; err != nil {
+			continue
+		}
+
+		paths = append(paths, r.Filename)
+
+		switch {
+		case flagJSON:
+			printJSON(JSONRelink{Type: "relink", File: r.Filename, Line: r.Line, From: r.From, To: r.To, Applied: apply})
+		case flagQuiet:
+		case flagList:
+			fmt.Println(r.Filename)
+		default:
+			fmt.Printf("%s %s:%d %s -> %s\n", paint("0;33", "relink"), paint("0;35", r.Filename), r.Line, r.From, r.To)
+		}
+	}
+
+	for name, err := range errs {
+		reportError(name, err)
+	}
+
+	return uniqueStrings(paths)
+}
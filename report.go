@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fileError is the error that prevented one file from being processed.
+type fileError struct {
+	Filename string
+	Err      error
+}
+
+// failures collects the errors found during the execution, so they are
+// reported together at the end instead of scrolling away with the results.
+var failures []fileError
+
+// reportError records the error of the file. The file name is empty for the
+// errors that are not related to one single file.
+func reportError(filename string, err error) {
+	failures = append(failures, fileError{Filename: filename, Err: err})
+}
+
+// skips collects the files that were not searched because of a limit.
+var skips []fileSkip
+
+// fileSkip is the reason why one file was not searched.
+type fileSkip struct {
+	Filename string
+	Reason   string
+}
+
+// reportSkip records the file that was skipped and the reason.
+func reportSkip(filename string, reason string) {
+	skips = append(skips, fileSkip{Filename: filename, Reason: reason})
+}
+
+// JSONSkip is the machine-readable representation of one skipped file.
+type JSONSkip struct {
+	Type   string `json:"type"`
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// printSkips writes the skipped files to stderr, or as JSON records.
+func printSkips() {
+	if len(skips) == 0 {
+		return
+	}
+
+	if flagJSON {
+		for _, item := range skips {
+			printJSON(JSONSkip{Type: "skip", File: item.Filename, Reason: item.Reason})
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%d file(s) skipped:\n", len(skips))
+
+	for _, item := range skips {
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", item.Filename, item.Reason)
+	}
+}
+
+// JSONError is the machine-readable representation of one failure.
+type JSONError struct {
+	Type  string `json:"type"`
+	File  string `json:"file,omitempty"`
+	Error string `json:"error"`
+}
+
+// printErrors writes the errors to stderr, or as JSON records with -json.
+func printErrors() {
+	if len(failures) == 0 {
+		return
+	}
+
+	if flagJSON {
+		for _, item := range failures {
+			printJSON(JSONError{Type: "error", File: item.Filename, Error: item.Err.Error()})
+		}
+		return
+	}
+
+	if flagOutputFormat == "github" {
+		for _, item := range failures {
+			printGitHubError(item)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%d error(s):\n", len(failures))
+
+	for _, item := range failures {
+		// most errors already mention the file.
+		if text := item.Err.Error(); item.Filename == "" || strings.Contains(text, item.Filename) {
+			fmt.Fprintf(os.Stderr, "  %s\n", text)
+		} else {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", item.Filename, text)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// rulesCommand validates a rules file and prints its rules in the order they
+// are applied, with the options that differ from the defaults.
+func rulesCommand(args []string) {
+	fs := flag.NewFlagSet("rules", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage:\n  refactor rules FILE")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	specs, err := engine.LoadRulesFile(fs.Arg(0))
+
+	if err != nil {
+		fmt.Println("rules:", err)
+		os.Exit(exitUsage)
+	}
+
+	var invalid bool
+
+	for i, spec := range specs {
+		rule, err := spec.Compile(engine.RuleOptions{})
+
+		if err != nil {
+			fmt.Printf("%d. %q: %s\n", i+1, spec.Search, err)
+			invalid = true
+			continue
+		}
+
+		if rule.Deny {
+			fmt.Printf("%d. deny %q %s: %s%s\n", i+1, rule.Search, rule.Severity, ruleMessage(rule), describeRule(spec, rule))
+			continue
+		}
+
+		fmt.Printf("%d. %q -> %q%s\n", i+1, rule.Search, rule.Replace, describeRule(spec, rule))
+	}
+
+	if invalid {
+		os.Exit(exitUsage)
+	}
+}
+
+// describeRule formats the options, the file filters and the description of
+// the rule, if any.
+func describeRule(spec engine.RuleSpec, rule *engine.Rule) string {
+	var options []string
+
+	for _, opt := range []struct {
+		name string
+		on   bool
+	}{
+		{"regexp", rule.Options.Regexp},
+		{"ignore-case", rule.Options.IgnoreCase},
+		{"whole-word", rule.Options.WholeWord},
+		{"preserve-case", rule.Options.PreserveCase},
+		{"structural", rule.Options.Structural},
+		{"placeholders", rule.Options.Placeholders},
+	} {
+		if opt.on {
+			options = append(options, opt.name)
+		}
+	}
+
+	if len(spec.Include) > 0 {
+		options = append(options, "include "+strings.Join(spec.Include, " "))
+	}
+
+	if len(spec.Exclude) > 0 {
+		options = append(options, "exclude "+strings.Join(spec.Exclude, " "))
+	}
+
+	var text string
+
+	if len(options) > 0 {
+		text = " (" + strings.Join(options, ", ") + ")"
+	}
+
+	if rule.Name != "" {
+		text += " [" + rule.Name + "]"
+	}
+
+	if rule.Description != "" {
+		text += " # " + rule.Description
+	}
+
+	return text
+}
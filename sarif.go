@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// sarifSchema and sarifVersion identify the format of the SARIF log.
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+)
+
+// sarifResults are the results collected until the log is printed, at the
+// end, because SARIF is one single document.
+var sarifResults struct {
+	sync.Mutex
+	list []sarifResult
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful bool                `json:"executionSuccessful"`
+	Notifications       []sarifNotification `json:"toolExecutionNotifications"`
+}
+
+type sarifNotification struct {
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifResult struct {
+	rule      *engine.Rule
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+}
+
+// sarifRuleID is the identifier of the rule with the 0-based index.
+func sarifRuleID(index int) string {
+	return fmt.Sprintf("refactor/%d", index+1)
+}
+
+// sarifURI converts the file name to the relative URI expected by the code
+// scanning services.
+func sarifURI(filename string) string {
+	return strings.TrimPrefix(strings.Replace(filename, string(os.PathSeparator), "/", -1), "./")
+}
+
+// collectSARIF records one result per occurrence of the finding. The rule of
+// every occurrence is the one matching at its position.
+func collectSARIF(filename string, item engine.Finding, rs engine.RuleSet) {
+	text := []byte(item.OriginalText)
+	matches := rs.FindAll(text)
+
+	sarifResults.Lock()
+	defer sarifResults.Unlock()
+
+	for _, pos := range item.Positions {
+		rule, message := rs[0], fmt.Sprintf("%q should be replaced with %q", rs[0].Search, rs[0].Replace)
+
+		for _, m := range matches {
+			if line, column := offsetPosition(text, item.LineNumber, m.Loc[0]); line == pos.Line && column == pos.Column {
+				rule = m.Rule
+				message = replacementMessage(text, m)
+				break
+			}
+		}
+
+		sarifResults.list = append(sarifResults.list, sarifResult{
+			rule:    rule,
+			Level:   sarifLevel(rule),
+			Message: sarifMessage{Text: message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: sarifURI(filename)},
+					Region:           &sarifRegion{StartLine: pos.Line, StartColumn: pos.Column},
+				},
+			}},
+		})
+	}
+}
+
+// replacementMessage describes the replacement of one occurrence, or the
+// message of the deny rule.
+func replacementMessage(text []byte, m engine.RuleMatch) string {
+	if m.Rule.Deny {
+		return fmt.Sprintf("%q: %s", text[m.Loc[0]:m.Loc[1]], ruleMessage(m.Rule))
+	}
+
+	return fmt.Sprintf("%q should be replaced with %q", text[m.Loc[0]:m.Loc[1]], m.Rule.Expand(text, m.Loc))
+}
+
+// sarifLevel returns the level of the results of the rule, which is the
+// severity of the deny rules.
+func sarifLevel(rule *engine.Rule) string {
+	switch {
+	case !rule.Deny:
+		return "warning"
+	case rule.Severity == engine.SeverityInfo:
+		return "note"
+	}
+
+	return rule.Severity
+}
+
+// offsetPosition returns the line and the 1-based column of the byte offset
+// of the text starting at the line.
+func offsetPosition(text []byte, line int, offset int) (int, int) {
+	column := offset + 1
+
+	for i := 0; i < offset; i++ {
+		if text[i] == '\n' {
+			line++
+			column = offset - i
+		}
+	}
+
+	return line, column
+}
+
+// ruleIndex returns the index of the rule in the list of all the rules.
+func ruleIndex(rules engine.RuleSet, rule *engine.Rule) int {
+	for i, r := range rules {
+		if r == rule {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// printSARIF writes the SARIF log with the rules, the results and the errors.
+func printSARIF(rules engine.RuleSet) {
+	driver := sarifDriver{
+		Name:           "refactor",
+		InformationURI: "https://github.com/cixtor/refactor",
+		Rules:          []sarifRule{},
+	}
+
+	for i, rule := range rules {
+		description, name := rule.Description, rule.Search
+
+		if rule.Deny {
+			description = ruleMessage(rule)
+		} else if description == "" {
+			description = fmt.Sprintf("Replace %q with %q", rule.Search, rule.Replace)
+		}
+
+		if rule.Name != "" {
+			name = rule.Name
+		}
+
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:               sarifRuleID(i),
+			Name:             name,
+			ShortDescription: sarifMessage{Text: description},
+		})
+	}
+
+	invocation := sarifInvocation{
+		ExecutionSuccessful: len(failures) == 0,
+		Notifications:       []sarifNotification{},
+	}
+
+	for _, item := range failures {
+		notification := sarifNotification{Level: "error", Message: sarifMessage{Text: item.Err.Error()}}
+
+		if item.Filename != "" {
+			notification.Locations = []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: sarifURI(item.Filename)},
+				},
+			}}
+		}
+
+		invocation.Notifications = append(invocation.Notifications, notification)
+	}
+
+	results := []sarifResult{}
+
+	for _, res := range sarifResults.list {
+		res.RuleIndex = ruleIndex(rules, res.rule)
+		res.RuleID = sarifRuleID(res.RuleIndex)
+		results = append(results, res)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	err := enc.Encode(sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool:        sarifTool{Driver: driver},
+			Invocations: []sarifInvocation{invocation},
+			Results:     results,
+		}},
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "json.Encode", err)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// The states of the jobs of the server.
+const (
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+	jobApplying = "applying"
+	jobApplied  = "applied"
+)
+
+// searchRequest describes the files and the rules of a search submitted by
+// the clients of the servers.
+type searchRequest struct {
+	Paths        []string          `json:"paths"`
+	Rules        []engine.RuleSpec `json:"rules"`
+	Regexp       bool              `json:"regexp"`
+	IgnoreCase   bool              `json:"ignore_case"`
+	WholeWord    bool              `json:"whole_word"`
+	PreserveCase bool              `json:"preserve_case"`
+	Include      []string          `json:"include"`
+	Exclude      []string          `json:"exclude"`
+}
+
+// jobRequest is the body of POST /jobs. The job is a dry run unless Apply is
+// true, and then the findings are replaced as they are found; a dry run can
+// be applied later with POST /jobs/ID/apply.
+type jobRequest struct {
+	searchRequest
+	Apply bool `json:"apply"`
+}
+
+// jobStatus is the representation of a job in the responses.
+type jobStatus struct {
+	ID       string          `json:"id"`
+	State    string          `json:"state"`
+	DryRun   bool            `json:"dry_run"`
+	Progress engine.Progress `json:"progress"`
+	Stats    engine.Stats    `json:"stats"`
+	Errors   []string        `json:"errors,omitempty"`
+}
+
+// job is a search, or a replacement, running in the background.
+type job struct {
+	id     string
+	engine *engine.Engine
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	state   string
+	dryRun  bool
+	results []engine.SearchResult
+	errors  []string
+}
+
+// server keeps the jobs submitted since it started.
+type server struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+	next int
+	// root is the folder where the paths of the jobs must be.
+	root string
+	// token is the secret the clients must send in every request.
+	token string
+}
+
+// randomToken returns 128 random bits in hexadecimal.
+func randomToken() (string, error) {
+	buf := make([]byte, 16)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// guard rejects the requests without the token, and the ones a web browser
+// could send from any page the user visits: the ones from another origin and
+// the POST requests without a JSON body, which need no CORS preflight.
+func (s *server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, fmt.Errorf("origin %s is not allowed", origin))
+				return
+			}
+		}
+
+		auth := r.Header.Get("Authorization")
+
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid token"))
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("content type must be application/json"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveCommand runs the HTTP API to submit jobs and fetch their findings.
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8080", "Address of the HTTP server")
+	token := fs.String("token", "", "Token the clients must send as Authorization: Bearer TOKEN, random if empty")
+
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage:\n  refactor serve [-listen ADDR] [-token TOKEN]")
+		fmt.Fprintln(os.Stderr, `
+endpoints:
+  POST   /jobs              submit a job, {"paths": [], "rules": [{"search": "", "replace": ""}], "apply": false}
+  GET    /jobs              list the jobs
+  GET    /jobs/ID           state, progress and statistics of the job
+  GET    /jobs/ID/findings  findings of the job
+  POST   /jobs/ID/apply     replace the findings of a finished dry run
+  DELETE /jobs/ID           cancel the job
+
+Every request must send the token in the Authorization: Bearer header, and
+the POST requests a Content-Type: application/json header.
+
+flags:`)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	root, err := os.Getwd()
+
+	if err != nil {
+		fmt.Println("serve:", err)
+		os.Exit(exitFailure)
+	}
+
+	if *token == "" {
+		if *token, err = randomToken(); err != nil {
+			fmt.Println("serve:", err)
+			os.Exit(exitFailure)
+		}
+	}
+
+	s := &server{jobs: map[string]*job{}, root: root, token: *token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJob)
+
+	fmt.Fprintf(os.Stderr, "serving %s on %s with token %s\n", root, *listen, *token)
+
+	if err := http.ListenAndServe(*listen, s.guard(mux)); err != nil {
+		fmt.Println("serve:", err)
+		os.Exit(exitFailure)
+	}
+}
+
+// handleJobs lists the jobs or submits a new one.
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		list := make([]jobStatus, 0, len(s.jobs))
+		for _, j := range s.jobs {
+			list = append(list, j.status())
+		}
+		s.mu.Unlock()
+
+		sort.Slice(list, func(a, b int) bool {
+			x, _ := strconv.Atoi(list[a].ID)
+			y, _ := strconv.Atoi(list[b].ID)
+			return x < y
+		})
+
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		var req jobRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("json.Decode %s", err))
+			return
+		}
+
+		j, err := s.submit(req)
+
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, j.status())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+	}
+}
+
+// handleJob serves the state and the findings of a job, applies it or
+// cancels it.
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/"), "/")
+
+	s.mu.Lock()
+	j := s.jobs[parts[0]]
+	s.mu.Unlock()
+
+	if j == nil || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.URL.Path))
+		return
+	}
+
+	var action string
+
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, j.status())
+	case action == "" && r.Method == http.MethodDelete:
+		j.cancel()
+		writeJSON(w, http.StatusOK, j.status())
+	case action == "findings" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, j.findings())
+	case action == "apply" && r.Method == http.MethodPost:
+		if err := j.apply(); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, j.status())
+	case action == "" || action == "findings" || action == "apply":
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.URL.Path))
+	}
+}
+
+// submit validates the request and starts the job.
+func (s *server) submit(req jobRequest) (*job, error) {
+	e, err := req.engine(s.root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.next++
+	j := &job{id: strconv.Itoa(s.next), engine: e, cancel: cancel, state: jobRunning, dryRun: !req.Apply}
+	s.jobs[j.id] = j
+	s.mu.Unlock()
+
+	go j.run(ctx, req.Apply)
+
+	return j, nil
+}
+
+// engine validates the request and returns the engine to run it, which
+// cannot modify the files outside of the root folder.
+func (req searchRequest) engine(root string) (*engine.Engine, error) {
+	if len(req.Rules) == 0 {
+		return nil, fmt.Errorf("the job has no rules")
+	}
+
+	// {env:NAME} would reveal the environment of the server to the clients.
+	for _, rule := range req.Rules {
+		if rule.Placeholders != nil && *rule.Placeholders {
+			return nil, fmt.Errorf("the rules cannot use placeholders")
+		}
+	}
+
+	paths := req.Paths
+
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if rel, err := filepath.Rel(root, abs); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s is outside of %s", path, root)
+		}
+	}
+
+	return engine.New(engine.Options{
+		Rules:        req.Rules,
+		Regexp:       req.Regexp,
+		IgnoreCase:   req.IgnoreCase,
+		WholeWord:    req.WholeWord,
+		PreserveCase: req.PreserveCase,
+		Paths:        paths,
+		Include:      req.Include,
+		Exclude:      req.Exclude,
+		JournalDir:   engine.DefaultJournalDir,
+	})
+}
+
+// run searches the files, or replaces the findings if apply is true.
+func (j *job) run(ctx context.Context, apply bool) {
+	collect := func(res engine.SearchResult) {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		if res.Err != nil {
+			j.errors = append(j.errors, res.Err.Error())
+		}
+
+		if len(res.Findings) > 0 {
+			j.results = append(j.results, res)
+		}
+	}
+
+	var err error
+
+	if apply {
+		err = j.engine.ApplyFunc(ctx, collect)
+	} else {
+		err = j.engine.SearchFunc(ctx, collect)
+	}
+
+	j.finish(err, jobDone)
+}
+
+// finish records the final state of the job.
+func (j *job) finish(err error, done string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch {
+	case err == context.Canceled:
+		j.state = jobCanceled
+	case err != nil:
+		j.errors = append(j.errors, err.Error())
+		j.state = jobFailed
+	default:
+		j.state = done
+	}
+}
+
+// apply replaces the findings of a finished dry run in the background. The
+// files modified since the search are skipped and reported as errors.
+func (j *job) apply() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.dryRun || j.state != jobDone {
+		return fmt.Errorf("only a finished dry run can be applied, the job is %s", j.state)
+	}
+
+	j.state = jobApplying
+
+	go func() {
+		j.mu.Lock()
+		n := len(j.results)
+		j.mu.Unlock()
+
+		for i := 0; i < n; i++ {
+			// the findings are marked as applied in a copy, since they can
+			// be requested in the meantime.
+			j.mu.Lock()
+			res := j.results[i]
+			res.Findings = append([]engine.Finding(nil), res.Findings...)
+			j.mu.Unlock()
+
+			err := j.engine.ApplyFile(&res, nil)
+
+			j.mu.Lock()
+			j.results[i] = res
+			if err != nil {
+				j.errors = append(j.errors, err.Error())
+			}
+			j.mu.Unlock()
+		}
+
+		j.finish(nil, jobApplied)
+	}()
+
+	return nil
+}
+
+// status returns the representation of the job.
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return jobStatus{
+		ID:       j.id,
+		State:    j.state,
+		DryRun:   j.dryRun,
+		Progress: j.engine.Progress(),
+		Stats:    j.engine.Stats(),
+		Errors:   append([]string(nil), j.errors...),
+	}
+}
+
+// findings returns the findings of the job found so far.
+func (j *job) findings() []JSONFinding {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return jsonFindings(j.results)
+}
+
+// jsonFindings returns the machine-readable representation of the findings.
+func jsonFindings(results []engine.SearchResult) []JSONFinding {
+	list := []JSONFinding{}
+
+	for _, res := range results {
+		for _, item := range res.Findings {
+			var column int
+
+			if len(item.Positions) > 0 {
+				column = item.Positions[0].Column
+			}
+
+			filename := res.Filename
+
+			if item.Member != "" {
+				filename += engine.MemberSeparator + item.Member
+			}
+
+			list = append(list, JSONFinding{
+				Type:        "finding",
+				File:        filename,
+				Line:        item.LineNumber,
+				EndLine:     item.EndLine,
+				Column:      column,
+				Occurrences: item.Occurrences,
+				Before:      item.OriginalText,
+				After:       item.Replaced(res.Rules),
+				Applied:     item.Applied,
+				Positions:   item.Positions,
+				Messages:    denyMessages(item, res.Rules),
+			})
+		}
+	}
+
+	return list
+}
+
+// writeJSON writes the value as the response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, "json.Encode", err)
+	}
+}
+
+// writeError writes the error as the response.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
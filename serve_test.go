@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+func TestServerGuard(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{
+			name:    "json with the token",
+			method:  http.MethodPost,
+			headers: map[string]string{"Authorization": "Bearer secret", "Content-Type": "application/json; charset=utf-8"},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "get with the token",
+			method:  http.MethodGet,
+			headers: map[string]string{"Authorization": "Bearer secret"},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "same origin",
+			method:  http.MethodGet,
+			headers: map[string]string{"Authorization": "Bearer secret", "Origin": "http://example.com"},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "no token",
+			method:  http.MethodGet,
+			headers: map[string]string{},
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name:    "wrong token",
+			method:  http.MethodGet,
+			headers: map[string]string{"Authorization": "Bearer other"},
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name:    "simple request from a web page",
+			method:  http.MethodPost,
+			headers: map[string]string{"Authorization": "Bearer secret", "Content-Type": "text/plain"},
+			want:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:    "no content type",
+			method:  http.MethodPost,
+			headers: map[string]string{"Authorization": "Bearer secret"},
+			want:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:    "foreign origin",
+			method:  http.MethodGet,
+			headers: map[string]string{"Authorization": "Bearer secret", "Origin": "http://evil.example"},
+			want:    http.StatusForbidden,
+		},
+	}
+
+	s := &server{token: "secret"}
+	handler := s.guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://example.com/jobs", strings.NewReader(`{"apply": true}`))
+
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestSearchRequestEngine(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name    string
+		req     searchRequest
+		wantErr string
+	}{
+		{
+			name: "rules",
+			req:  searchRequest{Rules: []engine.RuleSpec{{Search: "foo", Replace: "bar", Placeholders: &no}}},
+		},
+		{
+			name:    "no rules",
+			req:     searchRequest{},
+			wantErr: "no rules",
+		},
+		{
+			name:    "placeholders",
+			req:     searchRequest{Rules: []engine.RuleSpec{{Search: "foo", Replace: "{env:HOME}", Placeholders: &yes}}},
+			wantErr: "placeholders",
+		},
+		{
+			name:    "outside of the root",
+			req:     searchRequest{Paths: []string{".."}, Rules: []engine.RuleSpec{{Search: "foo", Replace: "bar"}}},
+			wantErr: "outside of",
+		},
+	}
+
+	root, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.req.engine(root)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("engine %s", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("engine = %v, want an error with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag holding a number of bytes, optionally followed by one of
+// the K, M or G suffixes, i.e. "512K" or "2G".
+type byteSize int64
+
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *byteSize) Set(value string) error {
+	n, err := parseSize(value)
+
+	if err != nil {
+		return err
+	}
+
+	*s = byteSize(n)
+
+	return nil
+}
+
+// parseSize converts a human readable size into a number of bytes.
+func parseSize(text string) (int64, error) {
+	units := map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30}
+	mult := int64(1)
+	text = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(text)), "B")
+
+	if n := len(text); n > 0 && units[text[n-1]] > 0 {
+		mult = units[text[n-1]]
+		text = text[:n-1]
+	}
+
+	n, err := strconv.ParseInt(text, 10, 64)
+
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+
+	return n * mult, nil
+}
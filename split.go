@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// splitFlag is the value of -split-by: "dir" groups the files by their top
+// folder, "dir=N" by their first N folders, and "count=N" in chunks of N
+// files, in the order of their names.
+type splitFlag struct {
+	by string
+	n  int
+}
+
+func (s *splitFlag) String() string {
+	if s.by == "" {
+		return ""
+	}
+
+	if s.by == "dir" && s.n == 1 {
+		return "dir"
+	}
+
+	return s.by + "=" + strconv.Itoa(s.n)
+}
+
+func (s *splitFlag) Set(value string) error {
+	by, arg := value, ""
+
+	if k := strings.IndexByte(value, '='); k >= 0 {
+		by, arg = value[:k], value[k+1:]
+	}
+
+	n := 1
+
+	if arg != "" || by == "count" {
+		var err error
+
+		if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+			return fmt.Errorf("invalid number %q, use dir, dir=N or count=N", arg)
+		}
+	}
+
+	if by != "dir" && by != "count" {
+		return fmt.Errorf("unsupported split %q, use dir, dir=N or count=N", value)
+	}
+
+	s.by, s.n = by, n
+
+	return nil
+}
+
+// enabled reports whether the changes are split.
+func (s splitFlag) enabled() bool {
+	return s.by != ""
+}
+
+// chunk is a part of the changes.
+type chunk struct {
+	// name is the folder of the files, or empty when split by count.
+	name  string
+	files []string
+}
+
+// split partitions the files, sorted by name, in chunks.
+func (s splitFlag) split(files []string) []chunk {
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+
+	var chunks []chunk
+
+	if s.by == "count" {
+		for i := 0; i < len(files); i += s.n {
+			end := i + s.n
+
+			if end > len(files) {
+				end = len(files)
+			}
+
+			chunks = append(chunks, chunk{files: files[i:end]})
+		}
+
+		return chunks
+	}
+
+	index := map[string]int{}
+
+	for _, filename := range files {
+		dir := topFolders(filename, s.n)
+
+		if _, ok := index[dir]; !ok {
+			index[dir] = len(chunks)
+			chunks = append(chunks, chunk{name: dir})
+		}
+
+		chunks[index[dir]].files = append(chunks[index[dir]].files, filename)
+	}
+
+	return chunks
+}
+
+// topFolders returns the first n folders of the relative path of the file,
+// or "." if it is in the working directory.
+func topFolders(filename string, n int) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				filename = rel
+			}
+		}
+	}
+
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(filepath.Clean(filename))), "/")
+
+	if len(parts) > n {
+		parts = parts[:n]
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// describe returns the position of the chunk, and its folder, for the
+// messages of the commits.
+func (c chunk) describe(i int, total int) string {
+	if c.name == "" {
+		return fmt.Sprintf("%d/%d", i+1, total)
+	}
+
+	return fmt.Sprintf("%s, %d/%d", c.name, i+1, total)
+}
+
+// chunkName returns the name of the patch file, or of the branch, of the
+// chunk, numbered from 1 before the extension, i.e. changes-01.patch.
+func chunkName(name string, ext string, i int, total int) string {
+	return fmt.Sprintf("%s-%0*d%s", strings.TrimSuffix(name, ext), len(strconv.Itoa(total)), i+1, ext)
+}
+
+// commitChunks commits the files in one commit per chunk. With a branch, one
+// branch per chunk is created from the current HEAD, numbered like the patch
+// files, and the current branch is checked out again at the end, without the
+// changes, which are in the branches.
+func commitChunks(files []string, message string, branch string) error {
+	chunks := flagSplitBy.split(files)
+
+	var head string
+
+	if branch != "" {
+		out, err := exec.Command("git", "symbolic-ref", "-q", "--short", "HEAD").Output()
+
+		// a detached HEAD is checked out again by its commit.
+		if err != nil {
+			out, err = exec.Command("git", "rev-parse", "HEAD").Output()
+		}
+
+		if err != nil {
+			return fmt.Errorf("git rev-parse %s", err)
+		}
+
+		head = strings.TrimSpace(string(out))
+	}
+
+	for i, c := range chunks {
+		var name string
+
+		if branch != "" {
+			name = chunkName(branch, "", i, len(chunks))
+
+			// the next branch starts from the same commit as the first one.
+			if i > 0 {
+				if err := runGit(nil, "checkout", "-q", head); err != nil {
+					return err
+				}
+			}
+		}
+
+		hash, err := commitFiles(c.files, fmt.Sprintf("%s (%s)", message, c.describe(i, len(chunks))), name)
+
+		if err != nil {
+			return err
+		}
+
+		if name != "" {
+			fmt.Fprintf(os.Stderr, "committed %d file(s) in %s on %s\n", len(c.files), hash, name)
+		} else {
+			fmt.Fprintf(os.Stderr, "committed %d file(s) in %s\n", len(c.files), hash)
+		}
+	}
+
+	if branch != "" {
+		return runGit(nil, "checkout", "-q", head)
+	}
+
+	return nil
+}
+
+// splitBranchesExist reports whether any branch numbered after the branch
+// already exists, so the error can be reported before any file is modified.
+func splitBranchesExist(branch string) bool {
+	out, err := exec.Command("git", "for-each-ref", "--format=%(refname)", "refs/heads/"+branch+"-[0-9]*").Output()
+	return err == nil && len(strings.TrimSpace(string(out))) > 0
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// rdjsonDiagnostic is one finding in the Reviewdog Diagnostic Format, read by
+// reviewdog -f=rdjsonl, one per line. The suggestions become the suggested
+// changes of the pull requests with the github-pr-review reporter.
+type rdjsonDiagnostic struct {
+	Message     string             `json:"message"`
+	Location    rdjsonLocation     `json:"location"`
+	Severity    string             `json:"severity"`
+	Source      rdjsonSource       `json:"source"`
+	Code        *rdjsonCode        `json:"code,omitempty"`
+	Suggestions []rdjsonSuggestion `json:"suggestions,omitempty"`
+}
+
+type rdjsonLocation struct {
+	Path  string      `json:"path"`
+	Range rdjsonRange `json:"range"`
+}
+
+type rdjsonRange struct {
+	Start rdjsonPosition `json:"start"`
+	End   rdjsonPosition `json:"end"`
+}
+
+// rdjsonPosition is 1-based; the column counts the bytes of the line.
+type rdjsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column,omitempty"`
+}
+
+type rdjsonSource struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type rdjsonCode struct {
+	Value string `json:"value"`
+}
+
+// rdjsonSuggestion replaces the text of the range.
+type rdjsonSuggestion struct {
+	Range rdjsonRange `json:"range"`
+	Text  string      `json:"text"`
+}
+
+// printSuggestion writes the finding as a diagnostic whose suggestion replaces
+// its entire lines, since the suggested changes of GitHub cannot replace part
+// of a line. The occurrences of the deny rules have no suggestion.
+func printSuggestion(filename string, item engine.Finding, rs engine.RuleSet) {
+	text := []byte(item.OriginalText)
+	matches := item.Matches(rs)
+	lines := strings.Split(strings.TrimSuffix(item.OriginalText, "\n"), "\n")
+	last := strings.TrimSuffix(lines[len(lines)-1], "\r")
+
+	var messages []string
+
+	severity := "INFO"
+
+	for _, m := range matches {
+		messages = append(messages, replacementMessage(text, m))
+
+		if m.Rule.Severity == engine.SeverityError {
+			severity = "ERROR"
+		} else if severity == "INFO" && m.Rule.Severity != engine.SeverityInfo {
+			severity = "WARNING"
+		}
+	}
+
+	diagnostic := rdjsonDiagnostic{
+		Message: strings.Join(uniqueStrings(messages), "\n"),
+		Location: rdjsonLocation{
+			Path: sarifURI(filename),
+			Range: rdjsonRange{
+				Start: rdjsonPosition{Line: item.LineNumber, Column: 1},
+				End:   rdjsonPosition{Line: item.EndLine, Column: len(last) + 1},
+			},
+		},
+		Severity: severity,
+		Source:   rdjsonSource{Name: "refactor", URL: "https://github.com/cixtor/refactor"},
+	}
+
+	if len(matches) > 0 {
+		code := matches[0].Rule.Name
+
+		if code == "" {
+			code = matches[0].Rule.Search
+		}
+
+		diagnostic.Code = &rdjsonCode{Value: code}
+	}
+
+	if !item.Denied(rs) {
+		replaced := strings.TrimSuffix(item.Replaced(rs), "\n")
+		replaced = strings.TrimSuffix(strings.Replace(replaced, "\r\n", "\n", -1), "\r")
+
+		diagnostic.Suggestions = []rdjsonSuggestion{{Range: diagnostic.Location.Range, Text: replaced}}
+	}
+
+	printJSON(diagnostic)
+}
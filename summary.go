@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// fileCount is the number of changes found in one single file.
+type fileCount struct {
+	Filename    string
+	Findings    int
+	Occurrences int
+	Modified    bool
+}
+
+// fileCounts collects the per-file counts printed with -stats.
+var fileCounts []fileCount
+
+// countFile records the number of findings and occurrences of the file, and
+// its findings for the HTML report.
+func countFile(res engine.SearchResult, modified bool) {
+	collectReport(res, modified)
+
+	if !flagStats || len(res.Findings) == 0 {
+		return
+	}
+
+	count := fileCount{
+		Filename: res.Filename,
+		Findings: len(res.Findings),
+		Modified: modified,
+	}
+
+	for _, item := range res.Findings {
+		count.Occurrences += item.Occurrences
+	}
+
+	fileCounts = append(fileCounts, count)
+}
+
+// printFileCounts writes the per-file counts, sorted by name, to stderr.
+func printFileCounts() {
+	sort.Slice(fileCounts, func(i, j int) bool {
+		return fileCounts[i].Filename < fileCounts[j].Filename
+	})
+
+	for _, count := range fileCounts {
+		var note string
+
+		if count.Modified {
+			note = " (modified)"
+		}
+
+		fmt.Fprintf(os.Stderr, "%8d %8d  %s%s\n", count.Findings, count.Occurrences, count.Filename, note)
+	}
+}
+
+// printSummary writes the statistics of the execution to stderr.
+func printSummary(stats engine.Stats, elapsed time.Duration) {
+	var cached, suppressed string
+
+	if stats.FilesCached > 0 {
+		cached = fmt.Sprintf(" (%d cached)", stats.FilesCached)
+	}
+
+	if stats.Suppressed > 0 {
+		suppressed = fmt.Sprintf(" (%d suppressed)", stats.Suppressed)
+	}
+
+	fmt.Fprintf(
+		os.Stderr,
+		"%d file(s) scanned%s, %d skipped, %d matched, %d modified, %d occurrence(s)%s, %d byte(s) written in %s\n",
+		stats.FilesScanned,
+		cached,
+		stats.FilesSkipped,
+		stats.FilesMatched,
+		stats.FilesModified,
+		stats.Occurrences,
+		suppressed,
+		stats.BytesWritten,
+		elapsed.Round(time.Millisecond),
+	)
+}
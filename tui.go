@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cixtor/refactor/engine"
+)
+
+// tuiRow is one line of the list, either a file or one of its findings.
+type tuiRow struct {
+	file    int
+	finding int // -1 for the row of the file itself.
+}
+
+// tui is a minimal full-screen interface to review the findings before they
+// are applied. The terminal is configured with stty(1) to avoid depending on
+// platform-specific system calls.
+type tui struct {
+	results  []engine.SearchResult
+	selected []map[int]bool
+	rows     []tuiRow
+	cursor   int
+	offset   int
+	width    int
+	height   int
+	diff     bool
+	tty      *os.File
+}
+
+// runTUI lets the user toggle individual findings and applies the selected
+// ones when the user presses "w". Pressing "q" exits without changes. It
+// returns the list of modified files.
+func runTUI(e *engine.Engine, results []engine.SearchResult) ([]string, error) {
+	var modified []string
+
+	if len(results) == 0 {
+		fmt.Println("no findings")
+		return nil, nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tty.Close()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Filename < results[j].Filename
+	})
+
+	t := &tui{results: results, tty: tty}
+
+	for i, res := range results {
+		t.selected = append(t.selected, map[int]bool{})
+		t.rows = append(t.rows, tuiRow{file: i, finding: -1})
+
+		for j, item := range res.Findings {
+			t.selected[i][item.LineNumber] = true
+			t.rows = append(t.rows, tuiRow{file: i, finding: j})
+		}
+	}
+
+	state, err := t.stty("-g")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := t.stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+
+	// alternate screen buffer and hidden cursor.
+	fmt.Fprint(t.tty, "\x1b[?1049h\x1b[?25l")
+
+	apply := t.loop()
+
+	fmt.Fprint(t.tty, "\x1b[?25h\x1b[?1049l")
+
+	if _, err := t.stty(strings.TrimSpace(state)); err != nil {
+		return nil, err
+	}
+
+	if !apply {
+		fmt.Println("no changes were applied")
+		return nil, nil
+	}
+
+	for i, res := range t.results {
+		if len(t.selected[i]) == 0 {
+			continue
+		}
+
+		if err := e.ApplyFile(&res, t.selected[i]); err != nil {
+			reportError(res.Filename, err)
+		}
+
+		if !res.Modified {
+			continue
+		}
+
+		modified = append(modified, res.Filename)
+
+		for _, item := range res.Findings {
+			if t.selected[i][item.LineNumber] {
+				fmt.Println(formatReplacement(res.Filename, item, res.Rules))
+			}
+		}
+	}
+
+	return modified, nil
+}
+
+// stty runs stty(1) with the terminal as its standard input.
+func (t *tui) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = t.tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// resize reads the dimensions of the terminal, defaulting to 80x24.
+func (t *tui) resize() {
+	t.width, t.height = 80, 24
+
+	out, err := t.stty("size")
+
+	if err != nil {
+		return
+	}
+
+	if fields := strings.Fields(out); len(fields) == 2 {
+		if h, err := strconv.Atoi(fields[0]); err == nil && h > 5 {
+			t.height = h
+		}
+		if w, err := strconv.Atoi(fields[1]); err == nil && w > 20 {
+			t.width = w
+		}
+	}
+}
+
+// loop processes the key strokes until the user applies the changes or quits.
+func (t *tui) loop() bool {
+	buf := make([]byte, 16)
+
+	for {
+		t.resize()
+		t.draw()
+
+		n, err := t.tty.Read(buf)
+
+		if err != nil {
+			return false
+		}
+
+		switch key := string(buf[:n]); key {
+		case "q", "\x03", "\x1b":
+			return false
+		case "w":
+			return true
+		case "k", "\x1b[A", "\x1bOA":
+			t.move(-1)
+		case "j", "\x1b[B", "\x1bOB":
+			t.move(+1)
+		case "\x1b[5~":
+			t.move(-t.listHeight())
+		case "\x1b[6~":
+			t.move(+t.listHeight())
+		case "g":
+			t.move(-len(t.rows))
+		case "G":
+			t.move(+len(t.rows))
+		case " ":
+			t.toggle(t.rows[t.cursor])
+		case "a":
+			t.toggleAll()
+		case "d", "\r":
+			t.diff = !t.diff
+		}
+	}
+}
+
+func (t *tui) move(delta int) {
+	t.cursor += delta
+
+	if t.cursor < 0 {
+		t.cursor = 0
+	}
+
+	if t.cursor >= len(t.rows) {
+		t.cursor = len(t.rows) - 1
+	}
+}
+
+// toggle selects or deselects a finding. Toggling a file row applies the same
+// state to all its findings.
+func (t *tui) toggle(row tuiRow) {
+	res := t.results[row.file]
+	sel := t.selected[row.file]
+
+	if row.finding >= 0 {
+		line := res.Findings[row.finding].LineNumber
+		if sel[line] {
+			delete(sel, line)
+		} else {
+			sel[line] = true
+		}
+		return
+	}
+
+	all := len(sel) < len(res.Findings)
+
+	for _, item := range res.Findings {
+		if all {
+			sel[item.LineNumber] = true
+		} else {
+			delete(sel, item.LineNumber)
+		}
+	}
+}
+
+// toggleAll selects every finding or, if they all are selected, none of them.
+func (t *tui) toggleAll() {
+	total, count := t.counts()
+	all := count < total
+
+	for i, res := range t.results {
+		t.selected[i] = map[int]bool{}
+		if all {
+			for _, item := range res.Findings {
+				t.selected[i][item.LineNumber] = true
+			}
+		}
+	}
+}
+
+// counts returns the total number of findings and how many are selected.
+func (t *tui) counts() (int, int) {
+	var total, count int
+
+	for i, res := range t.results {
+		total += len(res.Findings)
+		count += len(t.selected[i])
+	}
+
+	return total, count
+}
+
+// listHeight is the number of rows available for the list of findings.
+func (t *tui) listHeight() int {
+	h := t.height - 2
+
+	if t.diff {
+		h -= t.height / 3
+	}
+
+	if h < 1 {
+		h = 1
+	}
+
+	return h
+}
+
+// draw renders the whole screen at once to avoid flickering.
+func (t *tui) draw() {
+	var out bytes.Buffer
+
+	total, count := t.counts()
+	height := t.listHeight()
+
+	if t.cursor < t.offset {
+		t.offset = t.cursor
+	}
+
+	if t.cursor >= t.offset+height {
+		t.offset = t.cursor - height + 1
+	}
+
+	out.WriteString("\x1b[H\x1b[2J")
+	out.WriteString(fmt.Sprintf("\x1b[1mrefactor\x1b[0m %d files, %d findings, %d selected\r\n", len(t.results), total, count))
+
+	for i := t.offset; i < t.offset+height && i < len(t.rows); i++ {
+		out.WriteString(t.formatRow(t.rows[i], i == t.cursor))
+		out.WriteString("\r\n")
+	}
+
+	if t.diff {
+		for i := len(t.rows) - t.offset; i < height; i++ {
+			out.WriteString("\r\n")
+		}
+		t.drawDiff(&out)
+	}
+
+	out.WriteString(fmt.Sprintf("\x1b[%d;1H\x1b[7m%s\x1b[0m", t.height, truncate(" j/k move  space toggle  a all  d diff  w apply  q quit", t.width)))
+
+	_, _ = t.tty.Write(out.Bytes())
+}
+
+// formatRow renders one line of the list.
+func (t *tui) formatRow(row tuiRow, current bool) string {
+	var text string
+
+	res := t.results[row.file]
+	sel := t.selected[row.file]
+
+	if row.finding < 0 {
+		mark := "[-]"
+		if len(sel) == 0 {
+			mark = "[ ]"
+		} else if len(sel) == len(res.Findings) {
+			mark = "[x]"
+		}
+		text = fmt.Sprintf("%s \x1b[0;35m%s\x1b[0m (%d)", mark, truncate(res.Filename, t.width-10), len(res.Findings))
+	} else {
+		item := res.Findings[row.finding]
+		mark := "[ ]"
+		if sel[item.LineNumber] {
+			mark = "[x]"
+		}
+		prefix := fmt.Sprintf("    %s %s: ", mark, item.Lines())
+		full := strings.Replace(item.OriginalText, "\n", " ", -1)
+		line := truncate(full, t.width-len(prefix))
+		text = prefix + highlightVisible(line, len(full), item.Matches(res.Rules))
+	}
+
+	if current {
+		return "\x1b[1m>\x1b[0m" + text
+	}
+
+	return " " + text
+}
+
+// drawDiff renders the old and new version of the finding under the cursor.
+func (t *tui) drawDiff(out *bytes.Buffer) {
+	row := t.rows[t.cursor]
+	res := t.results[row.file]
+
+	out.WriteString("\x1b[2m" + strings.Repeat("─", t.width) + "\x1b[0m\r\n")
+
+	if row.finding < 0 {
+		out.WriteString(truncate(res.Filename, t.width) + "\r\n")
+		return
+	}
+
+	item := res.Findings[row.finding]
+	after := item.Replaced(res.Rules)
+
+	for _, line := range strings.Split(item.OriginalText, "\n") {
+		out.WriteString("\x1b[0;31m" + truncate("-"+line, t.width) + "\x1b[0m\r\n")
+	}
+
+	for _, line := range strings.Split(after, "\n") {
+		out.WriteString("\x1b[0;32m" + truncate("+"+line, t.width) + "\x1b[0m\r\n")
+	}
+}
+
+// highlightVisible highlights the matches of the text that are not cut by the
+// truncation of its original length.
+func highlightVisible(line string, length int, matches []engine.RuleMatch) string {
+	var last int
+	var out strings.Builder
+
+	visible := len(line)
+
+	if visible < length {
+		visible -= len("…")
+	}
+
+	for _, m := range matches {
+		if m.Loc[1] > visible {
+			break
+		}
+
+		out.WriteString(line[last:m.Loc[0]])
+		out.WriteString("\x1b[1;31m" + line[m.Loc[0]:m.Loc[1]] + "\x1b[0m")
+		last = m.Loc[1]
+	}
+
+	out.WriteString(line[last:])
+
+	return out.String()
+}
+
+// truncate shortens the text to the specified number of characters.
+func truncate(text string, width int) string {
+	runes := []rune(text)
+
+	if width < 1 {
+		return ""
+	}
+
+	if len(runes) > width {
+		return string(runes[:width-1]) + "…"
+	}
+
+	return text
+}